@@ -0,0 +1,149 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+)
+
+const crawlWorkers = 16 // number of nodes checked concurrently
+
+// crawler walks the discovery DHT and collects the records of all nodes it
+// finds. If a prober is set, it also performs an RLPx handshake with every
+// node. Nodes of the input set which are no longer reachable keep their old
+// data, so crawls can be repeated to update the set incrementally.
+type crawler struct {
+	input  nodeSet
+	tab    *discover.Table
+	prober *prober // may be nil
+
+	mu     sync.Mutex
+	output nodeSet
+}
+
+func newCrawler(input nodeSet, tab *discover.Table, prober *prober) *crawler {
+	c := &crawler{input: input, tab: tab, prober: prober, output: make(nodeSet, len(input))}
+	for id, n := range input {
+		c.output[id] = n
+	}
+	return c
+}
+
+func (c *crawler) run(timeout time.Duration) nodeSet {
+	var (
+		deadline = make(chan struct{})
+		queue    = make(chan *discover.Node)
+		checked  = make(map[discover.NodeID]bool)
+		wg       sync.WaitGroup
+	)
+	timer := time.AfterFunc(timeout, func() { close(deadline) })
+	defer timer.Stop()
+
+	wg.Add(crawlWorkers)
+	for i := 0; i < crawlWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for n := range queue {
+				c.updateNode(n)
+			}
+		}()
+	}
+	defer func() {
+		close(queue)
+		wg.Wait()
+	}()
+
+	// check queues n unless it was checked before. It returns false when
+	// the crawl is over.
+	check := func(n *discover.Node) bool {
+		if checked[n.ID] {
+			return true
+		}
+		select {
+		case queue <- n:
+			checked[n.ID] = true
+			return true
+		case <-deadline:
+			return false
+		}
+	}
+	// Revisit the known nodes first, then walk the DHT.
+	for _, n := range c.input.nodes() {
+		if !check(n) {
+			return c.output
+		}
+	}
+	for {
+		var target discover.NodeID
+		rand.Read(target[:])
+		results := c.tab.Lookup(target)
+		if len(results) == 0 {
+			// Don't spin when the table is empty.
+			select {
+			case <-time.After(time.Second):
+			case <-deadline:
+				return c.output
+			}
+		}
+		for _, n := range results {
+			if !check(n) {
+				return c.output
+			}
+		}
+	}
+}
+
+// updateNode fetches the record of n, performs the RLPx handshake and stores
+// the results in the output set.
+func (c *crawler) updateNode(n *discover.Node) {
+	rec, err := c.tab.RequestENR(n)
+	if err != nil {
+		log.Debug("Skipping node", "id", n.ID, "err", err)
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, known := c.output[n.ID]
+	c.mu.Unlock()
+	entry.Record, entry.LastSeen = rec.ENR(), now
+
+	if c.prober != nil && rec.TCP != 0 {
+		res := c.prober.probe(rec)
+		if res.name != "" {
+			entry.Name, entry.LastProbed = res.name, &now
+		}
+		if res.status != nil {
+			entry.Huc = res.status
+		}
+		if res.err != nil {
+			log.Debug("RLPx handshake failed", "id", n.ID, "err", res.err)
+		}
+	}
+
+	c.mu.Lock()
+	c.output[n.ID] = entry
+	c.mu.Unlock()
+	if !known {
+		log.Info("Found new node", "id", n.ID, "addr", rec.IP, "name", entry.Name)
+	}
+}
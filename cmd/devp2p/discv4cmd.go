@@ -0,0 +1,132 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/cmd/utils"
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/params"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	discv4Command = cli.Command{
+		Name:  "discv4",
+		Usage: "Node Discovery v4 tools",
+		Subcommands: []cli.Command{
+			discv4CrawlCommand,
+		},
+	}
+	discv4CrawlCommand = cli.Command{
+		Name:      "crawl",
+		Usage:     "Updates a nodes.json file with random nodes found in the DHT",
+		ArgsUsage: "<nodes.json>",
+		Action:    discv4Crawl,
+		Flags:     []cli.Flag{bootnodesFlag, crawlTimeoutFlag, noHandshakeFlag, handshakeTimeoutFlag},
+	}
+)
+
+var (
+	bootnodesFlag = cli.StringFlag{
+		Name:  "bootnodes",
+		Usage: "Comma separated nodes used for bootstrapping (defaults to the mainnet bootnodes)",
+	}
+	crawlTimeoutFlag = cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Time limit for the crawl",
+		Value: 30 * time.Minute,
+	}
+	noHandshakeFlag = cli.BoolFlag{
+		Name:  "nohandshake",
+		Usage: "Don't perform RLPx handshakes with the crawled nodes",
+	}
+	handshakeTimeoutFlag = cli.DurationFlag{
+		Name:  "handshake-timeout",
+		Usage: "Time limit for a single RLPx handshake",
+		Value: 10 * time.Second,
+	}
+)
+
+func discv4Crawl(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need nodes file as argument")
+	}
+	nodesFile := ctx.Args().First()
+	inputSet := loadNodesJSON(nodesFile)
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("can't generate key: %v", err)
+	}
+	tab := startV4(ctx, key, inputSet)
+	defer tab.Close()
+
+	var pr *prober
+	if !ctx.Bool(noHandshakeFlag.Name) {
+		if pr, err = newProber(key, crawlWorkers, ctx.Duration(handshakeTimeoutFlag.Name)); err != nil {
+			return fmt.Errorf("can't start RLPx prober: %v", err)
+		}
+		defer pr.close()
+	}
+
+	c := newCrawler(inputSet, tab, pr)
+	output := c.run(ctx.Duration(crawlTimeoutFlag.Name))
+	writeNodesJSON(nodesFile, output)
+	return nil
+}
+
+// startV4 starts an ephemeral discovery v4 node. Nodes of the given set are
+// used as additional bootstrap nodes.
+func startV4(ctx *cli.Context, key *ecdsa.PrivateKey, known nodeSet) *discover.Table {
+	addr, _ := net.ResolveUDPAddr("udp", "0.0.0.0:0")
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		utils.Fatalf("Can't create UDP socket: %v", err)
+	}
+	cfg := discover.Config{
+		PrivateKey: key,
+		Bootnodes:  append(parseBootnodes(ctx), known.nodes()...),
+	}
+	tab, err := discover.ListenUDP(conn, cfg)
+	if err != nil {
+		utils.Fatalf("Can't start discovery: %v", err)
+	}
+	return tab
+}
+
+func parseBootnodes(ctx *cli.Context) []*discover.Node {
+	urls := params.MainnetBootnodes
+	if ctx.IsSet(bootnodesFlag.Name) {
+		urls = strings.Split(ctx.String(bootnodesFlag.Name), ",")
+	}
+	nodes := make([]*discover.Node, len(urls))
+	for i, url := range urls {
+		n, err := discover.ParseNode(url)
+		if err != nil {
+			utils.Fatalf("Invalid bootstrap node %q: %v", url, err)
+		}
+		nodes[i] = n
+	}
+	return nodes
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/cmd/utils"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+)
+
+const jsonIndent = "    "
+
+// nodeSet is the nodes.json file format. It holds a set of node records
+// keyed by node ID.
+type nodeSet map[discover.NodeID]nodeJSON
+
+type nodeJSON struct {
+	Record   string    `json:"record"`
+	LastSeen time.Time `json:"lastSeen"` // last successful record request
+
+	// Results of the last successful RLPx handshake.
+	Name       string         `json:"name,omitempty"`
+	Huc        *hucStatusJSON `json:"huc,omitempty"`
+	LastProbed *time.Time     `json:"lastProbed,omitempty"`
+}
+
+// loadNodesJSON reads a node set. A missing file yields an empty set, which
+// allows re-running commands on a fresh output file.
+func loadNodesJSON(file string) nodeSet {
+	var nodes nodeSet
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return make(nodeSet)
+	}
+	if err := loadJSON(file, &nodes); err != nil {
+		utils.Fatalf("Can't load node set: %v", err)
+	}
+	if nodes == nil {
+		nodes = make(nodeSet)
+	}
+	return nodes
+}
+
+func writeNodesJSON(file string, nodes nodeSet) {
+	if err := writeJSON(file, nodes); err != nil {
+		utils.Fatalf("Can't write node set: %v", err)
+	}
+}
+
+// add stores n in the set, marking it as seen at the given time. Nodes without
+// a signed record are ignored.
+func (ns nodeSet) add(n *discover.Node, seen time.Time) {
+	if n.Record() == nil {
+		return
+	}
+	entry := ns[n.ID]
+	entry.Record, entry.LastSeen = n.ENR(), seen
+	ns[n.ID] = entry
+}
+
+// nodes returns the nodes of the set, sorted by ID.
+func (ns nodeSet) nodes() []*discover.Node {
+	result := make([]*discover.Node, 0, len(ns))
+	for id, entry := range ns {
+		n, err := discover.ParseNode(entry.Record)
+		if err != nil {
+			utils.Fatalf("Invalid record of node %x: %v", id[:8], err)
+		}
+		result = append(result, n)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return string(result[i].ID[:]) < string(result[j].ID[:])
+	})
+	return result
+}
+
+func loadJSON(file string, val interface{}) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, val)
+}
+
+func writeJSON(file string, val interface{}) error {
+	data, err := json.MarshalIndent(val, "", jsonIndent)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
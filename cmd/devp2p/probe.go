@@ -0,0 +1,168 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/huc"
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/p2p"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/params"
+	"github.com/happyuc-project/happyuc-go/rlp"
+)
+
+var errProbeTimeout = errors.New("handshake timeout")
+
+// hucStatusJSON is the huc protocol status of a node, as stored in nodes.json.
+type hucStatusJSON struct {
+	ProtocolVersion uint32      `json:"protocolVersion"`
+	NetworkID       uint64      `json:"networkID"`
+	TD              *big.Int    `json:"td"`
+	Head            common.Hash `json:"head"`
+	Genesis         common.Hash `json:"genesis"`
+}
+
+// probeResult is the outcome of a single RLPx handshake attempt.
+type probeResult struct {
+	name   string         // client name from the Hello message
+	status *hucStatusJSON // huc status, nil if the node didn't send one
+	err    error
+}
+
+// prober performs RLPx handshakes with nodes to find out which client and
+// chain they run. It uses a p2p.Server which doesn't listen or discover,
+// dialing the probed nodes as static peers.
+type prober struct {
+	srv     *p2p.Server
+	timeout time.Duration
+
+	mu      sync.Mutex
+	waiting map[discover.NodeID]chan *probeResult
+}
+
+func newProber(key *ecdsa.PrivateKey, maxPeers int, timeout time.Duration) (*prober, error) {
+	pr := &prober{
+		timeout: timeout,
+		waiting: make(map[discover.NodeID]chan *probeResult),
+	}
+	var protos []p2p.Protocol
+	for i, version := range huc.ProtocolVersions {
+		protos = append(protos, p2p.Protocol{
+			Name:    huc.ProtocolName,
+			Version: version,
+			Length:  huc.ProtocolLengths[i],
+			Run:     pr.runProtocol,
+		})
+	}
+	pr.srv = &p2p.Server{Config: p2p.Config{
+		PrivateKey:  key,
+		MaxPeers:    maxPeers,
+		NoDiscovery: true,
+		Name:        common.MakeName("devp2p", params.Version),
+		Protocols:   protos,
+		Logger:      log.New("component", "prober"),
+	}}
+	if err := pr.srv.Start(); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+func (pr *prober) close() {
+	pr.srv.Stop()
+}
+
+// probe connects to n and waits for the result of the handshake.
+func (pr *prober) probe(n *discover.Node) *probeResult {
+	ch := make(chan *probeResult, 1)
+	pr.mu.Lock()
+	pr.waiting[n.ID] = ch
+	pr.mu.Unlock()
+	defer func() {
+		pr.mu.Lock()
+		delete(pr.waiting, n.ID)
+		pr.mu.Unlock()
+		pr.srv.RemovePeer(n)
+	}()
+
+	pr.srv.AddPeer(n)
+	timeout := time.NewTimer(pr.timeout)
+	defer timeout.Stop()
+	select {
+	case res := <-ch:
+		return res
+	case <-timeout.C:
+		return &probeResult{err: errProbeTimeout}
+	}
+}
+
+// runProtocol reads the status message of a connected peer and disconnects.
+func (pr *prober) runProtocol(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	res := &probeResult{name: p.Name()}
+	res.status, res.err = readHucStatus(rw)
+
+	pr.mu.Lock()
+	ch := pr.waiting[p.ID()]
+	pr.mu.Unlock()
+	if ch != nil {
+		select {
+		case ch <- res:
+		default:
+		}
+	}
+	return nil
+}
+
+func readHucStatus(rw p2p.MsgReadWriter) (*hucStatusJSON, error) {
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	defer msg.Discard()
+	if msg.Code != huc.StatusMsg {
+		return nil, fmt.Errorf("first message has code %#x, want status", msg.Code)
+	}
+	if msg.Size > huc.ProtocolMaxMsgSize {
+		return nil, fmt.Errorf("status message too large: %v", msg.Size)
+	}
+	var status struct {
+		ProtocolVersion uint32
+		NetworkId       uint64
+		TD              *big.Int
+		CurrentBlock    common.Hash
+		GenesisBlock    common.Hash
+		Rest            []rlp.RawValue `rlp:"tail"` // fork ID of huc/64 and later
+	}
+	if err := msg.Decode(&status); err != nil {
+		return nil, err
+	}
+	return &hucStatusJSON{
+		ProtocolVersion: status.ProtocolVersion,
+		NetworkID:       status.NetworkId,
+		TD:              status.TD,
+		Head:            status.CurrentBlock,
+		Genesis:         status.GenesisBlock,
+	}, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core/forkid"
+	"github.com/happyuc-project/happyuc-go/p2p"
+)
+
+func TestReadHucStatus(t *testing.T) {
+	want := &hucStatusJSON{
+		ProtocolVersion: 64,
+		NetworkID:       1,
+		TD:              big.NewInt(100),
+		Head:            common.Hash{1},
+		Genesis:         common.Hash{2},
+	}
+	// A huc/64 status carries the fork ID as an additional field.
+	status := []interface{}{
+		want.ProtocolVersion, want.NetworkID, want.TD, want.Head, want.Genesis,
+		forkid.ID{Hash: [4]byte{1, 2, 3, 4}, Next: 5},
+	}
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+	go p2p.Send(app, 0x00, status)
+
+	got, err := readHucStatus(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong status %+v, want %+v", got, want)
+	}
+}
+
+func TestReadHucStatusWrongCode(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+	go p2p.Send(app, 0x02, []interface{}{})
+
+	if _, err := readHucStatus(net); err == nil {
+		t.Fatal("expected error for non-status message")
+	}
+}
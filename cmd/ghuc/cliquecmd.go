@@ -0,0 +1,154 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of happyuc-go.
+//
+// happyuc-go is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// happyuc-go is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with happyuc-go. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/happyuc-project/happyuc-go/cmd/utils"
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/consensus/clique"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	cliqueCommand = cli.Command{
+		Name:     "clique",
+		Usage:    "Inspect the proof-of-authority signers and votes",
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The clique commands inspect the authorization state of a proof-of-authority
+chain from the local database. The node must not be running.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "signers",
+				Usage:     "Print the authorized signers at the given blocks",
+				ArgsUsage: "[<blockHash> | <blockNum>]...",
+				Action:    utils.MigrateFlags(cliqueSigners),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.TestnetFlag,
+					utils.RinkebyFlag,
+				},
+				Description: `
+The arguments are interpreted as block numbers or hashes. Without arguments
+the signers at the current head are printed.`,
+			},
+			{
+				Name:   "proposals",
+				Usage:  "Print the proposals the local signer votes on",
+				Action: utils.MigrateFlags(cliqueProposals),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.TestnetFlag,
+					utils.RinkebyFlag,
+				},
+			},
+		},
+	}
+)
+
+// makeCliqueChain opens the local chain, ensuring it runs the clique engine.
+func makeCliqueChain(ctx *cli.Context) (*core.BlockChain, hucdb.Database, *clique.Clique) {
+	stack := makeFullNode(ctx)
+	chain, chainDb := utils.MakeChain(ctx, stack)
+
+	// Chains switching over from proof-of-work run clique after the switch
+	engine := chain.Engine()
+	if sw, ok := engine.(*consensus.Switch); ok {
+		engine = sw.After()
+	}
+	clique, ok := engine.(*clique.Clique)
+	if !ok {
+		utils.Fatalf("Chain is not running the clique consensus engine")
+	}
+	return chain, chainDb, clique
+}
+
+// cliqueSigners prints the authorized signers at the requested blocks.
+func cliqueSigners(ctx *cli.Context) error {
+	chain, chainDb, engine := makeCliqueChain(ctx)
+	defer chainDb.Close()
+
+	var headers []*types.Header
+	if len(ctx.Args()) == 0 {
+		headers = append(headers, chain.CurrentHeader())
+	}
+	for _, arg := range ctx.Args() {
+		var header *types.Header
+		if hashish(arg) {
+			header = chain.GetHeaderByHash(common.HexToHash(arg))
+		} else {
+			num, _ := strconv.ParseUint(arg, 10, 64)
+			header = chain.GetHeaderByNumber(num)
+		}
+		if header == nil {
+			utils.Fatalf("Block %s not found", arg)
+		}
+		headers = append(headers, header)
+	}
+	for _, header := range headers {
+		snap, err := engine.Snapshot(chain, header)
+		if err != nil {
+			utils.Fatalf("Failed to retrieve snapshot of block #%d: %v", header.Number, err)
+		}
+		signers := make([]common.Address, 0, len(snap.Signers))
+		for signer := range snap.Signers {
+			signers = append(signers, signer)
+		}
+		sort.Slice(signers, func(i, j int) bool {
+			return bytes.Compare(signers[i][:], signers[j][:]) < 0
+		})
+		fmt.Printf("Block #%d [%x…]: %d signers\n", header.Number, header.Hash().Bytes()[:4], len(signers))
+		for _, signer := range signers {
+			fmt.Printf("  %s\n", signer.Hex())
+		}
+		for address, tally := range snap.Tally {
+			fmt.Printf("  pending: %s authorize=%v votes=%d\n", address.Hex(), tally.Authorize, tally.Votes)
+		}
+	}
+	return nil
+}
+
+// cliqueProposals prints the proposals persisted by the local signer.
+func cliqueProposals(ctx *cli.Context) error {
+	_, chainDb, engine := makeCliqueChain(ctx)
+	defer chainDb.Close()
+
+	proposals := engine.Proposals()
+	if len(proposals) == 0 {
+		fmt.Println("No proposals")
+		return nil
+	}
+	for address, proposal := range proposals {
+		expiry := "never"
+		if proposal.Expiry != 0 {
+			expiry = fmt.Sprintf("#%d", proposal.Expiry)
+		}
+		fmt.Printf("%s authorize=%v expiry=%s\n", address.Hex(), proposal.Authorize, expiry)
+	}
+	return nil
+}
@@ -75,11 +75,14 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TestnetFlag,
 			utils.RinkebyFlag,
 			utils.SyncModeFlag,
+			utils.SyncCheckpointFlag,
 			utils.GCModeFlag,
 			utils.HucStatsURLFlag,
 			utils.IdentityFlag,
 			utils.LightServFlag,
 			utils.LightPeersFlag,
+			utils.UltraLightServersFlag,
+			utils.UltraLightFractionFlag,
 			utils.LightKDFFlag,
 		},
 	},
@@ -87,6 +90,7 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			utils.DeveloperFlag,
 			utils.DeveloperPeriodFlag,
+			utils.DeveloperInstantFlag,
 		},
 	},
 	{
@@ -171,9 +175,12 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.ListenPortFlag,
 			utils.MaxPeersFlag,
 			utils.MaxPendingPeersFlag,
+			utils.MaxSubnetPeersFlag,
 			utils.NATFlag,
 			utils.NoDiscoverFlag,
+			utils.HolePunchFlag,
 			utils.DiscoveryV5Flag,
+			utils.DNSDiscoveryFlag,
 			utils.NetrestrictFlag,
 			utils.NodeKeyFileFlag,
 			utils.NodeKeyHexFlag,
@@ -185,9 +192,13 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.MiningEnabledFlag,
 			utils.MinerThreadsFlag,
 			utils.CoinbaseFlag,
+			utils.MinerGasTargetFlag,
+			utils.MinerGasLimitFlag,
 			utils.TargetGasLimitFlag,
 			utils.GasPriceFlag,
 			utils.ExtraDataFlag,
+			utils.StratumAddrFlag,
+			utils.StratumDifficultyFlag,
 		},
 	},
 	{
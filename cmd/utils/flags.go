@@ -33,6 +33,7 @@ import (
 	"github.com/happyuc-project/happyuc-go/common"
 	"github.com/happyuc-project/happyuc-go/common/fdlimit"
 	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/consensus/bft"
 	"github.com/happyuc-project/happyuc-go/consensus/clique"
 	"github.com/happyuc-project/happyuc-go/consensus/huchash"
 	"github.com/happyuc-project/happyuc-go/core"
@@ -147,6 +148,10 @@ var (
 		Name:  "dev.period",
 		Usage: "Block period to use in developer mode (0 = mine only if transaction pending)",
 	}
+	DeveloperInstantFlag = cli.BoolFlag{
+		Name:  "dev.instant",
+		Usage: "Use instant-seal consensus in developer mode, mining on every transaction (ignores --dev.period)",
+	}
 	IdentityFlag = cli.StringFlag{
 		Name:  "identity",
 		Usage: "Custom node name",
@@ -170,6 +175,11 @@ var (
 		Usage: `Blockchain sync mode ("fast", "full", or "light")`,
 		Value: &defaultSyncMode,
 	}
+	SyncCheckpointFlag = TextMarshalerFlag{
+		Name:  "syncfrom.checkpoint",
+		Usage: "Trusted checkpoint (<hash>:<number>) to sync from, skipping the verification of older blocks",
+		Value: new(downloader.Checkpoint),
+	}
 	GCModeFlag = cli.StringFlag{
 		Name:  "gcmode",
 		Usage: `Blockchain garbage collection mode ("full", "archive")`,
@@ -185,6 +195,16 @@ var (
 		Usage: "Maximum number of LES client peers",
 		Value: huc.DefaultConfig.LightPeers,
 	}
+	UltraLightServersFlag = cli.StringFlag{
+		Name:  "ulc.servers",
+		Usage: "Comma separated hnode URLs of trusted LES servers, enabling ultra-light client mode",
+		Value: "",
+	}
+	UltraLightFractionFlag = cli.IntFlag{
+		Name:  "ulc.fraction",
+		Usage: "Percentage of trusted LES servers that need to announce a head in ultra-light mode",
+		Value: huc.DefaultConfig.UltraLightFraction,
+	}
 	LightKDFFlag = cli.BoolFlag{
 		Name:  "lightkdf",
 		Usage: "Reduce key-derivation RAM & CPU usage at some expense of KDF strength",
@@ -217,7 +237,7 @@ var (
 	// Ethash settings
 	EthashCacheDirFlag = DirectoryFlag{
 		Name:  "huchash.cachedir",
-		Usage: "Directory to store the huchash verification caches (default = inside the datadir)",
+		Usage: "Directory to store the huchash verification caches, may be shared between processes (default = inside the datadir)",
 	}
 	EthashCachesInMemoryFlag = cli.IntFlag{
 		Name:  "huchash.cachesinmem",
@@ -327,8 +347,18 @@ var (
 	}
 	TargetGasLimitFlag = cli.Uint64Flag{
 		Name:  "targetgaslimit",
-		Usage: "Target gas limit sets the artificial target gas floor for the blocks to mine",
-		Value: params.GenesisGasLimit,
+		Usage: "Target gas floor for mined blocks (deprecated, use --miner.gastarget)",
+		Value: huc.DefaultConfig.GasFloor,
+	}
+	MinerGasTargetFlag = cli.Uint64Flag{
+		Name:  "miner.gastarget",
+		Usage: "Target gas floor for mined blocks",
+		Value: huc.DefaultConfig.GasFloor,
+	}
+	MinerGasLimitFlag = cli.Uint64Flag{
+		Name:  "miner.gaslimit",
+		Usage: "Target gas ceiling for mined blocks",
+		Value: huc.DefaultConfig.GasCeil,
 	}
 	CoinbaseFlag = cli.StringFlag{
 		Name:  "coinbase",
@@ -344,6 +374,15 @@ var (
 		Name:  "extradata",
 		Usage: "Block extra data set by the miner (default = client version)",
 	}
+	StratumAddrFlag = cli.StringFlag{
+		Name:  "stratum",
+		Usage: "Stratum mining server listening address (e.g. 0.0.0.0:8008, disabled if empty)",
+	}
+	StratumDifficultyFlag = BigFlag{
+		Name:  "stratum.difficulty",
+		Usage: "Default share difficulty assigned to stratum miners",
+		Value: new(big.Int).Lsh(common.Big1, 32),
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -459,6 +498,11 @@ var (
 		Usage: "Maximum number of pending connection attempts (defaults used if set to 0)",
 		Value: 0,
 	}
+	MaxSubnetPeersFlag = cli.IntFlag{
+		Name:  "maxsubnetpeers",
+		Usage: "Maximum number of peers from the same /24 (IPv4) or /56 (IPv6) network (defaults used if set to 0)",
+		Value: 0,
+	}
 	ListenPortFlag = cli.IntFlag{
 		Name:  "port",
 		Usage: "Network listening port",
@@ -496,10 +540,18 @@ var (
 		Name:  "nodiscover",
 		Usage: "Disables the peer discovery mechanism (manual peer addition)",
 	}
+	HolePunchFlag = cli.BoolFlag{
+		Name:  "holepunch",
+		Usage: "Asks discovery nodes to relay UDP/TCP hole punching for peers behind NAT",
+	}
 	DiscoveryV5Flag = cli.BoolFlag{
 		Name:  "v5disc",
 		Usage: "Enables the experimental RLPx V5 (Topic Discovery) mechanism",
 	}
+	DNSDiscoveryFlag = cli.StringFlag{
+		Name:  "discovery.dns",
+		Usage: "Comma separated enrtree:// URLs of DNS node lists used as dial candidates",
+	}
 	NetrestrictFlag = cli.StringFlag{
 		Name:  "netrestrict",
 		Usage: "Restricts network communication to the given IP networks (CIDR masks)",
@@ -839,9 +891,15 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 	if ctx.GlobalIsSet(MaxPendingPeersFlag.Name) {
 		cfg.MaxPendingPeers = ctx.GlobalInt(MaxPendingPeersFlag.Name)
 	}
+	if ctx.GlobalIsSet(MaxSubnetPeersFlag.Name) {
+		cfg.MaxPeersPerSubnet = ctx.GlobalInt(MaxSubnetPeersFlag.Name)
+	}
 	if ctx.GlobalIsSet(NoDiscoverFlag.Name) || lightClient {
 		cfg.NoDiscovery = true
 	}
+	if ctx.GlobalIsSet(HolePunchFlag.Name) {
+		cfg.HolePunch = ctx.GlobalBool(HolePunchFlag.Name)
+	}
 
 	// if we're running a light client or server, force enable the v5 peer discovery
 	// unless it is explicitly disabled with --nodiscover note that explicitly specifying
@@ -853,6 +911,10 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 		cfg.DiscoveryV5 = true
 	}
 
+	if urls := ctx.GlobalString(DNSDiscoveryFlag.Name); urls != "" {
+		cfg.DNSDiscovery = strings.Split(urls, ",")
+	}
+
 	if netrestrict := ctx.GlobalString(NetrestrictFlag.Name); netrestrict != "" {
 		list, err := netutil.ParseNetlist(netrestrict)
 		if err != nil {
@@ -1033,12 +1095,27 @@ func SetHucConfig(ctx *cli.Context, stack *node.Node, cfg *huc.Config) {
 	case ctx.GlobalBool(LightModeFlag.Name):
 		cfg.SyncMode = downloader.LightSync
 	}
+	if ctx.GlobalIsSet(SyncCheckpointFlag.Name) {
+		if cfg.SyncMode == downloader.LightSync {
+			Fatalf("--%s is not supported in light client mode", SyncCheckpointFlag.Name)
+		}
+		cfg.SyncCheckpoint = GlobalTextMarshaler(ctx, SyncCheckpointFlag.Name).(*downloader.Checkpoint)
+	}
 	if ctx.GlobalIsSet(LightServFlag.Name) {
 		cfg.LightServ = ctx.GlobalInt(LightServFlag.Name)
 	}
 	if ctx.GlobalIsSet(LightPeersFlag.Name) {
 		cfg.LightPeers = ctx.GlobalInt(LightPeersFlag.Name)
 	}
+	if ctx.GlobalIsSet(UltraLightServersFlag.Name) {
+		if cfg.SyncMode != downloader.LightSync {
+			Fatalf("--%s is only supported in light client mode", UltraLightServersFlag.Name)
+		}
+		cfg.UltraLightServers = strings.Split(ctx.GlobalString(UltraLightServersFlag.Name), ",")
+	}
+	if ctx.GlobalIsSet(UltraLightFractionFlag.Name) {
+		cfg.UltraLightFraction = ctx.GlobalInt(UltraLightFractionFlag.Name)
+	}
 	if ctx.GlobalIsSet(NetworkIdFlag.Name) {
 		cfg.NetworkId = ctx.GlobalUint64(NetworkIdFlag.Name)
 	}
@@ -1068,6 +1145,25 @@ func SetHucConfig(ctx *cli.Context, stack *node.Node, cfg *huc.Config) {
 	if ctx.GlobalIsSet(GasPriceFlag.Name) {
 		cfg.GasPrice = GlobalBig(ctx, GasPriceFlag.Name)
 	}
+	if ctx.GlobalIsSet(TargetGasLimitFlag.Name) {
+		cfg.GasFloor = ctx.GlobalUint64(TargetGasLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerGasTargetFlag.Name) {
+		cfg.GasFloor = ctx.GlobalUint64(MinerGasTargetFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerGasLimitFlag.Name) {
+		cfg.GasCeil = ctx.GlobalUint64(MinerGasLimitFlag.Name)
+	}
+	if cfg.GasCeil < cfg.GasFloor {
+		log.Warn("Raising gas ceiling to the gas floor", "floor", cfg.GasFloor, "ceil", cfg.GasCeil)
+		cfg.GasCeil = cfg.GasFloor
+	}
+	if ctx.GlobalIsSet(StratumAddrFlag.Name) {
+		cfg.StratumAddr = ctx.GlobalString(StratumAddrFlag.Name)
+	}
+	if ctx.GlobalIsSet(StratumDifficultyFlag.Name) {
+		cfg.StratumDifficulty = GlobalBig(ctx, StratumDifficultyFlag.Name)
+	}
 	if ctx.GlobalIsSet(VMEnableDebugFlag.Name) {
 		// TODO(fjl): force-enable this in --dev mode
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
@@ -1104,7 +1200,11 @@ func SetHucConfig(ctx *cli.Context, stack *node.Node, cfg *huc.Config) {
 		}
 		log.Info("Using developer account", "address", developer.Address)
 
-		cfg.Genesis = core.DeveloperGenesisBlock(uint64(ctx.GlobalInt(DeveloperPeriodFlag.Name)), developer.Address)
+		if ctx.GlobalBool(DeveloperInstantFlag.Name) {
+			cfg.Genesis = core.DeveloperInstantGenesisBlock(developer.Address)
+		} else {
+			cfg.Genesis = core.DeveloperGenesisBlock(uint64(ctx.GlobalInt(DeveloperPeriodFlag.Name)), developer.Address)
+		}
 		if !ctx.GlobalIsSet(GasPriceFlag.Name) {
 			cfg.GasPrice = big.NewInt(1)
 		}
@@ -1178,12 +1278,6 @@ func RegisterHucStatsService(stack *node.Node, url string) {
 	}
 }
 
-// SetupNetwork configures the system for either the main net or some test network.
-func SetupNetwork(ctx *cli.Context) {
-	// TODO(fjl): move target gas limit into config
-	params.TargetGasLimit = ctx.GlobalUint64(TargetGasLimitFlag.Name)
-}
-
 // MakeChainDatabase open an LevelDB using the flags passed to the client and will hard crash if it fails.
 func MakeChainDatabase(ctx *cli.Context, stack *node.Node) hucdb.Database {
 	var (
@@ -1224,20 +1318,14 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		Fatalf("%v", err)
 	}
 	var engine consensus.Engine
-	if config.Clique != nil {
+	if config.Clique != nil && config.CliqueSwitchBlock != nil {
+		engine = consensus.NewSwitch(config.CliqueSwitchBlock, makeEthashVerifier(ctx, stack), clique.New(config.Clique, chainDb))
+	} else if config.Clique != nil {
 		engine = clique.New(config.Clique, chainDb)
+	} else if config.BFT != nil {
+		engine = bft.New(config.BFT, chainDb)
 	} else {
-		engine = huchash.NewFaker()
-		if !ctx.GlobalBool(FakePoWFlag.Name) {
-			engine = huchash.New(huchash.Config{
-				CacheDir:       stack.ResolvePath(huc.DefaultConfig.Ethash.CacheDir),
-				CachesInMem:    huc.DefaultConfig.Ethash.CachesInMem,
-				CachesOnDisk:   huc.DefaultConfig.Ethash.CachesOnDisk,
-				DatasetDir:     stack.ResolvePath(huc.DefaultConfig.Ethash.DatasetDir),
-				DatasetsInMem:  huc.DefaultConfig.Ethash.DatasetsInMem,
-				DatasetsOnDisk: huc.DefaultConfig.Ethash.DatasetsOnDisk,
-			})
-		}
+		engine = makeEthashVerifier(ctx, stack)
 	}
 	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
@@ -1258,6 +1346,19 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 	return chain, chainDb
 }
 
+// makeEthashVerifier creates a verification only ethash engine for the chain
+// tooling, or a fake one if proof-of-work checks were disabled.
+func makeEthashVerifier(ctx *cli.Context, stack *node.Node) consensus.Engine {
+	if ctx.GlobalBool(FakePoWFlag.Name) {
+		return huchash.NewFaker()
+	}
+	return huchash.NewVerifier(huchash.Config{
+		CacheDir:     stack.ResolvePath(huc.DefaultConfig.Ethash.CacheDir),
+		CachesInMem:  huc.DefaultConfig.Ethash.CachesInMem,
+		CachesOnDisk: huc.DefaultConfig.Ethash.CachesOnDisk,
+	})
+}
+
 // MakeConsolePreloads retrieves the absolute paths for the console JavaScript
 // scripts to preload before starting.
 func MakeConsolePreloads(ctx *cli.Context) []string {
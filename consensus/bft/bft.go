@@ -0,0 +1,830 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package bft implements a byzantine fault tolerant proof-of-authority consensus
+// engine with immediate finality.
+package bft
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/accounts"
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/common/hexutil"
+	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/consensus/misc"
+	"github.com/happyuc-project/happyuc-go/core/state"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/p2p"
+	"github.com/happyuc-project/happyuc-go/params"
+	"github.com/happyuc-project/happyuc-go/rlp"
+	"github.com/happyuc-project/happyuc-go/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	checkpointInterval = 1024 // Number of blocks after which to save the vote snapshot to the database
+	inmemorySnapshots  = 128  // Number of recent vote snapshots to keep in memory
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+	inmemoryMessages   = 4096 // Number of recent consensus message hashes to keep for deduplication
+	inmemoryCommits    = 128  // Number of recently finalized blocks to keep the commit seals of
+)
+
+// BFT protocol constants.
+var (
+	epochLength    = uint64(30000) // Default number of blocks after which to checkpoint and reset the pending votes
+	requestTimeout = uint64(10000) // Default number of milliseconds after which an unfinished round is changed
+
+	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // Magic nonce number to vote on adding a new validator
+	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // Magic nonce number to vote on removing a validator.
+
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	defaultDifficulty = big.NewInt(1) // Fixed difficulty of every block, finality makes it meaningless
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of validators is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidCheckpointBeneficiary is returned if a checkpoint/epoch transition
+	// block has a beneficiary set to non-zeroes.
+	errInvalidCheckpointBeneficiary = errors.New("beneficiary in checkpoint block non-zero")
+
+	// errInvalidVote is returned if a nonce value is something else that the two
+	// allowed constants of 0x00..0 or 0xff..f.
+	errInvalidVote = errors.New("vote nonce not 0x00..0 or 0xff..f")
+
+	// errInvalidCheckpointVote is returned if a checkpoint/epoch transition block
+	// has a vote nonce set to non-zeroes.
+	errInvalidCheckpointVote = errors.New("vote nonce in checkpoint block non-zero")
+
+	// errMissingSignature is returned if a block's extra-data section doesn't seem
+	// to contain a 65 byte proposer seal.
+	errMissingSignature = errors.New("extra-data 65 byte seal missing")
+
+	// errExtraValidators is returned if non-checkpoint block contain validator data
+	// in their extra-data fields.
+	errExtraValidators = errors.New("non-checkpoint block contains extra validator list")
+
+	// errInvalidCheckpointValidators is returned if a checkpoint block contains an
+	// invalid list of validators (i.e. doesn't match the ones in the snapshot).
+	errInvalidCheckpointValidators = errors.New("invalid validator list on checkpoint block")
+
+	// errInvalidMixDigest is returned if a block's mix digest isn't the BFT digest.
+	errInvalidMixDigest = errors.New("invalid mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errInvalidVotingChain is returned if an authorization list is attempted to
+	// be modified via out-of-range or non-contiguous headers.
+	errInvalidVotingChain = errors.New("invalid voting chain")
+
+	// errUnauthorized is returned if a header is signed by a non-validator.
+	errUnauthorized = errors.New("unauthorized")
+
+	// errInvalidCommittedSeals is returned if the parent commit seals of a block
+	// are not signed by a quorum of distinct validators of the parent.
+	errInvalidCommittedSeals = errors.New("invalid committed seals")
+
+	// errMissingParentCommit is returned if a block is attempted to be prepared
+	// without knowing the commit seals finalizing its parent.
+	errMissingParentCommit = errors.New("parent commit seals unknown")
+
+	// errNotStarted is returned if a block is attempted to be sealed before the
+	// consensus message processing was started.
+	errNotStarted = errors.New("consensus not started")
+
+	// errStarted is returned if consensus message processing is started twice.
+	errStarted = errors.New("consensus already started")
+)
+
+// SignerFn is a signer callback function to request a hash to be signed by a
+// backing account.
+type SignerFn func(accounts.Account, []byte) ([]byte, error)
+
+// commitHash returns the hash which validators sign to commit to a block.
+func commitHash(hash common.Hash) common.Hash {
+	return crypto.Keccak256Hash(hash.Bytes(), []byte{byte(msgCommit)})
+}
+
+// recoverAddress extracts the HappyUC account address from a signature.
+func recoverAddress(hash common.Hash, signature []byte) (common.Address, error) {
+	pubkey, err := crypto.Ecrecover(hash.Bytes(), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var signer common.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+	return signer, nil
+}
+
+// ecrecover extracts the HappyUC account address of the proposer of a header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	// Retrieve the signature from the header extra-data
+	extra, err := extractExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if len(extra.Seal) != extraSeal {
+		return common.Address{}, errMissingSignature
+	}
+	signer, err := recoverAddress(sigHash(header), extra.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	sigcache.Add(hash, signer)
+	return signer, nil
+}
+
+// GenesisExtra assembles the extra-data of a genesis block authorizing the given
+// initial set of validators.
+func GenesisExtra(vanity []byte, validators []common.Address) ([]byte, error) {
+	header := &types.Header{Extra: make([]byte, extraVanity)}
+	copy(header.Extra, vanity)
+
+	sorted := make([]common.Address, len(validators))
+	copy(sorted, validators)
+	sortAddresses(sorted)
+
+	if err := setExtra(header, &Extra{Validators: sorted, Seal: []byte{}, ParentCommit: [][]byte{}}); err != nil {
+		return nil, err
+	}
+	return header.Extra, nil
+}
+
+// sortAddresses sorts a list of addresses in ascending order.
+func sortAddresses(addresses []common.Address) {
+	for i := 0; i < len(addresses); i++ {
+		for j := i + 1; j < len(addresses); j++ {
+			if bytes.Compare(addresses[i][:], addresses[j][:]) > 0 {
+				addresses[i], addresses[j] = addresses[j], addresses[i]
+			}
+		}
+	}
+}
+
+// quorum returns the number of validators needed to agree on a block, such that
+// any two quorums overlap in at least one honest validator.
+func quorum(validators int) int {
+	return (2*validators + 2) / 3
+}
+
+// faulty returns the maximum number of faulty validators the network tolerates.
+func faulty(validators int) int {
+	return (validators - 1) / 3
+}
+
+// BFT is a byzantine fault tolerant proof-of-authority consensus engine. Blocks
+// are proposed by the validators in a round-robin fashion and finalized the
+// moment a quorum of them signs a commit, the seals of which are stored in the
+// header of the next block. Validators are added and removed by voting, just
+// like with clique.
+type BFT struct {
+	config *params.BFTConfig // Consensus engine configuration parameters
+	db     hucdb.Database    // Database to store and retrieve snapshot checkpoints
+
+	recents    *lru.ARCCache // Snapshots for recent block to speed up reorgs
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+	commits    *lru.ARCCache // Commit seals of recently finalized blocks, backed by the database
+
+	proposals map[common.Address]bool // Current list of proposals we are pushing
+
+	signer common.Address // HappyUC address of the signing key
+	signFn SignerFn       // Signer function to authorize hashes with
+	lock   sync.RWMutex   // Protects the signer fields
+
+	core     *core        // Consensus state machine, nil if not started
+	coreLock sync.RWMutex // Protects the consensus state machine
+
+	peers     map[string]*peer // Peers connected via the consensus sub-protocol
+	known     *lru.ARCCache    // Hashes of recently seen consensus messages
+	peersLock sync.RWMutex     // Protects the peer set
+}
+
+// New creates a BFT proof-of-authority consensus engine with the initial
+// validators set to the ones in the genesis block.
+func New(config *params.BFTConfig, db hucdb.Database) *BFT {
+	// Set any missing consensus parameters to their defaults
+	conf := *config
+	if conf.Epoch == 0 {
+		conf.Epoch = epochLength
+	}
+	if conf.RequestTimeout == 0 {
+		conf.RequestTimeout = requestTimeout
+	}
+	// Allocate the snapshot caches and create the engine
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+	known, _ := lru.NewARC(inmemoryMessages)
+	commits, _ := lru.NewARC(inmemoryCommits)
+
+	return &BFT{
+		config:     &conf,
+		db:         db,
+		recents:    recents,
+		signatures: signatures,
+		commits:    commits,
+		proposals:  make(map[common.Address]bool),
+		peers:      make(map[string]*peer),
+		known:      known,
+	}
+}
+
+// Author implements consensus.Engine, returning the HappyUC address recovered
+// from the proposer seal in the header's extra-data section.
+func (b *BFT) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, b.signatures)
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules. The seal
+// flag decides whether the commit seals finalizing the parent are checked.
+func (b *BFT) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return b.verifyHeader(chain, header, nil, seal)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (b *BFT) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	go func() {
+		for i, header := range headers {
+			err := b.verifyHeader(chain, header, headers[:i], seals[i])
+
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database.
+func (b *BFT) verifyHeader(chain consensus.ChainReader, header *types.Header, parents []*types.Header, seal bool) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time.Cmp(big.NewInt(time.Now().Unix())) > 0 {
+		return consensus.ErrFutureBlock
+	}
+	extra, err := extractExtra(header)
+	if err != nil {
+		return err
+	}
+	// Checkpoint blocks need to enforce zero beneficiary
+	checkpoint := (number % b.config.Epoch) == 0
+	if checkpoint && header.Coinbase != (common.Address{}) {
+		return errInvalidCheckpointBeneficiary
+	}
+	// Nonces must be 0x00..0 or 0xff..f, zeroes enforced on checkpoints
+	if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidVote
+	}
+	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidCheckpointVote
+	}
+	// Ensure that the extra-data contains a validator list on checkpoint, but none otherwise
+	if !checkpoint && len(extra.Validators) != 0 {
+		return errExtraValidators
+	}
+	// Ensure that the mix digest marks the header as a BFT one
+	if header.MixDigest != MixDigest {
+		return errInvalidMixDigest
+	}
+	// Ensure that the block doesn't contain any uncles which are meaningless in PoA
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	// Ensure that the block's difficulty is meaningful
+	if number > 0 && (header.Difficulty == nil || header.Difficulty.Cmp(defaultDifficulty) != 0) {
+		return errInvalidDifficulty
+	}
+	// If all checks passed, validate any special fields for hard forks
+	if err := misc.VerifyForkHashes(chain.Config(), header, false); err != nil {
+		return err
+	}
+	// All basic checks passed, verify cascading fields
+	return b.verifyCascadingFields(chain, header, parents, seal)
+}
+
+// verifyCascadingFields verifies all the header fields that are not standalone,
+// rather depend on a batch of previous headers. The caller may optionally pass
+// in a batch of parents (ascending order) to avoid looking those up from the
+// database. This is useful for concurrently verifying a batch of new headers.
+func (b *BFT) verifyCascadingFields(chain consensus.ChainReader, header *types.Header, parents []*types.Header, seal bool) error {
+	// The genesis block is the always valid dead-end
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	// Ensure that the block's timestamp isn't too close to it's parent
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time.Uint64()+b.config.Period > header.Time.Uint64() {
+		return errInvalidTimestamp
+	}
+	// Retrieve the snapshot needed to verify this header and cache it
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	// If the block is a checkpoint block, verify the validator list
+	if number%b.config.Epoch == 0 {
+		extra, err := extractExtra(header)
+		if err != nil {
+			return err
+		}
+		validators := snap.validators()
+		if len(extra.Validators) != len(validators) {
+			return errInvalidCheckpointValidators
+		}
+		for i, validator := range validators {
+			if extra.Validators[i] != validator {
+				return errInvalidCheckpointValidators
+			}
+		}
+	}
+	// All basic checks passed, verify the seals if requested and return
+	if !seal {
+		return nil
+	}
+	if len(parents) > 0 {
+		parents = parents[:len(parents)-1]
+	}
+	return b.verifySeal(chain, snap, header, parent, parents)
+}
+
+// snapshot retrieves the authorization snapshot at a given point in time.
+func (b *BFT) snapshot(chain consensus.ChainReader, number uint64, hash common.Hash, parents []*types.Header) (*Snapshot, error) {
+	// Search for a snapshot in memory or on disk for checkpoints
+	var (
+		headers []*types.Header
+		snap    *Snapshot
+	)
+	for snap == nil {
+		// If an in-memory snapshot was found, use that
+		if s, ok := b.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		// If an on-disk checkpoint snapshot can be found, use that
+		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(b.config, b.signatures, b.db, hash); err == nil {
+				log.Trace("Loaded voting snapshot form disk", "number", number, "hash", hash)
+				snap = s
+				break
+			}
+		}
+		// If we're at block zero, make a snapshot
+		if number == 0 {
+			genesis := chain.GetHeaderByNumber(0)
+			if err := b.VerifyHeader(chain, genesis, false); err != nil {
+				return nil, err
+			}
+			extra, err := extractExtra(genesis)
+			if err != nil {
+				return nil, err
+			}
+			snap = newSnapshot(b.config, b.signatures, 0, genesis.Hash(), extra.Validators)
+			if err := snap.store(b.db); err != nil {
+				return nil, err
+			}
+			log.Trace("Stored genesis voting snapshot to disk")
+			break
+		}
+		// No snapshot for this header, gather the header and move backward
+		var header *types.Header
+		if len(parents) > 0 {
+			// If we have explicit parents, pick from there (enforced)
+			header = parents[len(parents)-1]
+			if header.Hash() != hash || header.Number.Uint64() != number {
+				return nil, consensus.ErrUnknownAncestor
+			}
+			parents = parents[:len(parents)-1]
+		} else {
+			// No explicit parents (or no more left), reach out to the database
+			header = chain.GetHeader(hash, number)
+			if header == nil {
+				return nil, consensus.ErrUnknownAncestor
+			}
+		}
+		headers = append(headers, header)
+		number, hash = number-1, header.ParentHash
+	}
+	// Previous snapshot found, apply any pending headers on top of it
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	snap, err := snap.apply(headers)
+	if err != nil {
+		return nil, err
+	}
+	b.recents.Add(snap.Hash, snap)
+
+	// If we've generated a new checkpoint snapshot, save to disk
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err = snap.store(b.db); err != nil {
+			return nil, err
+		}
+		log.Trace("Stored voting snapshot to disk", "number", snap.Number, "hash", snap.Hash)
+	}
+	return snap, err
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (b *BFT) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// VerifySeal implements consensus.Engine, checking whether the header was
+// proposed by a validator and carries the commit seals finalizing its parent.
+func (b *BFT) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	// Verifying the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	return b.verifySeal(chain, snap, header, parent, nil)
+}
+
+// verifySeal checks whether the header was proposed by a validator of the parent
+// snapshot and whether its parent was finalized by a quorum of the validators
+// agreeing on it. The caller may optionally pass in a batch of ancestors of the
+// parent (ascending order) to avoid looking those up from the database.
+func (b *BFT) verifySeal(chain consensus.ChainReader, snap *Snapshot, header *types.Header, parent *types.Header, parents []*types.Header) error {
+	proposer, err := ecrecover(header, b.signatures)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Validators[proposer]; !ok {
+		return errUnauthorized
+	}
+	extra, err := extractExtra(header)
+	if err != nil {
+		return err
+	}
+	// The genesis block is final by definition, all others need a commit
+	if parent.Number.Sign() == 0 {
+		if len(extra.ParentCommit) != 0 {
+			return errInvalidCommittedSeals
+		}
+		return nil
+	}
+	committers, err := b.snapshot(chain, parent.Number.Uint64()-1, parent.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	return verifyCommit(committers, parent.Hash(), extra.ParentCommit)
+}
+
+// verifyCommit checks whether the given seals are commits to the block with the
+// given hash, signed by a quorum of distinct validators of the snapshot.
+func verifyCommit(snap *Snapshot, hash common.Hash, seals [][]byte) error {
+	var (
+		digest  = commitHash(hash)
+		signers = make(map[common.Address]struct{})
+	)
+	for _, seal := range seals {
+		signer, err := recoverAddress(digest, seal)
+		if err != nil {
+			return errInvalidCommittedSeals
+		}
+		if _, ok := snap.Validators[signer]; !ok {
+			return errInvalidCommittedSeals
+		}
+		if _, ok := signers[signer]; ok {
+			return errInvalidCommittedSeals
+		}
+		signers[signer] = struct{}{}
+	}
+	if len(signers) < quorum(len(snap.Validators)) {
+		return errInvalidCommittedSeals
+	}
+	return nil
+}
+
+// loadCommit retrieves the commit seals finalizing the given block, from memory
+// or the database.
+func (b *BFT) loadCommit(hash common.Hash) ([][]byte, bool) {
+	if seals, ok := b.commits.Get(hash); ok {
+		return seals.([][]byte), true
+	}
+	blob, err := b.db.Get(append([]byte("bft-commit-"), hash[:]...))
+	if err != nil {
+		return nil, false
+	}
+	var seals [][]byte
+	if err := rlp.DecodeBytes(blob, &seals); err != nil {
+		return nil, false
+	}
+	b.commits.Add(hash, seals)
+	return seals, true
+}
+
+// storeCommit saves the commit seals finalizing the given block into memory and
+// the database, so the next block can be proposed even after a restart.
+func (b *BFT) storeCommit(hash common.Hash, seals [][]byte) {
+	b.commits.Add(hash, seals)
+
+	blob, err := rlp.EncodeToBytes(seals)
+	if err != nil {
+		log.Error("Failed to encode commit seals", "err", err)
+		return
+	}
+	if err := b.db.Put(append([]byte("bft-commit-"), hash[:]...), blob); err != nil {
+		log.Error("Failed to store commit seals", "hash", hash, "err", err)
+	}
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (b *BFT) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	// If the block isn't a checkpoint, cast a random vote (good enough for now)
+	header.Coinbase = common.Address{}
+	header.Nonce = types.BlockNonce{}
+
+	number := header.Number.Uint64()
+
+	// Assemble the voting snapshot to check which votes make sense
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if number%b.config.Epoch != 0 {
+		b.lock.RLock()
+
+		// Gather all the proposals that make sense voting on
+		addresses := make([]common.Address, 0, len(b.proposals))
+		for address, authorize := range b.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		// If there's pending proposals, cast a vote on them
+		if len(addresses) > 0 {
+			header.Coinbase = addresses[rand.Intn(len(addresses))]
+			if b.proposals[header.Coinbase] {
+				copy(header.Nonce[:], nonceAuthVote)
+			} else {
+				copy(header.Nonce[:], nonceDropVote)
+			}
+		}
+		b.lock.RUnlock()
+	}
+	// Set the fixed difficulty and mark the header as a BFT one
+	header.Difficulty = new(big.Int).Set(defaultDifficulty)
+	header.MixDigest = MixDigest
+
+	// Ensure the extra data has all it's components, including the commit seals
+	// finalizing the parent block
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	extra := &Extra{Seal: []byte{}, ParentCommit: [][]byte{}}
+	if number%b.config.Epoch == 0 {
+		extra.Validators = snap.validators()
+	}
+	if number > 1 {
+		seals, ok := b.loadCommit(header.ParentHash)
+		if !ok {
+			return errMissingParentCommit
+		}
+		extra.ParentCommit = seals
+	}
+	if err := setExtra(header, extra); err != nil {
+		return err
+	}
+
+	// Ensure the timestamp has the correct delay
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Time = new(big.Int).Add(parent.Time, new(big.Int).SetUint64(b.config.Period))
+	if header.Time.Int64() < time.Now().Unix() {
+		header.Time = big.NewInt(time.Now().Unix())
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given, and returns the final block.
+func (b *BFT) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// No block rewards in PoA, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts), nil
+}
+
+// Authorize injects a private key into the consensus engine to propose and
+// commit new blocks with.
+func (b *BFT) Authorize(signer common.Address, signFn SignerFn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.signer = signer
+	b.signFn = signFn
+}
+
+// Seal implements consensus.Engine, signing the block as its proposer and running
+// it through consensus. The sealed block is only returned if the local proposal
+// is committed, otherwise the method waits until aborted.
+func (b *BFT) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil, errUnknownBlock
+	}
+	// Don't hold the signer fields for the entire sealing procedure
+	b.lock.RLock()
+	signer, signFn := b.signer, b.signFn
+	b.lock.RUnlock()
+
+	// Bail out if we're unauthorized to propose a block
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, authorized := snap.Validators[signer]; !authorized {
+		return nil, errUnauthorized
+	}
+	b.coreLock.RLock()
+	core := b.core
+	b.coreLock.RUnlock()
+
+	if core == nil {
+		return nil, errNotStarted
+	}
+	// Sweet, the protocol permits us to propose the block, wait for our time
+	delay := time.Unix(header.Time.Int64(), 0).Sub(time.Now()) // nolint: gosimple
+	log.Trace("Waiting for slot to propose", "delay", common.PrettyDuration(delay))
+
+	select {
+	case <-stop:
+		return nil, nil
+	case <-time.After(delay):
+	}
+	// Sign the proposal and hand it over to consensus
+	extra, err := extractExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	extra.Seal, err = signFn(accounts.Account{Address: signer}, sigHash(header).Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := setExtra(header, extra); err != nil {
+		return nil, err
+	}
+
+	result := core.request(block.WithSeal(header))
+	select {
+	case sealed := <-result:
+		return sealed, nil
+	case <-stop:
+		// The proposal might have been committed concurrently, don't lose it
+		core.cancel(result)
+		select {
+		case sealed := <-result:
+			return sealed, nil
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the fixed
+// difficulty of BFT blocks, as finality makes it meaningless.
+func (b *BFT) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(defaultDifficulty)
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC API to allow
+// controlling the validator voting.
+func (b *BFT) APIs(chain consensus.ChainReader) []rpc.API {
+	return []rpc.API{{
+		Namespace: "bft",
+		Version:   "1.0",
+		Service:   &API{chain: chain, bft: b},
+		Public:    false,
+	}}
+}
+
+// Protocols returns the sub-protocol used to exchange consensus messages.
+func (b *BFT) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    protocolName,
+		Version: protocolVersion,
+		Length:  protocolLength,
+		Run:     b.runPeer,
+	}}
+}
+
+// Start begins processing consensus messages on top of the current head of the
+// chain. Blocks finalized while the local node isn't their proposer are imported
+// via insert.
+func (b *BFT) Start(chain consensus.ChainReader, insert func(block *types.Block) error) error {
+	b.coreLock.Lock()
+	defer b.coreLock.Unlock()
+
+	if b.core != nil {
+		return errStarted
+	}
+	b.core = newCore(b, chain, insert)
+	b.core.start(chain.CurrentHeader())
+	return nil
+}
+
+// Stop terminates consensus message processing.
+func (b *BFT) Stop() error {
+	b.coreLock.Lock()
+	defer b.coreLock.Unlock()
+
+	if b.core != nil {
+		b.core.stop()
+		b.core = nil
+	}
+	return nil
+}
+
+// NewChainHead moves consensus on to the block following the new head.
+func (b *BFT) NewChainHead(head *types.Header) {
+	b.coreLock.RLock()
+	defer b.coreLock.RUnlock()
+
+	if b.core != nil {
+		b.core.newHead(head)
+	}
+}
@@ -0,0 +1,560 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/accounts"
+	"github.com/happyuc-project/happyuc-go/common"
+	chaincore "github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/core/vm"
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/p2p"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/params"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// testNode is an in-process validator running the BFT engine on its own chain.
+type testNode struct {
+	id     discover.NodeID
+	key    *ecdsa.PrivateKey
+	addr   common.Address
+	db     hucdb.Database
+	config *params.ChainConfig
+	engine *BFT
+	chain  *chaincore.BlockChain
+	quit   chan struct{}
+}
+
+// newTestNetwork creates a set of validators sharing the same genesis block. Only
+// the first online ones are started, the rest are offline validators.
+func newTestNetwork(t *testing.T, validators int, online int, timeout uint64) []*testNode {
+	keys := make([]*ecdsa.PrivateKey, validators)
+	addrs := make([]common.Address, validators)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	extra, err := GenesisExtra(nil, addrs)
+	if err != nil {
+		t.Fatalf("failed to create genesis extra-data: %v", err)
+	}
+	config := *params.AllBFTProtocolChanges
+	config.BFT = &params.BFTConfig{Epoch: 30000, RequestTimeout: timeout}
+
+	genesis := &chaincore.Genesis{
+		Config:     &config,
+		ExtraData:  extra,
+		GasLimit:   params.GenesisGasLimit,
+		Difficulty: big.NewInt(1),
+		Mixhash:    MixDigest,
+	}
+	nodes := make([]*testNode, online)
+	for i := range nodes {
+		db, _ := hucdb.NewMemDatabase()
+		genesis.MustCommit(db)
+
+		nodes[i] = &testNode{id: discover.NodeID{byte(i + 1)}, key: keys[i], addr: addrs[i], db: db, config: &config}
+		nodes[i].start(t)
+	}
+	connectTestNodes(nodes)
+	return nodes
+}
+
+// start runs the validator on top of its database, just like after a restart.
+func (n *testNode) start(t *testing.T) {
+	n.engine = New(n.config.BFT, n.db)
+	n.engine.Authorize(n.addr, func(account accounts.Account, hash []byte) ([]byte, error) {
+		return crypto.Sign(hash, n.key)
+	})
+	chain, err := chaincore.NewBlockChain(n.db, nil, n.config, n.engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	n.chain, n.quit = chain, make(chan struct{})
+
+	n.engine.Start(chain, func(block *types.Block) error {
+		_, err := chain.InsertChain(types.Blocks{block})
+		return err
+	})
+	// Feed new chain heads to the engine, just like the node does
+	var (
+		engine = n.engine
+		quit   = n.quit
+		heads  = make(chan chaincore.ChainHeadEvent, 16)
+		sub    = chain.SubscribeChainHeadEvent(heads)
+	)
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-heads:
+				engine.NewChainHead(ev.Block.Header())
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+// connectTestNodes connects all the given validators with each other.
+func connectTestNodes(nodes []*testNode) {
+	for i := 0; i < len(nodes); i++ {
+		for j := i + 1; j < len(nodes); j++ {
+			a, b := p2p.MsgPipe()
+			go nodes[i].engine.runPeer(p2p.NewPeer(nodes[j].id, "", nil), a)
+			go nodes[j].engine.runPeer(p2p.NewPeer(nodes[i].id, "", nil), b)
+		}
+	}
+	// Wait for all connections to be registered, so no early message is lost
+	for _, node := range nodes {
+		for {
+			node.engine.peersLock.RLock()
+			peers := len(node.engine.peers)
+			node.engine.peersLock.RUnlock()
+
+			if peers == len(nodes)-1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// close tears down a test validator.
+func (n *testNode) close() {
+	close(n.quit)
+	n.engine.Stop()
+	n.chain.Stop()
+}
+
+// seal assembles the next block on top of the node's head and runs it through
+// consensus. If the local proposal is committed, it's imported just like the
+// miner would do.
+func (n *testNode) seal(t *testing.T, stop chan struct{}) {
+	parent := n.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   parent.GasLimit(),
+		Extra:      []byte("bft test"),
+	}
+	if err := n.engine.Prepare(n.chain, header); err != nil {
+		t.Errorf("failed to prepare header: %v", err)
+		return
+	}
+	statedb, _ := n.chain.StateAt(parent.Root())
+	block, _ := n.engine.Finalize(n.chain, header, statedb, nil, nil, nil)
+
+	go func() {
+		sealed, err := n.engine.Seal(n.chain, block, stop)
+		if err != nil {
+			t.Errorf("failed to seal block: %v", err)
+		}
+		if sealed != nil {
+			if _, err := n.chain.InsertChain(types.Blocks{sealed}); err != nil {
+				t.Errorf("failed to import sealed block: %v", err)
+			}
+		}
+	}()
+}
+
+// waitHead waits until all nodes import a block at the given height and checks
+// that they agree on it.
+func waitHead(t *testing.T, nodes []*testNode, number uint64) *types.Block {
+	deadline := time.Now().Add(10 * time.Second)
+	for _, node := range nodes {
+		for node.chain.CurrentBlock().NumberU64() < number {
+			if time.Now().After(deadline) {
+				t.Fatalf("node %x: block #%d not committed in time", node.addr, number)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	head := nodes[0].chain.GetBlockByNumber(number)
+	for _, node := range nodes[1:] {
+		if block := node.chain.GetBlockByNumber(number); block.Hash() != head.Hash() {
+			t.Fatalf("node %x: block #%d mismatch: have %x, want %x", node.addr, number, block.Hash(), head.Hash())
+		}
+	}
+	return head
+}
+
+// Tests that a network of validators agrees on and finalizes a sequence of blocks.
+func TestCommit(t *testing.T) {
+	nodes := newTestNetwork(t, 4, 4, 10000)
+	defer func() {
+		for _, node := range nodes {
+			node.close()
+		}
+	}()
+	for number := uint64(1); number <= 3; number++ {
+		stop := make(chan struct{})
+		for _, node := range nodes {
+			node.seal(t, stop)
+		}
+		head := waitHead(t, nodes, number)
+		close(stop)
+
+		// Every block past the first must carry the commit finalizing its parent
+		extra, err := extractExtra(head.Header())
+		if err != nil {
+			t.Fatalf("block #%d: failed to decode extra-data: %v", number, err)
+		}
+		if number == 1 && len(extra.ParentCommit) != 0 {
+			t.Fatalf("block #%d: commit seals for genesis present", number)
+		}
+		if number > 1 && len(extra.ParentCommit) < quorum(4) {
+			t.Fatalf("block #%d: parent commit seal count mismatch: have %d, want >= %d", number, len(extra.ParentCommit), quorum(4))
+		}
+		for _, node := range nodes {
+			if err := node.engine.VerifyHeader(node.chain, head.Header(), true); err != nil {
+				t.Fatalf("node %x: block #%d: failed to verify header: %v", node.addr, number, err)
+			}
+		}
+		// Every validator must have the block proposed in the first round
+		snap, _ := nodes[0].engine.snapshot(nodes[0].chain, number-1, head.ParentHash(), nil)
+		proposer, _ := nodes[0].engine.Author(head.Header())
+		if want := snap.validators()[number%4]; proposer != want {
+			t.Errorf("block #%d: proposer mismatch: have %x, want %x", number, proposer, want)
+		}
+	}
+}
+
+// Tests that block production resumes after every validator restarts, the commit
+// seals finalizing the head being persisted.
+func TestRestart(t *testing.T) {
+	nodes := newTestNetwork(t, 4, 4, 10000)
+	for number := uint64(1); number <= 2; number++ {
+		stop := make(chan struct{})
+		for _, node := range nodes {
+			node.seal(t, stop)
+		}
+		waitHead(t, nodes, number)
+		close(stop)
+	}
+	for _, node := range nodes {
+		node.close()
+	}
+	for _, node := range nodes {
+		node.start(t)
+	}
+	connectTestNodes(nodes)
+	defer func() {
+		for _, node := range nodes {
+			node.close()
+		}
+	}()
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for _, node := range nodes {
+		node.seal(t, stop)
+	}
+	head := waitHead(t, nodes, 3)
+
+	extra, err := extractExtra(head.Header())
+	if err != nil {
+		t.Fatalf("failed to decode extra-data: %v", err)
+	}
+	if len(extra.ParentCommit) < quorum(4) {
+		t.Fatalf("parent commit seal count mismatch: have %d, want >= %d", len(extra.ParentCommit), quorum(4))
+	}
+}
+
+// Tests that the commit seals of a head which wasn't committed locally are
+// rebuilt from the commit messages of the validators.
+func TestParentCommitRebuild(t *testing.T) {
+	nodes := newTestNetwork(t, 4, 4, 10000)
+	defer func() {
+		for _, node := range nodes {
+			node.close()
+		}
+	}()
+	for number := uint64(1); number <= 2; number++ {
+		stop := make(chan struct{})
+		for _, node := range nodes {
+			node.seal(t, stop)
+		}
+		waitHead(t, nodes, number)
+		close(stop)
+	}
+	// Forget the seals of the head and run consensus on top of it without them
+	node := nodes[0]
+	head := node.chain.CurrentBlock().Header()
+	node.db.Delete(append([]byte("bft-commit-"), head.Hash().Bytes()...))
+
+	engine := New(node.config.BFT, node.db)
+	c := newCore(engine, node.chain, nil)
+	c.start(head)
+	defer c.stop()
+
+	commit := func(n *testNode, hash common.Hash) *message {
+		seal, _ := crypto.Sign(commitHash(hash).Bytes(), n.key)
+		return &message{Code: msgCommit, Sequence: head.Number.Uint64(), Digest: hash, CommittedSeal: seal, address: n.addr}
+	}
+	// Commits to other blocks and with forged seals are ignored
+	if err := c.handleMessage(commit(nodes[1], common.Hash{0x01})); err != errOldMessage {
+		t.Fatalf("foreign commit error mismatch: have %v, want %v", err, errOldMessage)
+	}
+	forged := commit(nodes[1], head.Hash())
+	forged.address = nodes[2].addr
+	if err := c.handleMessage(forged); err != errInvalidCommitSeal {
+		t.Fatalf("forged commit error mismatch: have %v, want %v", err, errInvalidCommitSeal)
+	}
+	// The seals are stored once a quorum of validators committed
+	for i := 0; i < quorum(4); i++ {
+		if _, ok := engine.loadCommit(head.Hash()); ok {
+			t.Fatalf("seals rebuilt from %d commits", i)
+		}
+		if err := c.handleMessage(commit(nodes[i], head.Hash())); err != nil {
+			t.Fatalf("commit %d: failed to process: %v", i, err)
+		}
+	}
+	seals, ok := New(node.config.BFT, node.db).loadCommit(head.Hash())
+	if !ok {
+		t.Fatalf("seals not rebuilt")
+	}
+	snap, _ := engine.snapshot(node.chain, head.Number.Uint64()-1, head.ParentHash, nil)
+	if err := verifyCommit(snap, head.Hash(), seals); err != nil {
+		t.Fatalf("rebuilt seals invalid: %v", err)
+	}
+}
+
+// Tests that validators change round and still finalize a block if the proposer
+// of the first round is offline.
+func TestRoundChange(t *testing.T) {
+	nodes := newTestNetwork(t, 4, 4, 100)
+
+	// Take the proposer of the first round of block #1 offline
+	snap, _ := nodes[0].engine.snapshot(nodes[0].chain, 0, nodes[0].chain.Genesis().Hash(), nil)
+	offline := snap.validators()[1]
+
+	var online []*testNode
+	for _, node := range nodes {
+		if node.addr == offline {
+			node.close()
+			continue
+		}
+		online = append(online, node)
+	}
+	defer func() {
+		for _, node := range online {
+			node.close()
+		}
+	}()
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for _, node := range online {
+		node.seal(t, stop)
+	}
+	head := waitHead(t, online, 1)
+
+	if proposer, _ := online[0].engine.Author(head.Header()); proposer == offline {
+		t.Fatalf("block proposed by offline validator")
+	}
+	if err := online[0].engine.VerifySeal(online[0].chain, head.Header()); err != nil {
+		t.Fatalf("failed to verify seal: %v", err)
+	}
+}
+
+// Tests that blocks are only accepted if their parent commit was signed by a
+// quorum of distinct validators of the parent, and only if seals are verified.
+func TestParentCommitVerification(t *testing.T) {
+	nodes := newTestNetwork(t, 4, 4, 10000)
+	defer func() {
+		for _, node := range nodes {
+			node.close()
+		}
+	}()
+	for number := uint64(1); number <= 2; number++ {
+		stop := make(chan struct{})
+		for _, node := range nodes {
+			node.seal(t, stop)
+		}
+		waitHead(t, nodes, number)
+		close(stop)
+	}
+	var (
+		node   = nodes[0]
+		head   = node.chain.GetBlockByNumber(2).Header()
+		parent = node.chain.GetBlockByNumber(1).Header()
+	)
+	// Find the proposer of the block to be able to re-sign tampered versions
+	proposer, _ := node.engine.Author(head)
+	var key *ecdsa.PrivateKey
+	for _, n := range nodes {
+		if n.addr == proposer {
+			key = n.key
+		}
+	}
+	resign := func(commit [][]byte) *types.Header {
+		header := types.CopyHeader(head)
+		extra, _ := extractExtra(header)
+		extra.ParentCommit, extra.Seal = commit, []byte{}
+		setExtra(header, extra)
+
+		extra.Seal, _ = crypto.Sign(sigHash(header).Bytes(), key)
+		setExtra(header, extra)
+		return header
+	}
+	extra, _ := extractExtra(head)
+	commit := extra.ParentCommit
+
+	forged, _ := crypto.GenerateKey()
+	foreign, _ := crypto.Sign(commitHash(parent.Hash()).Bytes(), forged)
+	other, _ := crypto.Sign(commitHash(common.Hash{0x01}).Bytes(), nodes[1].key)
+
+	tests := []struct {
+		commit [][]byte
+		err    error
+	}{
+		{commit, nil},
+		{commit[:quorum(4)-1], errInvalidCommittedSeals},
+		{append([][]byte{commit[0]}, commit[:quorum(4)-1]...), errInvalidCommittedSeals},
+		{append([][]byte{foreign}, commit[:quorum(4)-1]...), errInvalidCommittedSeals},
+		{append([][]byte{other}, commit[:quorum(4)-1]...), errInvalidCommittedSeals},
+		{nil, errInvalidCommittedSeals},
+	}
+	for i, tt := range tests {
+		header := resign(tt.commit)
+		if err := node.engine.VerifyHeader(node.chain, header, true); err != tt.err {
+			t.Errorf("test %d: sealed verification error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		if err := node.engine.VerifyHeader(node.chain, header, false); err != nil {
+			t.Errorf("test %d: unsealed verification failed: %v", i, err)
+		}
+	}
+}
+
+// Tests that validator votes are tallied correctly, passing once a majority of
+// the validators agree and being discarded on checkpoints.
+func TestVoting(t *testing.T) {
+	keys := make(map[string]*ecdsa.PrivateKey)
+	address := func(name string) common.Address {
+		if keys[name] == nil {
+			keys[name], _ = crypto.GenerateKey()
+		}
+		return crypto.PubkeyToAddress(keys[name].PublicKey)
+	}
+	type vote struct {
+		validator string
+		voted     string
+		auth      bool
+	}
+	tests := []struct {
+		epoch      uint64
+		validators []string
+		votes      []vote
+		results    []string
+		failure    error
+	}{
+		{
+			// A single vote out of three doesn't pass
+			validators: []string{"A", "B", "C"},
+			votes:      []vote{{"A", "D", true}},
+			results:    []string{"A", "B", "C"},
+		}, {
+			// Two votes out of three authorize a new validator
+			validators: []string{"A", "B", "C"},
+			votes:      []vote{{"A", "D", true}, {"B", "D", true}},
+			results:    []string{"A", "B", "C", "D"},
+		}, {
+			// Repeated votes of the same validator are only counted once
+			validators: []string{"A", "B", "C"},
+			votes:      []vote{{"A", "D", true}, {"A", "D", true}},
+			results:    []string{"A", "B", "C"},
+		}, {
+			// Deauthorizing needs a strict majority of the current validators
+			validators: []string{"A", "B", "C", "D"},
+			votes:      []vote{{"B", "A", false}, {"C", "A", false}, {"D", "A", false}},
+			results:    []string{"B", "C", "D"},
+		}, {
+			// Votes of a deauthorized validator are discarded along with it
+			validators: []string{"A", "B", "C"},
+			votes:      []vote{{"A", "D", true}, {"B", "A", false}, {"C", "A", false}, {"B", "D", true}},
+			results:    []string{"B", "C"},
+		}, {
+			// Checkpoints discard all pending votes
+			epoch:      3,
+			validators: []string{"A", "B", "C"},
+			votes:      []vote{{"A", "D", true}, {"B", "", false}, {"C", "", false}, {"B", "D", true}},
+			results:    []string{"A", "B", "C"},
+		}, {
+			// Deauthorized validators can't propose anymore
+			validators: []string{"A", "B", "C"},
+			votes:      []vote{{"B", "A", false}, {"C", "A", false}, {"A", "", false}},
+			failure:    errUnauthorized,
+		},
+	}
+	for i, tt := range tests {
+		validators := make([]common.Address, len(tt.validators))
+		for j, validator := range tt.validators {
+			validators[j] = address(validator)
+		}
+		headers := make([]*types.Header, len(tt.votes))
+		for j, v := range tt.votes {
+			headers[j] = &types.Header{Number: big.NewInt(int64(j) + 1), Extra: make([]byte, extraVanity)}
+			if v.voted != "" {
+				headers[j].Coinbase = address(v.voted)
+				if v.auth {
+					copy(headers[j].Nonce[:], nonceAuthVote)
+				}
+			}
+			setExtra(headers[j], &Extra{Seal: []byte{}})
+
+			extra := &Extra{}
+			extra.Seal, _ = crypto.Sign(sigHash(headers[j]).Bytes(), keys[v.validator])
+			setExtra(headers[j], extra)
+		}
+		if tt.epoch == 0 {
+			tt.epoch = epochLength
+		}
+		sigcache, _ := lru.NewARC(inmemorySignatures)
+		snap, err := newSnapshot(&params.BFTConfig{Epoch: tt.epoch}, sigcache, 0, common.Hash{}, validators).apply(headers)
+		if err != tt.failure {
+			t.Errorf("test %d: failure mismatch: have %v, want %v", i, err, tt.failure)
+		}
+		if err != nil {
+			continue
+		}
+		want := make([]common.Address, len(tt.results))
+		for j, result := range tt.results {
+			want[j] = address(result)
+		}
+		sortAddresses(want)
+
+		have := snap.validators()
+		if len(have) != len(want) {
+			t.Errorf("test %d: validator count mismatch: have %x, want %x", i, have, want)
+			continue
+		}
+		for j := range want {
+			if have[j] != want[j] {
+				t.Errorf("test %d, validator %d: mismatch: have %x, want %x", i, j, have[j], want[j])
+			}
+		}
+	}
+}
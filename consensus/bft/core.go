@@ -0,0 +1,679 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/accounts"
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/rlp"
+)
+
+const (
+	maxBacklog         = 1024 // Maximum number of future messages to keep around
+	maxFutureSequences = 16   // Maximum number of blocks ahead to accept messages for
+	maxTimeoutShift    = 8    // Maximum exponent of the round timeout backoff
+)
+
+var (
+	// errOldMessage is returned if a consensus message belongs to a past sequence
+	// or round.
+	errOldMessage = errors.New("old consensus message")
+
+	// errFutureMessage is returned if a consensus message belongs to a sequence
+	// too far in the future to be kept around.
+	errFutureMessage = errors.New("future consensus message")
+
+	// errNotValidator is returned if a consensus message was not sent by one of
+	// the current validators.
+	errNotValidator = errors.New("message from non-validator")
+
+	// errNotProposer is returned if a proposal was not sent by the proposer of
+	// the current round.
+	errNotProposer = errors.New("proposal from non-proposer")
+
+	// errInvalidProposal is returned if a proposal doesn't extend the current
+	// head or is otherwise malformed.
+	errInvalidProposal = errors.New("invalid proposal")
+
+	// errLockedProposal is returned if a proposal differs from the one the local
+	// validator is locked on.
+	errLockedProposal = errors.New("proposal differs from locked one")
+
+	// errInvalidCommitSeal is returned if the committed seal of a commit message
+	// wasn't signed by its sender.
+	errInvalidCommitSeal = errors.New("invalid committed seal")
+
+	// errCoreStopped is returned if a message arrives after consensus was stopped.
+	errCoreStopped = errors.New("consensus stopped")
+)
+
+// roundState is the progress of agreeing on a proposal within a round.
+type roundState int
+
+const (
+	stateAcceptRequest roundState = iota // Waiting for the proposal of the round
+	statePreprepared                     // Proposal accepted, collecting prepares
+	statePrepared                        // Quorum of prepares seen, collecting commits
+	stateCommitted                       // Quorum of commits seen, block finalized
+)
+
+// core is the consensus state machine agreeing on the block following the local
+// head. Each block (sequence) goes through a number of rounds, each of which has
+// a designated proposer. The proposal is prepared and committed by a quorum of
+// validators, or the round is abandoned via round changes if that doesn't happen
+// in time.
+type core struct {
+	bft    *BFT
+	chain  consensus.ChainReader
+	insert func(block *types.Block) error
+
+	sequence   uint64           // Number of the block being agreed on
+	round      uint64           // Current round of the sequence
+	parent     *types.Header    // Parent of the block being agreed on
+	validators []common.Address // Validators of the sequence in ascending order
+	state      roundState       // Progress within the current round
+
+	proposal    *types.Block      // Block proposed in the current round
+	locked      *types.Block      // Block prepared in an earlier round, only one to accept
+	lockedRound uint64            // Round in which the locked block was prepared
+	pending     *types.Block      // Local block waiting to be proposed
+	results     chan *types.Block // Channel to deliver the local block to once committed
+
+	prepares     map[common.Address]*message            // Prepares of the current round
+	commits      map[common.Address]*message            // Commits of the current round
+	roundChanges map[uint64]map[common.Address]*message // Round changes for future rounds
+	sentRound    uint64                                 // Highest round a round change was sent for
+	backlog      []*message                             // Messages of future sequences or rounds
+
+	parentCommits map[common.Address]*message // Commits of the parent, collected if it wasn't committed locally
+
+	timer   *time.Timer // Round change timer of the current round
+	stopped bool        // Whether consensus message processing was stopped
+
+	lock sync.Mutex
+}
+
+// newCore creates a consensus state machine on top of the given chain.
+func newCore(bft *BFT, chain consensus.ChainReader, insert func(block *types.Block) error) *core {
+	return &core{
+		bft:    bft,
+		chain:  chain,
+		insert: insert,
+	}
+}
+
+// start begins agreeing on the block following the given head.
+func (c *core) start(head *types.Header) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.moveTo(head)
+}
+
+// stop terminates consensus, dropping any further messages.
+func (c *core) stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.stopped = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+// newHead moves consensus on to the block following the new head, if it's not
+// already past it.
+func (c *core) newHead(head *types.Header) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.stopped && head.Number.Uint64() >= c.sequence {
+		c.moveTo(head)
+	}
+}
+
+// request submits a local block to be proposed once the local validator is the
+// proposer of a round. The returned channel receives the block if it's finalized.
+func (c *core) request(block *types.Block) chan *types.Block {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pending, c.results = block, make(chan *types.Block, 1)
+	if !c.stopped && c.state == stateAcceptRequest && c.isProposer() {
+		c.propose()
+	}
+	return c.results
+}
+
+// cancel stops delivering the committed local block to the given channel.
+func (c *core) cancel(results chan *types.Block) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.results == results {
+		c.results = nil
+	}
+}
+
+// handleMessage processes a consensus message received from the network. An
+// error is returned if the message is invalid or useless, in which case it must
+// not be relayed further.
+func (c *core) handleMessage(msg *message) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.stopped {
+		return errCoreStopped
+	}
+	return c.handle(msg)
+}
+
+// moveTo starts agreeing on the block following the given head. The lock must be
+// held by the caller.
+func (c *core) moveTo(head *types.Header) {
+	// Keep the commits to the new head seen so far, they rebuild its commit seals
+	// if it was imported instead of committed locally
+	var commits []*message
+	if c.sequence == head.Number.Uint64() {
+		for _, msg := range c.commits {
+			commits = append(commits, msg)
+		}
+	}
+	for _, msg := range c.backlog {
+		if msg.Code == msgCommit && msg.Sequence == head.Number.Uint64() {
+			commits = append(commits, msg)
+		}
+	}
+	c.sequence, c.parent = head.Number.Uint64()+1, head
+
+	c.validators = nil
+	if snap, err := c.bft.snapshot(c.chain, head.Number.Uint64(), head.Hash(), nil); err != nil {
+		log.Warn("Failed to retrieve validator snapshot", "number", head.Number, "hash", head.Hash(), "err", err)
+	} else {
+		c.validators = snap.validators()
+	}
+	c.locked, c.lockedRound = nil, 0
+	if c.pending != nil && c.pending.ParentHash() != head.Hash() {
+		c.pending, c.results = nil, nil
+	}
+	c.roundChanges = make(map[uint64]map[common.Address]*message)
+	c.sentRound = 0
+
+	c.parentCommits = make(map[common.Address]*message)
+	for _, msg := range commits {
+		c.handleParentCommit(msg)
+	}
+	c.startRound(0)
+}
+
+// startRound resets the round state and, if the local validator is the proposer
+// of the new round, proposes a block. The lock must be held by the caller.
+func (c *core) startRound(round uint64) {
+	log.Trace("Starting consensus round", "sequence", c.sequence, "round", round)
+
+	c.round, c.state, c.proposal = round, stateAcceptRequest, nil
+	c.prepares = make(map[common.Address]*message)
+	c.commits = make(map[common.Address]*message)
+	for r := range c.roundChanges {
+		if r <= round {
+			delete(c.roundChanges, r)
+		}
+	}
+	c.resetTimer()
+
+	if c.isProposer() {
+		c.propose()
+	}
+	// Process any messages that were waiting for this round
+	backlog := c.backlog
+	c.backlog = nil
+	for _, msg := range backlog {
+		if msg.Sequence < c.sequence {
+			continue
+		}
+		c.handle(msg)
+	}
+}
+
+// resetTimer schedules a round change if the current round isn't finished in
+// time. The timeout doubles with every round. The lock must be held by the caller.
+func (c *core) resetTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	shift := c.round
+	if c.sentRound > shift {
+		shift = c.sentRound
+	}
+	if shift > maxTimeoutShift {
+		shift = maxTimeoutShift
+	}
+	var (
+		timeout  = time.Duration(c.bft.config.RequestTimeout) * time.Millisecond << shift
+		sequence = c.sequence
+	)
+	c.timer = time.AfterFunc(timeout, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		if c.stopped || c.sequence != sequence || c.state == stateCommitted {
+			return
+		}
+		round := c.round
+		if c.sentRound > round {
+			round = c.sentRound
+		}
+		log.Debug("Consensus round timed out", "sequence", c.sequence, "round", c.round)
+		c.sendRoundChange(round + 1)
+		c.resetTimer()
+	})
+}
+
+// address returns the address of the local validator.
+func (c *core) address() common.Address {
+	c.bft.lock.RLock()
+	defer c.bft.lock.RUnlock()
+
+	return c.bft.signer
+}
+
+// isValidator returns whether the given address is a validator of the sequence.
+func (c *core) isValidator(address common.Address) bool {
+	for _, validator := range c.validators {
+		if validator == address {
+			return true
+		}
+	}
+	return false
+}
+
+// proposer returns the proposer of the given round of the current sequence.
+func (c *core) proposer(round uint64) common.Address {
+	if len(c.validators) == 0 {
+		return common.Address{}
+	}
+	return c.validators[(c.sequence+round)%uint64(len(c.validators))]
+}
+
+// isProposer returns whether the local validator proposes in the current round.
+func (c *core) isProposer() bool {
+	return len(c.validators) > 0 && c.proposer(c.round) == c.address()
+}
+
+// propose broadcasts the locked block, or lacking one, the local request as the
+// proposal of the current round. The lock must be held by the caller.
+func (c *core) propose() {
+	block := c.locked
+	if block == nil {
+		if c.pending == nil || c.pending.ParentHash() != c.parent.Hash() {
+			return // Nothing to propose yet, wait for the local request
+		}
+		block = c.pending
+	}
+	payload, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		log.Error("Failed to encode proposal", "err", err)
+		return
+	}
+	log.Debug("Proposing block", "number", block.Number(), "hash", block.Hash(), "round", c.round)
+	c.broadcast(&message{
+		Code:     msgPreprepare,
+		Sequence: c.sequence,
+		Round:    c.round,
+		Digest:   block.Hash(),
+		Proposal: payload,
+	})
+}
+
+// sendRoundChange broadcasts a request to move on to the given round, carrying
+// along the locked block if any. The lock must be held by the caller.
+func (c *core) sendRoundChange(round uint64) {
+	c.sentRound = round
+
+	msg := &message{
+		Code:     msgRoundChange,
+		Sequence: c.sequence,
+		Round:    round,
+	}
+	if c.locked != nil {
+		payload, err := rlp.EncodeToBytes(c.locked)
+		if err != nil {
+			log.Error("Failed to encode locked proposal", "err", err)
+			return
+		}
+		msg.Digest, msg.Proposal, msg.PreparedRound = c.locked.Hash(), payload, c.lockedRound
+	}
+	c.broadcast(msg)
+}
+
+// broadcast signs a consensus message, processes it locally and relays it to
+// all connected validators. The lock must be held by the caller.
+func (c *core) broadcast(msg *message) {
+	c.bft.lock.RLock()
+	signer, signFn := c.bft.signer, c.bft.signFn
+	c.bft.lock.RUnlock()
+
+	if signFn == nil {
+		return // Not a validator, nothing to broadcast
+	}
+	var err error
+	if msg.Signature, err = signFn(accounts.Account{Address: signer}, msg.sigHash().Bytes()); err != nil {
+		log.Error("Failed to sign consensus message", "err", err)
+		return
+	}
+	msg.address = signer
+
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		log.Error("Failed to encode consensus message", "err", err)
+		return
+	}
+	c.bft.known.Add(crypto.Keccak256Hash(payload), struct{}{})
+	c.bft.gossip(payload, "")
+
+	if err := c.handle(msg); err != nil {
+		log.Debug("Failed to process own consensus message", "code", msg.Code, "err", err)
+	}
+}
+
+// handle processes a consensus message. The lock must be held by the caller.
+func (c *core) handle(msg *message) error {
+	// Keep messages of future sequences around until we catch up, and commits to
+	// the current head in case its seals are missing
+	if msg.Sequence < c.sequence {
+		if msg.Code == msgCommit && msg.Sequence+1 == c.sequence {
+			return c.handleParentCommit(msg)
+		}
+		return errOldMessage
+	}
+	if msg.Sequence > c.sequence {
+		return c.store(msg)
+	}
+	if !c.isValidator(msg.address) {
+		return errNotValidator
+	}
+	if msg.Code == msgRoundChange {
+		return c.handleRoundChange(msg)
+	}
+	// Keep messages of future rounds around until we move there
+	if msg.Round < c.round {
+		return errOldMessage
+	}
+	if msg.Round > c.round {
+		return c.store(msg)
+	}
+	switch msg.Code {
+	case msgPreprepare:
+		return c.handlePreprepare(msg)
+	case msgPrepare:
+		return c.handlePrepare(msg)
+	case msgCommit:
+		return c.handleCommit(msg)
+	default:
+		return errInvalidMessage
+	}
+}
+
+// store adds a future message to the backlog. The lock must be held by the caller.
+func (c *core) store(msg *message) error {
+	if msg.Sequence > c.sequence+maxFutureSequences || len(c.backlog) >= maxBacklog {
+		return errFutureMessage
+	}
+	c.backlog = append(c.backlog, msg)
+	return nil
+}
+
+// handlePreprepare verifies the proposal of the current round and, if valid,
+// acknowledges it. The lock must be held by the caller.
+func (c *core) handlePreprepare(msg *message) error {
+	if msg.address != c.proposer(c.round) {
+		return errNotProposer
+	}
+	if c.state != stateAcceptRequest {
+		return nil // Already have the proposal
+	}
+	block, err := c.verifyProposal(msg.Proposal)
+	if err != nil {
+		return err
+	}
+	if c.locked != nil && c.locked.Hash() != block.Hash() {
+		return errLockedProposal
+	}
+	c.proposal, c.state = block, statePreprepared
+
+	c.broadcast(&message{
+		Code:     msgPrepare,
+		Sequence: c.sequence,
+		Round:    c.round,
+		Digest:   block.Hash(),
+	})
+	c.checkQuorum()
+	return nil
+}
+
+// verifyProposal decodes a proposed block and checks that it extends the current
+// head and is signed by a validator.
+func (c *core) verifyProposal(payload []byte) (*types.Block, error) {
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(payload, block); err != nil {
+		return nil, errInvalidProposal
+	}
+	if block.NumberU64() != c.sequence || block.ParentHash() != c.parent.Hash() {
+		return nil, errInvalidProposal
+	}
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return nil, errInvalidProposal
+	}
+	if err := c.bft.verifyHeader(c.chain, block.Header(), nil, true); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// handlePrepare records an acknowledgement of a proposal. The lock must be held
+// by the caller.
+func (c *core) handlePrepare(msg *message) error {
+	c.prepares[msg.address] = msg
+	c.checkQuorum()
+	return nil
+}
+
+// handleCommit records a commitment to a proposal. The lock must be held by the
+// caller.
+func (c *core) handleCommit(msg *message) error {
+	signer, err := recoverAddress(commitHash(msg.Digest), msg.CommittedSeal)
+	if err != nil || signer != msg.address {
+		return errInvalidCommitSeal
+	}
+	c.commits[msg.address] = msg
+	c.checkQuorum()
+	return nil
+}
+
+// handleParentCommit records a commitment to the parent block and, once a quorum
+// of them is collected, stores the commit seals needed to propose the next block.
+// It is a no-op if the seals are already known. The lock must be held by the caller.
+func (c *core) handleParentCommit(msg *message) error {
+	hash := c.parent.Hash()
+	if c.parent.Number.Sign() == 0 || msg.Digest != hash {
+		return errOldMessage
+	}
+	if _, ok := c.bft.loadCommit(hash); ok {
+		return errOldMessage
+	}
+	signer, err := recoverAddress(commitHash(hash), msg.CommittedSeal)
+	if err != nil || signer != msg.address {
+		return errInvalidCommitSeal
+	}
+	snap, err := c.bft.snapshot(c.chain, c.parent.Number.Uint64()-1, c.parent.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Validators[msg.address]; !ok {
+		return errNotValidator
+	}
+	c.parentCommits[msg.address] = msg
+
+	if seals := commitSeals(c.parentCommits, hash); verifyCommit(snap, hash, seals) == nil {
+		log.Debug("Rebuilt parent commit seals", "number", c.parent.Number, "hash", hash, "seals", len(seals))
+		c.bft.storeCommit(hash, seals)
+	}
+	return nil
+}
+
+// checkQuorum advances the round state if a quorum of validators prepared or
+// committed the current proposal. The lock must be held by the caller.
+func (c *core) checkQuorum() {
+	if c.proposal == nil || c.state == stateCommitted {
+		return
+	}
+	var (
+		digest = c.proposal.Hash()
+		needed = quorum(len(c.validators))
+	)
+	if c.state == statePreprepared && count(c.prepares, digest) >= needed {
+		c.state = statePrepared
+		c.locked, c.lockedRound = c.proposal, c.round
+
+		seal, err := c.signCommit(digest)
+		if err != nil {
+			log.Error("Failed to sign commit", "err", err)
+			return
+		}
+		c.broadcast(&message{
+			Code:          msgCommit,
+			Sequence:      c.sequence,
+			Round:         c.round,
+			Digest:        digest,
+			CommittedSeal: seal,
+		})
+	}
+	if c.state != stateCommitted && count(c.commits, digest) >= needed {
+		c.commit()
+	}
+}
+
+// signCommit signs the commit hash of a proposal with the local validator key.
+func (c *core) signCommit(digest common.Hash) ([]byte, error) {
+	c.bft.lock.RLock()
+	signer, signFn := c.bft.signer, c.bft.signFn
+	c.bft.lock.RUnlock()
+
+	if signFn == nil {
+		return nil, errUnauthorized
+	}
+	return signFn(accounts.Account{Address: signer}, commitHash(digest).Bytes())
+}
+
+// count returns the number of messages about the given digest.
+func count(msgs map[common.Address]*message, digest common.Hash) int {
+	n := 0
+	for _, msg := range msgs {
+		if msg.Digest == digest {
+			n++
+		}
+	}
+	return n
+}
+
+// commitSeals returns the committed seals of the messages about the given digest,
+// ordered by the addresses of their senders.
+func commitSeals(msgs map[common.Address]*message, digest common.Hash) [][]byte {
+	var signers []common.Address
+	for address, msg := range msgs {
+		if msg.Digest == digest {
+			signers = append(signers, address)
+		}
+	}
+	sortAddresses(signers)
+
+	seals := make([][]byte, len(signers))
+	for i, signer := range signers {
+		seals[i] = msgs[signer].CommittedSeal
+	}
+	return seals
+}
+
+// commit finalizes the current proposal, storing the commit seals to include them
+// in the next block, and hands the block over for import. The lock must be held
+// by the caller.
+func (c *core) commit() {
+	c.state = stateCommitted
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	block := c.proposal
+
+	seals := commitSeals(c.commits, block.Hash())
+	c.bft.storeCommit(block.Hash(), seals)
+
+	log.Info("Committed block", "number", block.Number(), "hash", block.Hash(), "round", c.round, "seals", len(seals))
+
+	// Deliver the block to the local sealer if it's waiting for it, import otherwise
+	if c.results != nil && c.pending != nil && c.pending.Hash() == block.Hash() {
+		c.results <- block
+		c.results = nil
+		return
+	}
+	go func() {
+		if err := c.insert(block); err != nil {
+			log.Warn("Failed to import committed block", "number", block.Number(), "hash", block.Hash(), "err", err)
+		}
+	}()
+}
+
+// handleRoundChange records a request to move on to a future round, joining in
+// if enough validators want to and moving there once a quorum does. The lock must
+// be held by the caller.
+func (c *core) handleRoundChange(msg *message) error {
+	if msg.Round <= c.round {
+		return errOldMessage
+	}
+	if c.roundChanges[msg.Round] == nil {
+		c.roundChanges[msg.Round] = make(map[common.Address]*message)
+	}
+	c.roundChanges[msg.Round][msg.address] = msg
+
+	// If enough validators want to change round, at least one is honest, join them
+	if len(c.roundChanges[msg.Round]) > faulty(len(c.validators)) && msg.Round > c.sentRound {
+		c.sendRoundChange(msg.Round)
+	}
+	// If a quorum wants to change round, move there. Unless already locked, adopt
+	// the block prepared in the highest round, as some validators may be locked on it.
+	if msg.Round > c.round && len(c.roundChanges[msg.Round]) >= quorum(len(c.validators)) {
+		if c.locked == nil {
+			for _, rc := range c.roundChanges[msg.Round] {
+				if len(rc.Proposal) == 0 || (c.locked != nil && rc.PreparedRound <= c.lockedRound) {
+					continue
+				}
+				if block, err := c.verifyProposal(rc.Proposal); err == nil && block.Hash() == rc.Digest {
+					c.locked, c.lockedRound = block, rc.PreparedRound
+				}
+			}
+		}
+		c.startRound(msg.Round)
+	}
+	return nil
+}
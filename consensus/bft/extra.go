@@ -0,0 +1,94 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"errors"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/crypto/sha3"
+	"github.com/happyuc-project/happyuc-go/rlp"
+)
+
+const (
+	extraVanity = 32 // Fixed number of extra-data prefix bytes reserved for validator vanity
+	extraSeal   = 65 // Fixed number of bytes of a validator seal
+)
+
+// MixDigest is the mix digest marking headers sealed by the byzantine fault
+// tolerant consensus engine ("practical byzantine fault tolerance").
+var MixDigest = common.HexToHash("0x63746963616c2062797a616e74696e65206661756c7420746f6c6572616e6365")
+
+// errInvalidExtra is returned if the extra-data of a header can't be decoded
+// into the BFT fields.
+var errInvalidExtra = errors.New("invalid bft header extra-data")
+
+// Extra is the consensus data of BFT headers, stored RLP encoded after the
+// vanity prefix of the extra-data.
+//
+// The commit seals finalizing a block are gathered independently by every
+// validator, so they can't be part of the block itself without each validator
+// ending up with a different block hash. Instead, the proposer of the next block
+// includes the seals of a quorum, making them part of the agreed upon chain.
+type Extra struct {
+	Validators   []common.Address // Validator set on checkpoint blocks, empty otherwise
+	Seal         []byte           // Signature of the block proposer
+	ParentCommit [][]byte         // Commit seals of a quorum of validators finalizing the parent
+}
+
+// extractExtra decodes the BFT consensus fields from the extra-data of a header.
+func extractExtra(header *types.Header) (*Extra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, errInvalidExtra
+	}
+	extra := new(Extra)
+	if err := rlp.DecodeBytes(header.Extra[extraVanity:], extra); err != nil {
+		return nil, errInvalidExtra
+	}
+	return extra, nil
+}
+
+// setExtra replaces the BFT consensus fields in the extra-data of a header,
+// keeping the vanity prefix intact.
+func setExtra(header *types.Header, extra *Extra) error {
+	payload, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return err
+	}
+	header.Extra = append(header.Extra[:extraVanity:extraVanity], payload...)
+	return nil
+}
+
+// sigHash returns the hash which is used as input for the proposer seal. It is
+// the hash of the entire header apart from the proposer seal itself.
+func sigHash(header *types.Header) (hash common.Hash) {
+	extra, err := extractExtra(header)
+	if err != nil {
+		return common.Hash{}
+	}
+	extra.Seal = []byte{}
+
+	cpy := types.CopyHeader(header)
+	if err := setExtra(cpy, extra); err != nil {
+		return common.Hash{}
+	}
+	hasher := sha3.NewKeccak256()
+	rlp.Encode(hasher, cpy)
+	hasher.Sum(hash[:0])
+	return hash
+}
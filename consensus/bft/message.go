@@ -0,0 +1,82 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"errors"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/crypto/sha3"
+	"github.com/happyuc-project/happyuc-go/rlp"
+)
+
+// Consensus message codes.
+const (
+	msgPreprepare  uint64 = iota // Proposal of a block by the round's proposer
+	msgPrepare                   // Acknowledgement of a valid proposal
+	msgCommit                    // Commitment to a proposal acknowledged by a quorum
+	msgRoundChange               // Request to abandon the current round
+)
+
+// errInvalidMessage is returned if a consensus message can't be decoded or its
+// signature can't be recovered.
+var errInvalidMessage = errors.New("invalid consensus message")
+
+// message is a signed consensus message exchanged between validators.
+type message struct {
+	Code          uint64      // Type of the consensus message
+	Sequence      uint64      // Number of the block being agreed on
+	Round         uint64      // Round of the sequence the message belongs to
+	Digest        common.Hash // Hash of the proposal the message is about
+	Proposal      []byte      // RLP encoded block of preprepare and round change messages
+	PreparedRound uint64      // Round in which a round change message's proposal was prepared
+	CommittedSeal []byte      // Signature over the commit hash of commit messages
+	Signature     []byte      // Signature of the sender over all other fields
+
+	address common.Address // Sender of the message, recovered from the signature
+}
+
+// sigHash returns the hash which is signed by the sender of the message.
+func (m *message) sigHash() (hash common.Hash) {
+	hasher := sha3.NewKeccak256()
+
+	rlp.Encode(hasher, []interface{}{
+		m.Code,
+		m.Sequence,
+		m.Round,
+		m.Digest,
+		m.Proposal,
+		m.PreparedRound,
+		m.CommittedSeal,
+	})
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+// decodeMessage parses a consensus message and recovers its sender.
+func decodeMessage(payload []byte) (*message, error) {
+	msg := new(message)
+	if err := rlp.DecodeBytes(payload, msg); err != nil {
+		return nil, errInvalidMessage
+	}
+	address, err := recoverAddress(msg.sigHash(), msg.Signature)
+	if err != nil {
+		return nil, errInvalidMessage
+	}
+	msg.address = address
+	return msg, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/p2p"
+)
+
+const (
+	protocolName    = "bft" // Name of the consensus sub-protocol
+	protocolVersion = 1     // Version of the consensus sub-protocol
+	protocolLength  = 1     // Number of message codes used by the sub-protocol
+
+	consensusMsg = 0x00 // Message code wrapping an encoded consensus message
+
+	maxMessageSize    = 10 * 1024 * 1024 // Maximum cap on the size of a consensus message
+	maxQueuedMessages = 256              // Maximum number of messages queued for a peer
+)
+
+// errMsgTooLarge is returned if a peer sends a message exceeding the size limit.
+var errMsgTooLarge = errors.New("message too large")
+
+// peer is a remote node connected via the consensus sub-protocol.
+type peer struct {
+	id    string
+	rw    p2p.MsgReadWriter
+	queue chan []byte   // Consensus messages waiting to be sent
+	term  chan struct{} // Termination channel to stop the sender
+}
+
+// loop sends the queued consensus messages to the remote peer.
+func (p *peer) loop() {
+	for {
+		select {
+		case payload := <-p.queue:
+			if err := p2p.Send(p.rw, consensusMsg, payload); err != nil {
+				return
+			}
+		case <-p.term:
+			return
+		}
+	}
+}
+
+// send queues a consensus message for the remote peer, dropping it if the peer
+// can't keep up.
+func (p *peer) send(payload []byte) {
+	select {
+	case p.queue <- payload:
+	default:
+		log.Debug("Dropping consensus message", "peer", p.id)
+	}
+}
+
+// runPeer is the sub-protocol handler of a remote peer, processing the consensus
+// messages it sends until the connection is torn down.
+func (b *BFT) runPeer(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	peer := &peer{
+		id:    fmt.Sprintf("%x", p.ID().Bytes()[:8]),
+		rw:    rw,
+		queue: make(chan []byte, maxQueuedMessages),
+		term:  make(chan struct{}),
+	}
+	b.peersLock.Lock()
+	b.peers[peer.id] = peer
+	b.peersLock.Unlock()
+
+	defer func() {
+		b.peersLock.Lock()
+		delete(b.peers, peer.id)
+		b.peersLock.Unlock()
+
+		close(peer.term)
+	}()
+	go peer.loop()
+
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		if msg.Size > maxMessageSize {
+			msg.Discard()
+			return errMsgTooLarge
+		}
+		if msg.Code != consensusMsg {
+			msg.Discard()
+			return fmt.Errorf("invalid message code %d", msg.Code)
+		}
+		var payload []byte
+		if err := msg.Decode(&payload); err != nil {
+			return err
+		}
+		b.handlePayload(payload, peer.id)
+	}
+}
+
+// handlePayload processes an encoded consensus message received from a peer and
+// relays it to all other peers if it's valid.
+func (b *BFT) handlePayload(payload []byte, origin string) {
+	hash := crypto.Keccak256Hash(payload)
+	if b.known.Contains(hash) {
+		return
+	}
+	b.known.Add(hash, struct{}{})
+
+	msg, err := decodeMessage(payload)
+	if err != nil {
+		log.Debug("Failed to decode consensus message", "peer", origin, "err", err)
+		return
+	}
+	b.coreLock.RLock()
+	core := b.core
+	b.coreLock.RUnlock()
+
+	if core == nil {
+		return
+	}
+	if err := core.handleMessage(msg); err != nil {
+		log.Trace("Dropped consensus message", "peer", origin, "code", msg.Code, "sequence", msg.Sequence, "round", msg.Round, "err", err)
+		return
+	}
+	b.gossip(payload, origin)
+}
+
+// gossip queues an encoded consensus message for all peers apart from the one it
+// originates from.
+func (b *BFT) gossip(payload []byte, origin string) {
+	b.peersLock.RLock()
+	defer b.peersLock.RUnlock()
+
+	for id, peer := range b.peers {
+		if id != origin {
+			peer.send(payload)
+		}
+	}
+}
@@ -17,12 +17,20 @@
 package clique
 
 import (
+	"bytes"
+	"fmt"
+
 	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/common/hexutil"
 	"github.com/happyuc-project/happyuc-go/consensus"
 	"github.com/happyuc-project/happyuc-go/core/types"
 	"github.com/happyuc-project/happyuc-go/rpc"
 )
 
+// maxVoteHistory is the maximum number of blocks whose votes can be retrieved
+// in a single request.
+const maxVoteHistory = 16384
+
 // API is a user facing RPC API to allow controlling the signer and voting
 // mechanisms of the proof-of-authority scheme.
 type API struct {
@@ -90,23 +98,32 @@ func (api *API) GetSignersAtHash(hash common.Hash) ([]common.Address, error) {
 
 // Proposals returns the current proposals the node tries to uphold and vote on.
 func (api *API) Proposals() map[common.Address]bool {
-	api.clique.lock.RLock()
-	defer api.clique.lock.RUnlock()
-
 	proposals := make(map[common.Address]bool)
-	for address, auth := range api.clique.proposals {
-		proposals[address] = auth
+	for address, proposal := range api.clique.Proposals() {
+		proposals[address] = proposal.Authorize
 	}
 	return proposals
 }
 
+// ProposalsDetailed returns the current proposals the node tries to uphold and
+// vote on, along with their expiry blocks.
+func (api *API) ProposalsDetailed() map[common.Address]*Proposal {
+	return api.clique.Proposals()
+}
+
 // Propose injects a new authorization proposal that the signer will attempt to
-// push through.
-func (api *API) Propose(address common.Address, auth bool) {
+// push through. If an expiry block is given, the signer stops voting on the
+// proposal after that block.
+func (api *API) Propose(address common.Address, auth bool, expiry *hexutil.Uint64) {
 	api.clique.lock.Lock()
 	defer api.clique.lock.Unlock()
 
-	api.clique.proposals[address] = auth
+	proposal := &Proposal{Authorize: auth}
+	if expiry != nil {
+		proposal.Expiry = uint64(*expiry)
+	}
+	api.clique.proposals[address] = proposal
+	api.clique.storeProposals()
 }
 
 // Discard drops a currently running proposal, stopping the signer from casting
@@ -116,4 +133,83 @@ func (api *API) Discard(address common.Address) {
 	defer api.clique.lock.Unlock()
 
 	delete(api.clique.proposals, address)
+	api.clique.storeProposals()
+}
+
+// VoteRecord is a single vote cast in a block, along with its effect on the
+// authorization tally.
+type VoteRecord struct {
+	*Vote
+	Hash    common.Hash `json:"hash"`    // Hash of the block the vote was cast in
+	Counted bool        `json:"counted"` // Whether the vote was meaningful and counted in the tally
+	Votes   int         `json:"votes"`   // Number of votes for the proposal after this block
+	Passed  bool        `json:"passed"`  // Whether this vote made the proposal pass
+}
+
+// GetVotes retrieves the history of all votes cast in the given block range. If
+// no start block is given, the range starts at the last checkpoint, as all votes
+// before that were discarded; if no end block is given, it ends at the head.
+func (api *API) GetVotes(from *rpc.BlockNumber, to *rpc.BlockNumber) ([]*VoteRecord, error) {
+	// Resolve the requested block range
+	last := api.chain.CurrentHeader().Number.Uint64()
+	if to != nil && *to != rpc.LatestBlockNumber {
+		if uint64(to.Int64()) < last {
+			last = uint64(to.Int64())
+		}
+	}
+	first := last - last%api.clique.config.Epoch
+	if from != nil && *from != rpc.LatestBlockNumber {
+		first = uint64(from.Int64())
+	}
+	if first > last {
+		return nil, fmt.Errorf("invalid range: %d > %d", first, last)
+	}
+	if last-first >= maxVoteHistory {
+		return nil, fmt.Errorf("range too large: %d > %d blocks", last-first+1, maxVoteHistory)
+	}
+	// Gather the votes from the headers, evaluating them against their snapshots
+	var records []*VoteRecord
+	for number := first; number <= last; number++ {
+		if number == 0 || number%api.clique.config.Epoch == 0 {
+			continue // Checkpoint blocks carry no votes
+		}
+		header := api.chain.GetHeaderByNumber(number)
+		if header == nil {
+			return nil, errUnknownBlock
+		}
+		if header.Coinbase == (common.Address{}) {
+			continue
+		}
+		signer, err := ecrecover(header, api.clique.signatures)
+		if err != nil {
+			return nil, err
+		}
+		parent, err := api.clique.snapshot(api.chain, number-1, header.ParentHash, nil)
+		if err != nil {
+			return nil, err
+		}
+		snap, err := api.clique.snapshot(api.chain, number, header.Hash(), nil)
+		if err != nil {
+			return nil, err
+		}
+		authorize := bytes.Equal(header.Nonce[:], nonceAuthVote)
+		record := &VoteRecord{
+			Vote: &Vote{
+				Signer:    signer,
+				Block:     number,
+				Address:   header.Coinbase,
+				Authorize: authorize,
+			},
+			Hash:    header.Hash(),
+			Counted: parent.validVote(header.Coinbase, authorize),
+		}
+		if record.Counted {
+			record.Passed = !snap.validVote(header.Coinbase, authorize)
+			if tally, ok := snap.Tally[header.Coinbase]; ok && tally.Authorize == authorize {
+				record.Votes = tally.Votes
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
 }
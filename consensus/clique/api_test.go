@@ -0,0 +1,212 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/common/hexutil"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/core/vm"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/params"
+	"github.com/happyuc-project/happyuc-go/rpc"
+)
+
+// testerVotingChain is a clique chain whose blocks are signed by tester accounts
+// casting explicit votes.
+type testerVotingChain struct {
+	t        *testing.T
+	accounts *testerAccountPool
+	engine   *Clique
+	chain    *core.BlockChain
+}
+
+// newTesterVotingChain creates a clique chain with the given initial signers.
+func newTesterVotingChain(t *testing.T, signers ...string) *testerVotingChain {
+	accounts := newTesterAccountPool()
+
+	extra := make([]byte, extraVanity+common.AddressLength*len(signers)+extraSeal)
+	for i, signer := range sortedTesterSigners(accounts, signers) {
+		copy(extra[extraVanity+i*common.AddressLength:], signer[:])
+	}
+	config := *params.AllCliqueProtocolChanges
+	config.Clique = &params.CliqueConfig{Epoch: 30000}
+
+	db, _ := hucdb.NewMemDatabase()
+	(&core.Genesis{Config: &config, ExtraData: extra, GasLimit: params.GenesisGasLimit}).MustCommit(db)
+
+	engine := New(config.Clique, db)
+	chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	return &testerVotingChain{t: t, accounts: accounts, engine: engine, chain: chain}
+}
+
+// sortedTesterSigners resolves the addresses of the given accounts in ascending order.
+func sortedTesterSigners(accounts *testerAccountPool, signers []string) []common.Address {
+	addrs := make([]common.Address, len(signers))
+	for i, signer := range signers {
+		addrs[i] = accounts.address(signer)
+	}
+	for i := 0; i < len(addrs); i++ {
+		for j := i + 1; j < len(addrs); j++ {
+			if addrs[j].Big().Cmp(addrs[i].Big()) < 0 {
+				addrs[i], addrs[j] = addrs[j], addrs[i]
+			}
+		}
+	}
+	return addrs
+}
+
+// mine seals a new block on top of the chain head by the given signer, casting
+// a vote on the given account if it's non-empty.
+func (c *testerVotingChain) mine(signer string, voted string, auth bool) *types.Block {
+	parent := c.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   parent.GasLimit(),
+		Time:       new(big.Int).Add(parent.Time(), common.Big1),
+		Extra:      make([]byte, extraVanity+extraSeal),
+	}
+	if voted != "" {
+		header.Coinbase = c.accounts.address(voted)
+		if auth {
+			copy(header.Nonce[:], nonceAuthVote)
+		} else {
+			copy(header.Nonce[:], nonceDropVote)
+		}
+	}
+	snap, err := c.engine.snapshot(c.chain, parent.NumberU64(), parent.Hash(), nil)
+	if err != nil {
+		c.t.Fatalf("failed to retrieve parent snapshot: %v", err)
+	}
+	header.Difficulty = CalcDifficulty(snap, c.accounts.address(signer))
+
+	statedb, _ := c.chain.StateAt(parent.Root())
+	block, _ := c.engine.Finalize(c.chain, header, statedb, nil, nil, nil)
+
+	header = block.Header()
+	c.accounts.sign(header, signer)
+	block = block.WithSeal(header)
+
+	if _, err := c.chain.InsertChain(types.Blocks{block}); err != nil {
+		c.t.Fatalf("failed to import block #%d: %v", block.NumberU64(), err)
+	}
+	return block
+}
+
+// Tests that the vote history reports every cast vote along with its effect on
+// the authorization tally.
+func TestGetVotes(t *testing.T) {
+	c := newTesterVotingChain(t, "A")
+	defer c.chain.Stop()
+
+	c.mine("A", "B", true) // Passes immediately with a single signer
+	c.mine("B", "C", true) // One vote out of two, pending
+	c.mine("A", "C", true) // Second vote, passes
+	c.mine("B", "A", true) // Authorizing an existing signer is meaningless
+	c.mine("C", "", false) // No vote cast
+
+	api := &API{chain: c.chain, clique: c.engine}
+	records, err := api.GetVotes(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve votes: %v", err)
+	}
+	want := []struct {
+		signer, voted   string
+		counted, passed bool
+		votes           int
+	}{
+		{"A", "B", true, true, 0},
+		{"B", "C", true, false, 1},
+		{"A", "C", true, true, 0},
+		{"B", "A", false, false, 0},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("vote count mismatch: have %d, want %d", len(records), len(want))
+	}
+	for i, w := range want {
+		record := records[i]
+		if record.Block != uint64(i+1) || record.Signer != c.accounts.address(w.signer) || record.Address != c.accounts.address(w.voted) || !record.Authorize {
+			t.Errorf("vote %d: mismatch: have %+v", i, record.Vote)
+		}
+		if record.Counted != w.counted || record.Passed != w.passed || record.Votes != w.votes {
+			t.Errorf("vote %d: tally mismatch: have counted %v/passed %v/votes %d, want %v/%v/%d",
+				i, record.Counted, record.Passed, record.Votes, w.counted, w.passed, w.votes)
+		}
+	}
+	// Ensure the range is honoured
+	from, to := rpc.BlockNumber(2), rpc.BlockNumber(3)
+	if records, err := api.GetVotes(&from, &to); err != nil || len(records) != 2 || records[0].Block != 2 {
+		t.Fatalf("ranged votes mismatch: have %d records (err %v), want 2 from block 2", len(records), err)
+	}
+}
+
+// Tests that expired proposals are not voted on and are dropped persistently,
+// while proposals expiring in the block being prepared are still voted on.
+func TestProposalExpiry(t *testing.T) {
+	c := newTesterVotingChain(t, "A")
+	defer c.chain.Stop()
+
+	c.mine("A", "", false)
+	c.mine("A", "", false)
+
+	var (
+		api     = &API{chain: c.chain, clique: c.engine}
+		expired = hexutil.Uint64(2)
+		current = hexutil.Uint64(3)
+	)
+	api.Propose(c.accounts.address("B"), true, &expired)
+	api.Propose(c.accounts.address("C"), true, &current)
+
+	parent := c.chain.CurrentBlock()
+	header := &types.Header{ParentHash: parent.Hash(), Number: big.NewInt(3)}
+	if err := c.engine.Prepare(c.chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	if header.Coinbase != c.accounts.address("C") {
+		t.Errorf("vote mismatch: have %x, want %x", header.Coinbase, c.accounts.address("C"))
+	}
+	proposals := api.Proposals()
+	if _, ok := proposals[c.accounts.address("B")]; ok {
+		t.Errorf("expired proposal retained")
+	}
+	if auth, ok := proposals[c.accounts.address("C")]; !ok || !auth {
+		t.Errorf("live proposal dropped")
+	}
+	if reloaded := New(c.engine.config, c.engine.db).Proposals(); len(reloaded) != 1 {
+		t.Errorf("persisted proposal count mismatch: have %d, want 1", len(reloaded))
+	}
+	// Once the block passes the expiry, no more votes should be cast
+	header = &types.Header{ParentHash: parent.Hash(), Number: big.NewInt(3)}
+	c.engine.proposals[c.accounts.address("C")].Expiry = 2
+	if err := c.engine.Prepare(c.chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	if header.Coinbase != (common.Address{}) {
+		t.Errorf("vote cast on expired proposal: %x", header.Coinbase)
+	}
+	if details := api.ProposalsDetailed(); len(details) != 0 {
+		t.Errorf("expired proposals retained: %v", details)
+	}
+}
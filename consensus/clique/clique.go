@@ -201,7 +201,7 @@ type Clique struct {
 	recents    *lru.ARCCache // Snapshots for recent block to speed up reorgs
 	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
 
-	proposals map[common.Address]bool // Current list of proposals we are pushing
+	proposals map[common.Address]*Proposal // Current list of proposals we are pushing
 
 	signer common.Address // HappyUC address of the signing key
 	signFn SignerFn       // Signer function to authorize hashes with
@@ -220,13 +220,44 @@ func New(config *params.CliqueConfig, db hucdb.Database) *Clique {
 	recents, _ := lru.NewARC(inmemorySnapshots)
 	signatures, _ := lru.NewARC(inmemorySignatures)
 
+	// Reload any proposals pushed before a restart
+	proposals, err := loadProposals(db)
+	if err != nil {
+		log.Warn("Failed to load clique proposals", "err", err)
+	}
 	return &Clique{
 		config:     &conf,
 		db:         db,
 		recents:    recents,
 		signatures: signatures,
-		proposals:  make(map[common.Address]bool),
+		proposals:  proposals,
+	}
+}
+
+// storeProposals persists the current proposals into the database. The lock must
+// be held by the caller.
+func (c *Clique) storeProposals() {
+	if err := storeProposals(c.db, c.proposals); err != nil {
+		log.Warn("Failed to store clique proposals", "err", err)
+	}
+}
+
+// Snapshot retrieves the authorization snapshot at the given header.
+func (c *Clique) Snapshot(chain consensus.ChainReader, header *types.Header) (*Snapshot, error) {
+	return c.snapshot(chain, header.Number.Uint64(), header.Hash(), nil)
+}
+
+// Proposals returns a copy of the proposals the node tries to uphold and vote on.
+func (c *Clique) Proposals() map[common.Address]*Proposal {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	proposals := make(map[common.Address]*Proposal)
+	for address, proposal := range c.proposals {
+		cpy := *proposal
+		proposals[address] = &cpy
 	}
+	return proposals
 }
 
 // Author implements consensus.Engine, returning the HappyUC address recovered
@@ -354,13 +385,27 @@ func (c *Clique) verifyCascadingFields(chain consensus.ChainReader, header *type
 	}
 	// If the block is a checkpoint block, verify the signer list
 	if number%c.config.Epoch == 0 {
-		signers := make([]byte, len(snap.Signers)*common.AddressLength)
-		for i, signer := range snap.signers() {
-			copy(signers[i*common.AddressLength:], signer[:])
-		}
-		extraSuffix := len(header.Extra) - extraSeal
-		if !bytes.Equal(header.Extra[extraVanity:extraSuffix], signers) {
-			return errInvalidCheckpointSigners
+		if c.config.ValidatorContract != nil {
+			// On contract governed chains the list comes from the contract. Without
+			// the parent state (inside import batches, header and light syncs) the
+			// list in the extra-data is accepted if well formed, as signed by a
+			// current signer. Processing the block checks it against the contract.
+			err := c.verifyGovernedCheckpoint(chain, header, parent, snap)
+			if err == errMissingState {
+				err = verifyExtraSigners(header)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			signers := make([]byte, len(snap.Signers)*common.AddressLength)
+			for i, signer := range snap.signers() {
+				copy(signers[i*common.AddressLength:], signer[:])
+			}
+			extraSuffix := len(header.Extra) - extraSeal
+			if !bytes.Equal(header.Extra[extraVanity:extraSuffix], signers) {
+				return errInvalidCheckpointSigners
+			}
 		}
 	}
 	// All basic checks passed, verify the seal and return
@@ -388,17 +433,24 @@ func (c *Clique) snapshot(chain consensus.ChainReader, number uint64, hash commo
 				break
 			}
 		}
+		// If we're at the last block before switching over to clique, start with
+		// the initial signers from the config. This must precede the genesis case
+		// as a proof-of-work genesis carries no signers in its extra-data.
+		if block := chain.Config().CliqueSwitchBlock; block != nil && number+1 == block.Uint64() {
+			snap = newSnapshot(c.config, c.signatures, number, hash, c.config.Signers)
+			if err := snap.store(c.db); err != nil {
+				return nil, err
+			}
+			log.Trace("Stored clique switch voting snapshot to disk", "number", number, "hash", hash)
+			break
+		}
 		// If we're at block zero, make a snapshot
 		if number == 0 {
 			genesis := chain.GetHeaderByNumber(0)
 			if err := c.VerifyHeader(chain, genesis, false); err != nil {
 				return nil, err
 			}
-			signers := make([]common.Address, (len(genesis.Extra)-extraVanity-extraSeal)/common.AddressLength)
-			for i := 0; i < len(signers); i++ {
-				copy(signers[i][:], genesis.Extra[extraVanity+i*common.AddressLength:])
-			}
-			snap = newSnapshot(c.config, c.signatures, 0, genesis.Hash(), signers)
+			snap = newSnapshot(c.config, c.signatures, 0, genesis.Hash(), extraSigners(genesis))
 			if err := snap.store(c.db); err != nil {
 				return nil, err
 			}
@@ -515,26 +567,36 @@ func (c *Clique) Prepare(chain consensus.ChainReader, header *types.Header) erro
 	if err != nil {
 		return err
 	}
-	if number%c.config.Epoch != 0 {
-		c.lock.RLock()
+	if number%c.config.Epoch != 0 && c.config.ValidatorContract == nil {
+		c.lock.Lock()
 
-		// Gather all the proposals that make sense voting on
+		// Drop all expired proposals and gather the ones that make sense voting on
+		expired := false
 		addresses := make([]common.Address, 0, len(c.proposals))
-		for address, authorize := range c.proposals {
-			if snap.validVote(address, authorize) {
+		for address, proposal := range c.proposals {
+			if proposal.expired(number) {
+				log.Info("Clique proposal expired", "address", address, "authorize", proposal.Authorize, "expiry", proposal.Expiry)
+				delete(c.proposals, address)
+				expired = true
+				continue
+			}
+			if snap.validVote(address, proposal.Authorize) {
 				addresses = append(addresses, address)
 			}
 		}
+		if expired {
+			c.storeProposals()
+		}
 		// If there's pending proposals, cast a vote on them
 		if len(addresses) > 0 {
 			header.Coinbase = addresses[rand.Intn(len(addresses))]
-			if c.proposals[header.Coinbase] {
+			if c.proposals[header.Coinbase].Authorize {
 				copy(header.Nonce[:], nonceAuthVote)
 			} else {
 				copy(header.Nonce[:], nonceDropVote)
 			}
 		}
-		c.lock.RUnlock()
+		c.lock.Unlock()
 	}
 	// Set the correct difficulty
 	header.Difficulty = CalcDifficulty(snap, c.signer)
@@ -546,7 +608,18 @@ func (c *Clique) Prepare(chain consensus.ChainReader, header *types.Header) erro
 	header.Extra = header.Extra[:extraVanity]
 
 	if number%c.config.Epoch == 0 {
-		for _, signer := range snap.signers() {
+		signers := snap.signers()
+		if c.config.ValidatorContract != nil {
+			parent := chain.GetHeader(header.ParentHash, number-1)
+			if parent == nil {
+				return consensus.ErrUnknownAncestor
+			}
+			var ok bool
+			if signers, ok = c.governedSigners(chain, parent, snap); !ok {
+				return errMissingState
+			}
+		}
+		for _, signer := range signers {
 			header.Extra = append(header.Extra, signer[:]...)
 		}
 	}
@@ -570,6 +643,21 @@ func (c *Clique) Prepare(chain consensus.ChainReader, header *types.Header) erro
 // Finalize implements consensus.Engine, ensuring no uncles are set, nor block
 // rewards given, and returns the final block.
 func (c *Clique) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// Checkpoints of contract governed chains may have skipped the signer list
+	// check during header verification, verify them against the parent state
+	if number := header.Number.Uint64(); number > 0 && number%c.config.Epoch == 0 && c.config.ValidatorContract != nil {
+		parent := chain.GetHeader(header.ParentHash, number-1)
+		if parent == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+		snap, err := c.snapshot(chain, number-1, header.ParentHash, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.verifyGovernedCheckpoint(chain, header, parent, snap); err != nil {
+			return nil, err
+		}
+	}
 	// No block rewards in PoA, so the state remains as is and uncles are dropped
 	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 	header.UncleHash = types.CalcUncleHash(nil)
@@ -0,0 +1,151 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/core/state"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/crypto"
+)
+
+// maxContractSigners is the maximum number of signers read from the validator
+// contract, protecting against a runaway list length.
+const maxContractSigners = 1024
+
+// errMissingState is returned if a checkpoint is attempted to be created or
+// verified on a contract governed chain without the state of its parent being
+// available.
+var errMissingState = errors.New("validator contract state unavailable")
+
+// stateReader is implemented by chains giving access to historical state, which
+// is needed to read the signer set of contract governed chains.
+type stateReader interface {
+	StateAt(root common.Hash) (*state.StateDB, error)
+}
+
+// contractSigners reads the signer set from the storage of a validator contract.
+// The signers are expected in a dynamic address array in the first storage slot,
+// i.e. the layout of a Solidity contract starting with `address[] signers`. The
+// returned list is sorted in ascending order and free of duplicates.
+func contractSigners(statedb *state.StateDB, contract common.Address) []common.Address {
+	length := statedb.GetState(contract, common.Hash{}).Big()
+	if length.Cmp(big.NewInt(maxContractSigners)) > 0 {
+		length.SetUint64(maxContractSigners)
+	}
+	var (
+		base    = crypto.Keccak256Hash(common.Hash{}.Bytes()).Big()
+		seen    = make(map[common.Address]struct{})
+		signers []common.Address
+	)
+	for i := int64(0); i < length.Int64(); i++ {
+		slot := common.BigToHash(new(big.Int).Add(base, big.NewInt(i)))
+		signer := common.BytesToAddress(statedb.GetState(contract, slot).Bytes())
+		if signer == (common.Address{}) {
+			continue
+		}
+		if _, ok := seen[signer]; ok {
+			continue
+		}
+		seen[signer] = struct{}{}
+		signers = append(signers, signer)
+	}
+	sortAddresses(signers)
+	return signers
+}
+
+// governedSigners returns the signer set a checkpoint on top of the given parent
+// must carry on a contract governed chain. If the contract holds no signers, the
+// current ones are kept to avoid halting the chain. The second return value is
+// false if the parent state is unavailable (e.g. light or fast syncing nodes), in
+// which case the signer set can't be determined locally.
+func (c *Clique) governedSigners(chain consensus.ChainReader, parent *types.Header, snap *Snapshot) ([]common.Address, bool) {
+	reader, ok := chain.(stateReader)
+	if !ok {
+		return nil, false
+	}
+	statedb, err := reader.StateAt(parent.Root)
+	if err != nil {
+		return nil, false
+	}
+	if signers := contractSigners(statedb, *c.config.ValidatorContract); len(signers) > 0 {
+		return signers, true
+	}
+	return snap.signers(), true
+}
+
+// extraSigners extracts the signer list from the extra-data of a checkpoint header.
+func extraSigners(header *types.Header) []common.Address {
+	signers := make([]common.Address, (len(header.Extra)-extraVanity-extraSeal)/common.AddressLength)
+	for i := 0; i < len(signers); i++ {
+		copy(signers[i][:], header.Extra[extraVanity+i*common.AddressLength:])
+	}
+	return signers
+}
+
+// sortAddresses sorts a list of addresses in ascending order.
+func sortAddresses(addresses []common.Address) {
+	for i := 0; i < len(addresses); i++ {
+		for j := i + 1; j < len(addresses); j++ {
+			if bytes.Compare(addresses[i][:], addresses[j][:]) > 0 {
+				addresses[i], addresses[j] = addresses[j], addresses[i]
+			}
+		}
+	}
+}
+
+// verifyExtraSigners checks that the signer list of a checkpoint header on a
+// contract governed chain is one the contract could have produced: non-empty,
+// sorted in ascending order and free of duplicates. It is all that can be done
+// without the parent state, the checkpoint itself being signed by a signer of
+// the current snapshot.
+func verifyExtraSigners(header *types.Header) error {
+	signers := extraSigners(header)
+	if len(signers) == 0 {
+		return errInvalidCheckpointSigners
+	}
+	for i := 1; i < len(signers); i++ {
+		if bytes.Compare(signers[i-1][:], signers[i][:]) >= 0 {
+			return errInvalidCheckpointSigners
+		}
+	}
+	return nil
+}
+
+// verifyGovernedCheckpoint checks the signer list of a checkpoint header on a
+// contract governed chain against the validator contract in the parent state.
+// If that state is unavailable, errMissingState is returned and the caller has
+// to settle for verifyExtraSigners until the block is processed.
+func (c *Clique) verifyGovernedCheckpoint(chain consensus.ChainReader, header *types.Header, parent *types.Header, snap *Snapshot) error {
+	expected, ok := c.governedSigners(chain, parent, snap)
+	if !ok {
+		return errMissingState
+	}
+	signers := make([]byte, len(expected)*common.AddressLength)
+	for i, signer := range expected {
+		copy(signers[i*common.AddressLength:], signer[:])
+	}
+	if !bytes.Equal(header.Extra[extraVanity:len(header.Extra)-extraSeal], signers) {
+		return errInvalidCheckpointSigners
+	}
+	return nil
+}
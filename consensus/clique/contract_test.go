@@ -0,0 +1,228 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/core/vm"
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/params"
+)
+
+// Tests that on contract governed chains the signer set is switched to the one
+// stored in the validator contract at checkpoints.
+func TestContractGovernedSigners(t *testing.T) {
+	accounts := newTesterAccountPool()
+
+	var (
+		contract = common.HexToAddress("0xc0ffee")
+		base     = crypto.Keccak256Hash(common.Hash{}.Bytes()).Big()
+	)
+	// Create a genesis authorizing A, with the contract listing B and C (twice)
+	config := *params.AllCliqueProtocolChanges
+	config.Clique = &params.CliqueConfig{Epoch: 2, ValidatorContract: &contract}
+
+	genesis := &core.Genesis{
+		Config:    &config,
+		ExtraData: make([]byte, extraVanity+common.AddressLength+extraSeal),
+		GasLimit:  params.GenesisGasLimit,
+		Alloc: core.GenesisAlloc{
+			contract: {
+				Code:    []byte{0x00},
+				Balance: big.NewInt(0),
+				Storage: map[common.Hash]common.Hash{
+					common.Hash{}:          common.BigToHash(big.NewInt(3)),
+					common.BigToHash(base): accounts.address("B").Hash(),
+					common.BigToHash(new(big.Int).Add(base, big.NewInt(1))): accounts.address("C").Hash(),
+					common.BigToHash(new(big.Int).Add(base, big.NewInt(2))): accounts.address("B").Hash(),
+				},
+			},
+		},
+	}
+	copy(genesis.ExtraData[extraVanity:], accounts.address("A").Bytes())
+
+	db, _ := hucdb.NewMemDatabase()
+	genesis.MustCommit(db)
+
+	engine := New(config.Clique, db)
+	chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	// mine creates the next block signed by the given account and imports it
+	mine := func(signer string) error {
+		parent := chain.CurrentBlock()
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			GasLimit:   parent.GasLimit(),
+		}
+		engine.Authorize(accounts.address(signer), nil)
+		if err := engine.Prepare(chain, header); err != nil {
+			return err
+		}
+		statedb, _ := chain.StateAt(parent.Root())
+		block, _ := engine.Finalize(chain, header, statedb, nil, nil, nil)
+
+		header = block.Header()
+		accounts.sign(header, signer)
+		_, err := chain.InsertChain(types.Blocks{block.WithSeal(header)})
+		return err
+	}
+	// Block 1 is signed by A, the checkpoint at block 2 switches over to B and C
+	for i := 0; i < 2; i++ {
+		if err := mine("A"); err != nil {
+			t.Fatalf("block %d: failed to import: %v", i+1, err)
+		}
+	}
+	checkpoint := chain.CurrentHeader()
+	if signers := extraSigners(checkpoint); len(signers) != 2 {
+		t.Fatalf("checkpoint signer count mismatch: have %d, want 2", len(signers))
+	}
+	snap, err := engine.Snapshot(chain, checkpoint)
+	if err != nil {
+		t.Fatalf("failed to retrieve snapshot: %v", err)
+	}
+	if _, ok := snap.Signers[accounts.address("A")]; ok || len(snap.Signers) != 2 {
+		t.Fatalf("signer set not switched: %v", snap.signers())
+	}
+	if err := mine("A"); err != errUnauthorized {
+		t.Fatalf("removed signer error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+	if err := mine("B"); err != nil {
+		t.Fatalf("failed to import block of governed signer: %v", err)
+	}
+}
+
+// Tests that a checkpoint carrying a signer list other than the one in the
+// validator contract is rejected by header verification and block processing
+// (where the check is deferred to inside import batches). Chains without state
+// access accept it if well formed, leaving the contract check to processing.
+func TestContractForgedCheckpoint(t *testing.T) {
+	accounts := newTesterAccountPool()
+
+	var (
+		contract = common.HexToAddress("0xc0ffee")
+		base     = crypto.Keccak256Hash(common.Hash{}.Bytes()).Big()
+	)
+	// Create a genesis authorizing A, with the contract listing B only
+	config := *params.AllCliqueProtocolChanges
+	config.Clique = &params.CliqueConfig{Epoch: 2, ValidatorContract: &contract}
+
+	genesis := &core.Genesis{
+		Config:    &config,
+		ExtraData: make([]byte, extraVanity+common.AddressLength+extraSeal),
+		GasLimit:  params.GenesisGasLimit,
+		Alloc: core.GenesisAlloc{
+			contract: {
+				Code:    []byte{0x00},
+				Balance: big.NewInt(0),
+				Storage: map[common.Hash]common.Hash{
+					common.Hash{}:          common.BigToHash(big.NewInt(1)),
+					common.BigToHash(base): accounts.address("B").Hash(),
+				},
+			},
+		},
+	}
+	copy(genesis.ExtraData[extraVanity:], accounts.address("A").Bytes())
+
+	db, _ := hucdb.NewMemDatabase()
+	genesis.MustCommit(db)
+
+	engine := New(config.Clique, db)
+	chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	// Create block 1 and a checkpoint on top of it keeping A instead of B, both
+	// signed by A. The chain doesn't contain transactions, so all state roots
+	// are the genesis one.
+	var blocks types.Blocks
+	for number := int64(1); number <= 2; number++ {
+		parent := chain.Genesis().Header()
+		if len(blocks) > 0 {
+			parent = blocks[len(blocks)-1].Header()
+		}
+		header := &types.Header{
+			ParentHash:  parent.Hash(),
+			Number:      big.NewInt(number),
+			GasLimit:    parent.GasLimit,
+			Time:        new(big.Int).Add(parent.Time, common.Big1),
+			Difficulty:  diffInTurn,
+			Root:        parent.Root,
+			TxHash:      types.EmptyRootHash,
+			ReceiptHash: types.EmptyRootHash,
+			UncleHash:   types.EmptyUncleHash,
+			Extra:       make([]byte, extraVanity+extraSeal),
+		}
+		if number == 2 {
+			header.Extra = make([]byte, extraVanity+common.AddressLength+extraSeal)
+			copy(header.Extra[extraVanity:], accounts.address("A").Bytes())
+		}
+		accounts.sign(header, "A")
+		blocks = append(blocks, types.NewBlockWithHeader(header))
+	}
+	// Header only chains can't check the list against the contract, but must
+	// still reject malformed ones
+	hdb, _ := hucdb.NewMemDatabase()
+	genesis.MustCommit(hdb)
+
+	headers, err := core.NewBlockChain(hdb, nil, &config, New(config.Clique, hdb), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create header chain: %v", err)
+	}
+	defer headers.Stop()
+
+	malformed := types.CopyHeader(blocks[1].Header())
+	malformed.Extra = make([]byte, extraVanity+2*common.AddressLength+extraSeal)
+	copy(malformed.Extra[extraVanity:], accounts.address("A").Bytes())
+	copy(malformed.Extra[extraVanity+common.AddressLength:], accounts.address("A").Bytes())
+	accounts.sign(malformed, "A")
+
+	if _, err := headers.InsertHeaderChain([]*types.Header{blocks[0].Header(), malformed}, 1); err != errInvalidCheckpointSigners {
+		t.Fatalf("malformed checkpoint error mismatch: have %v, want %v", err, errInvalidCheckpointSigners)
+	}
+	if _, err := headers.InsertHeaderChain([]*types.Header{blocks[0].Header(), blocks[1].Header()}, 1); err != nil {
+		t.Fatalf("failed to import header chain: %v", err)
+	}
+	if head := headers.CurrentHeader().Number.Uint64(); head != 2 {
+		t.Fatalf("header chain head mismatch: have %d, want 2", head)
+	}
+	// With the parent state present, header verification rejects it directly
+	if _, err := chain.InsertChain(blocks); err != errInvalidCheckpointSigners {
+		t.Fatalf("import error mismatch: have %v, want %v", err, errInvalidCheckpointSigners)
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != 1 {
+		t.Fatalf("chain head mismatch: have %d, want 1", head)
+	}
+	// Block processing, where checks deferred by header verification end up, must
+	// reject it too
+	statedb, _ := chain.StateAt(blocks[0].Root())
+	if _, _, _, err := chain.Processor().Process(blocks[1], statedb, vm.Config{}); err != errInvalidCheckpointSigners {
+		t.Fatalf("processing error mismatch: have %v, want %v", err, errInvalidCheckpointSigners)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"encoding/json"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+)
+
+// proposalsKey is the database key under which the local proposals are stored.
+var proposalsKey = []byte("clique-proposals")
+
+// Proposal is an authorization change the local signer tries to push through by
+// voting on it in every block it seals.
+type Proposal struct {
+	Authorize bool   `json:"authorize"` // Whether to authorize or deauthorize the account
+	Expiry    uint64 `json:"expiry"`    // Last block number to vote in (0 = never expires)
+}
+
+// expired returns whether the proposal should no longer be voted on in the block
+// with the given number.
+func (p *Proposal) expired(number uint64) bool {
+	return p.Expiry != 0 && number > p.Expiry
+}
+
+// loadProposals retrieves the locally pushed proposals from the database.
+func loadProposals(db hucdb.Database) (map[common.Address]*Proposal, error) {
+	proposals := make(map[common.Address]*Proposal)
+
+	// Missing proposals are not an error, there simply weren't any made yet
+	if ok, _ := db.Has(proposalsKey); !ok {
+		return proposals, nil
+	}
+	blob, err := db.Get(proposalsKey)
+	if err != nil {
+		return proposals, err
+	}
+	if err := json.Unmarshal(blob, &proposals); err != nil {
+		return make(map[common.Address]*Proposal), err
+	}
+	return proposals, nil
+}
+
+// storeProposals inserts the locally pushed proposals into the database.
+func storeProposals(db hucdb.Database, proposals map[common.Address]*Proposal) error {
+	blob, err := json.Marshal(proposals)
+	if err != nil {
+		return err
+	}
+	return db.Put(proposalsKey, blob)
+}
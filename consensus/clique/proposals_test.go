@@ -0,0 +1,52 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/common/hexutil"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/params"
+)
+
+// Tests that proposals survive an engine restart and that discarded ones don't.
+func TestProposalPersistence(t *testing.T) {
+	db, _ := hucdb.NewMemDatabase()
+
+	var (
+		kept    = common.HexToAddress("0x01")
+		dropped = common.HexToAddress("0x02")
+		expiry  = hexutil.Uint64(100)
+	)
+	api := &API{clique: New(&params.CliqueConfig{}, db)}
+	api.Propose(kept, true, &expiry)
+	api.Propose(dropped, false, nil)
+	api.Discard(dropped)
+
+	proposals := New(&params.CliqueConfig{}, db).Proposals()
+	if len(proposals) != 1 {
+		t.Fatalf("proposal count mismatch: have %d, want 1", len(proposals))
+	}
+	if proposal := proposals[kept]; proposal == nil || !proposal.Authorize || proposal.Expiry != 100 {
+		t.Fatalf("proposal mismatch: have %+v, want authorize until block 100", proposal)
+	}
+	if proposals[kept].expired(100) || !proposals[kept].expired(101) {
+		t.Errorf("proposal expiry mismatch")
+	}
+}
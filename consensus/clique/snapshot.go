@@ -215,6 +215,22 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 		}
 		snap.Recents[number] = signer
 
+		// On contract governed chains, checkpoints carry the new signer set and
+		// votes are meaningless
+		if s.config.ValidatorContract != nil {
+			if number%s.config.Epoch == 0 {
+				snap.Signers = make(map[common.Address]struct{})
+				for _, signer := range extraSigners(header) {
+					snap.Signers[signer] = struct{}{}
+				}
+				for block, recent := range snap.Recents {
+					if _, ok := snap.Signers[recent]; !ok {
+						delete(snap.Recents, block)
+					}
+				}
+			}
+			continue
+		}
 		// Header authorized, discard any previous votes from the signer
 		for i, vote := range snap.Votes {
 			if vote.Signer == signer && vote.Address == header.Coinbase {
@@ -290,13 +306,7 @@ func (s *Snapshot) signers() []common.Address {
 	for signer := range s.Signers {
 		signers = append(signers, signer)
 	}
-	for i := 0; i < len(signers); i++ {
-		for j := i + 1; j < len(signers); j++ {
-			if bytes.Compare(signers[i][:], signers[j][:]) > 0 {
-				signers[i], signers[j] = signers[j], signers[i]
-			}
-		}
-	}
+	sortAddresses(signers)
 	return signers
 }
 
@@ -0,0 +1,138 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/consensus/huchash"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/core/vm"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/params"
+)
+
+// Tests that a chain switching from proof-of-work to clique can be built and
+// imported, both as full blocks and as headers only, in batches spanning the
+// switch.
+func TestEthashSwitch(t *testing.T) { testEthashSwitch(t, 3) }
+
+// Tests that a chain can switch over to clique right after its proof-of-work
+// genesis block.
+func TestEthashSwitchAfterGenesis(t *testing.T) { testEthashSwitch(t, 1) }
+
+func testEthashSwitch(t *testing.T, switchBlock int64) {
+	accounts := newTesterAccountPool()
+
+	config := *params.AllEthashProtocolChanges
+	config.CliqueSwitchBlock = big.NewInt(switchBlock)
+	config.Clique = &params.CliqueConfig{Epoch: 30000, Signers: []common.Address{accounts.address("A")}}
+
+	genesis := &core.Genesis{Config: &config, GasLimit: params.GenesisGasLimit}
+
+	// newChain creates a fresh chain running the switching engine
+	newChain := func() *core.BlockChain {
+		db, _ := hucdb.NewMemDatabase()
+		genesis.MustCommit(db)
+
+		clique := New(config.Clique, db)
+		clique.Authorize(accounts.address("A"), nil)
+
+		engine := consensus.NewSwitch(config.CliqueSwitchBlock, huchash.NewFaker(), clique)
+		chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{})
+		if err != nil {
+			t.Fatalf("failed to create chain: %v", err)
+		}
+		return chain
+	}
+	// Build a chain crossing the switch, sealing the clique blocks with signer A
+	chain := newChain()
+	defer chain.Stop()
+
+	mine := func(signer string) (*types.Block, error) {
+		parent := chain.CurrentBlock()
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			GasLimit:   parent.GasLimit(),
+			Time:       new(big.Int).Add(parent.Time(), big.NewInt(10)),
+		}
+		engine := chain.Engine()
+		if err := engine.Prepare(chain, header); err != nil {
+			return nil, err
+		}
+		statedb, _ := chain.StateAt(parent.Root())
+		block, _ := engine.Finalize(chain, header, statedb, nil, nil, nil)
+
+		if config.IsCliqueSwitch(header.Number) {
+			header = block.Header()
+			accounts.sign(header, signer)
+			block = block.WithSeal(header)
+		} else {
+			block, _ = engine.Seal(chain, block, nil)
+		}
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			return nil, err
+		}
+		return block, nil
+	}
+	var blocks types.Blocks
+	for i := 0; i < 5; i++ {
+		block, err := mine("A")
+		if err != nil {
+			t.Fatalf("block %d: failed to import: %v", i+1, err)
+		}
+		blocks = append(blocks, block)
+	}
+	for _, block := range blocks[:switchBlock-1] {
+		if block.Difficulty().Cmp(big.NewInt(2)) <= 0 {
+			t.Errorf("block %d: proof-of-work difficulty too low: %v", block.NumberU64(), block.Difficulty())
+		}
+	}
+	for _, block := range blocks[switchBlock-1:] {
+		if signer, err := chain.Engine().Author(block.Header()); err != nil || signer != accounts.address("A") {
+			t.Errorf("block %d: signer mismatch: have %x, want %x (err %v)", block.NumberU64(), signer, accounts.address("A"), err)
+		}
+	}
+	if _, err := mine("B"); err != errUnauthorized {
+		t.Fatalf("unauthorized signer error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+	// Import the entire chain in a single batch into fresh chains
+	full := newChain()
+	defer full.Stop()
+
+	if n, err := full.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import block %d: %v", n, err)
+	}
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	light := newChain()
+	defer light.Stop()
+
+	if n, err := light.InsertHeaderChain(headers, 100); err != nil {
+		t.Fatalf("failed to import header %d: %v", n, err)
+	}
+	if head := light.CurrentHeader().Hash(); head != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", head, blocks[len(blocks)-1].Hash())
+	}
+}
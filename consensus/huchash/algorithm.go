@@ -138,7 +138,12 @@ func generateCache(dest []uint32, epoch uint64, seed []byte) {
 			logFn = logger.Info
 		}
 		logFn("Generated ethash verification cache", "elapsed", common.PrettyDuration(elapsed))
+
+		cacheProgressGauge.Update(100)
+		cacheGenerateTimer.Update(elapsed)
 	}()
+	cacheProgressGauge.Update(0)
+
 	// Convert our destination slice to a byte buffer
 	header := *(*reflect.SliceHeader)(unsafe.Pointer(&dest))
 	header.Len *= 4
@@ -161,7 +166,10 @@ func generateCache(dest []uint32, epoch uint64, seed []byte) {
 			case <-done:
 				return
 			case <-time.After(3 * time.Second):
-				logger.Info("Generating ethash verification cache", "percentage", atomic.LoadUint32(&progress)*100/uint32(rows)/4, "elapsed", common.PrettyDuration(time.Since(start)))
+				percentage := atomic.LoadUint32(&progress) * 100 / uint32(rows) / 4
+
+				cacheProgressGauge.Update(int64(percentage))
+				logger.Info("Generating ethash verification cache", "percentage", percentage, "elapsed", common.PrettyDuration(time.Since(start)))
 			}
 		}
 	}()
@@ -275,7 +283,11 @@ func generateDataset(dest []uint32, epoch uint64, cache []uint32) {
 			logFn = logger.Info
 		}
 		logFn("Generated ethash verification cache", "elapsed", common.PrettyDuration(elapsed))
+
+		datasetProgressGauge.Update(100)
+		datasetGenerateTimer.Update(elapsed)
 	}()
+	datasetProgressGauge.Update(0)
 
 	// Figure out whether the bytes need to be swapped for the machine
 	swapped := !isLittleEndian()
@@ -318,7 +330,10 @@ func generateDataset(dest []uint32, epoch uint64, cache []uint32) {
 				copy(dataset[index*hashBytes:], item)
 
 				if status := atomic.AddUint32(&progress, 1); status%percent == 0 {
-					logger.Info("Generating DAG in progress", "percentage", uint64(status*100)/(size/hashBytes), "elapsed", common.PrettyDuration(time.Since(start)))
+					percentage := uint64(status*100) / (size / hashBytes)
+
+					datasetProgressGauge.Update(int64(percentage))
+					logger.Info("Generating DAG in progress", "percentage", percentage, "elapsed", common.PrettyDuration(time.Since(start)))
 				}
 			}
 		}(i)
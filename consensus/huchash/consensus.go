@@ -500,6 +500,31 @@ func (ethash *Ethash) VerifySeal(chain consensus.ChainReader, header *types.Head
 	return nil
 }
 
+// Hashimoto computes the mix digest and the proof-of-work value of a header with
+// its current nonce using the verification cache, without checking them against
+// any difficulty. It is used to validate mining shares below the block difficulty.
+func (ethash *Ethash) Hashimoto(header *types.Header) (common.Hash, *big.Int) {
+	// If we're running a fake PoW, every seal is as good as it gets
+	if ethash.config.PowMode == ModeFake || ethash.config.PowMode == ModeFullFake {
+		return common.Hash{}, new(big.Int)
+	}
+	// If we're running a shared PoW, delegate computation to it
+	if ethash.shared != nil {
+		return ethash.shared.Hashimoto(header)
+	}
+	number := header.Number.Uint64()
+
+	cache := ethash.cache(number)
+	size := datasetSize(number)
+	if ethash.config.PowMode == ModeTest {
+		size = 32 * 1024
+	}
+	digest, result := hashimotoLight(size, cache.cache, header.HashNoNonce().Bytes(), header.Nonce.Uint64())
+	runtime.KeepAlive(cache)
+
+	return common.BytesToHash(digest), new(big.Int).SetBytes(result)
+}
+
 // Prepare implements consensus.Engine, initializing the difficulty field of a
 // header to conform to the ethash protocol. The changes are done inline.
 func (ethash *Ethash) Prepare(chain consensus.ChainReader, header *types.Header) error {
@@ -38,10 +38,15 @@ import (
 	"github.com/happyuc-project/happyuc-go/metrics"
 	"github.com/happyuc-project/happyuc-go/rpc"
 	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/prometheus/prometheus/util/flock"
 )
 
 var ErrInvalidDumpMagic = errors.New("invalid dump magic")
 
+// errVerifyOnly is returned if a seal is requested from an ethash engine which
+// was created for verification purposes only.
+var errVerifyOnly = errors.New("ethash engine is verification only")
+
 var (
 	// maxUint256 is a big integer representing 2^256-1
 	maxUint256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
@@ -54,6 +59,15 @@ var (
 
 	// dumpMagic is a dataset dump header to sanity check a data dump.
 	dumpMagic = []uint32{0xbaddcafe, 0xfee1dead}
+
+	// lockRetryInterval is the time to wait between attempts to acquire a file
+	// lock held by another process generating the same cache or dataset.
+	lockRetryInterval = 100 * time.Millisecond
+
+	cacheProgressGauge   = metrics.NewRegisteredGauge("huchash/cache/progress", nil)
+	cacheGenerateTimer   = metrics.NewRegisteredTimer("huchash/cache/generate", nil)
+	datasetProgressGauge = metrics.NewRegisteredGauge("huchash/dataset/progress", nil)
+	datasetGenerateTimer = metrics.NewRegisteredTimer("huchash/dataset/generate", nil)
 )
 
 // isLittleEndian returns whether the local system is running in little or big
@@ -144,6 +158,31 @@ func memoryMapAndGenerate(path string, size uint64, generator func(buffer []uint
 	return memoryMap(path)
 }
 
+// lockFile acquires an exclusive lock on a file next to the given path, waiting
+// for any other process (or goroutine) currently holding it to release it. The
+// lock ensures that only one generator fills a shared cache or dataset at once.
+func lockFile(path string) (flock.Releaser, error) {
+	var (
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for {
+		release, _, err := flock.New(path + ".lock")
+		if err == nil {
+			return release, nil
+		}
+		// Filesystem errors are permanent, only retry lock contention
+		if _, ok := err.(*os.PathError); ok {
+			return nil, err
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Waiting for ethash file lock", "path", path, "elapsed", time.Since(start))
+			logged = time.Now()
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
 // lru tracks caches or datasets by their last use time, keeping at most N of them.
 type lru struct {
 	what string
@@ -246,6 +285,18 @@ func (c *cache) generate(dir string, limit int, test bool) {
 		}
 		logger.Debug("Failed to load old ethash cache", "err", err)
 
+		// Lock the cache file so concurrent processes sharing the same directory
+		// don't generate it multiple times, and retry loading once acquired.
+		if release, err := lockFile(path); err != nil {
+			logger.Warn("Failed to lock ethash cache", "err", err)
+		} else {
+			defer release.Release()
+
+			if c.dump, c.mmap, c.cache, err = memoryMap(path); err == nil {
+				logger.Debug("Loaded shared ethash cache from disk")
+				return
+			}
+		}
 		// No previous cache available, create a new cache file to fill
 		c.dump, c.mmap, c.cache, err = memoryMapAndGenerate(path, size, func(buffer []uint32) { generateCache(buffer, c.epoch, seed) })
 		if err != nil {
@@ -304,6 +355,7 @@ func (d *dataset) generate(dir string, limit int, test bool) {
 
 			d.dataset = make([]uint32, dsize/4)
 			generateDataset(d.dataset, d.epoch, cache)
+			return
 		}
 		// Disk storage is needed, this will get fancy
 		var endian string
@@ -326,6 +378,18 @@ func (d *dataset) generate(dir string, limit int, test bool) {
 		}
 		logger.Debug("Failed to load old ethash dataset", "err", err)
 
+		// Lock the dataset file so concurrent processes sharing the same directory
+		// don't generate it multiple times, and retry loading once acquired.
+		if release, err := lockFile(path); err != nil {
+			logger.Warn("Failed to lock ethash dataset", "err", err)
+		} else {
+			defer release.Release()
+
+			if d.dump, d.mmap, d.dataset, err = memoryMap(path); err == nil {
+				logger.Debug("Loaded shared ethash dataset from disk")
+				return
+			}
+		}
 		// No previous dataset available, create a new dataset file to fill
 		cache := make([]uint32, csize/4)
 		generateCache(cache, d.epoch, seed)
@@ -403,6 +467,8 @@ type Ethash struct {
 	update   chan struct{} // Notification channel to update mining parameters
 	hashrate metrics.Meter // Meter tracking the average hashrate
 
+	verifyOnly bool // Whether the engine is limited to seal verification (no datasets)
+
 	// The fields below are hooks for testing
 	shared    *Ethash       // Shared PoW verifier to avoid cache regeneration
 	fakeFail  uint64        // Block number which fails PoW check even in fake mode
@@ -432,6 +498,19 @@ func New(config Config) *Ethash {
 	}
 }
 
+// NewVerifier creates a full sized ethash PoW scheme that is only capable of
+// verifying seals. It never allocates or loads a mining dataset, so it is well
+// suited for light clients, chain imports and tooling that never mine.
+func NewVerifier(config Config) *Ethash {
+	config.DatasetDir, config.DatasetsInMem, config.DatasetsOnDisk = "", 0, 0
+
+	ethash := New(config)
+	ethash.datasets = nil
+	ethash.verifyOnly = true
+
+	return ethash
+}
+
 // NewTester creates a small sized ethash PoW scheme useful only for testing
 // purposes.
 func NewTester() *Ethash {
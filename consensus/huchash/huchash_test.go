@@ -17,10 +17,13 @@
 package huchash
 
 import (
+	"fmt"
 	"io/ioutil"
 	"math/big"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
 	"sync"
 	"testing"
 
@@ -77,3 +80,73 @@ func verifyTest(wg *sync.WaitGroup, e *Ethash, workerIndex, epochs int) {
 		e.VerifySeal(nil, head)
 	}
 }
+
+// Tests that verification only engines refuse to seal but still verify.
+func TestVerifierMode(t *testing.T) {
+	head := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(100)}
+
+	block, err := NewTester().Seal(nil, types.NewBlockWithHeader(head), nil)
+	if err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	head.Nonce = types.EncodeNonce(block.Nonce())
+	head.MixDigest = block.MixDigest()
+
+	verifier := NewVerifier(Config{CachesInMem: 1, PowMode: ModeTest})
+	if _, err := verifier.Seal(nil, types.NewBlockWithHeader(head), nil); err != errVerifyOnly {
+		t.Fatalf("seal error mismatch: have %v, want %v", err, errVerifyOnly)
+	}
+	if err := verifier.VerifySeal(nil, head); err != nil {
+		t.Fatalf("unexpected verification error: %v", err)
+	}
+	if verifier.datasets != nil {
+		t.Fatalf("verifier allocated dataset cache")
+	}
+}
+
+// Tests that multiple engines sharing a cache directory concurrently end up
+// with the same single cache file on disk.
+func TestSharedCacheDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "ethash-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	engines := make([]*Ethash, 4)
+	for i := range engines {
+		engines[i] = NewVerifier(Config{CachesInMem: 1, CachesOnDisk: 1, CacheDir: tmpdir, PowMode: ModeTest})
+	}
+	caches := make([]*cache, len(engines))
+
+	var wg sync.WaitGroup
+	for i, engine := range engines {
+		wg.Add(1)
+		go func(i int, engine *Ethash) {
+			defer wg.Done()
+			caches[i] = engine.cache(1)
+		}(i, engine)
+	}
+	wg.Wait()
+
+	for i := 1; i < len(caches); i++ {
+		if !reflect.DeepEqual(caches[i].cache, caches[0].cache) {
+			t.Fatalf("cache %d mismatch", i)
+		}
+	}
+	// Only check the current epoch, the next one is generated in the background
+	seed := seedHash(1)
+	dumps, err := filepath.Glob(filepath.Join(tmpdir, fmt.Sprintf("cache-R%d-%x*", algorithmRevision, seed[:8])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files int
+	for _, dump := range dumps {
+		if filepath.Ext(dump) != ".lock" {
+			files++
+		}
+	}
+	if files != 1 {
+		t.Fatalf("cache file count mismatch: have %d, want 1: %v", files, dumps)
+	}
+}
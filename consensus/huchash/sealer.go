@@ -43,6 +43,10 @@ func (ethash *Ethash) Seal(chain consensus.ChainReader, block *types.Block, stop
 	if ethash.shared != nil {
 		return ethash.shared.Seal(chain, block, stop)
 	}
+	// Verification only engines have no dataset to mine with
+	if ethash.verifyOnly {
+		return nil, errVerifyOnly
+	}
 	// Create a runner and the multiple search threads it directs
 	abort := make(chan struct{})
 	found := make(chan *types.Block)
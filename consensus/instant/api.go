@@ -0,0 +1,45 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package instant
+
+import (
+	"github.com/happyuc-project/happyuc-go/common/hexutil"
+	"github.com/happyuc-project/happyuc-go/consensus"
+)
+
+// API is a user facing RPC API to control the clock and sealing of an instant
+// developer chain.
+type API struct {
+	chain   consensus.ChainReader
+	instant *Instant
+}
+
+// IncreaseTime moves the engine clock forward by the given number of seconds,
+// returning the total number of seconds it is ahead of the system clock.
+func (api *API) IncreaseTime(seconds hexutil.Uint64) hexutil.Uint64 {
+	return hexutil.Uint64(api.instant.IncreaseTime(uint64(seconds)).Seconds())
+}
+
+// Mine seals the given number of blocks (one if omitted), regardless of whether
+// there are any pending transactions to include. The miner must be running.
+func (api *API) Mine(blocks *hexutil.Uint64) {
+	n := 1
+	if blocks != nil {
+		n = int(*blocks)
+	}
+	api.instant.Mine(n)
+}
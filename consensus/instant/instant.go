@@ -0,0 +1,287 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package instant implements an instant-seal consensus engine for developer chains.
+package instant
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/consensus/misc"
+	"github.com/happyuc-project/happyuc-go/core/state"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/params"
+	"github.com/happyuc-project/happyuc-go/rpc"
+)
+
+var (
+	// blockDifficulty is the fixed difficulty of every instant-sealed block.
+	blockDifficulty = big.NewInt(1)
+
+	// uncleHash is the hash of an empty uncle list, the only one allowed.
+	uncleHash = types.CalcUncleHash(nil)
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when an operation is requested on a block that
+	// is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is not higher
+	// than the previous block's timestamp.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+)
+
+// Instant is a consensus engine for single node developer chains. It seals any
+// block containing transactions the moment it's handed over, while empty blocks
+// are only sealed on explicit request. The engine clock can be moved forward to
+// test time dependent contract logic.
+//
+// Instant chains offer no security whatsoever, any node may create any block.
+type Instant struct {
+	offset int64 // Number of seconds the engine clock is ahead of the system clock
+	empty  int   // Number of empty blocks requested to be sealed
+	wake   chan struct{}
+
+	lock sync.Mutex // Protects the engine clock and sealing requests
+}
+
+// New creates an instant-seal consensus engine.
+func New() *Instant {
+	return &Instant{
+		wake: make(chan struct{}),
+	}
+}
+
+// now returns the current time of the engine clock.
+func (i *Instant) now() int64 {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return time.Now().Unix() + i.offset
+}
+
+// IncreaseTime moves the engine clock forward by the given number of seconds,
+// affecting the timestamps of all subsequently created blocks. The total offset
+// from the system clock is returned.
+func (i *Instant) IncreaseTime(seconds uint64) time.Duration {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.offset += int64(seconds)
+	return time.Duration(i.offset) * time.Second
+}
+
+// Mine requests the sealing of the given number of blocks, even if they don't
+// contain any transactions.
+func (i *Instant) Mine(blocks int) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.empty += blocks
+	close(i.wake)
+	i.wake = make(chan struct{})
+}
+
+// Author implements consensus.Engine, returning the header's coinbase as the
+// block author.
+func (i *Instant) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (i *Instant) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return i.verifyHeader(chain, header, nil)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (i *Instant) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	go func() {
+		for j, header := range headers {
+			err := i.verifyHeader(chain, header, headers[:j])
+
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database.
+//
+// Note, future blocks are deliberately accepted, as the engine clock may have
+// been moved ahead of the system clock.
+func (i *Instant) verifyHeader(chain consensus.ChainReader, header *types.Header, parents []*types.Header) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	if uint64(len(header.Extra)) > params.MaximumExtraDataSize {
+		return fmt.Errorf("extra-data too long: %d > %d", len(header.Extra), params.MaximumExtraDataSize)
+	}
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	if header.Difficulty == nil || header.Difficulty.Cmp(blockDifficulty) != 0 {
+		return errInvalidDifficulty
+	}
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	if err := misc.VerifyForkHashes(chain.Config(), header, false); err != nil {
+		return err
+	}
+	// All standalone checks passed, verify the header against its parent
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if header.Time.Cmp(parent.Time) <= 0 {
+		return errInvalidTimestamp
+	}
+	return nil
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (i *Instant) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// VerifySeal implements consensus.Engine. Instant blocks carry no seal, so only
+// the fixed difficulty is checked.
+func (i *Instant) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	if header.Difficulty == nil || header.Difficulty.Cmp(blockDifficulty) != 0 {
+		return errInvalidDifficulty
+	}
+	return nil
+}
+
+// Prepare implements consensus.Engine, setting the fixed difficulty and moving
+// the timestamp of the header up to the engine clock.
+func (i *Instant) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Difficulty = new(big.Int).Set(blockDifficulty)
+	header.MixDigest = common.Hash{}
+	header.Nonce = types.BlockNonce{}
+
+	if now := big.NewInt(i.now()); header.Time == nil || header.Time.Cmp(now) < 0 {
+		header.Time = now
+	}
+	if header.Time.Cmp(parent.Time) <= 0 {
+		header.Time = new(big.Int).Add(parent.Time, common.Big1)
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given, and returns the final block.
+func (i *Instant) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+
+	return types.NewBlock(header, txs, nil, receipts), nil
+}
+
+// Seal implements consensus.Engine. Blocks with transactions are sealed right
+// away, whereas empty blocks wait until explicitly requested via Mine.
+func (i *Instant) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	if block.NumberU64() == 0 {
+		return nil, errUnknownBlock
+	}
+	if len(block.Transactions()) > 0 {
+		return block, nil
+	}
+	for {
+		i.lock.Lock()
+		if i.empty > 0 {
+			i.empty--
+			i.lock.Unlock()
+			return block, nil
+		}
+		wake := i.wake
+		i.lock.Unlock()
+
+		select {
+		case <-stop:
+			return nil, nil
+		case <-wake:
+		}
+	}
+}
+
+// CalcDifficulty implements consensus.Engine, returning the fixed difficulty of
+// instant blocks.
+func (i *Instant) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(blockDifficulty)
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC API to allow
+// controlling the engine clock and sealing.
+func (i *Instant) APIs(chain consensus.ChainReader) []rpc.API {
+	return []rpc.API{{
+		Namespace: "dev",
+		Version:   "1.0",
+		Service:   &API{chain: chain, instant: i},
+		Public:    false,
+	}}
+}
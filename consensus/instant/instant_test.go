@@ -0,0 +1,109 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package instant
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/core/vm"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/params"
+)
+
+// Tests that blocks with transactions are sealed immediately, whereas empty ones
+// wait until they are explicitly requested.
+func TestSealEmptyOnRequest(t *testing.T) {
+	engine := New()
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+
+	// Blocks with transactions must be sealed right away
+	tx := types.NewTransaction(0, common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil)
+	if block, err := engine.Seal(nil, types.NewBlock(header, []*types.Transaction{tx}, nil, nil), nil); err != nil || block == nil {
+		t.Fatalf("failed to seal block with transactions: %v", err)
+	}
+	// Empty blocks must block until aborted or requested
+	empty := types.NewBlockWithHeader(header)
+
+	stop := make(chan struct{})
+	close(stop)
+	if block, err := engine.Seal(nil, empty, stop); err != nil || block != nil {
+		t.Fatalf("aborted seal mismatch: have %v/%v, want nil/nil", block, err)
+	}
+	results := make(chan *types.Block)
+	go func() {
+		block, _ := engine.Seal(nil, empty, make(chan struct{}))
+		results <- block
+	}()
+	select {
+	case <-results:
+		t.Fatalf("empty block sealed without request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	engine.Mine(1)
+
+	select {
+	case block := <-results:
+		if block == nil {
+			t.Fatalf("requested empty block not sealed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("requested empty block not sealed")
+	}
+}
+
+// Tests that increasing the engine clock advances the timestamps of all the
+// subsequently prepared headers.
+func TestIncreaseTime(t *testing.T) {
+	var (
+		engine  = New()
+		db, _   = hucdb.NewMemDatabase()
+		genesis = (&core.Genesis{Config: params.AllEthashProtocolChanges}).MustCommit(db)
+	)
+	chain, err := core.NewBlockChain(db, nil, params.AllEthashProtocolChanges, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	prepare := func() *types.Header {
+		header := &types.Header{ParentHash: genesis.Hash(), Number: big.NewInt(1)}
+		if err := engine.Prepare(chain, header); err != nil {
+			t.Fatalf("failed to prepare header: %v", err)
+		}
+		return header
+	}
+	start := time.Now().Unix()
+	if have := prepare().Time.Int64(); have < start || have > start+1 {
+		t.Fatalf("initial timestamp mismatch: have %d, want %d", have, start)
+	}
+	// Advance the clock through the API and ensure the offset accumulates
+	api := &API{chain: chain, instant: engine}
+	if offset := api.IncreaseTime(3600); offset != 3600 {
+		t.Fatalf("offset mismatch: have %d, want 3600", offset)
+	}
+	if offset := api.IncreaseTime(60); offset != 3660 {
+		t.Fatalf("offset mismatch: have %d, want 3660", offset)
+	}
+	if have := prepare().Time.Int64(); have < start+3660 || have > start+3661 {
+		t.Fatalf("advanced timestamp mismatch: have %d, want %d", have, start+3660)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"math/big"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core/state"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/rpc"
+)
+
+// Switch is a consensus engine delegating to one engine up to a given block and
+// to another from there on, allowing a live chain to migrate between consensus
+// algorithms (e.g. from proof-of-work to proof-of-authority).
+type Switch struct {
+	block  *big.Int // First block handled by the after engine
+	before Engine   // Engine responsible for the blocks preceding the switch
+	after  Engine   // Engine responsible for the blocks from the switch onward
+}
+
+// NewSwitch creates a composite consensus engine, delegating to before for all
+// blocks below the given number and to after for all others.
+func NewSwitch(block *big.Int, before, after Engine) *Switch {
+	return &Switch{
+		block:  new(big.Int).Set(block),
+		before: before,
+		after:  after,
+	}
+}
+
+// Before returns the consensus engine responsible for the blocks preceding the
+// switch.
+func (s *Switch) Before() Engine {
+	return s.before
+}
+
+// After returns the consensus engine responsible for the blocks from the switch
+// onward.
+func (s *Switch) After() Engine {
+	return s.after
+}
+
+// engine returns the consensus engine responsible for the given block number.
+func (s *Switch) engine(number *big.Int) Engine {
+	if number.Cmp(s.block) < 0 {
+		return s.before
+	}
+	return s.after
+}
+
+// Author implements Engine, delegating to the engine of the header.
+func (s *Switch) Author(header *types.Header) (common.Address, error) {
+	return s.engine(header.Number).Author(header)
+}
+
+// VerifyHeader implements Engine, delegating to the engine of the header.
+func (s *Switch) VerifyHeader(chain ChainReader, header *types.Header, seal bool) error {
+	return s.engine(header.Number).VerifyHeader(chain, header, seal)
+}
+
+// VerifyHeaders implements Engine, splitting the batch at the switch and running
+// the verifications of the two halves one after the other. Headers after the
+// switch may reference the ones before it as their ancestors.
+func (s *Switch) VerifyHeaders(chain ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	// Find the first header handled by the after engine
+	split := len(headers)
+	for i, header := range headers {
+		if header.Number.Cmp(s.block) >= 0 {
+			split = i
+			break
+		}
+	}
+	// If the entire batch belongs to one engine, delegate directly
+	switch split {
+	case 0:
+		return s.after.VerifyHeaders(chain, headers, seals)
+	case len(headers):
+		return s.before.VerifyHeaders(chain, headers, seals)
+	}
+	// Batch spans the switch, verify the halves sequentially
+	abort, results := make(chan struct{}), make(chan error, len(headers))
+
+	go func() {
+		forward := func(quit chan<- struct{}, errs <-chan error, count int) bool {
+			defer close(quit)
+
+			for i := 0; i < count; i++ {
+				select {
+				case <-abort:
+					return false
+				case err := <-errs:
+					results <- err
+				}
+			}
+			return true
+		}
+		quit, errs := s.before.VerifyHeaders(chain, headers[:split], seals[:split])
+		if !forward(quit, errs, split) {
+			return
+		}
+		quit, errs = s.after.VerifyHeaders(newBatchReader(chain, headers[:split]), headers[split:], seals[split:])
+		forward(quit, errs, len(headers)-split)
+	}()
+	return abort, results
+}
+
+// VerifyUncles implements Engine, delegating to the engine of the block.
+func (s *Switch) VerifyUncles(chain ChainReader, block *types.Block) error {
+	return s.engine(block.Number()).VerifyUncles(chain, block)
+}
+
+// VerifySeal implements Engine, delegating to the engine of the header.
+func (s *Switch) VerifySeal(chain ChainReader, header *types.Header) error {
+	return s.engine(header.Number).VerifySeal(chain, header)
+}
+
+// Prepare implements Engine, delegating to the engine of the header.
+func (s *Switch) Prepare(chain ChainReader, header *types.Header) error {
+	return s.engine(header.Number).Prepare(chain, header)
+}
+
+// Finalize implements Engine, delegating to the engine of the header.
+func (s *Switch) Finalize(chain ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	return s.engine(header.Number).Finalize(chain, header, state, txs, uncles, receipts)
+}
+
+// Seal implements Engine, delegating to the engine of the block.
+func (s *Switch) Seal(chain ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	return s.engine(block.Number()).Seal(chain, block, stop)
+}
+
+// CalcDifficulty implements Engine, delegating to the engine of the block built
+// on top of parent.
+func (s *Switch) CalcDifficulty(chain ChainReader, time uint64, parent *types.Header) *big.Int {
+	return s.engine(new(big.Int).Add(parent.Number, common.Big1)).CalcDifficulty(chain, time, parent)
+}
+
+// APIs implements Engine, returning the RPC APIs of both engines.
+func (s *Switch) APIs(chain ChainReader) []rpc.API {
+	return append(s.before.APIs(chain), s.after.APIs(chain)...)
+}
+
+// Hashrate implements PoW, returning the hashrate of any proof-of-work engine
+// the switch delegates to.
+func (s *Switch) Hashrate() float64 {
+	var hashrate float64
+	for _, engine := range []Engine{s.before, s.after} {
+		if pow, ok := engine.(PoW); ok {
+			hashrate += pow.Hashrate()
+		}
+	}
+	return hashrate
+}
+
+// SetThreads updates the number of mining threads of any engine the switch
+// delegates to that supports it.
+func (s *Switch) SetThreads(threads int) {
+	type threaded interface {
+		SetThreads(threads int)
+	}
+	for _, engine := range []Engine{s.before, s.after} {
+		if th, ok := engine.(threaded); ok {
+			th.SetThreads(threads)
+		}
+	}
+}
+
+// batchReader is a chain reader that also serves the headers of a batch being
+// verified, which are not yet present in the database.
+type batchReader struct {
+	ChainReader
+	headers map[common.Hash]*types.Header
+}
+
+// newBatchReader wraps a chain reader, extending it with a batch of headers.
+func newBatchReader(chain ChainReader, headers []*types.Header) *batchReader {
+	reader := &batchReader{
+		ChainReader: chain,
+		headers:     make(map[common.Hash]*types.Header, len(headers)),
+	}
+	for _, header := range headers {
+		reader.headers[header.Hash()] = header
+	}
+	return reader
+}
+
+// GetHeader retrieves a block header from the batch or the database by hash and
+// number.
+func (r *batchReader) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header, ok := r.headers[hash]; ok && header.Number.Uint64() == number {
+		return header
+	}
+	return r.ChainReader.GetHeader(hash, number)
+}
+
+// GetHeaderByHash retrieves a block header from the batch or the database by its
+// hash.
+func (r *batchReader) GetHeaderByHash(hash common.Hash) *types.Header {
+	if header, ok := r.headers[hash]; ok {
+		return header
+	}
+	return r.ChainReader.GetHeaderByHash(hash)
+}
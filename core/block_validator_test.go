@@ -17,6 +17,7 @@
 package core
 
 import (
+	"math"
 	"runtime"
 	"testing"
 	"time"
@@ -197,3 +198,32 @@ func testHeaderConcurrentAbortion(t *testing.T, threads int) {
 		t.Errorf("verification count too large: have %d, want below %d", verified, 2*threads)
 	}
 }
+
+// Tests that the gas limit of mined blocks is voted towards the configured floor
+// and ceiling, never moving more than the protocol allows per block.
+func TestCalcGasLimit(t *testing.T) {
+	tests := []struct {
+		parentLimit, parentUsed uint64
+		floor, ceil             uint64
+		want                    uint64
+	}{
+		// Within range, empty parent: limit decays slowly
+		{5000000, 0, 4000000, 6000000, 5000000 - (5000000/1024 - 1)},
+		// Below the floor: raise by the maximum allowed step
+		{4000000, 0, 5000000, 6000000, 4000000 + (4000000/1024 - 1)},
+		// Just below the floor: raise exactly to it
+		{4999000, 0, 5000000, 6000000, 5000000},
+		// Above the ceiling with full parent: lower by the maximum allowed step
+		{7000000, 7000000, 5000000, 6000000, 7000000 - (7000000/1024 - 1)},
+		// Just above the ceiling: lower exactly to it
+		{6001000, 6001000, 5000000, 6000000, 6000000},
+		// No ceiling with full parent above the floor: grow with demand
+		{8000000, 8000000, 4712388, math.MaxUint64, 8000000 - (8000000/1024 - 1) + 8000000*3/2/1024},
+	}
+	for i, tt := range tests {
+		parent := types.NewBlockWithHeader(&types.Header{GasLimit: tt.parentLimit, GasUsed: tt.parentUsed})
+		if have := CalcGasLimit(parent, tt.floor, tt.ceil); have != tt.want {
+			t.Errorf("test %d: gas limit mismatch: have %d, want %d", i, have, tt.want)
+		}
+	}
+}
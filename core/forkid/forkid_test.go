@@ -0,0 +1,163 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package forkid
+
+import (
+	"math"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/params"
+)
+
+// TestCreation tests that different genesis and fork rule combinations result in
+// the correct fork ID.
+func TestCreation(t *testing.T) {
+	type testcase struct {
+		head uint64
+		want ID
+	}
+	tests := []struct {
+		config  *params.ChainConfig
+		genesis common.Hash
+		cases   []testcase
+	}{
+		// Mainnet test cases
+		{
+			params.MainnetChainConfig,
+			params.MainnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0xfc64ec04), Next: 1150000}},       // Unsynced
+				{1149999, ID{Hash: checksumToBytes(0xfc64ec04), Next: 1150000}}, // Last Frontier block
+				{1150000, ID{Hash: checksumToBytes(0x97c2c34c), Next: 1920000}}, // First Homestead block
+				{1919999, ID{Hash: checksumToBytes(0x97c2c34c), Next: 1920000}}, // Last Homestead block
+				{1920000, ID{Hash: checksumToBytes(0x91d1f948), Next: 2463000}}, // First DAO block
+				{2462999, ID{Hash: checksumToBytes(0x91d1f948), Next: 2463000}}, // Last DAO block
+				{2463000, ID{Hash: checksumToBytes(0x7a64da13), Next: 2675000}}, // First Tangerine block
+				{2674999, ID{Hash: checksumToBytes(0x7a64da13), Next: 2675000}}, // Last Tangerine block
+				{2675000, ID{Hash: checksumToBytes(0x3edd5b10), Next: 4370000}}, // First Spurious block
+				{4369999, ID{Hash: checksumToBytes(0x3edd5b10), Next: 4370000}}, // Last Spurious block
+				{4370000, ID{Hash: checksumToBytes(0xa00bc324), Next: 0}},       // First Byzantium block
+				{8000000, ID{Hash: checksumToBytes(0xa00bc324), Next: 0}},       // Future Byzantium block
+			},
+		},
+		// Testnet test cases
+		{
+			params.TestnetChainConfig,
+			params.TestnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0x30c7ddbc), Next: 10}},            // Unsynced, last Frontier, Homestead and first Tangerine block
+				{9, ID{Hash: checksumToBytes(0x30c7ddbc), Next: 10}},            // Last Tangerine block
+				{10, ID{Hash: checksumToBytes(0x63760190), Next: 1700000}},      // First Spurious block
+				{1699999, ID{Hash: checksumToBytes(0x63760190), Next: 1700000}}, // Last Spurious block
+				{1700000, ID{Hash: checksumToBytes(0x3ea159c7), Next: 0}},       // First Byzantium block
+				{5000000, ID{Hash: checksumToBytes(0x3ea159c7), Next: 0}},       // Future Byzantium block
+			},
+		},
+	}
+	for i, tt := range tests {
+		for j, ttt := range tt.cases {
+			if have := newID(tt.config, tt.genesis, ttt.head); have != ttt.want {
+				t.Errorf("test %d, case %d: fork ID mismatch: have %x, want %x", i, j, have, ttt.want)
+			}
+		}
+	}
+}
+
+// TestValidation tests that a local peer correctly validates and accepts a remote
+// fork ID.
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		head uint64
+		id   ID
+		err  error
+	}{
+		// Local is mainnet Byzantium, remote announces the same. No future fork is announced.
+		{4370000, ID{Hash: checksumToBytes(0xa00bc324), Next: 0}, nil},
+
+		// Local is mainnet Byzantium, remote announces the same. Remote also announces
+		// a next fork at block 0xffffffff, but that is uncertain.
+		{4370000, ID{Hash: checksumToBytes(0xa00bc324), Next: math.MaxUint32}, nil},
+
+		// Local is mainnet currently in Spurious only (so it's aware of Byzantium),
+		// remote announces also Spurious, but it's not yet aware of Byzantium (e.g.
+		// non updated node before the fork). In this case we don't know if Byzantium
+		// passed yet or not.
+		{4369999, ID{Hash: checksumToBytes(0x3edd5b10), Next: 0}, nil},
+
+		// Local is mainnet currently in Spurious only (so it's aware of Byzantium),
+		// remote announces also Spurious, and it's also aware of Byzantium (e.g.
+		// updated node before the fork). We don't know if Byzantium passed yet (will
+		// pass at 4370000) or not.
+		{4369999, ID{Hash: checksumToBytes(0x3edd5b10), Next: 4370000}, nil},
+
+		// Local is mainnet currently in Spurious only (so it's aware of Byzantium),
+		// remote announces also Spurious, and it's also aware of some random fork
+		// (e.g. misconfigured Byzantium). As long as the remote hasn't passed the
+		// fork yet, we can't tell whether it's misconfigured.
+		{4369999, ID{Hash: checksumToBytes(0x3edd5b10), Next: math.MaxUint32}, nil},
+
+		// Local is mainnet Byzantium, remote announces Spurious + knowledge about
+		// Byzantium. Remote is simply out of sync, accept.
+		{4370000, ID{Hash: checksumToBytes(0x3edd5b10), Next: 4370000}, nil},
+
+		// Local is mainnet Byzantium, remote announces Tangerine + knowledge about
+		// Spurious. Remote is definitely out of sync. It may or may not need the
+		// update, we don't know yet.
+		{4370000, ID{Hash: checksumToBytes(0x7a64da13), Next: 2675000}, nil},
+
+		// Local is mainnet Spurious, remote announces Byzantium, but is not aware of
+		// further forks. Local is out of sync, accept.
+		{4369999, ID{Hash: checksumToBytes(0xa00bc324), Next: 0}, nil},
+
+		// Local is mainnet Byzantium, remote announces Spurious but is not aware of
+		// Byzantium. Remote needs software update.
+		{4370000, ID{Hash: checksumToBytes(0x3edd5b10), Next: 0}, ErrRemoteStale},
+
+		// Local is mainnet Byzantium, remote is random garbage on the same genesis.
+		{4370000, ID{Hash: checksumToBytes(0xafec6b27), Next: 0}, ErrLocalIncompatibleOrStale},
+
+		// Local is mainnet Byzantium, remote is the testnet, same starting point
+		// forks but a different genesis.
+		{4370000, ID{Hash: checksumToBytes(0x3ea159c7), Next: 0}, ErrLocalIncompatibleOrStale},
+
+		// Local is mainnet Byzantium, far in the future. Remote announces Byzantium
+		// and a random future fork that we have already passed.
+		{8000000, ID{Hash: checksumToBytes(0xa00bc324), Next: 7000000}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		filter := newFilter(params.MainnetChainConfig, params.MainnetGenesisHash, func() uint64 { return tt.head })
+		if err := filter(tt.id); err != tt.err {
+			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// TestGatherForks tests that fork blocks are sorted and deduplicated, with
+// forks activated at genesis ignored.
+func TestGatherForks(t *testing.T) {
+	forks := gatherForks(params.TestnetChainConfig)
+	want := []uint64{10, 1700000}
+	if len(forks) != len(want) {
+		t.Fatalf("fork count mismatch: have %v, want %v", forks, want)
+	}
+	for i := range want {
+		if forks[i] != want[i] {
+			t.Errorf("fork %d mismatch: have %d, want %d", i, forks[i], want[i])
+		}
+	}
+}
@@ -351,10 +351,24 @@ func DeveloperGenesisBlock(period uint64, faucet common.Address) *Genesis {
 	config := *params.AllCliqueProtocolChanges
 	config.Clique.Period = period
 
-	// Assemble and return the genesis with the precompiles and faucet pre-funded
+	genesis := developerGenesis(&config, faucet)
+	genesis.ExtraData = append(append(make([]byte, 32), faucet[:]...), make([]byte, 65)...)
+
+	return genesis
+}
+
+// DeveloperInstantGenesisBlock returns the 'ghuc --dev --dev.instant' genesis
+// block, running the instant-seal developer consensus.
+func DeveloperInstantGenesisBlock(faucet common.Address) *Genesis {
+	config := *params.AllInstantProtocolChanges
+	return developerGenesis(&config, faucet)
+}
+
+// developerGenesis assembles a developer genesis with the precompiles and the
+// faucet pre-funded.
+func developerGenesis(config *params.ChainConfig, faucet common.Address) *Genesis {
 	return &Genesis{
-		Config:     &config,
-		ExtraData:  append(append(make([]byte, 32), faucet[:]...), make([]byte, 65)...),
+		Config:     config,
 		GasLimit:   6283185,
 		Difficulty: big.NewInt(1),
 		Alloc: map[common.Address]GenesisAccount{
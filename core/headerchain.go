@@ -227,6 +227,14 @@ func (hc *HeaderChain) ValidateHeaderChain(chain []*types.Header, checkFreq int)
 	}
 	seals[len(seals)-1] = true // Last should always be verified to avoid junk
 
+	// The engine taking over after a consensus switch doesn't check the seal of
+	// the block it builds upon, so always verify the last one before the switch
+	if block := hc.config.CliqueSwitchBlock; block != nil && block.Sign() > 0 {
+		if index := new(big.Int).Sub(block, chain[0].Number).Int64() - 1; index >= 0 && index < int64(len(seals)) {
+			seals[index] = true
+		}
+	}
+
 	abort, results := hc.engine.VerifyHeaders(hc, chain, seals)
 	defer close(abort)
 
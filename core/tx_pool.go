@@ -369,6 +369,12 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 				rem = pool.chain.GetBlock(oldHead.Hash(), oldHead.Number.Uint64())
 				add = pool.chain.GetBlock(newHead.Hash(), newHead.Number.Uint64())
 			)
+			if rem == nil {
+				// The old head was discarded by a chain rewind (setHead), along with
+				// its transactions, so there is nothing left to reinject
+				log.Debug("Skipping transaction reorg of discarded head", "old", oldHead.Hash(), "new", newHead.Hash())
+				rem = add
+			}
 			for rem.NumberU64() > add.NumberU64() {
 				discarded = append(discarded, rem.Transactions()...)
 				if rem = pool.chain.GetBlock(rem.ParentHash(), rem.NumberU64()-1); rem == nil {
@@ -198,6 +198,59 @@ func (api *PrivateMinerAPI) GetHashrate() uint64 {
 	return uint64(api.e.miner.HashRate())
 }
 
+// SetGasTarget sets the gas floor the miner votes the block gas limit towards.
+func (api *PrivateMinerAPI) SetGasTarget(gasFloor hexutil.Uint64) (bool, error) {
+	_, gasCeil := api.e.Miner().GasLimits()
+	if uint64(gasFloor) > gasCeil {
+		gasCeil = uint64(gasFloor)
+	}
+	if err := api.e.Miner().SetGasLimits(uint64(gasFloor), gasCeil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetGasLimit sets the gas ceiling the miner votes the block gas limit towards.
+func (api *PrivateMinerAPI) SetGasLimit(gasCeil hexutil.Uint64) (bool, error) {
+	gasFloor, _ := api.e.Miner().GasLimits()
+	if uint64(gasCeil) < gasFloor {
+		gasFloor = uint64(gasCeil)
+	}
+	if err := api.e.Miner().SetGasLimits(gasFloor, uint64(gasCeil)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetOrdering switches the policy used to order transactions in mined blocks.
+// Supported policies are "price" (default) and "fcfs" (first-come-first-served).
+func (api *PrivateMinerAPI) SetOrdering(policy string) (bool, error) {
+	switch policy {
+	case "price":
+		api.e.Miner().SetOrderer(miner.PriceOrderer{})
+	case "fcfs":
+		api.e.Miner().SetOrderer(miner.NewFCFSOrderer())
+	default:
+		return false, fmt.Errorf("unknown ordering policy %q", policy)
+	}
+	return true, nil
+}
+
+// SubmitBundle schedules a list of signed, RLP encoded transactions for atomic
+// inclusion at the top of the given block. The bundle is dropped from that block
+// if any of its transactions fails. The identifier of the bundle is returned.
+func (api *PrivateMinerAPI) SubmitBundle(encodedTxs []hexutil.Bytes, blockNumber hexutil.Uint64) (common.Hash, error) {
+	txs := make(types.Transactions, len(encodedTxs))
+	for i, encodedTx := range encodedTxs {
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(encodedTx, tx); err != nil {
+			return common.Hash{}, fmt.Errorf("invalid transaction %d: %v", i, err)
+		}
+		txs[i] = tx
+	}
+	return api.e.Miner().SubmitBundle(txs, uint64(blockNumber))
+}
+
 // PrivateAdminAPI is the collection of HappyUC full node-related APIs
 // exposed over the private admin endpoint.
 type PrivateAdminAPI struct {
@@ -104,6 +104,11 @@ func (b *EthApiBackend) GetReceipts(ctx context.Context, blockHash common.Hash)
 	return core.GetBlockReceipts(b.huc.chainDb, blockHash, core.GetBlockNumber(b.huc.chainDb, blockHash)), nil
 }
 
+func (b *EthApiBackend) GetTransaction(ctx context.Context, txHash common.Hash) (*types.Transaction, common.Hash, uint64, uint64, error) {
+	tx, blockHash, blockNumber, index := core.GetTransaction(b.huc.chainDb, txHash)
+	return tx, blockHash, blockNumber, index, nil
+}
+
 func (b *EthApiBackend) GetLogs(ctx context.Context, blockHash common.Hash) ([][]*types.Log, error) {
 	receipts := core.GetBlockReceipts(b.huc.chainDb, blockHash, core.GetBlockNumber(b.huc.chainDb, blockHash))
 	if receipts == nil {
@@ -0,0 +1,91 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package huc
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/common/hexutil"
+	"github.com/happyuc-project/happyuc-go/core"
+)
+
+// PrivateDevAPI provides private RPC methods to snapshot and revert the chain
+// head of instant-seal developer chains, isolating test cases from each other.
+type PrivateDevAPI struct {
+	e *HappyUC
+
+	snapshots []common.Hash // Chain heads at the time of each snapshot
+	lock      sync.Mutex
+}
+
+// NewPrivateDevAPI creates a new RPC service to manage developer chain snapshots.
+func NewPrivateDevAPI(e *HappyUC) *PrivateDevAPI {
+	return &PrivateDevAPI{e: e}
+}
+
+// Snapshot records the current chain head, returning an identifier that can be
+// used to revert back to it.
+func (api *PrivateDevAPI) Snapshot() hexutil.Uint64 {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	api.snapshots = append(api.snapshots, api.e.blockchain.CurrentBlock().Hash())
+	return hexutil.Uint64(len(api.snapshots))
+}
+
+// Revert rewinds the chain head to a previously taken snapshot. The snapshot and
+// all the ones taken after it are discarded, along with the transactions of all
+// the rewound blocks.
+func (api *PrivateDevAPI) Revert(id hexutil.Uint64) (bool, error) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	if id == 0 || int(id) > len(api.snapshots) {
+		return false, fmt.Errorf("unknown snapshot %d", id)
+	}
+	chain := api.e.blockchain
+
+	block := chain.GetBlockByHash(api.snapshots[id-1])
+	if block == nil || core.GetCanonicalHash(api.e.chainDb, block.NumberU64()) != block.Hash() {
+		return false, fmt.Errorf("snapshot %d no longer canonical", id)
+	}
+	if !chain.HasState(block.Root()) {
+		return false, fmt.Errorf("snapshot %d state unavailable", id)
+	}
+	// Pause the miner while rewinding to avoid building on discarded blocks
+	mining := api.e.IsMining()
+	if mining {
+		api.e.StopMining()
+	}
+	if err := chain.SetHead(block.NumberU64()); err != nil {
+		return false, err
+	}
+	api.snapshots = api.snapshots[:id-1]
+
+	// Notify the transaction pool and any other subsystem of the new head
+	head := chain.CurrentBlock()
+	chain.PostChainEvents([]interface{}{core.ChainHeadEvent{Block: head}}, nil)
+
+	if mining {
+		if err := api.e.StartMining(true); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package huc
+
+import (
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/consensus/huchash"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/vm"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/miner"
+	"github.com/happyuc-project/happyuc-go/params"
+)
+
+// Tests that developer chain snapshots can be reverted to in any order, with
+// every revert discarding the target snapshot and all the ones after it.
+func TestDevSnapshotRevert(t *testing.T) {
+	var (
+		db, _   = hucdb.NewMemDatabase()
+		engine  = huchash.NewFaker()
+		genesis = (&core.Genesis{Config: params.TestChainConfig}).MustCommit(db)
+	)
+	chain, err := core.NewBlockChain(db, &core.CacheConfig{Disabled: true}, params.TestChainConfig, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	blocks, _ := core.GenerateChain(params.TestChainConfig, genesis, engine, db, 5, nil)
+	insert := func(from, to int) {
+		if _, err := chain.InsertChain(blocks[from:to]); err != nil {
+			t.Fatalf("failed to insert blocks %d-%d: %v", from, to, err)
+		}
+	}
+	api := NewPrivateDevAPI(&HappyUC{blockchain: chain, chainDb: db, miner: new(miner.Miner)})
+
+	insert(0, 2)
+	first := api.Snapshot()
+	insert(2, 4)
+	second := api.Snapshot()
+	insert(4, 5)
+
+	if first != 1 || second != 2 {
+		t.Fatalf("snapshot ids mismatch: have %d/%d, want 1/2", first, second)
+	}
+	// Revert to the latest snapshot and ensure the head is rewound
+	if ok, err := api.Revert(second); !ok || err != nil {
+		t.Fatalf("failed to revert to snapshot %d: %v", second, err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[3].Hash() {
+		t.Fatalf("head mismatch after revert: have #%d, want #%d", head.NumberU64(), blocks[3].NumberU64())
+	}
+	if _, err := api.Revert(second); err == nil {
+		t.Fatalf("reverted to discarded snapshot")
+	}
+	// Re-extend the chain and revert to the first snapshot, skipping the second
+	insert(3, 5)
+	api.Snapshot()
+
+	if ok, err := api.Revert(first); !ok || err != nil {
+		t.Fatalf("failed to revert to snapshot %d: %v", first, err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[1].Hash() {
+		t.Fatalf("head mismatch after revert: have #%d, want #%d", head.NumberU64(), blocks[1].NumberU64())
+	}
+	if len(api.snapshots) != 0 {
+		t.Fatalf("snapshots retained after revert: %d", len(api.snapshots))
+	}
+	if _, err := api.Revert(first); err == nil {
+		t.Fatalf("reverted to discarded snapshot")
+	}
+}
@@ -29,8 +29,10 @@ import (
 	"github.com/happyuc-project/happyuc-go/common"
 	"github.com/happyuc-project/happyuc-go/common/hexutil"
 	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/consensus/bft"
 	"github.com/happyuc-project/happyuc-go/consensus/clique"
 	"github.com/happyuc-project/happyuc-go/consensus/huchash"
+	"github.com/happyuc-project/happyuc-go/consensus/instant"
 	"github.com/happyuc-project/happyuc-go/core"
 	"github.com/happyuc-project/happyuc-go/core/bloombits"
 	"github.com/happyuc-project/happyuc-go/core/types"
@@ -55,6 +57,27 @@ type LesServer interface {
 	Stop()
 	Protocols() []p2p.Protocol
 	SetBloomBitsIndexer(bbIndexer *core.ChainIndexer)
+	APIs() []rpc.API
+}
+
+// consensusHandler is a consensus engine that agrees on blocks by exchanging
+// consensus messages with other nodes over a dedicated p2p sub-protocol.
+type consensusHandler interface {
+	consensus.Engine
+
+	// Protocols returns the p2p sub-protocols used to exchange consensus messages.
+	Protocols() []p2p.Protocol
+
+	// Start begins processing consensus messages on top of the given chain. Blocks
+	// finalized while the local node isn't their proposer are imported via insert.
+	Start(chain consensus.ChainReader, insert func(block *types.Block) error) error
+
+	// Stop terminates processing consensus messages.
+	Stop() error
+
+	// NewChainHead notifies the engine of a new canonical head, moving consensus
+	// on to the next block.
+	NewChainHead(head *types.Header)
 }
 
 // HappyUC implements the HappyUC full node service.
@@ -84,8 +107,10 @@ type HappyUC struct {
 
 	ApiBackend *EthApiBackend
 
-	miner     *miner.Miner
-	gasPrice  *big.Int
+	miner    *miner.Miner
+	stratum  *miner.StratumServer
+	headSub  event.Subscription // Chain head subscription of message based consensus engines
+	gasPrice *big.Int
 	coinbase common.Address
 
 	networkId     uint64
@@ -168,9 +193,20 @@ func New(ctx *node.ServiceContext, config *Config) (*HappyUC, error) {
 	if eth.protocolManager, err = NewProtocolManager(eth.chainConfig, config.SyncMode, config.NetworkId, eth.eventMux, eth.txPool, eth.engine, eth.blockchain, chainDb); err != nil {
 		return nil, err
 	}
-	eth.miner = miner.New(eth, eth.chainConfig, eth.EventMux(), eth.engine)
+	if config.SyncCheckpoint != nil {
+		log.Info("Synchronising from trusted checkpoint", "number", config.SyncCheckpoint.Number, "hash", config.SyncCheckpoint.Hash)
+		eth.protocolManager.SetCheckpoint(config.SyncCheckpoint)
+	}
+	eth.miner = miner.New(eth, eth.chainConfig, eth.EventMux(), eth.engine, config.GasFloor, config.GasCeil)
 	eth.miner.SetExtra(makeExtraData(config.ExtraData))
 
+	if config.StratumAddr != "" {
+		if eth.stratum, err = miner.NewStratumServer(eth.blockchain, eth.engine, config.StratumDifficulty); err != nil {
+			return nil, err
+		}
+		eth.miner.Register(eth.stratum)
+	}
+
 	eth.ApiBackend = &EthApiBackend{eth, nil}
 	gpoParams := config.GPO
 	if gpoParams.Default == nil {
@@ -214,9 +250,29 @@ func CreateDB(ctx *node.ServiceContext, config *Config, name string) (hucdb.Data
 func CreateConsensusEngine(ctx *node.ServiceContext, config *huchash.Config, chainConfig *params.ChainConfig, db hucdb.Database) consensus.Engine {
 	// If proof-of-authority is requested, set it up
 	if chainConfig.Clique != nil {
-		return clique.New(chainConfig.Clique, db)
+		engine := clique.New(chainConfig.Clique, db)
+
+		// If the chain migrates from proof-of-work, verify the old blocks with ethash
+		if chainConfig.CliqueSwitchBlock != nil {
+			return consensus.NewSwitch(chainConfig.CliqueSwitchBlock, createEthash(ctx, config), engine)
+		}
+		return engine
+	}
+	// If byzantine fault tolerant proof-of-authority is requested, set it up
+	if chainConfig.BFT != nil {
+		return bft.New(chainConfig.BFT, db)
+	}
+	// If an instant-seal developer chain is requested, set it up
+	if chainConfig.Instant != nil {
+		log.Warn("Using instant-seal developer consensus")
+		return instant.New()
 	}
 	// Otherwise assume proof-of-work
+	return createEthash(ctx, config)
+}
+
+// createEthash creates a proof-of-work consensus engine with the requested mode.
+func createEthash(ctx *node.ServiceContext, config *huchash.Config) consensus.Engine {
 	switch {
 	case config.PowMode == huchash.ModeFake:
 		log.Warn("Ethash used in fake mode")
@@ -249,6 +305,19 @@ func (s *HappyUC) APIs() []rpc.API {
 	// Append any APIs exposed explicitly by the consensus engine
 	apis = append(apis, s.engine.APIs(s.BlockChain())...)
 
+	// Developer chains may also snapshot and revert their head
+	if _, ok := s.engine.(*instant.Instant); ok {
+		apis = append(apis, rpc.API{
+			Namespace: "dev",
+			Version:   "1.0",
+			Service:   NewPrivateDevAPI(s),
+		})
+	}
+
+	// Append the APIs of the light server, if one's running
+	if s.lesServer != nil {
+		apis = append(apis, s.lesServer.APIs()...)
+	}
 	// Append all the local APIs and return
 	return append(apis, []rpc.API{
 		{
@@ -340,7 +409,12 @@ func (s *HappyUC) StartMining(local bool) error {
 		log.Error("Cannot start mining without coinbase", "err", err)
 		return fmt.Errorf("coinbase missing: %v", err)
 	}
-	if clique, ok := s.engine.(*clique.Clique); ok {
+	// Chains switching consensus engines need the signer credentials afterwards
+	engine := s.engine
+	if sw, ok := engine.(*consensus.Switch); ok {
+		engine = sw.After()
+	}
+	if clique, ok := engine.(*clique.Clique); ok {
 		wallet, err := s.accountManager.Find(accounts.Account{Address: eb})
 		if wallet == nil || err != nil {
 			log.Error("Coinbase account unavailable locally", "err", err)
@@ -348,6 +422,14 @@ func (s *HappyUC) StartMining(local bool) error {
 		}
 		clique.Authorize(eb, wallet.SignHash)
 	}
+	if bft, ok := s.engine.(*bft.BFT); ok {
+		wallet, err := s.accountManager.Find(accounts.Account{Address: eb})
+		if wallet == nil || err != nil {
+			log.Error("Coinbase account unavailable locally", "err", err)
+			return fmt.Errorf("validator missing: %v", err)
+		}
+		bft.Authorize(eb, wallet.SignHash)
+	}
 	if local {
 		// If local (CPU) mining is started, we can disable the transaction rejection
 		// mechanism introduced to speed sync times. CPU mining on mainnet is ludicrous
@@ -377,10 +459,14 @@ func (s *HappyUC) Downloader() *downloader.Downloader { return s.protocolManager
 // Protocols implements node.Service, returning all the currently configured
 // network protocols to start.
 func (s *HappyUC) Protocols() []p2p.Protocol {
+	protos := s.protocolManager.SubProtocols
+	if handler, ok := s.engine.(consensusHandler); ok {
+		protos = append(protos, handler.Protocols()...)
+	}
 	if s.lesServer == nil {
-		return s.protocolManager.SubProtocols
+		return protos
 	}
-	return append(s.protocolManager.SubProtocols, s.lesServer.Protocols()...)
+	return append(protos, s.lesServer.Protocols()...)
 }
 
 // Start implements node.Service, starting all internal goroutines needed by the
@@ -392,6 +478,11 @@ func (s *HappyUC) Start(srvr *p2p.Server) error {
 	// Start the RPC service
 	s.netRPCService = hucapi.NewPublicNetAPI(srvr, s.NetVersion())
 
+	// Advertise the fork ID in the local node record
+	if ln := srvr.LocalNode(); ln != nil {
+		s.startHucEntryUpdate(ln)
+	}
+
 	// Figure out a max peers count based on the server limits
 	maxPeers := srvr.MaxPeers
 	if s.config.LightServ > 0 {
@@ -405,9 +496,40 @@ func (s *HappyUC) Start(srvr *p2p.Server) error {
 	if s.lesServer != nil {
 		s.lesServer.Start(srvr)
 	}
+	// Start the stratum server for remote miners if requested
+	if s.stratum != nil {
+		if err := s.stratum.Listen(s.config.StratumAddr); err != nil {
+			return err
+		}
+	}
+	// Start exchanging consensus messages if the engine needs it
+	if handler, ok := s.engine.(consensusHandler); ok {
+		insert := func(block *types.Block) error {
+			_, err := s.blockchain.InsertChain(types.Blocks{block})
+			return err
+		}
+		if err := handler.Start(s.blockchain, insert); err != nil {
+			return err
+		}
+		heads := make(chan core.ChainHeadEvent, 16)
+		s.headSub = s.blockchain.SubscribeChainHeadEvent(heads)
+		go s.consensusLoop(handler, heads)
+	}
 	return nil
 }
 
+// consensusLoop feeds new chain heads into a message based consensus engine.
+func (s *HappyUC) consensusLoop(handler consensusHandler, heads chan core.ChainHeadEvent) {
+	for {
+		select {
+		case ev := <-heads:
+			handler.NewChainHead(ev.Block.Header())
+		case <-s.headSub.Err():
+			return
+		}
+	}
+}
+
 // Stop implements node.Service, terminating all internal goroutines used by the
 // HappyUC protocol.
 func (s *HappyUC) Stop() error {
@@ -422,6 +544,15 @@ func (s *HappyUC) Stop() error {
 	}
 	s.txPool.Stop()
 	s.miner.Stop()
+	if s.stratum != nil {
+		s.stratum.Close()
+	}
+	if handler, ok := s.engine.(consensusHandler); ok {
+		if s.headSub != nil {
+			s.headSub.Unsubscribe()
+		}
+		handler.Stop()
+	}
 	s.eventMux.Stop()
 
 	s.chainDb.Close()
@@ -17,6 +17,7 @@
 package huc
 
 import (
+	"math"
 	"math/big"
 	"os"
 	"os/user"
@@ -49,6 +50,10 @@ var DefaultConfig = Config{
 	TrieCache:     256,
 	TrieTimeout:   5 * time.Minute,
 	GasPrice:      big.NewInt(18 * params.Shannon),
+	GasFloor:      params.GenesisGasLimit,
+	GasCeil:       math.MaxUint64, // No ceiling, blocks may grow with demand as before
+
+	UltraLightFraction: 75,
 
 	TxPool: core.DefaultTxPoolConfig,
 	GPO: gasprice.Config{
@@ -79,14 +84,19 @@ type Config struct {
 	Genesis *core.Genesis `toml:",omitempty"`
 
 	// Protocol options
-	NetworkId uint64 // Network ID to use for selecting peers to connect to
-	SyncMode  downloader.SyncMode
-	NoPruning bool
+	NetworkId      uint64 // Network ID to use for selecting peers to connect to
+	SyncMode       downloader.SyncMode
+	SyncCheckpoint *downloader.Checkpoint `toml:",omitempty"` // Trusted checkpoint to sync from instead of genesis
+	NoPruning      bool
 
 	// Light client options
 	LightServ  int `toml:",omitempty"` // Maximum percentage of time allowed for serving LES requests
 	LightPeers int `toml:",omitempty"` // Maximum number of LES client peers
 
+	// Ultra-light client options
+	UltraLightServers  []string `toml:",omitempty"` // Hnode URLs of the trusted LES servers
+	UltraLightFraction int      `toml:",omitempty"` // Percentage of trusted servers needed to accept a new head
+
 	// Database options
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
@@ -95,10 +105,16 @@ type Config struct {
 	TrieTimeout        time.Duration
 
 	// Mining-related options
-	Coinbase    common.Address `toml:",omitempty"`
+	Coinbase     common.Address `toml:",omitempty"`
 	MinerThreads int            `toml:",omitempty"`
 	ExtraData    []byte         `toml:",omitempty"`
 	GasPrice     *big.Int
+	GasFloor     uint64 // Target gas floor for mined blocks
+	GasCeil      uint64 // Target gas ceiling for mined blocks
+
+	// Stratum server options
+	StratumAddr       string   `toml:",omitempty"` // Listening address of the stratum server, empty to disable
+	StratumDifficulty *big.Int `toml:",omitempty"` // Default share difficulty of stratum miners
 
 	// Ethash options
 	Ethash huchash.Config
@@ -0,0 +1,209 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/log"
+)
+
+var (
+	errInvalidCheckpoint   = errors.New("remote chain doesn't contain the trusted checkpoint")
+	errCheckpointNotPassed = errors.New("remote chain not beyond the trusted checkpoint")
+)
+
+// Checkpoint is a trusted block a full node may start synchronising from, skipping
+// the verification of all the headers preceding it (weak subjectivity).
+type Checkpoint struct {
+	Number uint64      // Block number of the trusted checkpoint
+	Hash   common.Hash // Block hash of the trusted checkpoint
+}
+
+// String implements the stringer interface.
+func (cp *Checkpoint) String() string {
+	if cp == nil || cp.Hash == (common.Hash{}) {
+		return ""
+	}
+	return fmt.Sprintf("%x:%d", cp.Hash, cp.Number)
+}
+
+// MarshalText implements encoding.TextMarshaler, encoding the checkpoint in the
+// <hash>:<number> format.
+func (cp Checkpoint) MarshalText() ([]byte, error) {
+	return []byte(cp.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, parsing a checkpoint in the
+// <hash>:<number> format.
+func (cp *Checkpoint) UnmarshalText(text []byte) error {
+	parts := strings.Split(string(text), ":")
+	if len(parts) != 2 {
+		return fmt.Errorf(`invalid checkpoint %q, want "<hash>:<number>"`, text)
+	}
+	hash := strings.TrimPrefix(parts[0], "0x")
+	if len(hash) != 2*common.HashLength {
+		return fmt.Errorf("invalid checkpoint hash %q", parts[0])
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return fmt.Errorf("invalid checkpoint hash %q: %v", parts[0], err)
+	}
+	number, err := strconv.ParseUint(parts[1], 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid checkpoint number %q: %v", parts[1], err)
+	}
+	cp.Hash, cp.Number = common.HexToHash(hash), number
+	return nil
+}
+
+// SetCheckpoint configures a trusted checkpoint for the downloader to anchor the
+// local chain on. Until the local chain passes it, synchronisations are done in
+// fast sync mode with the pivot block after the checkpoint.
+func (d *Downloader) SetCheckpoint(cp *Checkpoint) {
+	d.checkpoint = cp
+}
+
+// syncCheckpoint ensures the remote peer's chain contains the trusted checkpoint
+// and that the local chain is anchored on it, retrieving the checkpoint header
+// and backfilling its ancestors if needed. It returns whether the local chain
+// still needs to be synchronised up to the checkpoint.
+func (d *Downloader) syncCheckpoint(p *peerConnection, height uint64) (bool, error) {
+	cp := d.checkpoint
+	if cp == nil || d.mode == LightSync || d.blockchain.CurrentBlock().NumberU64() >= cp.Number {
+		return false, nil
+	}
+	if height <= cp.Number {
+		return false, errCheckpointNotPassed
+	}
+	// Make sure the remote canonical chain contains the checkpoint
+	headers, err := d.fetchHeaderBatch(p, func() error { return p.peer.RequestHeadersByNumber(cp.Number, 1, 0, false) })
+	if err != nil && err != errEmptyHeaderSet {
+		return false, err
+	}
+	if len(headers) != 1 || headers[0].Number.Uint64() != cp.Number || headers[0].Hash() != cp.Hash {
+		p.log.Warn("Trusted checkpoint missing from remote chain", "number", cp.Number, "hash", cp.Hash)
+		return false, errInvalidCheckpoint
+	}
+	// If the checkpoint was already anchored, there's nothing to backfill
+	if d.lightchain.GetTd(cp.Hash, cp.Number) != nil {
+		return true, nil
+	}
+	return true, d.backfillCheckpoint(p, headers[0])
+}
+
+// backfillCheckpoint writes the checkpoint header and all its ancestors missing
+// from the local chain into the database, retrieving them in reverse order from
+// the remote peer and verifying only their hash links. Once the known part of
+// the local chain is reached, the total difficulties are filled in too.
+func (d *Downloader) backfillCheckpoint(p *peerConnection, checkpoint *types.Header) error {
+	var (
+		start  = time.Now()
+		logged = time.Now()
+
+		hash   = checkpoint.ParentHash
+		number = checkpoint.Number.Uint64() - 1
+		total  = new(big.Int).Set(checkpoint.Difficulty)
+		base   *big.Int
+	)
+	p.log.Info("Backfilling headers below trusted checkpoint", "number", checkpoint.Number, "hash", checkpoint.Hash())
+
+	// Retrieve the ancestors of the checkpoint until a locally known one is found
+	for base == nil {
+		if base = d.lightchain.GetTd(hash, number); base != nil {
+			break
+		}
+		if number == 0 {
+			p.log.Warn("Trusted checkpoint on a different genesis", "hash", hash)
+			return errInvalidChain
+		}
+		headers, err := d.fetchHeaderBatch(p, func() error { return p.peer.RequestHeadersByHash(hash, MaxHeaderFetch, 0, true) })
+		if err != nil {
+			return err
+		}
+		batch := d.stateDB.NewBatch()
+		for _, header := range headers {
+			// Stop at the first locally known header
+			if base = d.lightchain.GetTd(hash, number); base != nil {
+				break
+			}
+			if header.Hash() != hash || header.Number.Uint64() != number {
+				p.log.Warn("Backfilled headers broke hash chain", "number", header.Number, "hash", header.Hash(), "want", hash)
+				return errInvalidChain
+			}
+			if err := core.WriteHeader(batch, header); err != nil {
+				return err
+			}
+			total.Add(total, header.Difficulty)
+			hash, number = header.ParentHash, number-1
+
+			if number == 0 {
+				break
+			}
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Backfilling checkpoint headers", "number", number, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	// Assign the total difficulties downward from the checkpoint, writing the one
+	// of the checkpoint itself last to mark the backfill complete
+	td := new(big.Int).Add(base, total)
+	tdCheckpoint := new(big.Int).Set(td)
+
+	batch := d.stateDB.NewBatch()
+	for header := checkpoint; header.ParentHash != hash; {
+		td.Sub(td, header.Difficulty)
+		parent := core.GetHeader(d.stateDB, header.ParentHash, header.Number.Uint64()-1)
+		if parent == nil {
+			return errInvalidChain
+		}
+		if err := core.WriteTd(batch, parent.Hash(), parent.Number.Uint64(), td); err != nil {
+			return err
+		}
+		if batch.ValueSize() > hucdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		header = parent
+	}
+	if err := core.WriteHeader(batch, checkpoint); err != nil {
+		return err
+	}
+	if err := core.WriteTd(batch, checkpoint.Hash(), checkpoint.Number.Uint64(), tdCheckpoint); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Anchored chain on trusted checkpoint", "number", checkpoint.Number, "hash", checkpoint.Hash(), "td", tdCheckpoint, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
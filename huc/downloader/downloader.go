@@ -111,6 +111,7 @@ type Downloader struct {
 
 	lightchain LightChain
 	blockchain BlockChain
+	checkpoint *Checkpoint // Trusted checkpoint to anchor the local chain on (nil = sync from genesis)
 
 	// Callbacks
 	dropPeer peerDropFn // Drops a peer for misbehaving
@@ -120,6 +121,7 @@ type Downloader struct {
 	synchronising   int32
 	notified        int32
 	committed       int32
+	pivot           uint64 // Fast sync pivot block number, moved forward if it becomes stale (atomic)
 
 	// Channels
 	headerCh      chan dataPack        // [eth/62] Channel receiving inbound block headers
@@ -168,6 +170,9 @@ type LightChain interface {
 
 	// Rollback removes a few recently added elements from the local chain.
 	Rollback([]common.Hash)
+
+	// Config retrieves the chain's fork and consensus configuration.
+	Config() *params.ChainConfig
 }
 
 // BlockChain encapsulates functions required to sync a (full or fast) blockchain.
@@ -320,7 +325,7 @@ func (d *Downloader) Synchronise(id string, head common.Hash, td *big.Int, mode
 
 	case errTimeout, errBadPeer, errStallingPeer,
 		errEmptyHeaderSet, errPeersUnavailable, errTooOld,
-		errInvalidAncestor, errInvalidChain:
+		errInvalidAncestor, errInvalidChain, errInvalidCheckpoint:
 		log.Warn("Synchronisation failed, dropping peer", "peer", id, "err", err)
 		if d.dropPeer == nil {
 			// The dropPeer method is nil when `--copydb` is used for a local copy.
@@ -426,10 +431,22 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 	}
 	height := latest.Number.Uint64()
 
+	// If a trusted checkpoint is configured, anchor the local chain on it first
+	anchored, err := d.syncCheckpoint(p, height)
+	if err != nil {
+		return err
+	}
+	if anchored && d.mode == FullSync {
+		log.Info("Fast syncing past trusted checkpoint", "number", d.checkpoint.Number)
+		d.mode = FastSync
+	}
 	origin, err := d.findAncestor(p, height)
 	if err != nil {
 		return err
 	}
+	if anchored && origin < d.checkpoint.Number {
+		origin = d.checkpoint.Number
+	}
 	d.syncStatsLock.Lock()
 	if d.syncStatsChainHeight <= origin || d.syncStatsChainOrigin > origin {
 		d.syncStatsChainOrigin = origin
@@ -448,11 +465,16 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 				origin = pivot - 1
 			}
 		}
+		// Never sync below the trusted checkpoint, the pivot needs to be after it
+		if anchored && pivot <= d.checkpoint.Number {
+			origin, pivot = d.checkpoint.Number, d.checkpoint.Number+1
+		}
 	}
 	d.committed = 1
 	if d.mode == FastSync && pivot != 0 {
 		d.committed = 0
 	}
+	atomic.StoreUint64(&d.pivot, pivot)
 	// Initiate the sync using a concurrent header and content retrieval algorithm
 	d.queue.Prepare(origin+1, d.mode)
 	if d.syncInitHook != nil {
@@ -460,10 +482,10 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 	}
 
 	fetchers := []func() error{
-		func() error { return d.fetchHeaders(p, origin+1, pivot) }, // Headers are always retrieved
-		func() error { return d.fetchBodies(origin + 1) },          // Bodies are retrieved during normal and fast sync
-		func() error { return d.fetchReceipts(origin + 1) },        // Receipts are retrieved during fast sync
-		func() error { return d.processHeaders(origin+1, pivot, td) },
+		func() error { return d.fetchHeadersReverse(p, origin+1, latest) }, // Headers are always retrieved
+		func() error { return d.fetchBodies(origin + 1) },                  // Bodies are retrieved during normal and fast sync
+		func() error { return d.fetchReceipts(origin + 1) },                // Receipts are retrieved during fast sync
+		func() error { return d.processHeaders(origin+1, td) },
 	}
 	if d.mode == FastSync {
 		fetchers = append(fetchers, func() error { return d.processFastSyncContent(latest) })
@@ -754,7 +776,7 @@ func (d *Downloader) findAncestor(p *peerConnection, height uint64) (uint64, err
 // other peers are only accepted if they map cleanly to the skeleton. If no one
 // can fill in the skeleton - not even the origin peer - it's assumed invalid and
 // the origin is dropped.
-func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, pivot uint64) error {
+func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 	p.log.Debug("Directing header downloads", "origin", from)
 	defer p.log.Debug("Header download terminated")
 
@@ -806,7 +828,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, pivot uint64)
 			// If no more headers are inbound, notify the content fetchers and return
 			if packet.Items() == 0 {
 				// Don't abort header fetches while the pivot is downloading
-				if atomic.LoadInt32(&d.committed) == 0 && pivot <= from {
+				if atomic.LoadInt32(&d.committed) == 0 && atomic.LoadUint64(&d.pivot) <= from {
 					p.log.Debug("No headers, waiting for pivot commit")
 					select {
 					case <-time.After(fsHeaderContCheck):
@@ -1150,7 +1172,7 @@ func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliv
 // processHeaders takes batches of retrieved headers from an input channel and
 // keeps processing and scheduling them into the header chain and downloader's
 // queue until the stream ends or a failure occurs.
-func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) error {
+func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 	// Keep a count of uncertain headers to roll back
 	rollback := []*types.Header{}
 	defer func() {
@@ -1258,11 +1280,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 						}
 					}
 					// If we're importing pure headers, verify based on their recentness
-					frequency := fsHeaderCheckFrequency
-					if chunk[len(chunk)-1].Number.Uint64()+uint64(fsHeaderForceVerify) > pivot {
-						frequency = 1
-					}
-					if n, err := d.lightchain.InsertHeaderChain(chunk, frequency); err != nil {
+					if n, err := d.lightchain.InsertHeaderChain(chunk, d.verifyFrequency(chunk, atomic.LoadUint64(&d.pivot))); err != nil {
 						// If some headers were inserted, add them too to the rollback list
 						if n > 0 {
 							rollback = append(rollback, chunk[:n]...)
@@ -1307,6 +1325,28 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 	}
 }
 
+// verifyFrequency returns how often the seals of a chunk of pure headers need to
+// be checked on import. Proof-of-work seals are costly to forge, so sampling them
+// suffices far from the pivot. Headers sealed by other engines, including the
+// ones after a switch over to clique, are cheap to forge and all get verified.
+func (d *Downloader) verifyFrequency(chunk []*types.Header, pivot uint64) int {
+	last := chunk[len(chunk)-1].Number
+	if last.Uint64()+uint64(fsHeaderForceVerify) > pivot || !d.sealedByWork(last) {
+		return 1
+	}
+	return fsHeaderCheckFrequency
+}
+
+// sealedByWork reports whether the header at the given height is sealed by proof-
+// of-work, taking any switch over to clique into account.
+func (d *Downloader) sealedByWork(number *big.Int) bool {
+	config := d.lightchain.Config()
+	if config.CliqueSwitchBlock != nil {
+		return !config.IsCliqueSwitch(number)
+	}
+	return config.Clique == nil && config.BFT == nil && config.Instant == nil
+}
+
 // processFullSyncContent takes fetch results from the queue and imports them into the chain.
 func (d *Downloader) processFullSyncContent() error {
 	for {
@@ -1352,7 +1392,12 @@ func (d *Downloader) importBlockResults(results []*fetchResult) error {
 
 // processFastSyncContent takes fetch results from the queue and writes them to the
 // database. It also controls the synchronisation of state nodes of the pivot block.
+// The initial pivot is the one chosen by syncWithPeer (never below a trusted
+// checkpoint), only moved forward if the sync takes long enough for the chain
+// head to leave it stale. Moves are published to the header fetcher/processor.
 func (d *Downloader) processFastSyncContent(latest *types.Header) error {
+	pivot := atomic.LoadUint64(&d.pivot)
+
 	// Start syncing state of the reported head block. This should get us most of
 	// the state of the pivot block.
 	stateSync := d.syncState(latest.Root)
@@ -1362,12 +1407,6 @@ func (d *Downloader) processFastSyncContent(latest *types.Header) error {
 			d.queue.Close() // wake up WaitResults
 		}
 	}()
-	// Figure out the ideal pivot block. Note, that this goalpost may move if the
-	// sync takes long enough for the chain head to move significantly.
-	pivot := uint64(0)
-	if height := latest.Number.Uint64(); height > uint64(fsMinFullBlocks) {
-		pivot = height - uint64(fsMinFullBlocks)
-	}
 	// To cater for moving pivot points, track the pivot block and subsequently
 	// accumulated download results separatey.
 	var (
@@ -1402,6 +1441,7 @@ func (d *Downloader) processFastSyncContent(latest *types.Header) error {
 			if height := latest.Number.Uint64(); height > pivot+2*uint64(fsMinFullBlocks) {
 				log.Warn("Pivot became stale, moving", "old", pivot, "new", height-uint64(fsMinFullBlocks))
 				pivot = height - uint64(fsMinFullBlocks)
+				atomic.StoreUint64(&d.pivot, pivot)
 			}
 		}
 		P, beforeP, afterP := splitAroundPivot(pivot, results)
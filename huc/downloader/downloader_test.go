@@ -17,6 +17,7 @@
 package downloader
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/big"
@@ -26,12 +27,14 @@ import (
 	"time"
 
 	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus"
 	"github.com/happyuc-project/happyuc-go/consensus/huchash"
 	"github.com/happyuc-project/happyuc-go/core"
 	"github.com/happyuc-project/happyuc-go/core/types"
 	"github.com/happyuc-project/happyuc-go/crypto"
-	"github.com/happyuc-project/happyuc-go/hucdb"
 	"github.com/happyuc-project/happyuc-go/event"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/log"
 	"github.com/happyuc-project/happyuc-go/params"
 	"github.com/happyuc-project/happyuc-go/trie"
 )
@@ -70,6 +73,9 @@ type downloadTester struct {
 
 	peerMissingStates map[string]map[common.Hash]bool // State entries that fast sync should not return
 
+	config *params.ChainConfig // Chain configuration reported to the downloader
+	engine consensus.Engine    // Consensus engine checking the seals of skeleton headers
+
 	lock sync.RWMutex
 }
 
@@ -92,6 +98,8 @@ func newTester() *downloadTester {
 		peerReceipts:      make(map[string]map[common.Hash]types.Receipts),
 		peerChainTds:      make(map[string]map[common.Hash]*big.Int),
 		peerMissingStates: make(map[string]map[common.Hash]bool),
+		config:            params.TestChainConfig,
+		engine:            huchash.NewFaker(),
 	}
 	tester.stateDb, _ = hucdb.NewMemDatabase()
 	tester.stateDb.Put(genesis.Root().Bytes(), []byte{0x00})
@@ -301,6 +309,43 @@ func (dl *downloadTester) GetTd(hash common.Hash, number uint64) *big.Int {
 	return dl.ownChainTd[hash]
 }
 
+// Config retrieves the chain configuration of the simulated chain.
+func (dl *downloadTester) Config() *params.ChainConfig {
+	return dl.config
+}
+
+// Engine retrieves the consensus engine of the simulated chain.
+func (dl *downloadTester) Engine() consensus.Engine {
+	return dl.engine
+}
+
+// GetHeader retrieves a header from the testers canonical chain by hash and number.
+func (dl *downloadTester) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := dl.GetHeaderByHash(hash); header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+// GetHeaderByNumber retrieves a header from the testers canonical chain by number.
+func (dl *downloadTester) GetHeaderByNumber(number uint64) *types.Header {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if number < uint64(len(dl.ownHashes)) {
+		return dl.ownHeaders[dl.ownHashes[number]]
+	}
+	return nil
+}
+
+// GetBlock retrieves a block from the testers canonical chain by hash and number.
+func (dl *downloadTester) GetBlock(hash common.Hash, number uint64) *types.Block {
+	if block := dl.GetBlockByHash(hash); block != nil && block.NumberU64() == number {
+		return block
+	}
+	return nil
+}
+
 // InsertHeaderChain injects a new batch of headers into the simulated chain.
 func (dl *downloadTester) InsertHeaderChain(headers []*types.Header, checkFreq int) (int, error) {
 	dl.lock.Lock()
@@ -521,8 +566,15 @@ func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int,
 	hashes := dlp.dl.peerHashes[dlp.id]
 	headers := dlp.dl.peerHeaders[dlp.id]
 	result := make([]*types.Header, 0, amount)
-	for i := 0; i < amount && len(hashes)-int(origin)-1-i*(skip+1) >= 0; i++ {
-		if header, ok := headers[hashes[len(hashes)-int(origin)-1-i*(skip+1)]]; ok {
+	for i := 0; i < amount; i++ {
+		number := int(origin) + i*(skip+1)
+		if reverse {
+			number = int(origin) - i*(skip+1)
+		}
+		if number < 0 || number >= len(hashes) {
+			break
+		}
+		if header, ok := headers[hashes[len(hashes)-number-1]]; ok {
 			result = append(result, header)
 		}
 	}
@@ -659,12 +711,14 @@ func assertOwnForkedChain(t *testing.T, tester *downloadTester, common int, leng
 // Tests that simple synchronization against a canonical chain works correctly.
 // In this test common ancestor lookup should be short circuited and not require
 // binary searching.
-func TestCanonicalSynchronisation62(t *testing.T)      { testCanonicalSynchronisation(t, 62, FullSync) }
-func TestCanonicalSynchronisation63Full(t *testing.T)  { testCanonicalSynchronisation(t, 63, FullSync) }
-func TestCanonicalSynchronisation63Fast(t *testing.T)  { testCanonicalSynchronisation(t, 63, FastSync) }
-func TestCanonicalSynchronisation64Full(t *testing.T)  { testCanonicalSynchronisation(t, 64, FullSync) }
-func TestCanonicalSynchronisation64Fast(t *testing.T)  { testCanonicalSynchronisation(t, 64, FastSync) }
-func TestCanonicalSynchronisation64Light(t *testing.T) { testCanonicalSynchronisation(t, 64, LightSync) }
+func TestCanonicalSynchronisation62(t *testing.T)     { testCanonicalSynchronisation(t, 62, FullSync) }
+func TestCanonicalSynchronisation63Full(t *testing.T) { testCanonicalSynchronisation(t, 63, FullSync) }
+func TestCanonicalSynchronisation63Fast(t *testing.T) { testCanonicalSynchronisation(t, 63, FastSync) }
+func TestCanonicalSynchronisation64Full(t *testing.T) { testCanonicalSynchronisation(t, 64, FullSync) }
+func TestCanonicalSynchronisation64Fast(t *testing.T) { testCanonicalSynchronisation(t, 64, FastSync) }
+func TestCanonicalSynchronisation64Light(t *testing.T) {
+	testCanonicalSynchronisation(t, 64, LightSync)
+}
 
 func testCanonicalSynchronisation(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -1753,3 +1807,230 @@ func testDeliverHeadersHang(t *testing.T, protocol int, mode SyncMode) {
 		tester.downloader.peers.peers["peer"].peer.(*floodingTestPeer).pend.Wait()
 	}
 }
+
+// Tests that headers are retrieved backwards from the announced head and that the
+// stashed header skeleton is cleaned up once linked and imported.
+func TestReverseHeaderSync63Full(t *testing.T)  { testReverseHeaderSync(t, 63, FullSync) }
+func TestReverseHeaderSync63Fast(t *testing.T)  { testReverseHeaderSync(t, 63, FastSync) }
+func TestReverseHeaderSync64Light(t *testing.T) { testReverseHeaderSync(t, 64, LightSync) }
+
+func testReverseHeaderSync(t *testing.T, protocol int, mode SyncMode) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	// Create a chain spanning multiple skeleton lookahead windows
+	targetBlocks := 2*maxSkeletonHeaders + 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+	tester.newPeer("peer", protocol, hashes, headers, blocks, receipts)
+
+	if err := tester.sync("peer", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, targetBlocks+1)
+	assertNoSkeleton(t, tester)
+}
+
+// assertNoSkeleton checks that no stashed skeleton headers remain in the database.
+func assertNoSkeleton(t *testing.T, tester *downloadTester) {
+	for _, key := range tester.stateDb.(*hucdb.MemDatabase).Keys() {
+		if bytes.HasPrefix(key, skeletonPrefix) {
+			t.Fatalf("skeleton header left in database: %x", key)
+		}
+	}
+}
+
+// reverseCountingPeer is a downloader peer counting the reverse header requests
+// it receives, i.e. the ancestor batches assigned to it.
+type reverseCountingPeer struct {
+	Peer
+	requests int32
+}
+
+func (p *reverseCountingPeer) RequestHeadersByHash(hash common.Hash, count, skip int, reverse bool) error {
+	if reverse {
+		atomic.AddInt32(&p.requests, 1)
+	}
+	return p.Peer.RequestHeadersByHash(hash, count, skip, reverse)
+}
+
+func (p *reverseCountingPeer) RequestHeadersByNumber(from uint64, count, skip int, reverse bool) error {
+	if reverse {
+		atomic.AddInt32(&p.requests, 1)
+	}
+	return p.Peer.RequestHeadersByNumber(from, count, skip, reverse)
+}
+
+// Tests that the ancestor batches of a reverse header sync are spread across all
+// idle peers, not only the master peer announcing the head.
+func TestReverseHeaderSyncMultiPeer(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	targetBlocks := maxSkeletonHeaders + 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+
+	helpers := make([]*reverseCountingPeer, 3)
+	for i := range helpers {
+		id := fmt.Sprintf("helper%d", i)
+		tester.newPeer(id, 63, hashes, headers, blocks, receipts)
+
+		helpers[i] = &reverseCountingPeer{Peer: tester.downloader.peers.peers[id].peer}
+		tester.downloader.peers.peers[id].peer = helpers[i]
+	}
+	tester.newPeer("peer", 63, hashes, headers, blocks, receipts)
+
+	if err := tester.sync("peer", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, targetBlocks+1)
+	assertNoSkeleton(t, tester)
+
+	for i, helper := range helpers {
+		if atomic.LoadInt32(&helper.requests) == 0 {
+			t.Errorf("helper %d: no ancestor batches assigned", i)
+		}
+	}
+}
+
+// Tests that ancestor batches from a helper peer on a different chain are dropped
+// and retrieved again, without failing the sync with the master peer.
+func TestReverseHeaderForkedHelper(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	targetBlocks := maxSkeletonHeaders + 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+	forkHashes, forkHeaders, forkBlocks, forkReceipts := tester.makeChain(targetBlocks, 1, tester.genesis, nil, false)
+
+	tester.newPeer("fork", 63, forkHashes, forkHeaders, forkBlocks, forkReceipts)
+	helper := &reverseCountingPeer{Peer: tester.downloader.peers.peers["fork"].peer}
+	tester.downloader.peers.peers["fork"].peer = helper
+
+	tester.newPeer("peer", 63, hashes, headers, blocks, receipts)
+
+	if err := tester.sync("peer", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if atomic.LoadInt32(&helper.requests) == 0 {
+		t.Fatalf("forked helper not assigned any ancestor batches")
+	}
+	assertOwnChain(t, tester, targetBlocks+1)
+	assertNoSkeleton(t, tester)
+}
+
+// Tests that a reverse header chain not linking up to its announced head, or not
+// reaching the local chain, is rejected.
+func TestReverseHeaderBrokenLink(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	targetBlocks := 2*MaxHeaderFetch + 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+
+	// Replace a header in the middle of the chain with a different one
+	forkHashes, forkHeaders, _, _ := tester.makeChain(targetBlocks, 1, tester.genesis, nil, false)
+
+	tester.newPeer("attack", 63, hashes, headers, blocks, receipts)
+	tester.peerHeaders["attack"][hashes[MaxHeaderFetch]] = forkHeaders[forkHashes[MaxHeaderFetch]]
+
+	if err := tester.sync("attack", nil, FullSync); err != errInvalidChain {
+		t.Fatalf("sync error mismatch: have %v, want %v", err, errInvalidChain)
+	}
+	if head := tester.CurrentHeader().Number.Uint64(); head != 0 {
+		t.Fatalf("headers imported from broken skeleton: head %d", head)
+	}
+	assertNoSkeleton(t, tester)
+}
+
+// Tests that skeleton headers with an invalid seal are rejected before being
+// stashed, and that the partially retrieved skeleton is removed.
+func TestReverseHeaderInvalidSeal(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	targetBlocks := 2*MaxHeaderFetch + 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+	tester.newPeer("attack", 63, hashes, headers, blocks, receipts)
+
+	// Fail the seal of the topmost header of the second reverse batch
+	tester.engine = huchash.NewFakeFailer(uint64(targetBlocks - 1))
+
+	if err := tester.sync("attack", nil, FullSync); err != errInvalidChain {
+		t.Fatalf("sync error mismatch: have %v, want %v", err, errInvalidChain)
+	}
+	if head := tester.CurrentHeader().Number.Uint64(); head != 0 {
+		t.Fatalf("headers imported from invalid skeleton: head %d", head)
+	}
+	assertNoSkeleton(t, tester)
+}
+
+// Tests that peer capacities adapt to the measured throughput: the first sample
+// is taken at face value, failures back off exponentially and well performing
+// peers are allowed to grow beyond their current estimate.
+func TestPeerCapacityAdaptation(t *testing.T) {
+	p := newPeerConnection("peer", 63, nil, log.New())
+
+	if have := p.BlockCapacity(time.Second); have != 1 {
+		t.Fatalf("unmeasured capacity mismatch: have %d, want 1", have)
+	}
+	p.blockStarted = time.Now().Add(-time.Second)
+	p.SetBlocksIdle(50)
+	if have := p.BlockCapacity(time.Second); have < 50 || have > 60 {
+		t.Fatalf("measured capacity mismatch: have %d, want ~55", have)
+	}
+	p.SetBlocksIdle(0)
+	if have := p.BlockCapacity(time.Second); have < 25 || have > 30 {
+		t.Fatalf("backed off capacity mismatch: have %d, want ~27", have)
+	}
+	p.blockThroughput = 1000000
+	if have := p.BlockCapacity(time.Second); have != MaxBlockFetch {
+		t.Fatalf("capacity cap mismatch: have %d, want %d", have, MaxBlockFetch)
+	}
+}
+
+// Tests that pure header imports only sample the seals of proof-of-work headers,
+// verifying all of them once a chain switched over to clique.
+func TestHeaderVerifyFrequency(t *testing.T) {
+	tester := newTester()
+	defer tester.terminate()
+
+	chunk := func(from int64) []*types.Header {
+		return []*types.Header{{Number: big.NewInt(from)}, {Number: big.NewInt(from + 1)}}
+	}
+	switched := *params.TestChainConfig
+	switched.CliqueSwitchBlock = big.NewInt(100)
+	switched.Clique = &params.CliqueConfig{Period: 15, Epoch: 30000}
+
+	clique := *params.TestChainConfig
+	clique.Ethash, clique.Clique = nil, &params.CliqueConfig{Period: 15, Epoch: 30000}
+
+	tests := []struct {
+		config *params.ChainConfig
+		from   int64
+		want   int
+	}{
+		{params.TestChainConfig, 10, fsHeaderCheckFrequency}, // Proof-of-work, far from the pivot
+		{params.TestChainConfig, 990, 1},                     // Proof-of-work, close to the pivot
+		{&switched, 10, fsHeaderCheckFrequency},              // Before the switch
+		{&switched, 98, fsHeaderCheckFrequency},              // Last header just before the switch
+		{&switched, 99, 1},                                   // Chunk reaching the switch
+		{&switched, 200, 1},                                  // After the switch
+		{&clique, 10, 1},                                     // Proof-of-authority from genesis
+	}
+	for i, tt := range tests {
+		tester.config = tt.config
+		if have := tester.downloader.verifyFrequency(chunk(tt.from), 1000); have != tt.want {
+			t.Errorf("test %d: frequency mismatch: have %d, want %d", i, have, tt.want)
+		}
+	}
+}
@@ -35,8 +35,9 @@ import (
 )
 
 const (
-	maxLackingHashes  = 4096 // Maximum number of entries allowed on the list or lacking items
-	measurementImpact = 0.1  // The impact a single measurement has on a peer's final throughput value.
+	maxLackingHashes       = 4096 // Maximum number of entries allowed on the list or lacking items
+	measurementImpact      = 0.1  // The impact a single measurement has on a peer's final throughput value.
+	capacityOverestimation = 1.1  // Ratio by which to request more than measured, allowing the estimate to grow
 )
 
 var (
@@ -161,6 +162,25 @@ func (p *peerConnection) FetchHeaders(from uint64, count int) error {
 	return nil
 }
 
+// FetchAncestors sends a reverse header retrieval request to the remote peer,
+// counting down from the given topmost header.
+func (p *peerConnection) FetchAncestors(top uint64, count int) error {
+	// Sanity check the protocol version
+	if p.version < 62 {
+		panic(fmt.Sprintf("header fetch [eth/62+] requested on eth/%d", p.version))
+	}
+	// Short circuit if the peer is already fetching
+	if !atomic.CompareAndSwapInt32(&p.headerIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.headerStarted = time.Now()
+
+	// Issue the header retrieval request (absolute downwards without gaps)
+	go p.peer.RequestHeadersByNumber(top, count, 0, true)
+
+	return nil
+}
+
 // FetchBodies sends a block body retrieval request to the remote peer.
 func (p *peerConnection) FetchBodies(request *fetchRequest) error {
 	// Sanity check the protocol version
@@ -259,6 +279,11 @@ func (p *peerConnection) SetNodeDataIdle(delivered int) {
 
 // setIdle sets the peer to idle, allowing it to execute new retrieval requests.
 // Its estimated retrieval throughput is updated with that measured just now.
+//
+// The very first measurement of a data type is taken at face value so that new
+// peers converge quickly, after which it's folded in as a moving average. Failed
+// requests halve the estimate instead of discarding it, letting a peer that hit
+// a transient hiccup recover without restarting from scratch.
 func (p *peerConnection) setIdle(started time.Time, delivered int, throughput *float64, idle *int32) {
 	// Irrelevant of the scaling, make sure the peer ends up idle
 	defer atomic.StoreInt32(idle, 0)
@@ -266,16 +291,20 @@ func (p *peerConnection) setIdle(started time.Time, delivered int, throughput *f
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	// If nothing was delivered (hard timeout / unavailable data), reduce throughput to minimum
+	// If nothing was delivered (hard timeout / unavailable data), back off exponentially
 	if delivered == 0 {
-		*throughput = 0
+		*throughput /= 2
 		return
 	}
 	// Otherwise update the throughput with a new measurement
 	elapsed := time.Since(started) + 1 // +1 (ns) to ensure non-zero divisor
 	measured := float64(delivered) / (float64(elapsed) / float64(time.Second))
 
-	*throughput = (1-measurementImpact)*(*throughput) + measurementImpact*measured
+	if *throughput == 0 {
+		*throughput = measured
+	} else {
+		*throughput = (1-measurementImpact)*(*throughput) + measurementImpact*measured
+	}
 	p.rtt = time.Duration((1-measurementImpact)*float64(p.rtt) + measurementImpact*float64(elapsed))
 
 	p.log.Trace("Peer throughput measurements updated",
@@ -290,7 +319,7 @@ func (p *peerConnection) HeaderCapacity(targetRTT time.Duration) int {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
-	return int(math.Min(1+math.Max(1, p.headerThroughput*float64(targetRTT)/float64(time.Second)), float64(MaxHeaderFetch)))
+	return capacity(p.headerThroughput, targetRTT, MaxHeaderFetch)
 }
 
 // BlockCapacity retrieves the peers block download allowance based on its
@@ -299,7 +328,7 @@ func (p *peerConnection) BlockCapacity(targetRTT time.Duration) int {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
-	return int(math.Min(1+math.Max(1, p.blockThroughput*float64(targetRTT)/float64(time.Second)), float64(MaxBlockFetch)))
+	return capacity(p.blockThroughput, targetRTT, MaxBlockFetch)
 }
 
 // ReceiptCapacity retrieves the peers receipt download allowance based on its
@@ -308,7 +337,7 @@ func (p *peerConnection) ReceiptCapacity(targetRTT time.Duration) int {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
-	return int(math.Min(1+math.Max(1, p.receiptThroughput*float64(targetRTT)/float64(time.Second)), float64(MaxReceiptFetch)))
+	return capacity(p.receiptThroughput, targetRTT, MaxReceiptFetch)
 }
 
 // NodeDataCapacity retrieves the peers state download allowance based on its
@@ -317,7 +346,18 @@ func (p *peerConnection) NodeDataCapacity(targetRTT time.Duration) int {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
-	return int(math.Min(1+math.Max(1, p.stateThroughput*float64(targetRTT)/float64(time.Second)), float64(MaxStateFetch)))
+	return capacity(p.stateThroughput, targetRTT, MaxStateFetch)
+}
+
+// capacity converts a measured throughput into the number of items a peer should
+// be able to deliver within the target round trip time. The estimate is inflated
+// slightly so that a peer serving everything asked of it gets a chance to prove
+// it can handle more.
+func capacity(throughput float64, targetRTT time.Duration, limit int) int {
+	estimate := throughput * float64(targetRTT) / float64(time.Second)
+	estimate = math.Max(estimate*capacityOverestimation, estimate+1)
+
+	return int(math.Max(1, math.Min(estimate, float64(limit))))
 }
 
 // MarkLacking appends a new entity to the set of items (blocks, receipts, states)
@@ -477,7 +517,7 @@ func (ps *peerSet) HeaderIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.headerThroughput
 	}
-	return ps.idlePeers(62, 64, idle, throughput)
+	return ps.idlePeers(62, 100, idle, throughput)
 }
 
 // BodyIdlePeers retrieves a flat list of all the currently body-idle peers within
@@ -491,7 +531,7 @@ func (ps *peerSet) BodyIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.blockThroughput
 	}
-	return ps.idlePeers(62, 64, idle, throughput)
+	return ps.idlePeers(62, 100, idle, throughput)
 }
 
 // ReceiptIdlePeers retrieves a flat list of all the currently receipt-idle peers
@@ -505,7 +545,7 @@ func (ps *peerSet) ReceiptIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.receiptThroughput
 	}
-	return ps.idlePeers(63, 64, idle, throughput)
+	return ps.idlePeers(63, 100, idle, throughput)
 }
 
 // NodeDataIdlePeers retrieves a flat list of all the currently node-data-idle
@@ -519,7 +559,7 @@ func (ps *peerSet) NodeDataIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.stateThroughput
 	}
-	return ps.idlePeers(63, 64, idle, throughput)
+	return ps.idlePeers(63, 100, idle, throughput)
 }
 
 // idlePeers retrieves a flat list of all currently idle peers satisfying the
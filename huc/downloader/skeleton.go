@@ -0,0 +1,367 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/rlp"
+)
+
+// maxSkeletonHeaders is the maximum number of headers a reverse header sync
+// requests ahead of the verified part of the skeleton. It bounds the batches that
+// are delivered out of order by faster peers and held until they can be linked.
+var maxSkeletonHeaders = 4 * MaxHeaderFetch
+
+// skeletonPrefix is the database key prefix under which headers retrieved during
+// the reverse header sync are stashed until they are linked to the local chain.
+var skeletonPrefix = []byte("dl-skeleton-") // skeletonPrefix + num (uint64 big endian) -> header
+
+// skeletonKey returns the database key of a stashed header at the given height.
+func skeletonKey(number uint64) []byte {
+	key := make([]byte, len(skeletonPrefix)+8)
+	copy(key, skeletonPrefix)
+	binary.BigEndian.PutUint64(key[len(skeletonPrefix):], number)
+	return key
+}
+
+// sealVerifier is implemented by local chains whose consensus engine can check
+// the seals of detached headers, used to sample the reverse synced headers before
+// stashing them.
+type sealVerifier interface {
+	consensus.ChainReader
+	Engine() consensus.Engine
+}
+
+// skeletonBatch is a range of skeleton headers requested in reverse order, from
+// the topmost one down.
+type skeletonBatch struct {
+	top   uint64 // Number of the topmost header in the batch
+	count int    // Number of headers in the batch
+
+	peer    *peerConnection // Peer that delivered the batch
+	headers []*types.Header // Headers delivered, nil while not yet retrieved
+}
+
+// headerRequest is a header retrieval request in flight to a remote peer.
+type headerRequest struct {
+	peer    *peerConnection
+	started time.Time      // Time the request was sent at
+	batch   *skeletonBatch // Skeleton batch being retrieved, if any
+}
+
+// fetchHeadersReverse retrieves the header chain of the remote peer up to its
+// announced head. The head is used as the anchor of the skeleton, which is then
+// retrieved backwards following the parent hashes until it links to the local
+// chain. Only the hash links and a sample of the seals are verified while doing
+// so, the headers are stashed in the database until the skeleton is linked.
+//
+// The linked skeleton is fed to the header processor in ascending order, from
+// where bodies and receipts are scheduled to all available peers. Any new headers
+// the remote peer produced in the meantime are picked up by a final forward header
+// fetch.
+func (d *Downloader) fetchHeadersReverse(p *peerConnection, from uint64, head *types.Header) (err error) {
+	p.log.Debug("Directing reverse header downloads", "origin", from, "head", head.Number)
+	defer p.log.Debug("Reverse header download terminated")
+
+	to := head.Number.Uint64()
+	if to < from {
+		return d.fetchHeaders(p, from)
+	}
+	defer func() {
+		if err != nil {
+			d.dropSkeleton(from, to)
+		}
+	}()
+	link, err := d.fetchSkeleton(p, from, head)
+	if err != nil {
+		return err
+	}
+	// Ensure the skeleton links into the local chain
+	if header := d.lightchain.GetHeaderByHash(link); header == nil || header.Number.Uint64()+1 != from {
+		p.log.Debug("Reverse headers not linked to local chain", "number", from, "parent", link)
+		return errInvalidAncestor
+	}
+	if err := d.deliverSkeleton(from, to); err != nil {
+		return err
+	}
+	return d.fetchHeaders(p, to+1)
+}
+
+// fetchSkeleton retrieves and stashes all the headers between the origin and the
+// given head, walking backwards from the head. Batches of ancestors are spread
+// across all idle peers and verified as their hash links reach them, with the
+// master peer being the authority on the chain: a batch failing verification
+// aborts the sync if it came from the master, otherwise the peer delivering it is
+// no longer used and the batch is retrieved again. The hash of the parent of the
+// lowest header is returned for the caller to link it.
+func (d *Downloader) fetchSkeleton(p *peerConnection, from uint64, head *types.Header) (common.Hash, error) {
+	var (
+		start  = time.Now()
+		logged = time.Now()
+
+		hash   = head.Hash()          // Hash of the next header to verify
+		number = head.Number.Uint64() // Number of the next header to verify
+		next   = number - 1           // Topmost header not yet scheduled
+
+		queue   []*skeletonBatch                  // Batches waiting to be (re)requested
+		pending = make(map[uint64]*skeletonBatch) // Batches delivered, waiting to be linked
+		active  = make(map[string]*headerRequest) // Requests currently in flight
+		failed  = make(map[string]struct{})       // Peers not to use for this skeleton
+	)
+	pending[number] = &skeletonBatch{top: number, count: 1, peer: p, headers: []*types.Header{head}}
+
+	for {
+		// Verify and stash all the delivered batches linking up to the skeleton
+		for batch := pending[number]; batch != nil; batch = pending[number] {
+			delete(pending, number)
+
+			if err := d.linkSkeleton(batch, hash); err != nil {
+				if batch.peer == p {
+					return common.Hash{}, err
+				}
+				batch.peer.log.Debug("Dropping reverse header batch", "number", batch.top, "err", err)
+				failed[batch.peer.id] = struct{}{}
+				batch.peer, batch.headers = nil, nil
+				queue = append([]*skeletonBatch{batch}, queue...)
+				break
+			}
+			hash = batch.headers[len(batch.headers)-1].ParentHash
+			number -= uint64(len(batch.headers))
+
+			// If the peer delivered only part of the batch, request the rest again
+			if len(batch.headers) < batch.count {
+				rest := &skeletonBatch{top: number, count: batch.count - len(batch.headers)}
+				queue = append([]*skeletonBatch{rest}, queue...)
+			}
+			if time.Since(logged) > 8*time.Second {
+				log.Info("Downloading header skeleton", "number", number, "origin", from, "head", head.Number, "elapsed", common.PrettyDuration(time.Since(start)))
+				logged = time.Now()
+			}
+		}
+		// If the origin was reached, return the hash the skeleton needs to link to
+		if number < from {
+			p.log.Debug("Header skeleton retrieved", "origin", from, "head", head.Number, "elapsed", common.PrettyDuration(time.Since(start)))
+			return hash, nil
+		}
+		// Otherwise assign the next batches of ancestors to all idle peers
+		idles, _ := d.peers.HeaderIdlePeers()
+		for _, peer := range idles {
+			if _, ok := failed[peer.id]; ok {
+				continue
+			}
+			var batch *skeletonBatch
+			switch {
+			case len(queue) > 0:
+				batch, queue = queue[0], queue[1:]
+
+			case next >= from && number-next < uint64(maxSkeletonHeaders):
+				count := MaxHeaderFetch
+				if next-from+1 < uint64(count) {
+					count = int(next - from + 1)
+				}
+				batch = &skeletonBatch{top: next, count: count}
+				next -= uint64(count)
+			}
+			if batch == nil {
+				break
+			}
+			if err := peer.FetchAncestors(batch.top, batch.count); err != nil {
+				queue = append([]*skeletonBatch{batch}, queue...)
+				continue
+			}
+			p.log.Trace("Fetching reverse headers", "peer", peer.id, "count", batch.count, "from", batch.top)
+			active[peer.id] = &headerRequest{peer: peer, started: time.Now(), batch: batch}
+		}
+		if len(active) == 0 {
+			return common.Hash{}, errNoPeers
+		}
+		// Wait for any of the batches to arrive and stash it until it's linked
+		req, headers, err := d.awaitHeaders(active)
+		if err == errCancelHeaderFetch {
+			return common.Hash{}, err
+		}
+		req.peer.SetHeadersIdle(len(headers))
+
+		if err == nil && len(headers) > req.batch.count {
+			req.peer.log.Debug("Reverse headers exceeded request", "count", len(headers), "want", req.batch.count)
+			err = errBadPeer
+		}
+		if err != nil {
+			if req.peer == p {
+				return common.Hash{}, err
+			}
+			failed[req.peer.id] = struct{}{}
+			queue = append([]*skeletonBatch{req.batch}, queue...)
+			continue
+		}
+		req.batch.peer, req.batch.headers = req.peer, headers
+		pending[req.batch.top] = req.batch
+	}
+}
+
+// linkSkeleton verifies that a delivered batch of skeleton headers links up to the
+// given hash, samples its seal and stashes it in the database.
+func (d *Downloader) linkSkeleton(batch *skeletonBatch, hash common.Hash) error {
+	for i, header := range batch.headers {
+		if header.Hash() != hash || header.Number.Uint64() != batch.top-uint64(i) {
+			batch.peer.log.Debug("Reverse headers broke hash chain", "number", header.Number, "hash", header.Hash(), "want", hash)
+			return errInvalidChain
+		}
+		hash = header.ParentHash
+	}
+	if err := d.verifySkeletonSeal(batch.headers[0]); err != nil {
+		batch.peer.log.Debug("Reverse header seal invalid", "number", batch.headers[0].Number, "hash", batch.headers[0].Hash(), "err", err)
+		return errInvalidChain
+	}
+	dbBatch := d.stateDB.NewBatch()
+	for _, header := range batch.headers {
+		blob, err := rlp.EncodeToBytes(header)
+		if err != nil {
+			return err
+		}
+		if err := dbBatch.Put(skeletonKey(header.Number.Uint64()), blob); err != nil {
+			return err
+		}
+	}
+	return dbBatch.Write()
+}
+
+// verifySkeletonSeal checks the seal of a sampled skeleton header, if the local
+// chain is able to. Only proof-of-work seals can be checked detached from their
+// ancestors, the others are left to the header processor once linked.
+func (d *Downloader) verifySkeletonSeal(header *types.Header) error {
+	chain, ok := d.lightchain.(sealVerifier)
+	if !ok || !d.sealedByWork(header.Number) {
+		return nil
+	}
+	return chain.Engine().VerifySeal(chain, header)
+}
+
+// fetchHeaderBatch issues a single header request to a remote peer and waits for
+// its reply, as per the rules of awaitHeaders.
+func (d *Downloader) fetchHeaderBatch(p *peerConnection, request func() error) ([]*types.Header, error) {
+	go request()
+
+	_, headers, err := d.awaitHeaders(map[string]*headerRequest{p.id: {peer: p, started: time.Now()}})
+	return headers, err
+}
+
+// awaitHeaders waits for the reply to any of the header requests in flight and
+// removes the answered request from the set. Deliveries from peers without an
+// active request are discarded. Empty replies are rejected, and peers not
+// replying in time are dropped.
+func (d *Downloader) awaitHeaders(active map[string]*headerRequest) (*headerRequest, []*types.Header, error) {
+	ttl := d.requestTTL()
+
+	// Find the request timing out first
+	var first *headerRequest
+	for _, req := range active {
+		if first == nil || req.started.Before(first.started) {
+			first = req
+		}
+	}
+	timeout := time.NewTimer(time.Until(first.started.Add(ttl)))
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-d.cancelCh:
+			return nil, nil, errCancelHeaderFetch
+
+		case packet := <-d.headerCh:
+			// Discard anything not from a peer with an active request
+			req := active[packet.PeerId()]
+			if req == nil {
+				log.Debug("Received headers from incorrect peer", "peer", packet.PeerId())
+				break
+			}
+			delete(active, req.peer.id)
+			headerReqTimer.UpdateSince(req.started)
+
+			headers := packet.(*headerPack).headers
+			if len(headers) == 0 {
+				return req, nil, errEmptyHeaderSet
+			}
+			return req, headers, nil
+
+		case <-timeout.C:
+			delete(active, first.peer.id)
+			if d.dropPeer == nil {
+				// The dropPeer method is nil when `--copydb` is used for a local copy.
+				first.peer.log.Warn("Downloader wants to drop peer, but peerdrop-function is not set", "peer", first.peer.id)
+				return first, nil, errTimeout
+			}
+			// Header retrieval timed out, consider the peer bad and drop
+			first.peer.log.Debug("Header request timed out", "elapsed", ttl)
+			headerTimeoutMeter.Mark(1)
+			d.dropPeer(first.peer.id)
+			return first, nil, errBadPeer
+		}
+	}
+}
+
+// deliverSkeleton feeds the stashed header skeleton to the header processor in
+// ascending order, removing each header from the database once it's scheduled.
+func (d *Downloader) deliverSkeleton(from, to uint64) error {
+	for from <= to {
+		headers := make([]*types.Header, 0, MaxHeaderFetch)
+		for ; from <= to && len(headers) < MaxHeaderFetch; from++ {
+			header, err := readSkeletonHeader(d.stateDB, from)
+			if err != nil {
+				return err
+			}
+			headers = append(headers, header)
+		}
+		select {
+		case d.headerProcCh <- headers:
+		case <-d.cancelCh:
+			return errCancelHeaderFetch
+		}
+		for _, header := range headers {
+			d.stateDB.Delete(skeletonKey(header.Number.Uint64()))
+		}
+	}
+	return nil
+}
+
+// dropSkeleton removes any stashed skeleton headers in the given range from the
+// database.
+func (d *Downloader) dropSkeleton(from, to uint64) {
+	for number := from; number <= to; number++ {
+		d.stateDB.Delete(skeletonKey(number))
+	}
+}
+
+// readSkeletonHeader retrieves a stashed skeleton header from the database.
+func readSkeletonHeader(db hucdb.Database, number uint64) (*types.Header, error) {
+	blob, err := db.Get(skeletonKey(number))
+	if err != nil {
+		return nil, err
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(blob, header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
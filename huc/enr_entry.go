@@ -0,0 +1,68 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package huc
+
+import (
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/forkid"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/rlp"
+)
+
+// hucEntry is the ENR entry which advertises the eth protocol on the discovery
+// network, allowing remote nodes to skip dialing peers of other chains.
+type hucEntry struct {
+	ForkID forkid.ID // Fork identifier per EIP-2124
+
+	// Ignore additional fields (for forward compatibility).
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+// ENRKey implements enr.Entry.
+func (e hucEntry) ENRKey() string {
+	return ProtocolName
+}
+
+// startHucEntryUpdate announces the fork ID in the local node record and keeps
+// it current as the chain passes fork blocks.
+func (s *HappyUC) startHucEntryUpdate(ln *discover.LocalNode) {
+	var (
+		heads = make(chan core.ChainHeadEvent, 10)
+		sub   = s.blockchain.SubscribeChainHeadEvent(heads)
+	)
+	current := forkid.NewID(s.blockchain)
+	ln.Set(hucEntry{ForkID: current})
+
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case <-heads:
+				// Only touch the record on fork transitions, every change
+				// bumps its sequence number.
+				if id := forkid.NewID(s.blockchain); id != current {
+					current = id
+					ln.Set(hucEntry{ForkID: current})
+				}
+			case <-sub.Err():
+				return
+			case <-s.shutdownChan:
+				return
+			}
+		}
+	}()
+}
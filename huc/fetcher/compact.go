@@ -0,0 +1,314 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/log"
+)
+
+// TxID is the abbreviated identifier of a transaction within a compact block.
+type TxID [8]byte
+
+// ShortTxID derives the abbreviated identifier of a transaction contained in a
+// compact block. The identifiers are salted with the block hash, so that crafted
+// colliding transactions need to be generated anew for every block.
+func ShortTxID(block common.Hash, tx common.Hash) TxID {
+	var id TxID
+	copy(id[:], crypto.Keccak256(block[:], tx[:]))
+	return id
+}
+
+// ShortTxIDs derives the abbreviated identifiers of all the transactions of a
+// block, to be propagated in its compact form.
+func ShortTxIDs(block *types.Block) []TxID {
+	hash := block.Hash()
+
+	ids := make([]TxID, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		ids[i] = ShortTxID(hash, tx.Hash())
+	}
+	return ids
+}
+
+// poolRetrievalFn is a callback type for retrieving the transactions currently
+// sitting in the local transaction pool.
+type poolRetrievalFn func() types.Transactions
+
+// txsRequesterFn is a callback type for requesting the missing transactions of
+// a compact block by their index.
+type txsRequesterFn func(common.Hash, []uint64) error
+
+// compactBlock is a block propagated as its header and abbreviated transaction
+// identifiers, being reconstructed from the local transaction pool.
+type compactBlock struct {
+	header *types.Header        // Header of the block being reconstructed
+	uncles []*types.Header      // Uncles contained within the block
+	ids    []TxID               // Abbreviated identifiers of the block's transactions
+	txs    []*types.Transaction // Transactions reconstructed so far (nil = missing)
+	time   time.Time            // Timestamp of the announcement
+	fetch  time.Time            // Timestamp of the missing transaction request (zero = verifying)
+	err    error                // Header verification failure, if any
+
+	origin string // Identifier of the peer propagating the block
+
+	fetchTxs    txsRequesterFn    // Fetcher function to retrieve the missing transactions
+	fetchHeader headerRequesterFn // Fetcher function to retrieve the header of the block (fallback)
+	fetchBodies bodyRequesterFn   // Fetcher function to retrieve the body of the block (fallback)
+}
+
+// blockTxsTask represents a batch of transactions delivered to complete a compact
+// block.
+type blockTxsTask struct {
+	peer string               // The source peer of the transactions
+	hash common.Hash          // Hash of the compact block the transactions belong to
+	txs  []*types.Transaction // Missing transactions of the compact block
+	time time.Time            // Arrival time of the transactions
+}
+
+// missing returns the indexes of the transactions not yet reconstructed.
+func (c *compactBlock) missing() []uint64 {
+	var indexes []uint64
+	for i, tx := range c.txs {
+		if tx == nil {
+			indexes = append(indexes, uint64(i))
+		}
+	}
+	return indexes
+}
+
+// assemble creates the full block from the reconstructed transactions, returning
+// nil if the transactions or uncles don't match the header.
+func (c *compactBlock) assemble() *types.Block {
+	if types.DeriveSha(types.Transactions(c.txs)) != c.header.TxHash {
+		return nil
+	}
+	if types.CalcUncleHash(c.uncles) != c.header.UncleHash {
+		return nil
+	}
+	block := types.NewBlockWithHeader(c.header).WithBody(c.txs, c.uncles)
+	block.ReceivedAt = c.time
+	return block
+}
+
+// EnqueueCompact schedules a block propagated in its compact form for import,
+// reconstructing its body from the local transaction pool and fetching only the
+// transactions missing from it.
+func (f *Fetcher) EnqueueCompact(peer string, header *types.Header, uncles []*types.Header, ids []TxID, time time.Time,
+	txsFetcher txsRequesterFn, headerFetcher headerRequesterFn, bodyFetcher bodyRequesterFn) error {
+	block := &compactBlock{
+		header:      header,
+		uncles:      uncles,
+		ids:         ids,
+		time:        time,
+		origin:      peer,
+		fetchTxs:    txsFetcher,
+		fetchHeader: headerFetcher,
+		fetchBodies: bodyFetcher,
+	}
+	select {
+	case f.compact <- block:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// DeliverBlockTxs injects the missing transactions of a compact block requested
+// earlier from a remote peer.
+func (f *Fetcher) DeliverBlockTxs(peer string, hash common.Hash, txs []*types.Transaction, time time.Time) error {
+	select {
+	case f.blockTxs <- &blockTxsTask{peer: peer, hash: hash, txs: txs, time: time}:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// reconstruct starts filling the body of a newly propagated compact block from
+// the local transaction pool. The header is verified on a background thread before
+// the pool is touched, with the outcome being picked up by rebuild.
+func (f *Fetcher) reconstruct(block *compactBlock, complete *time.Timer) {
+	hash := block.header.Hash()
+
+	// Discard the block if it's already known or being retrieved
+	if _, ok := f.reconstructing[hash]; ok {
+		return
+	}
+	if f.queued[hash] != nil || f.completing[hash] != nil || f.getBlock(hash) != nil {
+		return
+	}
+	if dist := int64(block.header.Number.Uint64()) - int64(f.chainHeight()); dist < -maxUncleDist || dist > maxQueueDist {
+		log.Debug("Discarded compact block, too far away", "peer", block.origin, "number", block.header.Number, "hash", hash, "distance", dist)
+		propBroadcastDropMeter.Mark(1)
+		return
+	}
+	// Ensure the peer isn't DOSing us with reconstructions
+	if count := f.compacts[block.origin] + 1; count > compactLimit {
+		log.Debug("Discarded compact block, exceeded allowance", "peer", block.origin, "number", block.header.Number, "hash", hash, "limit", compactLimit)
+		propBroadcastDropMeter.Mark(1)
+		return
+	}
+	// Headers can only be verified on top of a known parent, fetch the body otherwise
+	if f.getBlock(block.header.ParentHash) == nil {
+		f.fallback(block, complete)
+		return
+	}
+	f.compacts[block.origin]++
+	f.reconstructing[hash] = block
+
+	go func() {
+		// Only spend the pool pass on blocks with a valid header
+		switch err := f.verifyHeader(block.header); err {
+		case nil, consensus.ErrFutureBlock:
+			// Index the pooled transactions by their short identifier within this block
+			pool := make(map[TxID]*types.Transaction)
+			for _, tx := range f.getPoolTxs() {
+				pool[ShortTxID(hash, tx.Hash())] = tx
+			}
+			block.txs = make([]*types.Transaction, len(block.ids))
+			for i, id := range block.ids {
+				block.txs[i] = pool[id]
+			}
+		default:
+			block.err = err
+		}
+		select {
+		case f.rebuilt <- block:
+		case <-f.quit:
+		}
+	}()
+}
+
+// rebuild processes a compact block whose header was verified and whose body was
+// filled from the local pool, requesting any missing transactions from the peer.
+func (f *Fetcher) rebuild(block *compactBlock, complete *time.Timer) {
+	hash := block.header.Hash()
+	if f.reconstructing[hash] != block {
+		return
+	}
+	// If the header was invalid, drop the propagating peer
+	if block.err != nil {
+		f.forgetCompact(hash)
+
+		log.Debug("Compact block verification failed", "peer", block.origin, "number", block.header.Number, "hash", hash, "err", block.err)
+		f.dropPeer(block.origin)
+		return
+	}
+	// If everything was found locally, assemble and import the block
+	missing := block.missing()
+	if len(missing) == 0 {
+		f.forgetCompact(hash)
+
+		if full := block.assemble(); full != nil {
+			compactHitMeter.Mark(1)
+			f.enqueue(block.origin, full)
+			return
+		}
+		// Short identifiers collided, retrieve the full body instead
+		f.fallback(block, complete)
+		return
+	}
+	// Otherwise request the missing transactions from the propagating peer
+	log.Trace("Fetching compact block transactions", "peer", block.origin, "number", block.header.Number, "hash", hash, "missing", len(missing))
+	compactTxFetchMeter.Mark(int64(len(missing)))
+
+	block.fetch = time.Now()
+	go block.fetchTxs(hash, missing)
+}
+
+// completeCompact fills in the missing transactions of a compact block, falling back to
+// retrieving the full body if they don't match up.
+func (f *Fetcher) completeCompact(task *blockTxsTask, complete *time.Timer) {
+	block := f.reconstructing[task.hash]
+	if block == nil || block.origin != task.peer || block.fetch.IsZero() {
+		return
+	}
+	f.forgetCompact(task.hash)
+
+	missing := block.missing()
+	if len(missing) != len(task.txs) {
+		f.fallback(block, complete)
+		return
+	}
+	for i, index := range missing {
+		block.txs[index] = task.txs[i]
+	}
+	full := block.assemble()
+	if full == nil {
+		f.fallback(block, complete)
+		return
+	}
+	f.enqueue(block.origin, full)
+}
+
+// expireCompacts falls back to full body retrievals for any compact blocks whose
+// missing transactions didn't arrive in time.
+func (f *Fetcher) expireCompacts(complete *time.Timer) {
+	for hash, block := range f.reconstructing {
+		if !block.fetch.IsZero() && time.Since(block.fetch) > fetchTimeout {
+			f.forgetCompact(hash)
+			f.fallback(block, complete)
+		}
+	}
+}
+
+// forgetCompact removes all traces of a compact block reconstruction.
+func (f *Fetcher) forgetCompact(hash common.Hash) {
+	if block := f.reconstructing[hash]; block != nil {
+		f.compacts[block.origin]--
+		if f.compacts[block.origin] <= 0 {
+			delete(f.compacts, block.origin)
+		}
+		delete(f.reconstructing, hash)
+	}
+}
+
+// fallback schedules the body of a compact block that couldn't be reconstructed
+// for regular retrieval from the propagating peer.
+func (f *Fetcher) fallback(block *compactBlock, complete *time.Timer) {
+	hash := block.header.Hash()
+	log.Debug("Compact block reconstruction failed, fetching body", "peer", block.origin, "number", block.header.Number, "hash", hash)
+	compactFallbackMeter.Mark(1)
+
+	if _, ok := f.completing[hash]; ok {
+		return
+	}
+	if count := f.announces[block.origin] + 1; count > hashLimit {
+		log.Debug("Peer exceeded outstanding announces", "peer", block.origin, "limit", hashLimit)
+		propAnnounceDOSMeter.Mark(1)
+		return
+	}
+	f.announces[block.origin]++
+	f.fetched[hash] = append(f.fetched[hash], &announce{
+		hash:        hash,
+		number:      block.header.Number.Uint64(),
+		header:      block.header,
+		time:        time.Now(),
+		origin:      block.origin,
+		fetchHeader: block.fetchHeader,
+		fetchBodies: block.fetchBodies,
+	})
+	if len(f.fetched) == 1 {
+		f.rescheduleComplete(complete)
+	}
+}
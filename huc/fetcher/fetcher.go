@@ -37,6 +37,7 @@ const (
 	maxQueueDist  = 32                     // Maximum allowed distance from the chain head to queue
 	hashLimit     = 256                    // Maximum number of unique blocks a peer may have announced
 	blockLimit    = 64                     // Maximum number of unique blocks a peer may have delivered
+	compactLimit  = 8                      // Maximum number of compact blocks a peer may have pending reconstruction
 )
 
 var (
@@ -107,8 +108,11 @@ type inject struct {
 // and scheduling them for retrieval.
 type Fetcher struct {
 	// Various event channels
-	notify chan *announce
-	inject chan *inject
+	notify   chan *announce
+	inject   chan *inject
+	compact  chan *compactBlock
+	rebuilt  chan *compactBlock
+	blockTxs chan *blockTxsTask
 
 	blockFilter  chan chan []*types.Block
 	headerFilter chan chan *headerFilterTask
@@ -124,6 +128,10 @@ type Fetcher struct {
 	fetched    map[common.Hash][]*announce // Blocks with headers fetched, scheduled for body retrieval
 	completing map[common.Hash]*announce   // Blocks with headers, currently body-completing
 
+	// Compact block states
+	compacts       map[string]int                // Per peer compact block counts to prevent CPU and memory exhaustion
+	reconstructing map[common.Hash]*compactBlock // Compact blocks being verified or waiting for missing transactions
+
 	// Block cache
 	queue  *prque.Prque            // Queue containing the import operations (block number sorted)
 	queues map[string]int          // Per peer block counts to prevent memory exhaustion
@@ -131,6 +139,7 @@ type Fetcher struct {
 
 	// Callbacks
 	getBlock       blockRetrievalFn   // Retrieves a block from the local chain
+	getPoolTxs     poolRetrievalFn    // Retrieves the transactions in the local pool
 	verifyHeader   headerVerifierFn   // Checks if a block's headers have a valid proof of work
 	broadcastBlock blockBroadcasterFn // Broadcasts a block to connected peers
 	chainHeight    chainHeightFn      // Retrieves the current chain's height
@@ -146,10 +155,13 @@ type Fetcher struct {
 }
 
 // New creates a block fetcher to retrieve blocks based on hash announcements.
-func New(getBlock blockRetrievalFn, verifyHeader headerVerifierFn, broadcastBlock blockBroadcasterFn, chainHeight chainHeightFn, insertChain chainInsertFn, dropPeer peerDropFn) *Fetcher {
+func New(getBlock blockRetrievalFn, getPoolTxs poolRetrievalFn, verifyHeader headerVerifierFn, broadcastBlock blockBroadcasterFn, chainHeight chainHeightFn, insertChain chainInsertFn, dropPeer peerDropFn) *Fetcher {
 	return &Fetcher{
 		notify:         make(chan *announce),
 		inject:         make(chan *inject),
+		compact:        make(chan *compactBlock),
+		rebuilt:        make(chan *compactBlock),
+		blockTxs:       make(chan *blockTxsTask),
 		blockFilter:    make(chan chan []*types.Block),
 		headerFilter:   make(chan chan *headerFilterTask),
 		bodyFilter:     make(chan chan *bodyFilterTask),
@@ -160,10 +172,13 @@ func New(getBlock blockRetrievalFn, verifyHeader headerVerifierFn, broadcastBloc
 		fetching:       make(map[common.Hash]*announce),
 		fetched:        make(map[common.Hash][]*announce),
 		completing:     make(map[common.Hash]*announce),
+		compacts:       make(map[string]int),
+		reconstructing: make(map[common.Hash]*compactBlock),
 		queue:          prque.New(),
 		queues:         make(map[string]int),
 		queued:         make(map[common.Hash]*inject),
 		getBlock:       getBlock,
+		getPoolTxs:     getPoolTxs,
 		verifyHeader:   verifyHeader,
 		broadcastBlock: broadcastBlock,
 		chainHeight:    chainHeight,
@@ -288,6 +303,8 @@ func (f *Fetcher) loop() {
 				f.forgetHash(hash)
 			}
 		}
+		f.expireCompacts(completeTimer)
+
 		// Import any queued blocks that could potentially fit
 		height := f.chainHeight()
 		for !f.queue.Empty() {
@@ -357,6 +374,20 @@ func (f *Fetcher) loop() {
 			propBroadcastInMeter.Mark(1)
 			f.enqueue(op.origin, op.block)
 
+		case block := <-f.compact:
+			// A compact block was propagated, try to reconstruct it from the pool
+			propBroadcastInMeter.Mark(1)
+			compactInMeter.Mark(1)
+			f.reconstruct(block, completeTimer)
+
+		case block := <-f.rebuilt:
+			// A compact block was verified and filled from the pool, complete it
+			f.rebuild(block, completeTimer)
+
+		case task := <-f.blockTxs:
+			// Missing transactions of a compact block arrived, try to complete it
+			f.completeCompact(task, completeTimer)
+
 		case hash := <-f.done:
 			// A pending import finished, remove all traces of the notification
 			f.forgetHash(hash)
@@ -19,6 +19,7 @@ package fetcher
 import (
 	"errors"
 	"math/big"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -81,6 +82,9 @@ type fetcherTester struct {
 	hashes []common.Hash                // Hash chain belonging to the tester
 	blocks map[common.Hash]*types.Block // Blocks belonging to the tester
 	drops  map[string]bool              // Map of peers dropped by the fetcher
+	pool   types.Transactions           // Transactions in the simulated pool
+	scans  int                          // Number of times the simulated pool was read
+	bad    map[common.Hash]bool         // Headers failing the simulated verification
 
 	lock sync.RWMutex
 }
@@ -91,8 +95,9 @@ func newTester() *fetcherTester {
 		hashes: []common.Hash{genesis.Hash()},
 		blocks: map[common.Hash]*types.Block{genesis.Hash(): genesis},
 		drops:  make(map[string]bool),
+		bad:    make(map[common.Hash]bool),
 	}
-	tester.fetcher = New(tester.getBlock, tester.verifyHeader, tester.broadcastBlock, tester.chainHeight, tester.insertChain, tester.dropPeer)
+	tester.fetcher = New(tester.getBlock, tester.getPoolTxs, tester.verifyHeader, tester.broadcastBlock, tester.chainHeight, tester.insertChain, tester.dropPeer)
 	tester.fetcher.Start()
 
 	return tester
@@ -106,8 +111,24 @@ func (f *fetcherTester) getBlock(hash common.Hash) *types.Block {
 	return f.blocks[hash]
 }
 
-// verifyHeader is a nop placeholder for the block header verification.
+// getPoolTxs retrieves the transactions in the tester's simulated pool.
+func (f *fetcherTester) getPoolTxs() types.Transactions {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.scans++
+	return f.pool
+}
+
+// verifyHeader is a placeholder for the block header verification, failing only
+// the headers explicitly marked as bad.
 func (f *fetcherTester) verifyHeader(header *types.Header) error {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if f.bad[header.Hash()] {
+		return errors.New("invalid header")
+	}
 	return nil
 }
 
@@ -788,3 +809,185 @@ func TestBlockMemoryExhaustionAttack(t *testing.T) {
 	}
 	verifyImportDone(t, imported)
 }
+
+// makeCompactBlock creates a block on top of the genesis containing the given
+// number of transactions, to be propagated in its compact form.
+func makeCompactBlock(txs int) *types.Block {
+	blocks, _ := core.GenerateChain(params.TestChainConfig, genesis, huchash.NewFaker(), testdb, 1, func(i int, block *core.BlockGen) {
+		signer := types.MakeSigner(params.TestChainConfig, block.Number())
+		for j := 0; j < txs; j++ {
+			tx, err := types.SignTx(types.NewTransaction(block.TxNonce(testAddress), common.Address{0x01}, big.NewInt(1000), params.TxGas, nil, nil), signer, testKey)
+			if err != nil {
+				panic(err)
+			}
+			block.AddTx(tx)
+		}
+	})
+	return blocks[0]
+}
+
+// makeTxsFetcher retrieves a compact block transaction fetcher associated with
+// a simulated peer, recording the requested indexes.
+func (f *fetcherTester) makeTxsFetcher(peer string, block *types.Block, requested chan []uint64) txsRequesterFn {
+	return func(hash common.Hash, indexes []uint64) error {
+		txs := make([]*types.Transaction, 0, len(indexes))
+		for _, index := range indexes {
+			txs = append(txs, block.Transactions()[index])
+		}
+		requested <- indexes
+		go f.fetcher.DeliverBlockTxs(peer, hash, txs, time.Now())
+		return nil
+	}
+}
+
+// Tests that compact blocks are reconstructed from the transaction pool, only
+// requesting the transactions missing from it.
+func TestCompactBlockReconstruction(t *testing.T) {
+	block := makeCompactBlock(4)
+	txs := block.Transactions()
+
+	tests := []struct {
+		pool    types.Transactions
+		missing []uint64
+	}{
+		{pool: txs, missing: nil}, // everything pooled
+		{pool: types.Transactions{txs[0], txs[2]}, missing: []uint64{1, 3}}, // partially pooled
+		{pool: nil, missing: []uint64{0, 1, 2, 3}},                          // nothing pooled
+	}
+	for i, tt := range tests {
+		tester := newTester()
+		tester.pool = tt.pool
+
+		imported := make(chan *types.Block)
+		tester.fetcher.importedHook = func(block *types.Block) { imported <- block }
+
+		requested := make(chan []uint64, 1)
+		txsFetcher := tester.makeTxsFetcher("valid", block, requested)
+		headerFetcher := tester.makeHeaderFetcher("valid", nil, 0)
+		bodyFetcher := tester.makeBodyFetcher("valid", nil, 0)
+
+		tester.fetcher.EnqueueCompact("valid", block.Header(), block.Uncles(), ShortTxIDs(block), time.Now(), txsFetcher, headerFetcher, bodyFetcher)
+		verifyImportEvent(t, imported, true)
+
+		select {
+		case indexes := <-requested:
+			if !reflect.DeepEqual(indexes, tt.missing) {
+				t.Errorf("test %d: requested indexes mismatch: have %v, want %v", i, indexes, tt.missing)
+			}
+		default:
+			if tt.missing != nil {
+				t.Errorf("test %d: missing transactions not requested", i)
+			}
+		}
+		if have := tester.getBlock(block.Hash()); have == nil {
+			t.Errorf("test %d: block not imported", i)
+		}
+		tester.fetcher.Stop()
+	}
+}
+
+// Tests that if the missing transactions of a compact block don't match up, the
+// fetcher falls back to retrieving the full block body.
+func TestCompactBlockFallback(t *testing.T) {
+	block := makeCompactBlock(4)
+	other := makeCompactBlock(2)
+
+	tester := newTester()
+	tester.pool = types.Transactions{block.Transactions()[0]}
+
+	imported := make(chan *types.Block)
+	tester.fetcher.importedHook = func(block *types.Block) { imported <- block }
+
+	completing := make(chan []common.Hash)
+	tester.fetcher.completingHook = func(hashes []common.Hash) { completing <- hashes }
+
+	// Deliver the transactions of a different block to the missing requests
+	requested := make(chan []uint64, 1)
+	txsFetcher := func(hash common.Hash, indexes []uint64) error {
+		requested <- indexes
+		go tester.fetcher.DeliverBlockTxs("bad", hash, other.Transactions(), time.Now())
+		return nil
+	}
+	headerFetcher := tester.makeHeaderFetcher("bad", map[common.Hash]*types.Block{block.Hash(): block}, 0)
+	bodyFetcher := tester.makeBodyFetcher("bad", map[common.Hash]*types.Block{block.Hash(): block}, 0)
+
+	tester.fetcher.EnqueueCompact("bad", block.Header(), block.Uncles(), ShortTxIDs(block), time.Now(), txsFetcher, headerFetcher, bodyFetcher)
+	<-requested
+
+	verifyCompletingEvent(t, completing, true)
+	verifyImportEvent(t, imported, true)
+}
+
+// Tests that compact blocks with an invalid header get the propagating peer
+// dropped without the transaction pool ever being scanned.
+func TestCompactBlockInvalidHeader(t *testing.T) {
+	block := makeCompactBlock(4)
+
+	tester := newTester()
+	defer tester.fetcher.Stop()
+
+	tester.pool = block.Transactions()
+	tester.bad[block.Hash()] = true
+
+	requested := make(chan []uint64, 1)
+	txsFetcher := tester.makeTxsFetcher("bad", block, requested)
+	headerFetcher := tester.makeHeaderFetcher("bad", nil, 0)
+	bodyFetcher := tester.makeBodyFetcher("bad", nil, 0)
+
+	tester.fetcher.EnqueueCompact("bad", block.Header(), block.Uncles(), ShortTxIDs(block), time.Now(), txsFetcher, headerFetcher, bodyFetcher)
+
+	for i := 0; ; i++ {
+		tester.lock.RLock()
+		dropped := tester.drops["bad"]
+		tester.lock.RUnlock()
+		if dropped {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("peer with invalid compact block not dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tester.lock.RLock()
+	defer tester.lock.RUnlock()
+
+	if tester.scans != 0 {
+		t.Errorf("transaction pool scanned for invalid block: have %d scans, want 0", tester.scans)
+	}
+	if _, ok := tester.blocks[block.Hash()]; ok {
+		t.Errorf("invalid compact block imported")
+	}
+}
+
+// Tests that a peer can't have more than a limited number of compact blocks
+// pending reconstruction at any one time.
+func TestCompactBlockPeerLimit(t *testing.T) {
+	tester := newTester()
+	defer tester.fetcher.Stop()
+
+	// Withhold all missing transactions to keep the reconstructions pending
+	requested := make(chan common.Hash, 2*compactLimit)
+	txsFetcher := func(hash common.Hash, indexes []uint64) error {
+		requested <- hash
+		return nil
+	}
+	headerFetcher := tester.makeHeaderFetcher("attacker", nil, 0)
+	bodyFetcher := tester.makeBodyFetcher("attacker", nil, 0)
+
+	for i := 1; i <= compactLimit+1; i++ {
+		block := makeCompactBlock(i)
+		tester.fetcher.EnqueueCompact("attacker", block.Header(), block.Uncles(), ShortTxIDs(block), time.Now(), txsFetcher, headerFetcher, bodyFetcher)
+	}
+	for i := 0; i < compactLimit; i++ {
+		select {
+		case <-requested:
+		case <-time.After(time.Second):
+			t.Fatalf("reconstruction %d: missing transactions not requested", i)
+		}
+	}
+	select {
+	case hash := <-requested:
+		t.Fatalf("reconstruction beyond the limit accepted: %x", hash)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
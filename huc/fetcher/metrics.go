@@ -40,4 +40,9 @@ var (
 	headerFilterOutMeter = metrics.NewRegisteredMeter("huc/fetcher/filter/headers/out", nil)
 	bodyFilterInMeter    = metrics.NewRegisteredMeter("huc/fetcher/filter/bodies/in", nil)
 	bodyFilterOutMeter   = metrics.NewRegisteredMeter("huc/fetcher/filter/bodies/out", nil)
+
+	compactInMeter       = metrics.NewRegisteredMeter("huc/fetcher/compact/in", nil)
+	compactHitMeter      = metrics.NewRegisteredMeter("huc/fetcher/compact/hits", nil)
+	compactTxFetchMeter  = metrics.NewRegisteredMeter("huc/fetcher/compact/txs", nil)
+	compactFallbackMeter = metrics.NewRegisteredMeter("huc/fetcher/compact/fallback", nil)
 )
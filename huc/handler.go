@@ -30,6 +30,7 @@ import (
 	"github.com/happyuc-project/happyuc-go/consensus"
 	"github.com/happyuc-project/happyuc-go/consensus/misc"
 	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/forkid"
 	"github.com/happyuc-project/happyuc-go/core/types"
 	"github.com/happyuc-project/happyuc-go/huc/downloader"
 	"github.com/happyuc-project/happyuc-go/huc/fetcher"
@@ -40,11 +41,13 @@ import (
 	"github.com/happyuc-project/happyuc-go/p2p/discover"
 	"github.com/happyuc-project/happyuc-go/params"
 	"github.com/happyuc-project/happyuc-go/rlp"
+	"github.com/hashicorp/golang-lru"
 )
 
 const (
 	softResponseLimit = 2 * 1024 * 1024 // Target maximum size of returned blocks, headers or node data.
 	estHeaderRlpSize  = 500             // Approximate size of an RLP encoded block header
+	compactCacheLimit = 64              // Number of propagated compact blocks to serve missing transactions for
 
 	// txChanSize is the size of channel listening to TxPreEvent.
 	// The number is referenced from the size of tx pool.
@@ -52,9 +55,14 @@ const (
 )
 
 var (
-	daoChallengeTimeout = 15 * time.Second // Time allowance for a node to reply to the DAO handshake challenge
+	daoChallengeTimeout        = 15 * time.Second // Time allowance for a node to reply to the DAO handshake challenge
+	checkpointChallengeTimeout = 15 * time.Second // Time allowance for a node to reply to the checkpoint challenge
 )
 
+// errCheckpointMismatch is returned if a remote peer's chain doesn't contain the
+// trusted checkpoint the local node synchronises from.
+var errCheckpointMismatch = errors.New("trusted checkpoint mismatch")
+
 // errIncompatibleConfig is returned if the requested protocols and configs are
 // not compatible (low protocol version restrictions and high requirements).
 var errIncompatibleConfig = errors.New("incompatible configuration")
@@ -72,11 +80,14 @@ type ProtocolManager struct {
 	txpool      txPool
 	blockchain  *core.BlockChain
 	chainconfig *params.ChainConfig
+	forkFilter  forkid.Filter // Fork ID filter, constant across the lifetime of the node
 	maxPeers    int
 
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
 	peers      *peerSet
+	checkpoint *downloader.Checkpoint // Trusted checkpoint all peers must be on
+	compacts   *lru.Cache             // Blocks propagated in compact form, not necessarily imported yet
 
 	SubProtocols []p2p.Protocol
 
@@ -106,6 +117,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		txpool:      txpool,
 		blockchain:  blockchain,
 		chainconfig: config,
+		forkFilter:  forkid.NewFilter(blockchain),
 		peers:       newPeerSet(),
 		newPeerCh:   make(chan *peer),
 		noMorePeers: make(chan struct{}),
@@ -174,13 +186,37 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 			return 0, nil
 		}
 		atomic.StoreUint32(&manager.acceptTxs, 1) // Mark initial sync done on any fetcher import
-		return manager.blockchain.InsertChain(blocks)
+		n, err := manager.blockchain.InsertChain(blocks)
+		if err != nil && n < len(blocks) {
+			// Penalize the peer that propagated the invalid block
+			if p, ok := blocks[n].ReceivedFrom.(*peer); ok {
+				p.score.invalidBlock()
+			}
+		}
+		return n, err
+	}
+	pooled := func() types.Transactions {
+		pending, _ := manager.txpool.Pending()
+
+		var txs types.Transactions
+		for _, batch := range pending {
+			txs = append(txs, batch...)
+		}
+		return txs
 	}
-	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.removePeer)
+	manager.compacts, _ = lru.New(compactCacheLimit)
+	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, pooled, validator, manager.BroadcastBlock, heighter, inserter, manager.removePeer)
 
 	return manager, nil
 }
 
+// SetCheckpoint configures a trusted checkpoint to synchronise from. Remote peers
+// whose chain doesn't contain it are disconnected.
+func (pm *ProtocolManager) SetCheckpoint(cp *downloader.Checkpoint) {
+	pm.checkpoint = cp
+	pm.downloader.SetCheckpoint(cp)
+}
+
 func (pm *ProtocolManager) removePeer(id string) {
 	// Short circuit if the peer was already removed
 	peer := pm.peers.Peer(id)
@@ -215,6 +251,9 @@ func (pm *ProtocolManager) Start(maxPeers int) {
 	// start sync handlers
 	go pm.syncer()
 	go pm.txsyncLoop()
+
+	// start evicting low quality peers
+	go pm.evictLoop()
 }
 
 func (pm *ProtocolManager) Stop() {
@@ -263,7 +302,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		number  = head.Number.Uint64()
 		td      = pm.blockchain.GetTd(hash, number)
 	)
-	if err := p.Handshake(pm.networkId, td, hash, genesis.Hash()); err != nil {
+	if err := p.Handshake(pm.networkId, td, hash, genesis.Hash(), forkid.NewID(pm.blockchain), pm.forkFilter); err != nil {
 		p.Log().Debug("HappyUC handshake failed", "err", err)
 		return err
 	}
@@ -304,6 +343,22 @@ func (pm *ProtocolManager) handle(p *peer) error {
 			}
 		}()
 	}
+	// If we're syncing from a trusted checkpoint, validate the remote chain contains it
+	if cp := pm.checkpoint; cp != nil {
+		if err := p.RequestHeadersByNumber(cp.Number, 1, 0, false); err != nil {
+			return err
+		}
+		p.cpDrop = time.AfterFunc(checkpointChallengeTimeout, func() {
+			p.Log().Debug("Timed out checkpoint check, dropping")
+			pm.removePeer(p.id)
+		})
+		defer func() {
+			if p.cpDrop != nil {
+				p.cpDrop.Stop()
+				p.cpDrop = nil
+			}
+		}()
+	}
 	// main loop. handle incoming messages.
 	for {
 		if err := pm.handleMsg(p); err != nil {
@@ -417,6 +472,11 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&headers); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		if len(headers) > 0 {
+			p.score.responded(BlockHeadersMsg, true, headers[0].Hash(), numberKey(headers[0].Number.Uint64()))
+		} else {
+			p.score.responded(BlockHeadersMsg, true) // Empty header replies are legitimate at the chain head
+		}
 		// If no headers were received, but we're expending a DAO fork check, maybe it's that
 		if len(headers) == 0 && p.forkDrop != nil {
 			// Possibly an empty reply to the fork header checks, sanity check TDs
@@ -437,9 +497,41 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				return nil
 			}
 		}
+		// If no headers were received to the checkpoint check, the peer may simply be
+		// behind it. Only if we have the checkpoint and the peer's ahead of it is it
+		// known to be on another chain, otherwise leave it to the downloader.
+		if len(headers) == 0 && p.forkDrop == nil && p.cpDrop != nil {
+			p.cpDrop.Stop()
+			p.cpDrop = nil
+
+			cp := pm.checkpoint
+			td := pm.blockchain.GetTd(cp.Hash, cp.Number)
+			if td == nil {
+				p.Log().Debug("Trusted checkpoint not yet local, deferring check to sync")
+				return nil
+			}
+			if _, ptd := p.Head(); ptd.Cmp(td) < 0 {
+				p.Log().Debug("Seems to be behind the trusted checkpoint")
+				return nil
+			}
+			p.Log().Debug("Trusted checkpoint missing, dropping")
+			return errCheckpointMismatch
+		}
 		// Filter out any explicitly requested headers, deliver the rest to the downloader
 		filter := len(headers) == 1
 		if filter {
+			// If it's the checkpoint check, validate the header hash
+			if p.cpDrop != nil && headers[0].Number.Uint64() == pm.checkpoint.Number {
+				p.cpDrop.Stop()
+				p.cpDrop = nil
+
+				if headers[0].Hash() != pm.checkpoint.Hash {
+					p.Log().Debug("Verified to be on another chain than the trusted checkpoint, dropping")
+					return errCheckpointMismatch
+				}
+				p.Log().Debug("Verified to contain the trusted checkpoint")
+				return nil
+			}
 			// If it's a potential DAO fork check, validate against the rules
 			if p.forkDrop != nil && pm.chainconfig.DAOForkBlock.Cmp(headers[0].Number) == 0 {
 				// Disable the fork drop timer
@@ -497,6 +589,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&request); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		p.score.responded(BlockBodiesMsg, len(request) > 0)
+
 		// Deliver them all to the downloader for queuing
 		trasactions := make([][]*types.Transaction, len(request))
 		uncles := make([][]*types.Header, len(request))
@@ -550,6 +644,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&data); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		p.score.responded(NodeDataMsg, len(data) > 0)
+
 		// Deliver all to the downloader
 		if err := pm.downloader.DeliverNodeData(p.id, data); err != nil {
 			log.Debug("Failed to deliver node state data", "err", err)
@@ -597,6 +693,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&receipts); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		p.score.responded(ReceiptsMsg, len(receipts) > 0)
+
 		// Deliver all to the downloader
 		if err := pm.downloader.DeliverReceipts(p.id, receipts); err != nil {
 			log.Debug("Failed to deliver receipts", "err", err)
@@ -610,6 +708,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		// Mark the hashes as present at the remote node
 		for _, block := range announces {
 			p.MarkBlock(block.Hash)
+			p.score.announce(block.Number)
 		}
 		// Schedule all the unknown hashes for retrieval
 		unknown := make(newBlockHashesData, 0, len(announces))
@@ -635,24 +734,71 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		p.MarkBlock(request.Block.Hash())
 		pm.fetcher.Enqueue(p.id, request.Block)
 
-		// Assuming the block is importable by the peer, but possibly not yet done so,
-		// calculate the head hash and TD that the peer truly must have.
+		pm.updatePeerHead(p, request.Block.Header(), request.TD)
+
+	case p.version >= eth100 && msg.Code == NewCompactBlockMsg:
+		// Retrieve and decode the propagated compact block
+		var request compactBlockData
+		if err := msg.Decode(&request); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		// Mark the peer as owning the block and schedule it for reconstruction
+		p.MarkBlock(request.Header.Hash())
+		pm.fetcher.EnqueueCompact(p.id, request.Header, request.Uncles, request.TxIDs, msg.ReceivedAt, p.RequestBlockTxs, p.RequestOneHeader, p.RequestBodies)
+
+		pm.updatePeerHead(p, request.Header, request.TD)
+
+	case p.version >= eth100 && msg.Code == GetBlockTxsMsg:
+		// Decode the retrieval message
+		var query getBlockTxsData
+		if err := msg.Decode(&query); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		// Look up the block among the recently propagated ones or the local chain
+		var block *types.Block
+		if cached, ok := pm.compacts.Get(query.Hash); ok {
+			block = cached.(*types.Block)
+		} else {
+			block = pm.blockchain.GetBlockByHash(query.Hash)
+		}
+		// Gather the requested transactions, bailing out if unavailable
 		var (
-			trueHead = request.Block.ParentHash()
-			trueTD   = new(big.Int).Sub(request.TD, request.Block.Difficulty())
+			bytes int
+			txs   []*types.Transaction
 		)
-		// Update the peers total difficulty if better than the previous
-		if _, td := p.Head(); trueTD.Cmp(td) > 0 {
-			p.SetHead(trueHead, trueTD)
-
-			// Schedule a sync if above ours. Note, this will not fire a sync for a gap of
-			// a singe block (as the true TD is below the propagated block), however this
-			// scenario should easily be covered by the fetcher.
-			currentBlock := pm.blockchain.CurrentBlock()
-			if trueTD.Cmp(pm.blockchain.GetTd(currentBlock.Hash(), currentBlock.NumberU64())) > 0 {
-				go pm.synchronise(p)
+		if block != nil {
+			all := block.Transactions()
+			if len(query.Indexes) > len(all) {
+				return errResp(ErrDecode, "%d transaction indexes for %d transactions", len(query.Indexes), len(all))
+			}
+			requested := make(map[uint64]struct{}, len(query.Indexes))
+			for _, index := range query.Indexes {
+				if index >= uint64(len(all)) {
+					return errResp(ErrDecode, "transaction index %d out of range", index)
+				}
+				if _, ok := requested[index]; ok {
+					return errResp(ErrDecode, "duplicate transaction index %d", index)
+				}
+				requested[index] = struct{}{}
 			}
+			for _, index := range query.Indexes {
+				if bytes >= softResponseLimit {
+					break
+				}
+				txs = append(txs, all[index])
+				bytes += int(all[index].Size())
+			}
+		}
+		return p.SendBlockTxs(query.Hash, txs)
+
+	case p.version >= eth100 && msg.Code == BlockTxsMsg:
+		// The missing transactions of a compact block arrived
+		var response blockTxsData
+		if err := msg.Decode(&response); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		p.score.responded(BlockTxsMsg, len(response.Txs) > 0, response.Hash)
+		pm.fetcher.DeliverBlockTxs(p.id, response.Hash, response.Txs, msg.ReceivedAt)
 
 	case msg.Code == TxMsg:
 		// Transactions arrived, make sure we have a valid and fresh chain to handle them
@@ -695,10 +841,15 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 			log.Error("Propagating dangling block", "number", block.Number(), "hash", hash)
 			return
 		}
-		// Send the block to a subset of our peers
+		// Send the block to a subset of our peers, compacted if they support it
 		transfer := peers[:int(math.Sqrt(float64(len(peers))))]
 		for _, peer := range transfer {
-			peer.SendNewBlock(block, td)
+			if peer.version >= eth100 {
+				pm.compacts.Add(hash, block)
+				peer.SendCompactBlock(block, td)
+			} else {
+				peer.SendNewBlock(block, td)
+			}
 		}
 		log.Trace("Propagated block", "hash", hash, "recipients", len(transfer), "duration", common.PrettyDuration(time.Since(block.ReceivedAt)))
 		return
@@ -712,6 +863,31 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 	}
 }
 
+// updatePeerHead updates the head and total difficulty of a peer based on a block
+// it propagated, scheduling a sync if it's ahead of us.
+func (pm *ProtocolManager) updatePeerHead(p *peer, header *types.Header, td *big.Int) {
+	// Assuming the block is importable by the peer, but possibly not yet done so,
+	// calculate the head hash and TD that the peer truly must have.
+	var (
+		trueHead = header.ParentHash
+		trueTD   = new(big.Int).Sub(td, header.Difficulty)
+	)
+	p.score.announce(header.Number.Uint64() - 1)
+
+	// Update the peers total difficulty if better than the previous
+	if _, td := p.Head(); trueTD.Cmp(td) > 0 {
+		p.SetHead(trueHead, trueTD)
+
+		// Schedule a sync if above ours. Note, this will not fire a sync for a gap of
+		// a singe block (as the true TD is below the propagated block), however this
+		// scenario should easily be covered by the fetcher.
+		currentBlock := pm.blockchain.CurrentBlock()
+		if trueTD.Cmp(pm.blockchain.GetTd(currentBlock.Hash(), currentBlock.NumberU64())) > 0 {
+			go pm.synchronise(p)
+		}
+	}
+}
+
 // BroadcastTx will propagate a transaction to all peers which are not known to
 // already have the given transaction.
 func (pm *ProtocolManager) BroadcastTx(hash common.Hash, tx *types.Transaction) {
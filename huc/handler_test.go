@@ -20,6 +20,7 @@ import (
 	"math"
 	"math/big"
 	"math/rand"
+	"reflect"
 	"testing"
 	"time"
 
@@ -31,10 +32,12 @@ import (
 	"github.com/happyuc-project/happyuc-go/core/vm"
 	"github.com/happyuc-project/happyuc-go/crypto"
 	"github.com/happyuc-project/happyuc-go/huc/downloader"
+	"github.com/happyuc-project/happyuc-go/huc/fetcher"
 	"github.com/happyuc-project/happyuc-go/hucdb"
 	"github.com/happyuc-project/happyuc-go/event"
 	"github.com/happyuc-project/happyuc-go/p2p"
 	"github.com/happyuc-project/happyuc-go/params"
+	"github.com/happyuc-project/happyuc-go/rlp"
 )
 
 // Tests that protocol versions and modes of operations are matched up properly.
@@ -520,3 +523,225 @@ func testDAOChallenge(t *testing.T, localForked, remoteForked bool, timeout bool
 		}
 	}
 }
+
+// Tests that peers are scored based on the quality of their responses, and that
+// the worst one is evicted once the peer set is full.
+func TestPeerScoreEviction(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 16, nil, nil)
+	defer pm.Stop()
+
+	good, _ := newTestPeer("good", eth63, pm, true)
+	defer good.close()
+	bad, _ := newTestPeer("bad", eth63, pm, true)
+	defer bad.close()
+
+	time.Sleep(100 * time.Millisecond) // Wait for the peers to register
+
+	// Have the bad peer send an unsolicited and an empty response
+	bad.peer.score.requested(BlockBodiesMsg, common.Hash{})
+	if err := p2p.Send(bad.app, BlockBodiesMsg, []*blockBody{}); err != nil {
+		t.Fatalf("failed to send empty bodies: %v", err)
+	}
+	if err := p2p.Send(bad.app, ReceiptsMsg, [][]*types.Receipt{}); err != nil {
+		t.Fatalf("failed to send unsolicited receipts: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond) // Wait for the responses to be processed
+
+	if info := bad.peer.score.info(); info.Useless != 2 {
+		t.Fatalf("useless response count mismatch: have %v, want 2", info.Useless)
+	}
+	bad.peer.score.invalidBlock()
+
+	// Nobody should be evicted within the grace period or if the set isn't full
+	pm.maxPeers = 2
+	if p := pm.updateScores(); p != nil {
+		t.Fatalf("peer %s evicted within grace period", p.id)
+	}
+	good.peer.score.connected = time.Now().Add(-2 * scoreGracePeriod)
+	bad.peer.score.connected = time.Now().Add(-2 * scoreGracePeriod)
+
+	pm.maxPeers = 3
+	if p := pm.updateScores(); p != nil {
+		t.Fatalf("peer %s evicted with free slots", p.id)
+	}
+	// Reset the decayed penalties and check that only the bad peer is evicted
+	bad.peer.score.useless, bad.peer.score.invalid = 2, 2
+
+	pm.maxPeers = 2
+	if p := pm.updateScores(); p != bad.peer {
+		t.Fatalf("evicted peer mismatch: have %v, want %s", p, bad.peer.id)
+	}
+	if score := good.peer.score.score(); score != maxScore {
+		t.Fatalf("good peer score mismatch: have %d, want %d", score, maxScore)
+	}
+}
+
+// Tests that response latencies are attributed to the requests they answer, that
+// unanswered requests are penalized as timeouts and that the head lag accounts
+// for hash announcements too.
+func TestPeerScoreRequests(t *testing.T) {
+	s := newPeerScore()
+
+	// A lost header response must not inflate the latency of the next one
+	s.requested(BlockHeadersMsg, numberKey(1))
+	s.requested(BlockHeadersMsg, numberKey(2))
+	s.pending[BlockHeadersMsg][0].sent = time.Now().Add(-10 * time.Second)
+
+	s.responded(BlockHeadersMsg, true, common.Hash{0x01}, numberKey(2))
+	if s.latency > time.Second {
+		t.Fatalf("latency misattributed: have %v", s.latency)
+	}
+	// Unidentifiable responses with multiple requests outstanding aren't sampled
+	latency := s.latency
+
+	s.requested(BlockBodiesMsg, common.Hash{})
+	s.requested(BlockBodiesMsg, common.Hash{})
+	s.pending[BlockBodiesMsg][0].sent = time.Now().Add(-10 * time.Second)
+	s.pending[BlockBodiesMsg][1].sent = time.Now().Add(-10 * time.Second)
+
+	s.responded(BlockBodiesMsg, true)
+	if s.latency != latency {
+		t.Fatalf("ambiguous response sampled: have %v, want %v", s.latency, latency)
+	}
+	// Requests left unanswered for too long count as timeouts
+	s.pending[BlockHeadersMsg][0].sent = time.Now().Add(-2 * requestTimeout)
+	s.pending[BlockBodiesMsg][0].sent = time.Now().Add(-2 * requestTimeout)
+
+	s.updateLag(10, 0, false)
+	if s.timeouts != 2 {
+		t.Fatalf("timeout count mismatch: have %v, want 2", s.timeouts)
+	}
+	if s.lag != 0 {
+		t.Fatalf("lag of unknown head mismatch: have %d, want 0", s.lag)
+	}
+	// Hash announcements and known heads both count towards the peer's head
+	s.announce(7)
+	if s.updateLag(10, 0, false); s.lag != 3 {
+		t.Fatalf("lag of announced head mismatch: have %d, want 3", s.lag)
+	}
+	if s.updateLag(10, 9, true); s.lag != 1 {
+		t.Fatalf("lag of known head mismatch: have %d, want 1", s.lag)
+	}
+	if score := s.score(); score != maxScore-1-2*timeoutPenalty {
+		t.Fatalf("score mismatch: have %d, want %d", score, maxScore-1-2*timeoutPenalty)
+	}
+}
+
+// Tests that blocks propagated in compact form are reconstructed from the local
+// transaction pool, retrieving only the missing transactions and thus using a
+// fraction of the bandwidth of a full block propagation.
+func TestCompactBlockPropagation(t *testing.T) {
+	// Create a source chain with a transaction heavy block
+	generator := func(i int, block *core.BlockGen) {
+		for j := 0; j < 20; j++ {
+			block.AddTx(newTestTransaction(testBankKey, block.TxNonce(testBank), 200))
+		}
+	}
+	source, _ := newTestProtocolManagerMust(t, downloader.FullSync, 1, generator, nil)
+	defer source.Stop()
+
+	block := source.blockchain.GetBlockByNumber(1)
+	td := source.blockchain.GetTd(block.Hash(), block.NumberU64())
+	txs := block.Transactions()
+
+	// Create a target node already knowing most of the transactions
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+	pm.txpool.AddRemotes(txs[:15])
+
+	peer, _ := newTestPeer("peer", eth100, pm, true)
+	defer peer.close()
+
+	// Propagate the block in compact form and serve the missing transactions
+	compact := &compactBlockData{Header: block.Header(), Uncles: block.Uncles(), TxIDs: fetcher.ShortTxIDs(block), TD: td}
+	if err := p2p.Send(peer.app, NewCompactBlockMsg, compact); err != nil {
+		t.Fatalf("failed to send compact block: %v", err)
+	}
+	var query getBlockTxsData
+	for {
+		msg, err := peer.app.ReadMsg()
+		if err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+		if msg.Code != GetBlockTxsMsg {
+			msg.Discard()
+			continue
+		}
+		if err := msg.Decode(&query); err != nil {
+			t.Fatalf("failed to decode transaction request: %v", err)
+		}
+		break
+	}
+	if query.Hash != block.Hash() || !reflect.DeepEqual(query.Indexes, []uint64{15, 16, 17, 18, 19}) {
+		t.Fatalf("transaction request mismatch: have %x %v, want %x [15..19]", query.Hash, query.Indexes, block.Hash())
+	}
+	reply := &blockTxsData{Hash: block.Hash(), Txs: txs[15:]}
+	if err := p2p.Send(peer.app, BlockTxsMsg, reply); err != nil {
+		t.Fatalf("failed to send missing transactions: %v", err)
+	}
+	// Wait for the block to be imported
+	for i := 0; pm.blockchain.CurrentBlock().NumberU64() != 1; i++ {
+		if i == 100 {
+			t.Fatalf("compact block not imported")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// Compare the bandwidth used against a full block propagation
+	compactSize, _ := rlp.EncodeToBytes(compact)
+	querySize, _ := rlp.EncodeToBytes(&query)
+	replySize, _ := rlp.EncodeToBytes(reply)
+	fullSize, _ := rlp.EncodeToBytes([]interface{}{block, td})
+
+	used := len(compactSize) + len(querySize) + len(replySize)
+	t.Logf("compact propagation used %d bytes, full block %d bytes", used, len(fullSize))
+	if used*2 > len(fullSize) {
+		t.Fatalf("compact propagation used too much bandwidth: have %d bytes, full block %d bytes", used, len(fullSize))
+	}
+}
+
+// Tests that the transactions of a block are served by index, and that requests
+// for more transactions than the block has, or for the same one repeatedly, are
+// rejected instead of amplifying the response.
+func TestGetBlockTxs(t *testing.T) {
+	generator := func(i int, block *core.BlockGen) {
+		for j := 0; j < 5; j++ {
+			block.AddTx(newTestTransaction(testBankKey, block.TxNonce(testBank), 100))
+		}
+	}
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 1, generator, nil)
+	defer pm.Stop()
+
+	block := pm.blockchain.GetBlockByNumber(1)
+	txs := block.Transactions()
+
+	// Valid requests are answered with the transactions in the requested order
+	peer, _ := newTestPeer("peer", eth100, pm, true)
+	defer peer.close()
+
+	if err := p2p.Send(peer.app, GetBlockTxsMsg, &getBlockTxsData{Hash: block.Hash(), Indexes: []uint64{3, 1}}); err != nil {
+		t.Fatalf("failed to send transaction request: %v", err)
+	}
+	if err := p2p.ExpectMsg(peer.app, BlockTxsMsg, &blockTxsData{Hash: block.Hash(), Txs: []*types.Transaction{txs[3], txs[1]}}); err != nil {
+		t.Fatalf("transaction response mismatch: %v", err)
+	}
+	// Invalid requests get the peer dropped
+	for i, indexes := range [][]uint64{
+		{1, 2, 1},
+		{0, 1, 2, 3, 4, 0},
+		{5},
+	} {
+		peer, errc := newTestPeer("bad", eth100, pm, true)
+		if err := p2p.Send(peer.app, GetBlockTxsMsg, &getBlockTxsData{Hash: block.Hash(), Indexes: indexes}); err != nil {
+			t.Fatalf("test %d: failed to send transaction request: %v", i, err)
+		}
+		select {
+		case err := <-errc:
+			if err == nil {
+				t.Errorf("test %d: peer not dropped", i)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("test %d: peer not dropped in time", i)
+		}
+		peer.close()
+	}
+}
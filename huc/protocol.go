@@ -23,8 +23,10 @@ import (
 
 	"github.com/happyuc-project/happyuc-go/common"
 	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/forkid"
 	"github.com/happyuc-project/happyuc-go/core/types"
 	"github.com/happyuc-project/happyuc-go/event"
+	"github.com/happyuc-project/happyuc-go/huc/fetcher"
 	"github.com/happyuc-project/happyuc-go/rlp"
 )
 
@@ -32,16 +34,22 @@ import (
 const (
 	eth62 = 62
 	eth63 = 63
+	eth64 = 64
+
+	// eth100 adds compact block propagation on top of eth/64. It is numbered
+	// well clear of the upstream eth versions to avoid negotiating their
+	// message sets with our compact block messages.
+	eth100 = 100
 )
 
 // Official short name of the protocol used during capability negotiation.
 var ProtocolName = "eth"
 
 // Supported versions of the eth protocol (first is primary).
-var ProtocolVersions = []uint{eth63, eth62}
+var ProtocolVersions = []uint{eth100, eth64, eth63, eth62}
 
 // Number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = []uint64{17, 8}
+var ProtocolLengths = []uint64{20, 17, 17, 8}
 
 const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
@@ -62,6 +70,11 @@ const (
 	NodeDataMsg    = 0x0e
 	GetReceiptsMsg = 0x0f
 	ReceiptsMsg    = 0x10
+
+	// Protocol messages belonging to eth/100
+	NewCompactBlockMsg = 0x11
+	GetBlockTxsMsg     = 0x12
+	BlockTxsMsg        = 0x13
 )
 
 type errCode int
@@ -76,6 +89,7 @@ const (
 	ErrNoStatusMsg
 	ErrExtraStatusMsg
 	ErrSuspendedPeer
+	ErrForkIDRejected
 )
 
 func (e errCode) String() string {
@@ -93,6 +107,7 @@ var errorToString = map[int]string{
 	ErrNoStatusMsg:             "No status message",
 	ErrExtraStatusMsg:          "Extra status message",
 	ErrSuspendedPeer:           "Suspended peer",
+	ErrForkIDRejected:          "Fork ID rejected",
 }
 
 type txPool interface {
@@ -117,6 +132,17 @@ type statusData struct {
 	GenesisBlock    common.Hash
 }
 
+// statusData64 is the network packet for the status message on eth/64 and
+// above, extending the legacy status with the sender's fork identifier.
+type statusData64 struct {
+	ProtocolVersion uint32
+	NetworkId       uint64
+	TD              *big.Int
+	CurrentBlock    common.Hash
+	GenesisBlock    common.Hash
+	ForkID          forkid.ID
+}
+
 // newBlockHashesData is the network packet for the block announcements.
 type newBlockHashesData []struct {
 	Hash   common.Hash // Hash of one particular block being announced
@@ -173,6 +199,29 @@ type newBlockData struct {
 	TD    *big.Int
 }
 
+// compactBlockData is the network packet for the compact block propagation
+// message, carrying abbreviated transaction identifiers instead of the bodies.
+type compactBlockData struct {
+	Header *types.Header
+	Uncles []*types.Header
+	TxIDs  []fetcher.TxID
+	TD     *big.Int
+}
+
+// getBlockTxsData is the network packet requesting the transactions of a compact
+// block missing from the local pool.
+type getBlockTxsData struct {
+	Hash    common.Hash // Hash of the compact block
+	Indexes []uint64    // Indexes of the transactions to retrieve
+}
+
+// blockTxsData is the network packet for the missing transactions of a compact
+// block.
+type blockTxsData struct {
+	Hash common.Hash          // Hash of the compact block
+	Txs  []*types.Transaction // Requested transactions, in the order of the indexes
+}
+
 // blockBody represents the data content of a single block.
 type blockBody struct {
 	Transactions []*types.Transaction // Transactions contained within a block
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core/forkid"
 	"github.com/happyuc-project/happyuc-go/core/types"
 	"github.com/happyuc-project/happyuc-go/crypto"
 	"github.com/happyuc-project/happyuc-go/huc/downloader"
@@ -92,6 +93,57 @@ func testStatusMsgErrors(t *testing.T, protocol int) {
 	}
 }
 
+// Tests that eth/64 peers announcing an incompatible fork ID are rejected during
+// the handshake, while compatible ones are accepted.
+func TestStatusMsgForkID64(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	var (
+		genesis = pm.blockchain.Genesis()
+		head    = pm.blockchain.CurrentHeader()
+		td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
+		local   = forkid.NewID(pm.blockchain)
+	)
+	defer pm.Stop()
+
+	tests := []struct {
+		id        forkid.ID
+		wantError error
+	}{
+		{
+			id:        local,
+			wantError: nil,
+		},
+		{
+			id:        forkid.ID{Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}},
+			wantError: errResp(ErrForkIDRejected, "%v", forkid.ErrLocalIncompatibleOrStale),
+		},
+	}
+	for i, test := range tests {
+		p, errc := newTestPeer("peer", eth64, pm, false)
+		go func() {
+			// Consume the local status so the handshake can complete
+			if msg, err := p.app.ReadMsg(); err == nil {
+				msg.Discard()
+			}
+		}()
+		go p2p.Send(p.app, StatusMsg, statusData64{eth64, DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash(), test.id})
+
+		select {
+		case err := <-errc:
+			if test.wantError == nil {
+				t.Errorf("test %d: protocol failed: %v", i, err)
+			} else if err == nil || err.Error() != test.wantError.Error() {
+				t.Errorf("test %d: wrong error: got %v, want %q", i, err, test.wantError)
+			}
+		case <-time.After(500 * time.Millisecond):
+			if test.wantError != nil {
+				t.Errorf("test %d: protocol did not shut down", i)
+			}
+		}
+		p.close()
+	}
+}
+
 // This test checks that received transactions are added to the local pool.
 func TestRecvTransactions62(t *testing.T) { testRecvTransactions(t, 62) }
 func TestRecvTransactions63(t *testing.T) { testRecvTransactions(t, 63) }
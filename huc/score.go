@@ -0,0 +1,317 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package huc
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/metrics"
+)
+
+const (
+	scoreEvictionCycle     = time.Minute     // Time interval to evict the worst peer if the peer set is full
+	scoreGracePeriod       = 2 * time.Minute // Time a new peer is given to prove itself before being evictable
+	scoreEvictionThreshold = 50              // Score below which a peer is considered for eviction
+
+	maxScore          = 100                    // Score of a perfectly behaving peer
+	latencyUnit       = 100 * time.Millisecond // Response latency costing a single score point
+	maxLatencyPenalty = 30                     // Maximum score points lost due to slow responses
+	uselessPenalty    = 5                      // Score points lost for each useless response
+	timeoutPenalty    = 10                     // Score points lost for each request left unanswered
+	invalidPenalty    = 25                     // Score points lost for each invalid block propagated
+	maxLagPenalty     = 30                     // Maximum score points lost due to head lag (1 per block)
+	scoreDecay        = 0.5                    // Ratio of the useless/timeout/invalid penalties kept each eviction cycle
+	latencyImpact     = 0.1                    // Impact a single response has on the peer's latency estimate
+	maxPendingTimes   = 16                     // Maximum number of outstanding requests timed per message code
+	requestTimeout    = 30 * time.Second       // Time after which an unanswered request counts as timed out
+)
+
+var evictedPeerMeter = metrics.NewRegisteredMeter("huc/peers/evicted", nil)
+
+// peerScore tracks the quality of service a remote peer provides, based on how
+// fast it responds to our requests, how many of its responses are useless or
+// missing, how many invalid blocks it propagates and how far its head lags
+// behind ours.
+type peerScore struct {
+	connected time.Time                    // Time instance when the peer connected
+	pending   map[uint64][]*pendingRequest // Requests awaiting a response, grouped by response message code
+	latency   time.Duration                // Moving average of the response latency
+	useless   float64                      // Decaying number of useless (empty or unsolicited) responses
+	timeouts  float64                      // Decaying number of requests left unanswered
+	invalid   float64                      // Decaying number of invalid blocks propagated
+	announced uint64                       // Highest block number the peer announced to have
+	lag       uint64                       // Number of blocks the peer's head is behind ours
+	lock      sync.Mutex
+}
+
+// pendingRequest is a request sent to the peer, awaiting its response.
+type pendingRequest struct {
+	key  common.Hash // Identifier the response can be matched by (zero = none)
+	sent time.Time   // Time instance when the request was sent
+}
+
+// numberKey converts a block number into a request identifier, for requests only
+// identifiable by the number of the block they start at.
+func numberKey(number uint64) common.Hash {
+	var key common.Hash
+	binary.BigEndian.PutUint64(key[common.HashLength-8:], number)
+	return key
+}
+
+// PeerScore is the breakdown of a peer's quality score reported via the admin API.
+type PeerScore struct {
+	Score   int     `json:"score"`   // Overall quality score, higher is better
+	Latency string  `json:"latency"` // Moving average of the response latency
+	Useless float64 `json:"useless"` // Decaying number of useless responses
+	Timeout float64 `json:"timeout"` // Decaying number of requests left unanswered
+	Invalid float64 `json:"invalid"` // Decaying number of invalid blocks propagated
+	Lag     uint64  `json:"lag"`     // Number of blocks the peer's head is behind ours
+}
+
+// newPeerScore creates a score tracker for a freshly connected peer.
+func newPeerScore() *peerScore {
+	return &peerScore{
+		connected: time.Now(),
+		pending:   make(map[uint64][]*pendingRequest),
+	}
+}
+
+// requested marks that a request was sent to the peer, expecting a response with
+// the given message code. If the response can be attributed to the request, the
+// key identifies it, otherwise it's left zero.
+func (s *peerScore) requested(code uint64, key common.Hash) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pending := append(s.pending[code], &pendingRequest{key: key, sent: time.Now()})
+	if len(pending) > maxPendingTimes {
+		pending = pending[1:]
+	}
+	s.pending[code] = pending
+}
+
+// responded marks that a response with the given message code arrived from the
+// peer. Unsolicited responses and those not containing anything useful (e.g. no
+// data at all) are penalized, others update the latency estimate.
+//
+// A response is matched to its request by any of the given keys. If it can't be
+// identified, it's attributed to the oldest pending request, but only sampled if
+// that is the only one: a lost response to a previous request would otherwise
+// inflate the latency estimate.
+func (s *peerScore) responded(code uint64, useful bool, keys ...common.Hash) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.expire()
+
+	pending := s.pending[code]
+	if len(pending) == 0 {
+		s.useless++
+		return
+	}
+	index, sample := 0, len(pending) == 1
+match:
+	for i, req := range pending {
+		if req.key == (common.Hash{}) {
+			continue
+		}
+		for _, key := range keys {
+			if req.key == key {
+				index, sample = i, true
+				break match
+			}
+		}
+	}
+	req := pending[index]
+	s.pending[code] = append(pending[:index:index], pending[index+1:]...)
+
+	if !useful {
+		s.useless++
+		return
+	}
+	if !sample {
+		return
+	}
+	elapsed := time.Since(req.sent)
+	if s.latency == 0 {
+		s.latency = elapsed
+	} else {
+		s.latency = time.Duration((1-latencyImpact)*float64(s.latency) + latencyImpact*float64(elapsed))
+	}
+}
+
+// expire drops all pending requests that went unanswered for too long, counting
+// them as timeouts. The caller must hold the lock.
+func (s *peerScore) expire() {
+	for code, pending := range s.pending {
+		for len(pending) > 0 && time.Since(pending[0].sent) > requestTimeout {
+			pending = pending[1:]
+			s.timeouts++
+		}
+		s.pending[code] = pending
+	}
+}
+
+// announce marks that the peer announced to have the block with the given number.
+func (s *peerScore) announce(number uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if number > s.announced {
+		s.announced = number
+	}
+}
+
+// invalidBlock marks that the peer propagated a block failing import.
+func (s *peerScore) invalidBlock() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.invalid++
+}
+
+// updateLag updates the number of blocks the peer's head is behind ours, based on
+// the highest block known to be available at the peer: either the one it last
+// announced or its head block, if that is on our chain. If neither is known, the
+// peer isn't considered lagging. Requests left unanswered are also expired.
+func (s *peerScore) updateLag(head uint64, number uint64, known bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.expire()
+
+	if s.announced > 0 && (!known || s.announced > number) {
+		number, known = s.announced, true
+	}
+	if known && number < head {
+		s.lag = head - number
+	} else {
+		s.lag = 0
+	}
+}
+
+// decay reduces the weight of past useless responses, timeouts and invalid blocks,
+// so that a peer may recover from a transient misbehaviour.
+func (s *peerScore) decay() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.useless *= scoreDecay
+	s.timeouts *= scoreDecay
+	s.invalid *= scoreDecay
+}
+
+// evictable returns whether the peer was connected long enough to be evicted.
+func (s *peerScore) evictable() bool {
+	return time.Since(s.connected) > scoreGracePeriod
+}
+
+// score calculates the overall quality score of the peer.
+func (s *peerScore) score() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.calculate()
+}
+
+// calculate computes the overall quality score of the peer. The caller must hold
+// the lock.
+func (s *peerScore) calculate() int {
+	score := float64(maxScore)
+
+	latency := float64(s.latency / latencyUnit)
+	if latency > maxLatencyPenalty {
+		latency = maxLatencyPenalty
+	}
+	lag := float64(s.lag)
+	if lag > maxLagPenalty {
+		lag = maxLagPenalty
+	}
+	score -= latency + lag + s.useless*uselessPenalty + s.timeouts*timeoutPenalty + s.invalid*invalidPenalty
+	return int(score)
+}
+
+// info returns the breakdown of the peer's quality score.
+func (s *peerScore) info() *PeerScore {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return &PeerScore{
+		Score:   s.calculate(),
+		Latency: s.latency.String(),
+		Useless: s.useless,
+		Timeout: s.timeouts,
+		Invalid: s.invalid,
+		Lag:     s.lag,
+	}
+}
+
+// evictLoop periodically updates the head lag and timeouts of all peers, and if
+// the peer set is full, disconnects the worst scoring one to make room for a
+// better peer.
+func (pm *ProtocolManager) evictLoop() {
+	ticker := time.NewTicker(scoreEvictionCycle)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if p := pm.updateScores(); p != nil {
+				p.Log().Debug("Evicting low quality peer", "score", p.score.score())
+				evictedPeerMeter.Mark(1)
+				pm.removePeer(p.id)
+			}
+		case <-pm.quitSync:
+			return
+		}
+	}
+}
+
+// updateScores refreshes the head lag and timeouts of all peers and decays their
+// penalties, returning the worst scoring peer to evict if the peer set is full.
+func (pm *ProtocolManager) updateScores() *peer {
+	head := pm.blockchain.CurrentHeader().Number.Uint64()
+
+	var (
+		worst *peer
+		low   = scoreEvictionThreshold
+	)
+	peers := pm.peers.AllPeers()
+	for _, p := range peers {
+		// Update the lag of the peer, using its head if that is on our chain
+		hash, _ := p.Head()
+		if header := pm.blockchain.GetHeaderByHash(hash); header != nil {
+			p.score.updateLag(head, header.Number.Uint64(), true)
+		} else {
+			p.score.updateLag(head, 0, false)
+		}
+		// Find the worst peer that may be evicted
+		if p.score.evictable() && !p.Peer.Info().Network.Trusted {
+			if score := p.score.score(); score < low {
+				worst, low = p, score
+			}
+		}
+		p.score.decay()
+	}
+	if len(peers) < pm.maxPeers {
+		return nil
+	}
+	return worst
+}
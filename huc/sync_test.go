@@ -21,6 +21,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/happyuc-project/happyuc-go/common"
 	"github.com/happyuc-project/happyuc-go/huc/downloader"
 	"github.com/happyuc-project/happyuc-go/p2p"
 	"github.com/happyuc-project/happyuc-go/p2p/discover"
@@ -53,3 +54,89 @@ func TestFastSyncDisabling(t *testing.T) {
 		t.Fatalf("fast sync not disabled after successful synchronisation")
 	}
 }
+
+// Tests that a node synchronising from a trusted checkpoint anchors its chain on
+// it, backfilling the older headers without their block bodies.
+func TestCheckpointSync(t *testing.T) {
+	pmFull, _ := newTestProtocolManagerMust(t, downloader.FastSync, 1024, nil, nil)
+	checkpoint := pmFull.blockchain.GetHeaderByNumber(500)
+
+	pmEmpty, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	pmEmpty.SetCheckpoint(&downloader.Checkpoint{Number: checkpoint.Number.Uint64(), Hash: checkpoint.Hash()})
+
+	// Sync up the two peers
+	io1, io2 := p2p.MsgPipe()
+
+	go pmFull.handle(pmFull.newPeer(63, p2p.NewPeer(discover.NodeID{}, "empty", nil), io2))
+	go pmEmpty.handle(pmEmpty.newPeer(63, p2p.NewPeer(discover.NodeID{}, "full", nil), io1))
+
+	time.Sleep(250 * time.Millisecond)
+	pmEmpty.synchronise(pmEmpty.peers.BestPeer())
+
+	if head := pmEmpty.blockchain.CurrentBlock().NumberU64(); head != 1024 {
+		t.Fatalf("head block mismatch: have %d, want %d", head, 1024)
+	}
+	for _, number := range []uint64{1, 250, 499, 500, 501, 1024} {
+		want := pmFull.blockchain.GetHeaderByNumber(number)
+		have := pmEmpty.blockchain.GetHeaderByNumber(number)
+		if have == nil || have.Hash() != want.Hash() {
+			t.Fatalf("header %d mismatch: have %v, want %x", number, have, want.Hash())
+		}
+		if td, wtd := pmEmpty.blockchain.GetTd(have.Hash(), number), pmFull.blockchain.GetTd(want.Hash(), number); td == nil || td.Cmp(wtd) != 0 {
+			t.Fatalf("header %d td mismatch: have %v, want %v", number, td, wtd)
+		}
+	}
+	if block := pmEmpty.blockchain.GetBlockByNumber(250); block != nil {
+		t.Fatalf("block below checkpoint downloaded")
+	}
+	if block := pmEmpty.blockchain.GetBlockByNumber(750); block == nil {
+		t.Fatalf("block above checkpoint missing")
+	}
+}
+
+// Tests that peers whose chain doesn't contain the trusted checkpoint are dropped.
+func TestCheckpointMismatch(t *testing.T) {
+	pmFull, _ := newTestProtocolManagerMust(t, downloader.FastSync, 1024, nil, nil)
+
+	pmEmpty, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	pmEmpty.SetCheckpoint(&downloader.Checkpoint{Number: 500, Hash: common.HexToHash("0xdeadbeef")})
+
+	io1, io2 := p2p.MsgPipe()
+
+	go pmFull.handle(pmFull.newPeer(63, p2p.NewPeer(discover.NodeID{}, "empty", nil), io2))
+	errc := make(chan error, 1)
+	go func() { errc <- pmEmpty.handle(pmEmpty.newPeer(63, p2p.NewPeer(discover.NodeID{}, "full", nil), io1)) }()
+
+	select {
+	case err := <-errc:
+		if err != errCheckpointMismatch {
+			t.Fatalf("disconnect error mismatch: have %v, want %v", err, errCheckpointMismatch)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("peer not dropped")
+	}
+}
+
+// Tests that peers behind a trusted checkpoint which isn't yet available locally
+// are kept, as they can't be shown to be on another chain.
+func TestCheckpointBehindPeer(t *testing.T) {
+	pmFull, _ := newTestProtocolManagerMust(t, downloader.FastSync, 100, nil, nil)
+
+	pmEmpty, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	pmEmpty.SetCheckpoint(&downloader.Checkpoint{Number: 500, Hash: common.HexToHash("0xdeadbeef")})
+
+	io1, io2 := p2p.MsgPipe()
+
+	go pmFull.handle(pmFull.newPeer(63, p2p.NewPeer(discover.NodeID{}, "empty", nil), io2))
+	errc := make(chan error, 1)
+	go func() { errc <- pmEmpty.handle(pmEmpty.newPeer(63, p2p.NewPeer(discover.NodeID{}, "full", nil), io1)) }()
+
+	select {
+	case err := <-errc:
+		t.Fatalf("peer dropped: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+	if pmEmpty.peers.Len() != 1 {
+		t.Fatalf("peer count mismatch: have %d, want 1", pmEmpty.peers.Len())
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+)
+
+// PrivateLightServerAPI is the collection of LES server related APIs exposed
+// over the private admin endpoint.
+type PrivateLightServerAPI struct {
+	server *LesServer
+}
+
+// NewPrivateLightServerAPI creates a new API definition for the private admin
+// methods of the LES server.
+func NewPrivateLightServerAPI(server *LesServer) *PrivateLightServerAPI {
+	return &PrivateLightServerAPI{server: server}
+}
+
+// TotalCapacity returns the total serving capacity shared by the clients.
+func (api *PrivateLightServerAPI) TotalCapacity() uint64 {
+	return api.server.clientPool.totalCap
+}
+
+// SetClientCapacity assigns a priority serving capacity (minimum recharge rate)
+// to the given node. A capacity of zero demotes the node to a free client.
+func (api *PrivateLightServerAPI) SetClientCapacity(id discover.NodeID, capacity uint64) error {
+	return api.server.clientPool.setCapacity(id, capacity)
+}
+
+// RemoveClientCapacity demotes the given node to a free client.
+func (api *PrivateLightServerAPI) RemoveClientCapacity(id discover.NodeID) error {
+	return api.server.clientPool.setCapacity(id, 0)
+}
+
+// ClientCapacities returns the priority capacities assigned to nodes.
+func (api *PrivateLightServerAPI) ClientCapacities() map[discover.NodeID]uint64 {
+	return api.server.clientPool.capacities()
+}
+
+// Clients returns the usage statistics of all connected light clients.
+func (api *PrivateLightServerAPI) Clients() map[discover.NodeID]ClientInfo {
+	return api.server.clientPool.info()
+}
@@ -87,6 +87,10 @@ func (b *LesApiBackend) GetReceipts(ctx context.Context, blockHash common.Hash)
 	return light.GetBlockReceipts(ctx, b.huc.odr, blockHash, core.GetBlockNumber(b.huc.chainDb, blockHash))
 }
 
+func (b *LesApiBackend) GetTransaction(ctx context.Context, txHash common.Hash) (*types.Transaction, common.Hash, uint64, uint64, error) {
+	return light.GetTransaction(ctx, b.huc.odr, txHash)
+}
+
 func (b *LesApiBackend) GetLogs(ctx context.Context, blockHash common.Hash) ([][]*types.Log, error) {
 	return light.GetBlockLogs(ctx, b.huc.odr, blockHash, core.GetBlockNumber(b.huc.chainDb, blockHash))
 }
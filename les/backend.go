@@ -44,6 +44,7 @@ import (
 	"github.com/happyuc-project/happyuc-go/params"
 	"github.com/happyuc-project/happyuc-go/rpc"
 	_ "github.com/happyuc-project/happyuc-go/swarm/api"
+	"github.com/hashicorp/golang-lru"
 )
 
 type LightHappyUC struct {
@@ -67,6 +68,7 @@ type LightHappyUC struct {
 
 	bloomRequests                              chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
 	bloomIndexer, chtIndexer, bloomTrieIndexer *core.ChainIndexer
+	bloomCache                                 *lru.Cache // Cache of verified and decompressed bloom bit vectors
 
 	ApiBackend *LesApiBackend
 
@@ -110,6 +112,7 @@ func New(ctx *node.ServiceContext, config *huc.Config) (*LightHappyUC, error) {
 		chtIndexer:       light.NewChtIndexer(chainDb, true),
 		bloomTrieIndexer: light.NewBloomTrieIndexer(chainDb, true),
 	}
+	leth.bloomCache, _ = lru.New(bloomCacheLimit)
 
 	leth.relay = NewLesTxRelay(peers, leth.reqDist)
 	leth.serverPool = newServerPool(chainDb, quitSync, &leth.wg)
@@ -118,7 +121,11 @@ func New(ctx *node.ServiceContext, config *huc.Config) (*LightHappyUC, error) {
 	if leth.blockchain, err = light.NewLightChain(leth.odr, leth.chainConfig, leth.engine); err != nil {
 		return nil, err
 	}
-	leth.bloomIndexer.Start(leth.blockchain)
+	// The indexers need a contiguous header chain, which ultra-light clients don't
+	// have. Their filter APIs reject log queries instead, see UltraLightFilterAPI.
+	if len(config.UltraLightServers) == 0 {
+		leth.bloomIndexer.Start(leth.blockchain)
+	}
 	// Rewind the chain in case of an incompatible config upgrade.
 	if compat, ok := genesisErr.(*params.ConfigCompatError); ok {
 		log.Warn("Rewinding chain to upgrade configuration", "err", compat)
@@ -130,6 +137,12 @@ func New(ctx *node.ServiceContext, config *huc.Config) (*LightHappyUC, error) {
 	if leth.protocolManager, err = NewProtocolManager(leth.chainConfig, true, ClientProtocolVersions, config.NetworkId, leth.eventMux, leth.engine, leth.peers, leth.blockchain, nil, chainDb, leth.odr, leth.relay, quitSync, &leth.wg); err != nil {
 		return nil, err
 	}
+	if len(config.UltraLightServers) > 0 {
+		if leth.protocolManager.ulc, err = newULC(config.UltraLightServers, config.UltraLightFraction); err != nil {
+			return nil, err
+		}
+		log.Info("Running in ultra-light client mode", "servers", len(config.UltraLightServers), "quorum", leth.protocolManager.ulc.quorum())
+	}
 	leth.ApiBackend = &LesApiBackend{leth, nil}
 	gpoParams := config.GPO
 	if gpoParams.Default == nil {
@@ -172,6 +185,12 @@ func (s *LightDummyAPI) Mining() bool {
 // APIs returns the collection of RPC services the happyuc package offers.
 // NOTE, some of these services probably need to be moved to somewhere else.
 func (s *LightHappyUC) APIs() []rpc.API {
+	filterAPI := filters.NewPublicFilterAPI(s.ApiBackend, true)
+
+	var filterService interface{} = filterAPI
+	if s.protocolManager.ulc != nil {
+		filterService = &UltraLightFilterAPI{filterAPI}
+	}
 	return append(hucapi.GetAPIs(s.ApiBackend), []rpc.API{
 		{
 			Namespace: "eth",
@@ -186,7 +205,7 @@ func (s *LightHappyUC) APIs() []rpc.API {
 		}, {
 			Namespace: "eth",
 			Version:   "1.0",
-			Service:   filters.NewPublicFilterAPI(s.ApiBackend, true),
+			Service:   filterService,
 			Public:    true,
 		}, {
 			Namespace: "net",
@@ -33,14 +33,25 @@ const (
 	bloomFilterThreads = 3
 
 	// bloomRetrievalBatch is the maximum number of bloom bit retrievals to service
-	// in a single batch.
-	bloomRetrievalBatch = 16
+	// in a single batch. Batches are split into light.BloomRetrievalChunk sized
+	// requests that are sent to multiple servers in parallel.
+	bloomRetrievalBatch = 64
 
 	// bloomRetrievalWait is the maximum time to wait for enough bloom bit requests
 	// to accumulate request an entire batch (avoiding hysteresis).
 	bloomRetrievalWait = time.Microsecond * 100
+
+	// bloomCacheLimit is the number of decompressed and verified bloom bit vectors
+	// to keep in memory, each being light.BloomTrieFrequency/8 bytes.
+	bloomCacheLimit = 4096
 )
 
+// bloomCacheKey identifies a bloom bit vector of a section in the bloom cache.
+type bloomCacheKey struct {
+	bit     uint
+	section uint64
+}
+
 // startBloomHandlers starts a batch of goroutines to accept bloom bit database
 // retrievals from possibly a range of filters and serving the data to satisfy.
 func (eth *LightHappyUC) startBloomHandlers() {
@@ -54,17 +65,34 @@ func (eth *LightHappyUC) startBloomHandlers() {
 				case request := <-eth.bloomRequests:
 					task := <-request
 					task.Bitsets = make([][]byte, len(task.Sections))
-					compVectors, err := light.GetBloomBits(task.Context, eth.odr, task.Bit, task.Sections)
-					if err == nil {
-						for i := range task.Sections {
-							if blob, err := bitutil.DecompressBytes(compVectors[i], int(light.BloomTrieFrequency/8)); err == nil {
-								task.Bitsets[i] = blob
-							} else {
-								task.Error = err
+
+					// Serve whatever we can from the cache, retrieve the rest
+					var (
+						missing []uint64
+						indices []int
+					)
+					for i, section := range task.Sections {
+						if blob, ok := eth.bloomCache.Get(bloomCacheKey{task.Bit, section}); ok {
+							task.Bitsets[i] = blob.([]byte)
+							continue
+						}
+						missing = append(missing, section)
+						indices = append(indices, i)
+					}
+					if len(missing) > 0 {
+						compVectors, err := light.GetBloomBits(task.Context, eth.odr, task.Bit, missing)
+						if err == nil {
+							for i, idx := range indices {
+								if blob, err := bitutil.DecompressBytes(compVectors[i], int(light.BloomTrieFrequency/8)); err == nil {
+									task.Bitsets[idx] = blob
+									eth.bloomCache.Add(bloomCacheKey{task.Bit, missing[i]}, blob)
+								} else {
+									task.Error = err
+								}
 							}
+						} else {
+							task.Error = err
 						}
-					} else {
-						task.Error = err
 					}
 					request <- task
 				}
@@ -0,0 +1,358 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common/mclock"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/les/flowcontrol"
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/metrics"
+	"github.com/happyuc-project/happyuc-go/p2p"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/rlp"
+)
+
+var (
+	errNoCapacity       = errors.New("not enough serving capacity")
+	errCapacityTooLarge = errors.New("capacity exceeds the total serving capacity")
+	errOverAssigned     = errors.New("assigned capacities exceed the total serving capacity")
+)
+
+// clientPoolKey is the database key under which the priority capacities assigned
+// by the operator are persisted.
+var clientPoolKey = []byte("clientPool/capacities")
+
+var (
+	priorityClientGauge = metrics.NewRegisteredGauge("les/server/clients/priority", nil)
+	freeClientGauge     = metrics.NewRegisteredGauge("les/server/clients/free", nil)
+	kickedClientMeter   = metrics.NewRegisteredMeter("les/server/clients/kicked", nil)
+	rejectedClientMeter = metrics.NewRegisteredMeter("les/server/clients/rejected", nil)
+	servedCostMeter     = metrics.NewRegisteredMeter("les/server/clients/cost", nil)
+)
+
+// poolClient is the accounting entry of a single connected light client.
+type poolClient struct {
+	peer      *peer
+	capacity  uint64 // minimum recharge rate granted in the handshake
+	priority  bool   // whether the capacity was assigned by the operator
+	connected mclock.AbsTime
+	requests  uint64
+	cost      uint64
+	costMeter metrics.Meter // per-client cost meter, only for priority clients
+}
+
+// assignedCapacity is the database representation of a priority capacity.
+type assignedCapacity struct {
+	ID       discover.NodeID
+	Capacity uint64
+}
+
+// ClientInfo contains the usage statistics of a connected light client.
+type ClientInfo struct {
+	Priority  bool          `json:"priority"`
+	Capacity  uint64        `json:"capacity"`
+	Connected time.Duration `json:"connected"`
+	Requests  uint64        `json:"requests"`
+	Cost      uint64        `json:"cost"`
+}
+
+// clientPool distributes the serving capacity of a LES server between the
+// connected light clients. Priority clients get the capacity assigned to them
+// by the operator, free clients share whatever is left, each receiving the
+// default flow control parameters. If a priority client cannot be fitted in,
+// free clients with the highest resource usage are kicked to make room.
+type clientPool struct {
+	db       hucdb.Database           // database persisting the assigned capacities
+	totalCap uint64                   // total recharge capacity of the server
+	defaults flowcontrol.ServerParams // parameters given to free clients
+
+	assigned    map[discover.NodeID]uint64 // capacities assigned by the operator
+	assignedSum uint64                     // sum of the capacities assigned by the operator
+	clients     map[discover.NodeID]*poolClient
+	prioritySum uint64 // sum of the capacities of connected priority clients
+	freeCount   uint64 // number of connected free clients
+
+	trust func(id discover.NodeID, trusted bool) // marks priority clients as trusted by the p2p server
+	lock  sync.Mutex
+}
+
+// newClientPool creates a client pool with the given total capacity, handing
+// out the default parameters to every free client. The priority capacities
+// previously assigned by the operator are loaded from the database.
+func newClientPool(db hucdb.Database, totalCap uint64, defaults flowcontrol.ServerParams) *clientPool {
+	cp := &clientPool{
+		db:       db,
+		totalCap: totalCap,
+		defaults: defaults,
+		assigned: make(map[discover.NodeID]uint64),
+		clients:  make(map[discover.NodeID]*poolClient),
+	}
+	cp.loadCapacities()
+	return cp
+}
+
+// loadCapacities loads the priority capacities assigned by the operator from the
+// database, dropping any that don't fit into the total capacity anymore.
+func (cp *clientPool) loadCapacities() {
+	enc, err := cp.db.Get(clientPoolKey)
+	if err != nil {
+		return
+	}
+	var list []assignedCapacity
+	if err := rlp.DecodeBytes(enc, &list); err != nil {
+		log.Debug("Failed to decode client capacities", "err", err)
+		return
+	}
+	for _, entry := range list {
+		if cp.assignedSum+entry.Capacity > cp.totalCap {
+			log.Warn("Dropping client capacity over the total", "id", entry.ID, "capacity", entry.Capacity, "total", cp.totalCap)
+			continue
+		}
+		cp.assigned[entry.ID] = entry.Capacity
+		cp.assignedSum += entry.Capacity
+	}
+}
+
+// saveCapacities persists the priority capacities assigned by the operator into
+// the database. The lock must be held.
+func (cp *clientPool) saveCapacities() error {
+	list := make([]assignedCapacity, 0, len(cp.assigned))
+	for id, capacity := range cp.assigned {
+		list = append(list, assignedCapacity{ID: id, Capacity: capacity})
+	}
+	enc, err := rlp.EncodeToBytes(list)
+	if err != nil {
+		return err
+	}
+	return cp.db.Put(clientPoolKey, enc)
+}
+
+// isPriority reports whether the operator assigned capacity to the given node.
+func (cp *clientPool) isPriority(id discover.NodeID) bool {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	_, ok := cp.assigned[id]
+	return ok
+}
+
+// setTrust installs the callback exempting priority clients from the peer
+// limits of the p2p server, applying it to the already assigned nodes.
+func (cp *clientPool) setTrust(trust func(id discover.NodeID, trusted bool)) {
+	cp.lock.Lock()
+	cp.trust = trust
+	ids := make([]discover.NodeID, 0, len(cp.assigned))
+	for id := range cp.assigned {
+		ids = append(ids, id)
+	}
+	cp.lock.Unlock()
+
+	for _, id := range ids {
+		trust(id, true)
+	}
+}
+
+// params converts a recharge capacity into flow control parameters, keeping
+// the buffer size proportional to the recharge rate of the defaults.
+func (cp *clientPool) params(capacity uint64) *flowcontrol.ServerParams {
+	return &flowcontrol.ServerParams{
+		BufLimit:    capacity * (cp.defaults.BufLimit / cp.defaults.MinRecharge),
+		MinRecharge: capacity,
+	}
+}
+
+// connect tries to admit a new client into the pool, returning the flow
+// control parameters granted to it.
+func (cp *clientPool) connect(p *peer) (*flowcontrol.ServerParams, error) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	id := p.ID()
+	if _, ok := cp.clients[id]; ok {
+		return nil, errAlreadyRegistered
+	}
+	client := &poolClient{peer: p, connected: mclock.Now()}
+
+	if capacity, ok := cp.assigned[id]; ok {
+		// Priority client, evict free clients until it fits in
+		for cp.prioritySum+capacity+cp.freeCount*cp.defaults.MinRecharge > cp.totalCap {
+			if !cp.kickFree() {
+				rejectedClientMeter.Mark(1)
+				return nil, errNoCapacity
+			}
+		}
+		client.capacity, client.priority = capacity, true
+		client.costMeter = metrics.NewRegisteredMeter(clientCostMeterName(id), nil)
+		cp.prioritySum += capacity
+	} else {
+		// Free client, admit only if there's leftover capacity
+		if cp.prioritySum+(cp.freeCount+1)*cp.defaults.MinRecharge > cp.totalCap {
+			rejectedClientMeter.Mark(1)
+			return nil, errNoCapacity
+		}
+		client.capacity = cp.defaults.MinRecharge
+		cp.freeCount++
+	}
+	cp.clients[id] = client
+	cp.updateGauges()
+
+	return cp.params(client.capacity), nil
+}
+
+// disconnect removes a client from the pool, releasing its capacity.
+func (cp *clientPool) disconnect(p *peer) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if client, ok := cp.clients[p.ID()]; ok && client.peer == p {
+		cp.remove(client)
+	}
+}
+
+// remove drops a client from the accounting. The lock must be held.
+func (cp *clientPool) remove(client *poolClient) {
+	id := client.peer.ID()
+	if client.priority {
+		cp.prioritySum -= client.capacity
+		metrics.DefaultRegistry.Unregister(clientCostMeterName(id))
+	} else {
+		cp.freeCount--
+	}
+	delete(cp.clients, id)
+	cp.updateGauges()
+}
+
+// kickFree disconnects the free client with the highest accumulated cost,
+// returning false if there are no free clients left. The lock must be held.
+func (cp *clientPool) kickFree() bool {
+	var worst *poolClient
+	for _, client := range cp.clients {
+		if !client.priority && (worst == nil || client.cost > worst.cost) {
+			worst = client
+		}
+	}
+	if worst == nil {
+		return false
+	}
+	worst.peer.Log().Debug("Kicking free light client", "requests", worst.requests, "cost", worst.cost)
+	kickedClientMeter.Mark(1)
+
+	cp.remove(worst)
+	go worst.peer.Disconnect(p2p.DiscTooManyPeers)
+	return true
+}
+
+// served accounts the cost of a request processed for the given client.
+func (cp *clientPool) served(p *peer, cost uint64) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	servedCostMeter.Mark(int64(cost))
+	if client, ok := cp.clients[p.ID()]; ok && client.peer == p {
+		client.requests++
+		client.cost += cost
+		if client.costMeter != nil {
+			client.costMeter.Mark(int64(cost))
+		}
+	}
+}
+
+// setCapacity assigns a priority capacity to a node, or reverts it to a free
+// client if the capacity is zero. The capacities assigned in total may not
+// exceed the serving capacity of the server. A connected client is disconnected,
+// so that it can reconnect with its new flow control parameters.
+func (cp *clientPool) setCapacity(id discover.NodeID, capacity uint64) error {
+	if capacity > cp.totalCap {
+		return errCapacityTooLarge
+	}
+	cp.lock.Lock()
+	old, priority := cp.assigned[id]
+	if cp.assignedSum-old+capacity > cp.totalCap {
+		cp.lock.Unlock()
+		return errOverAssigned
+	}
+	if capacity == 0 {
+		delete(cp.assigned, id)
+	} else {
+		cp.assigned[id] = capacity
+	}
+	cp.assignedSum = cp.assignedSum - old + capacity
+	if err := cp.saveCapacities(); err != nil {
+		log.Error("Failed to persist client capacities", "err", err)
+	}
+	if client, ok := cp.clients[id]; ok {
+		log.Debug("Reconnecting light client with new capacity", "id", id, "capacity", capacity)
+		cp.remove(client)
+		go client.peer.Disconnect(p2p.DiscRequested)
+	}
+	trust := cp.trust
+	cp.lock.Unlock()
+
+	// Let priority clients bypass the peer limits of the p2p server too
+	if trust != nil && priority != (capacity != 0) {
+		trust(id, capacity != 0)
+	}
+	return nil
+}
+
+// capacities returns the priority capacities assigned by the operator.
+func (cp *clientPool) capacities() map[discover.NodeID]uint64 {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	assigned := make(map[discover.NodeID]uint64, len(cp.assigned))
+	for id, capacity := range cp.assigned {
+		assigned[id] = capacity
+	}
+	return assigned
+}
+
+// info returns the usage statistics of all connected clients.
+func (cp *clientPool) info() map[discover.NodeID]ClientInfo {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	now := mclock.Now()
+	infos := make(map[discover.NodeID]ClientInfo, len(cp.clients))
+	for id, client := range cp.clients {
+		infos[id] = ClientInfo{
+			Priority:  client.priority,
+			Capacity:  client.capacity,
+			Connected: time.Duration(now - client.connected),
+			Requests:  client.requests,
+			Cost:      client.cost,
+		}
+	}
+	return infos
+}
+
+// updateGauges refreshes the client count metrics. The lock must be held.
+func (cp *clientPool) updateGauges() {
+	freeClientGauge.Update(int64(cp.freeCount))
+	priorityClientGauge.Update(int64(uint64(len(cp.clients)) - cp.freeCount))
+}
+
+// clientCostMeterName returns the metric name of a priority client's cost meter.
+func clientCostMeterName(id discover.NodeID) string {
+	return fmt.Sprintf("les/server/clients/%x/cost", id[:8])
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/les/flowcontrol"
+	"github.com/happyuc-project/happyuc-go/p2p"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+)
+
+func newPoolTestPeer(i byte) *peer {
+	var id discover.NodeID
+	id[0] = i
+	return newPeer(lpv2, NetworkId, p2p.NewPeer(id, "test", nil), nil)
+}
+
+// Tests that free clients share the leftover capacity, and that priority
+// clients kick out the most expensive free clients to fit in.
+func TestClientPoolCapacity(t *testing.T) {
+	defaults := flowcontrol.ServerParams{BufLimit: 3000, MinRecharge: 10}
+	db, _ := hucdb.NewMemDatabase()
+	pool := newClientPool(db, 30, defaults)
+
+	// Fill up the pool with free clients
+	free := []*peer{newPoolTestPeer(1), newPoolTestPeer(2), newPoolTestPeer(3)}
+	for i, p := range free {
+		params, err := pool.connect(p)
+		if err != nil {
+			t.Fatalf("free client %d: failed to connect: %v", i, err)
+		}
+		if *params != defaults {
+			t.Fatalf("free client %d: params mismatch: have %v, want %v", i, *params, defaults)
+		}
+	}
+	if _, err := pool.connect(newPoolTestPeer(4)); err != errNoCapacity {
+		t.Fatalf("overflowing free client: error mismatch: have %v, want %v", err, errNoCapacity)
+	}
+	// Make the second client the most expensive one and assign a priority client
+	pool.served(free[1], 100)
+	pool.served(free[0], 10)
+
+	prio := newPoolTestPeer(5)
+	if err := pool.setCapacity(prio.ID(), 15); err != nil {
+		t.Fatalf("failed to assign capacity: %v", err)
+	}
+	params, err := pool.connect(prio)
+	if err != nil {
+		t.Fatalf("priority client: failed to connect: %v", err)
+	}
+	if params.MinRecharge != 15 || params.BufLimit != 4500 {
+		t.Fatalf("priority client: params mismatch: have %v, want {4500 15}", *params)
+	}
+	infos := pool.info()
+	if len(infos) != 2 {
+		t.Fatalf("client count mismatch: have %d, want 2", len(infos))
+	}
+	if _, ok := infos[free[1].ID()]; ok {
+		t.Fatalf("most expensive free client not kicked")
+	}
+	if _, ok := infos[free[0].ID()]; ok {
+		t.Fatalf("second most expensive free client not kicked")
+	}
+	if _, ok := infos[free[2].ID()]; !ok {
+		t.Fatalf("cheapest free client kicked")
+	}
+	if info := infos[prio.ID()]; !info.Priority || info.Capacity != 15 {
+		t.Fatalf("priority client info mismatch: %+v", info)
+	}
+	// Disconnecting the priority client should release its capacity
+	pool.disconnect(prio)
+	for i, p := range free[:2] {
+		if _, err := pool.connect(p); err != nil {
+			t.Fatalf("free client %d: failed to reconnect: %v", i, err)
+		}
+	}
+	if err := pool.setCapacity(prio.ID(), 31); err != errCapacityTooLarge {
+		t.Fatalf("oversized capacity: error mismatch: have %v, want %v", err, errCapacityTooLarge)
+	}
+}
+
+// Tests that priority clients are marked trusted by the p2p server, so that they
+// can connect even if its peer slots are full.
+func TestClientPoolTrust(t *testing.T) {
+	db, _ := hucdb.NewMemDatabase()
+	pool := newClientPool(db, 30, flowcontrol.ServerParams{BufLimit: 3000, MinRecharge: 10})
+
+	var early, late discover.NodeID
+	early[0], late[0] = 1, 2
+
+	// Capacities assigned before the server started should be trusted on startup
+	if err := pool.setCapacity(early, 10); err != nil {
+		t.Fatalf("failed to assign capacity: %v", err)
+	}
+	trusted := make(map[discover.NodeID]bool)
+	pool.setTrust(func(id discover.NodeID, trust bool) { trusted[id] = trust })
+	if !trusted[early] {
+		t.Fatalf("priority client assigned before startup not trusted")
+	}
+	// Capacities assigned afterwards should be trusted and distrusted on demand
+	if err := pool.setCapacity(late, 10); err != nil {
+		t.Fatalf("failed to assign capacity: %v", err)
+	}
+	if !trusted[late] {
+		t.Fatalf("priority client assigned after startup not trusted")
+	}
+	if err := pool.setCapacity(early, 0); err != nil {
+		t.Fatalf("failed to remove capacity: %v", err)
+	}
+	if trusted[early] {
+		t.Fatalf("demoted client still trusted")
+	}
+}
+
+// Tests that the capacities assigned in total can't exceed the serving capacity,
+// and that the assignments are persisted across restarts.
+func TestClientPoolAssignments(t *testing.T) {
+	db, _ := hucdb.NewMemDatabase()
+	defaults := flowcontrol.ServerParams{BufLimit: 3000, MinRecharge: 10}
+	pool := newClientPool(db, 30, defaults)
+
+	var a, b, c discover.NodeID
+	a[0], b[0], c[0] = 1, 2, 3
+
+	if err := pool.setCapacity(a, 20); err != nil {
+		t.Fatalf("failed to assign capacity: %v", err)
+	}
+	if err := pool.setCapacity(b, 10); err != nil {
+		t.Fatalf("failed to assign capacity: %v", err)
+	}
+	if err := pool.setCapacity(c, 1); err != errOverAssigned {
+		t.Fatalf("overassigned capacity: error mismatch: have %v, want %v", err, errOverAssigned)
+	}
+	// Reassigning a node should only count its new capacity
+	if err := pool.setCapacity(a, 15); err != nil {
+		t.Fatalf("failed to reassign capacity: %v", err)
+	}
+	if err := pool.setCapacity(c, 5); err != nil {
+		t.Fatalf("failed to assign released capacity: %v", err)
+	}
+	if err := pool.setCapacity(b, 0); err != nil {
+		t.Fatalf("failed to remove capacity: %v", err)
+	}
+	// Restart the pool and check the assignments survived
+	want := map[discover.NodeID]uint64{a: 15, c: 5}
+
+	pool = newClientPool(db, 30, defaults)
+	if have := pool.capacities(); len(have) != len(want) || have[a] != want[a] || have[c] != want[c] {
+		t.Fatalf("reloaded capacities mismatch: have %v, want %v", have, want)
+	}
+	if err := pool.setCapacity(b, 11); err != errOverAssigned {
+		t.Fatalf("overassigned capacity after restart: error mismatch: have %v, want %v", err, errOverAssigned)
+	}
+	// Restarting with a lower total capacity should drop what doesn't fit
+	pool = newClientPool(db, 15, defaults)
+	if have := pool.capacities(); len(have) != 1 {
+		t.Fatalf("reloaded capacities over the total: have %v", have)
+	}
+}
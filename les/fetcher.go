@@ -91,6 +91,7 @@ type fetcherTreeNode struct {
 // fetchRequest represents a header download request
 type fetchRequest struct {
 	hash    common.Hash
+	td      *big.Int // announced td, trusted without validation in ultra-light mode
 	amount  uint64
 	peer    *peer
 	sent    mclock.AbsTime
@@ -358,6 +359,12 @@ func (f *lightFetcher) peerHasBlock(p *peer, hash common.Hash, number uint64) bo
 	}
 	f.chain.LockChain()
 	defer f.chain.UnlockChain()
+
+	if f.pm.ulc != nil && p.trusted && core.GetCanonicalHash(f.pm.chainDb, number) == hash {
+		// ultra-light clients only know the trusted heads below the peer's block tree,
+		// which the trusted servers announced and are thus expected to know
+		return true
+	}
 	// if it's older than the peer's block tree root but it's in the same canonical chain
 	// as the root, we can still be sure the peer knows it
 	//
@@ -381,6 +388,21 @@ func (f *lightFetcher) requestAmount(p *peer, n *fetcherTreeNode) uint64 {
 	return amount
 }
 
+// trustedAnnounces returns the number of trusted servers that announced the
+// given head with the given total difficulty.
+func (f *lightFetcher) trustedAnnounces(hash common.Hash, td *big.Int) int {
+	count := 0
+	for p, fp := range f.peers {
+		if !p.trusted {
+			continue
+		}
+		if n := fp.nodeByHash[hash]; n != nil && n.td != nil && n.td.Cmp(td) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
 // requestedID tells if a certain reqID has been requested by the fetcher
 func (f *lightFetcher) requestedID(reqID uint64) bool {
 	f.reqMu.RLock()
@@ -402,6 +424,14 @@ func (f *lightFetcher) nextRequest() (*distReq, uint64) {
 	for p, fp := range f.peers {
 		for hash, n := range fp.nodeByHash {
 			if !f.checkKnownNode(p, n) && !n.requested && (bestTd == nil || n.td.Cmp(bestTd) >= 0) {
+				if f.pm.ulc != nil {
+					// Ultra-light clients only fetch the head itself, once enough trusted servers vouched for it
+					if f.trustedAnnounces(hash, n.td) < f.pm.ulc.quorum() {
+						continue
+					}
+					bestHash, bestAmount, bestTd = hash, 1, n.td
+					continue
+				}
 				amount := f.requestAmount(p, n)
 				if bestTd == nil || n.td.Cmp(bestTd) > 0 || amount < bestAmount {
 					bestHash = hash
@@ -476,7 +506,7 @@ func (f *lightFetcher) nextRequest() (*distReq, uint64) {
 				cost := p.GetRequestCost(GetBlockHeadersMsg, int(bestAmount))
 				p.fcServer.QueueRequest(reqID, cost)
 				f.reqMu.Lock()
-				f.requested[reqID] = fetchRequest{hash: bestHash, amount: bestAmount, peer: p, sent: mclock.Now()}
+				f.requested[reqID] = fetchRequest{hash: bestHash, td: bestTd, amount: bestAmount, peer: p, sent: mclock.Now()}
 				f.reqMu.Unlock()
 				go func() {
 					time.Sleep(hardRequestTimeout)
@@ -500,6 +530,15 @@ func (f *lightFetcher) processResponse(req fetchRequest, resp fetchResponse) boo
 		req.peer.Log().Debug("Response content mismatch", "requested", len(resp.headers), "reqfrom", resp.headers[0], "delivered", req.amount, "delfrom", req.hash)
 		return false
 	}
+	if f.pm.ulc != nil {
+		// The head was vouched for by a quorum of trusted servers, accept it as is
+		if err := f.chain.InsertTrustedHeader(resp.headers[0], req.td); err != nil {
+			log.Debug("Failed to insert trusted header", "err", err)
+			return false
+		}
+		f.newHeaders(resp.headers, []*big.Int{req.td})
+		return true
+	}
 	headers := make([]*types.Header, req.amount)
 	for i, header := range resp.headers {
 		headers[int(req.amount)-1-i] = header
@@ -565,6 +604,10 @@ func (f *lightFetcher) checkAnnouncedHeaders(fp *fetcherPeerInfo, headers []*typ
 			hash, number := header.ParentHash, header.Number.Uint64()-1
 			td = f.chain.GetTd(hash, number)
 			header = f.chain.GetHeader(hash, number)
+			if (header == nil || td == nil) && f.pm.ulc != nil {
+				// ultra-light clients don't have the ancestry of trusted heads
+				return true
+			}
 			if header == nil || td == nil {
 				log.Error("Missing parent of validated header", "hash", hash, "number", number)
 				return false
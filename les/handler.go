@@ -58,6 +58,7 @@ const (
 	MaxHelperTrieProofsFetch = 64  // Amount of merkle proofs to be fetched per retrieval request
 	MaxTxSend                = 64  // Amount of transactions to be send per request
 	MaxTxStatus              = 256 // Amount of transactions to queried per request
+	MaxTxLookupFetch         = 64  // Amount of transaction lookups to be fetched per retrieval request
 
 	disableClientRemovePeer = false
 )
@@ -101,6 +102,7 @@ type ProtocolManager struct {
 	chainDb     hucdb.Database
 	odr         *LesOdr
 	server      *LesServer
+	ulc         *ulc // nil unless running in ultra-light mode
 	serverPool  *serverPool
 	lesTopic    discv5.Topic
 	reqDist     *requestDistributor
@@ -260,13 +262,29 @@ func (pm *ProtocolManager) newPeer(pv int, nv uint64, p *p2p.Peer, rw p2p.MsgRea
 // handle is the callback invoked to manage the life cycle of a les peer. When
 // this function terminates, the peer is disconnected.
 func (pm *ProtocolManager) handle(p *peer) error {
-	// Ignore maxPeers if this is a trusted peer
-	if pm.peers.Len() >= pm.maxPeers && !p.Peer.Info().Network.Trusted {
+	// Ignore maxPeers if this is a trusted peer or a priority client
+	priority := pm.server != nil && pm.server.clientPool.isPriority(p.ID())
+	if pm.peers.Len() >= pm.maxPeers && !p.Peer.Info().Network.Trusted && !priority {
 		return p2p.DiscTooManyPeers
 	}
 
 	p.Log().Debug("Light HappyUC peer connected", "name", p.Name())
 
+	// Assign the client its share of the serving capacity
+	if pm.server != nil {
+		params, err := pm.server.clientPool.connect(p)
+		if err != nil {
+			p.Log().Debug("Light HappyUC client rejected", "err", err)
+			return p2p.DiscTooManyPeers
+		}
+		defer pm.server.clientPool.disconnect(p)
+		p.fcParams = params
+	}
+
+	// Request signed announcements from trusted servers in ultra-light mode
+	if pm.ulc != nil {
+		p.trusted = pm.ulc.isTrusted(p.ID())
+	}
 	// Execute the LES handshake
 	var (
 		genesis = pm.blockchain.Genesis()
@@ -330,7 +348,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	}
 }
 
-var reqList = []uint64{GetBlockHeadersMsg, GetBlockBodiesMsg, GetCodeMsg, GetReceiptsMsg, GetProofsV1Msg, SendTxMsg, SendTxV2Msg, GetTxStatusMsg, GetHeaderProofsMsg, GetProofsV2Msg, GetHelperTrieProofsMsg}
+var reqList = []uint64{GetBlockHeadersMsg, GetBlockBodiesMsg, GetCodeMsg, GetReceiptsMsg, GetProofsV1Msg, SendTxMsg, SendTxV2Msg, GetTxStatusMsg, GetHeaderProofsMsg, GetProofsV2Msg, GetHelperTrieProofsMsg, GetTxLookupMsg}
 
 // handleMsg is invoked whenever an inbound message is received from a remote
 // peer. The remote connection is torn down upon returning any error.
@@ -349,11 +367,11 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		bufValue, _ := p.fcClient.AcceptRequest()
 		cost := costs.baseCost + reqCnt*costs.reqCost
-		if cost > pm.server.defParams.BufLimit {
-			cost = pm.server.defParams.BufLimit
+		if cost > p.fcParams.BufLimit {
+			cost = p.fcParams.BufLimit
 		}
 		if cost > bufValue {
-			recharge := time.Duration((cost - bufValue) * 1000000 / pm.server.defParams.MinRecharge)
+			recharge := time.Duration((cost - bufValue) * 1000000 / p.fcParams.MinRecharge)
 			p.Log().Error("Request came too early", "recharge", common.PrettyDuration(recharge))
 			return true
 		}
@@ -478,6 +496,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + query.Amount*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, query.Amount, rcost)
+		pm.server.clientPool.served(p, rcost)
 		return p.SendBlockHeaders(req.ReqID, bv, headers)
 
 	case BlockHeadersMsg:
@@ -535,6 +554,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 		return p.SendBlockBodiesRLP(req.ReqID, bv, bodies)
 
 	case BlockBodiesMsg:
@@ -598,6 +618,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 		return p.SendCode(req.ReqID, bv, data)
 
 	case CodeMsg:
@@ -661,6 +682,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 		return p.SendReceiptsRLP(req.ReqID, bv, receipts)
 
 	case ReceiptsMsg:
@@ -733,6 +755,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 		return p.SendProofs(req.ReqID, bv, proofs)
 
 	case GetProofsV2Msg:
@@ -793,6 +816,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 		return p.SendProofsV2(req.ReqID, bv, nodes.NodeList())
 
 	case ProofsV1Msg:
@@ -880,6 +904,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 		return p.SendHeaderProofs(req.ReqID, bv, proofs)
 
 	case GetHelperTrieProofsMsg:
@@ -941,6 +966,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 		return p.SendHelperTrieProofs(req.ReqID, bv, HelperTrieResps{Proofs: nodes.NodeList(), AuxData: auxData})
 
 	case HeaderProofsMsg:
@@ -1001,6 +1027,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 		_, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 
 	case SendTxV2Msg:
 		if pm.txpool == nil {
@@ -1036,6 +1063,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 
 		return p.SendTxStatus(req.ReqID, bv, stats)
 
@@ -1057,6 +1085,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
 		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
 
 		return p.SendTxStatus(req.ReqID, bv, pm.txStatus(req.Hashes))
 
@@ -1076,6 +1105,79 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 		p.fcServer.GotReply(resp.ReqID, resp.BV)
 
+	case GetTxLookupMsg:
+		p.Log().Trace("Received transaction lookup request")
+		// Decode the retrieval message
+		var req struct {
+			ReqID uint64
+			Reqs  []TxLookupReq
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		reqCnt := len(req.Reqs)
+		if reject(uint64(reqCnt), MaxTxLookupFetch) {
+			return errResp(ErrRequestRejected, "")
+		}
+		// Gather the lookups, proving the headers by the requested CHTs
+		var (
+			lookups []TxLookupResp
+			lastCnt uint64
+			chtTrie *trie.Trie
+		)
+		nodes := light.NewNodeSet()
+		for _, req := range req.Reqs {
+			if nodes.DataSize()+len(lookups)*estHeaderRlpSize >= softResponseLimit {
+				break
+			}
+			var lookup TxLookupResp
+			block, number, index := core.GetTxLookupEntry(pm.chainDb, req.Hash)
+			if header := core.GetHeader(pm.chainDb, block, number); header != nil {
+				lookup.Lookup = &core.TxLookupEntry{BlockHash: block, BlockIndex: number, Index: index}
+				lookup.Header = header
+
+				if number < req.ChtCount*light.CHTFrequencyClient {
+					if chtTrie == nil || req.ChtCount != lastCnt {
+						chtTrie, lastCnt = nil, req.ChtCount
+						if root, prefix := pm.getHelperTrie(htCanonical, req.ChtCount-1); root != (common.Hash{}) {
+							chtTrie, _ = trie.New(root, trie.NewDatabase(hucdb.NewTable(pm.chainDb, prefix)))
+						}
+					}
+					if chtTrie != nil {
+						var encNumber [8]byte
+						binary.BigEndian.PutUint64(encNumber[:], number)
+						chtTrie.Prove(encNumber[:], 0, nodes)
+					}
+				}
+			}
+			lookups = append(lookups, lookup)
+		}
+		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
+		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.server.clientPool.served(p, rcost)
+		return p.SendTxLookups(req.ReqID, bv, TxLookupResps{Lookups: lookups, Proofs: nodes.NodeList()})
+
+	case TxLookupMsg:
+		if pm.odr == nil {
+			return errResp(ErrUnexpectedResponse, "")
+		}
+
+		p.Log().Trace("Received transaction lookup response")
+		var resp struct {
+			ReqID, BV uint64
+			Data      TxLookupResps
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+
+		p.fcServer.GotReply(resp.ReqID, resp.BV)
+		deliverMsg = &Msg{
+			MsgType: MsgTxLookups,
+			ReqID:   resp.ReqID,
+			Obj:     resp.Data,
+		}
+
 	default:
 		p.Log().Trace("Received unknown message", "code", msg.Code)
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
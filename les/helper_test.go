@@ -161,7 +161,7 @@ func newTestProtocolManager(lightSync bool, blocks int, generator func(int, *cor
 
 		bbtIndexer := light.NewBloomTrieIndexer(db, false)
 
-		bloomIndexer := eth.NewBloomIndexer(db, params.BloomBitsBlocks)
+		bloomIndexer := huc.NewBloomIndexer(db, params.BloomBitsBlocks)
 		bloomIndexer.AddChildIndexer(bbtIndexer)
 		bloomIndexer.Start(blockchain)
 
@@ -190,6 +190,7 @@ func newTestProtocolManager(lightSync bool, blocks int, generator func(int, *cor
 			BufLimit:    testBufLimit,
 			MinRecharge: 1,
 		}
+		srv.clientPool = newClientPool(db, 1000, *srv.defParams)
 
 		srv.fcManager = flowcontrol.NewClientManager(50, 10, 1000000000)
 		srv.fcCostStats = newCostStats(nil)
@@ -45,6 +45,8 @@ var (
 	errCHTHashMismatch     = errors.New("cht hash mismatch")
 	errCHTNumberMismatch   = errors.New("cht number mismatch")
 	errUselessNodes        = errors.New("useless nodes in merkle proof nodeset")
+	errLookupHashMismatch  = errors.New("transaction lookup header mismatch")
+	errLookupNotCanonical  = errors.New("transaction lookup block not in the local chain")
 )
 
 type LesOdrRequest interface {
@@ -68,6 +70,8 @@ func LesRequest(req light.OdrRequest) LesOdrRequest {
 		return (*ChtRequest)(r)
 	case *light.BloomRequest:
 		return (*BloomRequest)(r)
+	case *light.TxLookupRequest:
+		return (*TxLookupRequest)(r)
 	default:
 		return nil
 	}
@@ -193,7 +197,7 @@ func (r *TrieRequest) GetCost(peer *peer) uint64 {
 	switch peer.version {
 	case lpv1:
 		return peer.GetRequestCost(GetProofsV1Msg, 1)
-	case lpv2:
+	case lpv2, lpv3:
 		return peer.GetRequestCost(GetProofsV2Msg, 1)
 	default:
 		panic(nil)
@@ -353,7 +357,7 @@ func (r *ChtRequest) GetCost(peer *peer) uint64 {
 	switch peer.version {
 	case lpv1:
 		return peer.GetRequestCost(GetHeaderProofsMsg, 1)
-	case lpv2:
+	case lpv2, lpv3:
 		return peer.GetRequestCost(GetHelperTrieProofsMsg, 1)
 	default:
 		panic(nil)
@@ -543,6 +547,119 @@ func (r *BloomRequest) Validate(db hucdb.Database, msg *Msg) error {
 	return nil
 }
 
+// TxLookupReq is a request for the position of a transaction in the canonical
+// chain, proving the containing header by the given number of CHT sections.
+type TxLookupReq struct {
+	Hash     common.Hash
+	ChtCount uint64
+}
+
+// TxLookupResp is the position of a transaction in the canonical chain and the
+// header of the containing block, both nil if the transaction is unknown.
+type TxLookupResp struct {
+	Lookup *core.TxLookupEntry `rlp:"nil"`
+	Header *types.Header       `rlp:"nil"`
+}
+
+// TxLookupResps describes all the responses to a batch of transaction lookups,
+// along with the CHT proofs of the headers covered by the requested sections.
+type TxLookupResps struct {
+	Lookups []TxLookupResp
+	Proofs  light.NodeList
+}
+
+// TxLookupRequest is the ODR request type for looking up the block containing a
+// transaction, see LesOdrRequest interface
+type TxLookupRequest light.TxLookupRequest
+
+// GetCost returns the cost of the given ODR request according to the serving
+// peer's cost table (implementation of LesOdrRequest)
+func (r *TxLookupRequest) GetCost(peer *peer) uint64 {
+	return peer.GetRequestCost(GetTxLookupMsg, 1)
+}
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *TxLookupRequest) CanSend(peer *peer) bool {
+	peer.lock.RLock()
+	defer peer.lock.RUnlock()
+
+	if peer.version < lpv3 {
+		return false
+	}
+	if r.ChtCount == 0 {
+		return true
+	}
+	return peer.headInfo.Number >= light.HelperTrieConfirmations && r.ChtCount-1 <= (peer.headInfo.Number-light.HelperTrieConfirmations)/light.CHTFrequencyClient
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *TxLookupRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting transaction lookup", "hash", r.Hash, "chts", r.ChtCount)
+	return peer.RequestTxLookups(reqID, r.GetCost(peer), []TxLookupReq{{Hash: r.Hash, ChtCount: r.ChtCount}})
+}
+
+// Valid processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (r *TxLookupRequest) Validate(db hucdb.Database, msg *Msg) error {
+	log.Debug("Validating transaction lookup", "hash", r.Hash)
+
+	// Ensure we have a correct message with a single lookup entry
+	if msg.MsgType != MsgTxLookups {
+		return errInvalidMessageType
+	}
+	resp := msg.Obj.(TxLookupResps)
+	if len(resp.Lookups) != 1 {
+		return errInvalidEntryCount
+	}
+	lookup, header := resp.Lookups[0].Lookup, resp.Lookups[0].Header
+	nodeSet := resp.Proofs.NodeSet()
+
+	// Absence of a transaction can't be proven, accept it if nothing was sent along
+	if lookup == nil {
+		if header != nil || nodeSet.KeyCount() != 0 {
+			return errUselessNodes
+		}
+		return nil
+	}
+	if header == nil || header.Hash() != lookup.BlockHash || header.Number.Uint64() != lookup.BlockIndex {
+		return errLookupHashMismatch
+	}
+	if number := lookup.BlockIndex; number < r.ChtCount*light.CHTFrequencyClient {
+		// The header is covered by the trusted CHT, verify its proof
+		var encNumber [8]byte
+		binary.BigEndian.PutUint64(encNumber[:], number)
+
+		reads := &readTraceDB{db: nodeSet}
+		value, err, _ := trie.VerifyProof(r.ChtRoot, encNumber[:], reads)
+		if err != nil {
+			return fmt.Errorf("merkle proof verification failed: %v", err)
+		}
+		if len(reads.reads) != nodeSet.KeyCount() {
+			return errUselessNodes
+		}
+		var node light.ChtNode
+		if err := rlp.DecodeBytes(value, &node); err != nil {
+			return err
+		}
+		if node.Hash != header.Hash() {
+			return errCHTHashMismatch
+		}
+		r.Td = node.Td
+	} else {
+		// The header is too recent for the CHT, it must be part of the local chain
+		if nodeSet.KeyCount() != 0 {
+			return errUselessNodes
+		}
+		if core.GetCanonicalHash(db, number) != lookup.BlockHash {
+			return errLookupNotCanonical
+		}
+	}
+	// Verifications passed, store and return
+	r.Lookup, r.Header = lookup, header
+	return nil
+}
+
 // readTraceDB stores the keys of database reads. We use this to check that received node
 // sets contain only the trie nodes necessary to make proofs pass.
 type readTraceDB struct {
@@ -0,0 +1,125 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/light"
+	"github.com/happyuc-project/happyuc-go/rlp"
+	"github.com/happyuc-project/happyuc-go/trie"
+)
+
+// newTestCht creates a CHT trie from the given headers, assigning each of them
+// a total difficulty equal to its number.
+func newTestCht(headers ...*types.Header) *trie.Trie {
+	db, _ := hucdb.NewMemDatabase()
+	cht, _ := trie.New(common.Hash{}, trie.NewDatabase(db))
+	for _, header := range headers {
+		var encNumber [8]byte
+		binary.BigEndian.PutUint64(encNumber[:], header.Number.Uint64())
+		data, _ := rlp.EncodeToBytes(light.ChtNode{Hash: header.Hash(), Td: header.Number})
+		cht.Update(encNumber[:], data)
+	}
+	return cht
+}
+
+// proveCht collects the CHT proofs of the given block numbers.
+func proveCht(cht *trie.Trie, numbers ...uint64) light.NodeList {
+	nodes := light.NewNodeSet()
+	for _, number := range numbers {
+		var encNumber [8]byte
+		binary.BigEndian.PutUint64(encNumber[:], number)
+		cht.Prove(encNumber[:], 0, nodes)
+	}
+	return nodes.NodeList()
+}
+
+// Tests that transaction lookup replies are only accepted if the containing
+// header is proven by the trusted CHT or is part of the local chain.
+func TestTxLookupValidation(t *testing.T) {
+	db, _ := hucdb.NewMemDatabase()
+
+	var headers []*types.Header
+	for i := 1; i <= 3; i++ {
+		headers = append(headers, &types.Header{Number: big.NewInt(int64(i)), Extra: []byte("cht")})
+	}
+	cht := newTestCht(headers...)
+	forged := &types.Header{Number: big.NewInt(2), Extra: []byte("forged")}
+	recent := &types.Header{Number: big.NewInt(int64(light.CHTFrequencyClient)), Extra: []byte("recent")}
+
+	lookupOf := func(header *types.Header) *core.TxLookupEntry {
+		return &core.TxLookupEntry{BlockHash: header.Hash(), BlockIndex: header.Number.Uint64(), Index: 1}
+	}
+	tests := []struct {
+		chts   uint64
+		header *types.Header
+		lookup *core.TxLookupEntry
+		proofs light.NodeList
+		err    error
+	}{
+		// Lookups proven by the trusted CHT
+		{1, headers[1], lookupOf(headers[1]), proveCht(cht, 2), nil},
+		{1, forged, lookupOf(forged), proveCht(cht, 2), errCHTHashMismatch},
+		{1, headers[1], lookupOf(headers[0]), proveCht(cht, 1), errLookupHashMismatch},
+		{1, headers[1], lookupOf(headers[1]), proveCht(cht, 2, 3), errUselessNodes},
+
+		// Lookups of blocks too recent for the CHT
+		{1, recent, lookupOf(recent), nil, errLookupNotCanonical},
+		{1, recent, lookupOf(recent), proveCht(cht, 2), errUselessNodes},
+
+		// Unknown transactions
+		{1, nil, nil, nil, nil},
+		{1, nil, nil, proveCht(cht, 2), errUselessNodes},
+	}
+	for i, tt := range tests {
+		req := &TxLookupRequest{Hash: common.Hash{0x01}, ChtCount: tt.chts, ChtRoot: cht.Hash()}
+		msg := &Msg{MsgType: MsgTxLookups, Obj: TxLookupResps{
+			Lookups: []TxLookupResp{{Lookup: tt.lookup, Header: tt.header}},
+			Proofs:  tt.proofs,
+		}}
+		if err := req.Validate(db, msg); err != tt.err {
+			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+			continue
+		}
+		if tt.err == nil && tt.lookup != nil {
+			if req.Lookup != tt.lookup || req.Header != tt.header {
+				t.Errorf("test %d: validated lookup not stored", i)
+			}
+			if req.Td == nil || req.Td.Cmp(tt.header.Number) != 0 {
+				t.Errorf("test %d: total difficulty mismatch: have %v, want %v", i, req.Td, tt.header.Number)
+			}
+		}
+	}
+	// Recent blocks are accepted once in the local chain
+	core.WriteCanonicalHash(db, recent.Hash(), recent.Number.Uint64())
+
+	req := &TxLookupRequest{Hash: common.Hash{0x01}, ChtCount: 1, ChtRoot: cht.Hash()}
+	msg := &Msg{MsgType: MsgTxLookups, Obj: TxLookupResps{Lookups: []TxLookupResp{{Lookup: lookupOf(recent), Header: recent}}}}
+	if err := req.Validate(db, msg); err != nil {
+		t.Fatalf("canonical recent lookup rejected: %v", err)
+	}
+	if req.Td != nil {
+		t.Errorf("total difficulty set without CHT proof: %v", req.Td)
+	}
+}
@@ -74,6 +74,39 @@ func odrGetReceipts(ctx context.Context, db hucdb.Database, config *params.Chain
 	return rlp
 }
 
+func TestOdrTxLookupLes3(t *testing.T) { testOdr(t, 3, 1, odrTxLookup) }
+
+func odrTxLookup(ctx context.Context, db hucdb.Database, config *params.ChainConfig, bc *core.BlockChain, lc *light.LightChain, bhash common.Hash) []byte {
+	var block *types.Block
+	if bc != nil {
+		block = bc.GetBlockByHash(bhash)
+	} else {
+		block, _ = lc.GetBlockByHash(ctx, bhash)
+	}
+	if block == nil {
+		return nil
+	}
+	var res []byte
+	for _, tx := range block.Transactions() {
+		var (
+			hash          common.Hash
+			number, index uint64
+			err           error
+		)
+		if bc != nil {
+			tx, hash, number, index = core.GetTransaction(db, tx.Hash())
+		} else {
+			tx, hash, number, index, err = light.GetTransaction(ctx, lc.Odr(), tx.Hash())
+		}
+		if err != nil || tx == nil {
+			return nil
+		}
+		data, _ := rlp.EncodeToBytes([]interface{}{tx, hash, number, index})
+		res = append(res, data...)
+	}
+	return res
+}
+
 func TestOdrAccountsLes1(t *testing.T) { testOdr(t, 1, 1, odrAccounts) }
 
 func TestOdrAccountsLes2(t *testing.T) { testOdr(t, 2, 1, odrAccounts) }
@@ -162,7 +195,7 @@ func testOdr(t *testing.T, protocol int, expFail uint64, fn odrTestFn) {
 	rm := newRetrieveManager(peers, dist, nil)
 	db, _ := hucdb.NewMemDatabase()
 	ldb, _ := hucdb.NewMemDatabase()
-	odr := NewLesOdr(ldb, light.NewChtIndexer(db, true), light.NewBloomTrieIndexer(db, true), eth.NewBloomIndexer(db, light.BloomTrieFrequency), rm)
+	odr := NewLesOdr(ldb, light.NewChtIndexer(db, true), light.NewBloomTrieIndexer(db, true), huc.NewBloomIndexer(db, light.BloomTrieFrequency), rm)
 	pm := newTestProtocolManagerMust(t, false, 4, testChainGen, nil, nil, db)
 	lpm := newTestProtocolManagerMust(t, true, 0, nil, peers, odr, ldb)
 	_, err1, lpeer, err2 := newTestPeerPair("peer", protocol, pm, lpm)
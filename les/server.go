@@ -32,8 +32,10 @@ import (
 	"github.com/happyuc-project/happyuc-go/light"
 	"github.com/happyuc-project/happyuc-go/log"
 	"github.com/happyuc-project/happyuc-go/p2p"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
 	"github.com/happyuc-project/happyuc-go/p2p/discv5"
 	"github.com/happyuc-project/happyuc-go/rlp"
+	"github.com/happyuc-project/happyuc-go/rpc"
 )
 
 type LesServer struct {
@@ -42,6 +44,7 @@ type LesServer struct {
 	fcManager       *flowcontrol.ClientManager // nil if our node is client only
 	fcCostStats     *requestCostStats
 	defParams       *flowcontrol.ServerParams
+	clientPool      *clientPool
 	lesTopics       []discv5.Topic
 	privateKey      *ecdsa.PrivateKey
 	quitSync        chan struct{}
@@ -97,11 +100,24 @@ func NewLesServer(huc *huc.HappyUC, config *huc.Config) (*LesServer, error) {
 		BufLimit:    300000000,
 		MinRecharge: 50000,
 	}
+	srv.clientPool = newClientPool(huc.ChainDb(), uint64(config.LightPeers)*srv.defParams.MinRecharge, *srv.defParams)
 	srv.fcManager = flowcontrol.NewClientManager(uint64(config.LightServ), 10, 1000000000)
 	srv.fcCostStats = newCostStats(huc.ChainDb())
 	return srv, nil
 }
 
+// APIs returns the collection of RPC services the light server offers.
+func (s *LesServer) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "les",
+			Version:   "1.0",
+			Service:   NewPrivateLightServerAPI(s),
+			Public:    false,
+		},
+	}
+}
+
 func (s *LesServer) Protocols() []p2p.Protocol {
 	return s.protocolManager.SubProtocols
 }
@@ -122,6 +138,16 @@ func (s *LesServer) Start(srvr *p2p.Server) {
 		}
 	}
 	s.privateKey = srvr.PrivateKey
+
+	// Priority clients need to get past the peer limits of the p2p server too
+	s.clientPool.setTrust(func(id discover.NodeID, trusted bool) {
+		node := discover.NewNode(id, nil, 0, 0)
+		if trusted {
+			srvr.AddTrustedPeer(node)
+		} else {
+			srvr.RemoveTrustedPeer(node)
+		}
+	})
 	s.protocolManager.blockLoop()
 }
 
@@ -0,0 +1,105 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/huc/filters"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/rpc"
+)
+
+// errNoLogFilters is returned by the log filtering APIs in ultra-light mode, as
+// the chain doesn't contain the headers and bloom sections needed to find logs.
+var errNoLogFilters = errors.New("log filtering is not supported in ultra-light mode")
+
+// ulc holds the configuration of the ultra-light client mode, in which the chain
+// head is not verified locally, but accepted once a quorum of trusted servers
+// has announced it.
+type ulc struct {
+	trusted  map[discover.NodeID]struct{} // Node IDs of the trusted servers
+	fraction int                          // Percentage of trusted servers needed to accept a head
+}
+
+// newULC creates the ultra-light configuration from a list of trusted server
+// hnode URLs and the minimum percentage of them required to accept a head.
+func newULC(servers []string, fraction int) (*ulc, error) {
+	if fraction <= 0 || fraction > 100 {
+		return nil, fmt.Errorf("invalid trusted server fraction %d, must be in (0, 100]", fraction)
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no trusted servers configured")
+	}
+	u := &ulc{
+		trusted:  make(map[discover.NodeID]struct{}),
+		fraction: fraction,
+	}
+	for _, url := range servers {
+		node, err := discover.ParseNode(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted server %q: %v", url, err)
+		}
+		u.trusted[node.ID] = struct{}{}
+	}
+	return u, nil
+}
+
+// isTrusted returns whether the given node is one of the trusted servers.
+func (u *ulc) isTrusted(id discover.NodeID) bool {
+	_, ok := u.trusted[id]
+	return ok
+}
+
+// quorum returns the number of trusted servers that need to announce a head
+// for it to be accepted.
+func (u *ulc) quorum() int {
+	quorum := (len(u.trusted)*u.fraction + 99) / 100
+	if quorum < 1 {
+		quorum = 1
+	}
+	return quorum
+}
+
+// UltraLightFilterAPI is the public filter API of ultra-light clients, rejecting
+// the log filtering requests explicitly instead of silently missing logs.
+type UltraLightFilterAPI struct {
+	*filters.PublicFilterAPI
+}
+
+// Logs rejects log subscriptions in ultra-light mode.
+func (api *UltraLightFilterAPI) Logs(ctx context.Context, crit filters.FilterCriteria) (*rpc.Subscription, error) {
+	return nil, errNoLogFilters
+}
+
+// NewFilter rejects log filters in ultra-light mode.
+func (api *UltraLightFilterAPI) NewFilter(crit filters.FilterCriteria) (rpc.ID, error) {
+	return rpc.ID(""), errNoLogFilters
+}
+
+// GetLogs rejects log queries in ultra-light mode.
+func (api *UltraLightFilterAPI) GetLogs(ctx context.Context, crit filters.FilterCriteria) ([]*types.Log, error) {
+	return nil, errNoLogFilters
+}
+
+// GetFilterLogs rejects log queries in ultra-light mode.
+func (api *UltraLightFilterAPI) GetFilterLogs(ctx context.Context, id rpc.ID) ([]*types.Log, error) {
+	return nil, errNoLogFilters
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/huc/filters"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/rpc"
+)
+
+// Tests that the ultra-light quorum is derived correctly from the configured
+// servers and fraction, and that invalid configurations are rejected.
+func TestULCQuorum(t *testing.T) {
+	var servers []string
+	for i := 0; i < 4; i++ {
+		var id discover.NodeID
+		id[0] = byte(i + 1)
+		servers = append(servers, fmt.Sprintf("hnode://%x@127.0.0.1:30303", id[:]))
+	}
+	tests := []struct {
+		fraction, quorum int
+	}{
+		{1, 1}, {25, 1}, {50, 2}, {75, 3}, {76, 4}, {100, 4},
+	}
+	for _, tt := range tests {
+		u, err := newULC(servers, tt.fraction)
+		if err != nil {
+			t.Fatalf("fraction %d: failed to create ulc: %v", tt.fraction, err)
+		}
+		if q := u.quorum(); q != tt.quorum {
+			t.Errorf("fraction %d: quorum mismatch: have %d, want %d", tt.fraction, q, tt.quorum)
+		}
+	}
+	u, _ := newULC(servers, 50)
+	if id, _ := discover.HexID(servers[0][8:136]); !u.isTrusted(id) {
+		t.Errorf("configured server not trusted")
+	}
+	if u.isTrusted(discover.NodeID{}) {
+		t.Errorf("unknown server trusted")
+	}
+	if _, err := newULC(servers, 0); err == nil {
+		t.Errorf("zero fraction accepted")
+	}
+	if _, err := newULC(nil, 50); err == nil {
+		t.Errorf("empty server list accepted")
+	}
+	if _, err := newULC([]string{"hnode://invalid"}, 50); err == nil {
+		t.Errorf("invalid server accepted")
+	}
+}
+
+// Tests that ultra-light clients reject log queries over RPC explicitly, instead
+// of answering them from a chain full of gaps.
+func TestULCFilterAPI(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &UltraLightFilterAPI{new(filters.PublicFilterAPI)}); err != nil {
+		t.Fatalf("failed to register filter API: %v", err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	var logs []*types.Log
+	if err := client.Call(&logs, "eth_getLogs", map[string]interface{}{}); err == nil || err.Error() != errNoLogFilters.Error() {
+		t.Errorf("eth_getLogs: error mismatch: have %v, want %v", err, errNoLogFilters)
+	}
+	var id rpc.ID
+	if err := client.Call(&id, "eth_newFilter", map[string]interface{}{}); err == nil || err.Error() != errNoLogFilters.Error() {
+		t.Errorf("eth_newFilter: error mismatch: have %v, want %v", err, errNoLogFilters)
+	}
+	if err := client.Call(&logs, "eth_getFilterLogs", "0x1"); err == nil || err.Error() != errNoLogFilters.Error() {
+		t.Errorf("eth_getFilterLogs: error mismatch: have %v, want %v", err, errNoLogFilters)
+	}
+}
@@ -311,6 +311,31 @@ func (self *LightChain) Rollback(chain []common.Hash) {
 
 		if head := self.hc.CurrentHeader(); head.Hash() == hash {
 			self.hc.SetCurrentHeader(self.GetHeader(head.ParentHash, head.Number.Uint64()-1))
+			self.deleteTxLookups(hash, head.Number.Uint64())
+		}
+	}
+}
+
+// pruneTxLookups walks back from a former head of the chain until reaching the
+// canonical chain again, deleting the transaction lookups of the blocks that were
+// reorged out. The caller must hold the chain lock.
+func (self *LightChain) pruneTxLookups(head *types.Header) {
+	for head != nil && core.GetCanonicalHash(self.chainDb, head.Number.Uint64()) != head.Hash() {
+		self.deleteTxLookups(head.Hash(), head.Number.Uint64())
+		head = self.hc.GetHeader(head.ParentHash, head.Number.Uint64()-1)
+	}
+}
+
+// deleteTxLookups deletes the transaction lookups pointing into the given block,
+// if its body was retrieved and its transactions indexed.
+func (self *LightChain) deleteTxLookups(hash common.Hash, number uint64) {
+	body := core.GetBody(self.chainDb, hash, number)
+	if body == nil {
+		return
+	}
+	for _, tx := range body.Transactions {
+		if block, _, _ := core.GetTxLookupEntry(self.chainDb, tx.Hash()); block == hash {
+			core.DeleteTxLookupEntry(self.chainDb, tx.Hash())
 		}
 	}
 }
@@ -363,6 +388,7 @@ func (self *LightChain) InsertHeaderChain(chain []*types.Header, checkFreq int)
 		self.mu.Lock()
 		defer self.mu.Unlock()
 
+		head := self.hc.CurrentHeader()
 		status, err := self.hc.WriteHeader(header)
 
 		switch status {
@@ -370,6 +396,10 @@ func (self *LightChain) InsertHeaderChain(chain []*types.Header, checkFreq int)
 			log.Debug("Inserted new header", "number", header.Number, "hash", header.Hash())
 			events = append(events, core.ChainEvent{Block: types.NewBlockWithHeader(header), Hash: header.Hash()})
 
+			if header.ParentHash != head.Hash() {
+				self.pruneTxLookups(head)
+			}
+
 		case core.SideStatTy:
 			log.Debug("Inserted forked header", "number", header.Number, "hash", header.Hash())
 			events = append(events, core.ChainSideEvent{Block: types.NewBlockWithHeader(header)})
@@ -381,6 +411,49 @@ func (self *LightChain) InsertHeaderChain(chain []*types.Header, checkFreq int)
 	return i, err
 }
 
+// InsertTrustedHeader writes a header vouched for by trusted servers as the new
+// head of the chain without validating its ancestry, which is used by the
+// ultra-light client mode. The total difficulty is taken from the announcements,
+// and the header is only accepted if it is heavier than the current head.
+func (self *LightChain) InsertTrustedHeader(header *types.Header, td *big.Int) error {
+	self.chainmu.Lock()
+	defer self.chainmu.Unlock()
+
+	var (
+		hash   = header.Hash()
+		number = header.Number.Uint64()
+	)
+	self.mu.Lock()
+	head := self.hc.CurrentHeader()
+	if td.Cmp(self.hc.GetTd(head.Hash(), head.Number.Uint64())) <= 0 {
+		self.mu.Unlock()
+		return nil
+	}
+	if err := self.hc.WriteTd(hash, number, td); err != nil {
+		self.mu.Unlock()
+		return err
+	}
+	if err := core.WriteHeader(self.chainDb, header); err != nil {
+		self.mu.Unlock()
+		return err
+	}
+	// Drop any canonical assignments above the new head and promote it
+	for i := number + 1; core.GetCanonicalHash(self.chainDb, i) != (common.Hash{}); i++ {
+		core.DeleteCanonicalHash(self.chainDb, i)
+	}
+	if err := core.WriteCanonicalHash(self.chainDb, hash, number); err != nil {
+		self.mu.Unlock()
+		return err
+	}
+	self.hc.SetCurrentHeader(header)
+	self.pruneTxLookups(head)
+	self.mu.Unlock()
+
+	log.Debug("Inserted trusted header", "number", number, "hash", hash, "td", td)
+	self.postChainEvents([]interface{}{core.ChainEvent{Block: types.NewBlockWithHeader(header), Hash: hash}})
+	return nil
+}
+
 // CurrentHeader retrieves the current head header of the canonical chain. The
 // header is retrieved from the HeaderChain's internal cache.
 func (self *LightChain) CurrentHeader() *types.Header {
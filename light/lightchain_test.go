@@ -307,6 +307,43 @@ func testReorg(t *testing.T, first, second []int, td int64) {
 	}
 }
 
+// Tests that reorganizing the chain deletes the transaction lookups indexed from
+// blocks no longer canonical, but keeps those pointing into the new chain.
+func TestReorgPrunesTxLookups(t *testing.T) {
+	bc := newTestLightChain()
+
+	first := makeHeaderChainWithDiff(bc.genesisBlock, []int{1, 2, 4}, 11)
+	if _, err := bc.InsertHeaderChain(first, 1); err != nil {
+		t.Fatalf("failed to insert first chain: %v", err)
+	}
+	// Index a few transactions from the soon-to-be reorged blocks
+	var txs []*types.Transaction
+	for i, header := range first {
+		tx := types.NewTransaction(uint64(i), common.Address{}, big.NewInt(0), 0, big.NewInt(0), nil)
+		txs = append(txs, tx)
+
+		body := &types.Body{Transactions: types.Transactions{tx}}
+		core.WriteBody(bc.chainDb, header.Hash(), header.Number.Uint64(), body)
+		core.WriteTxLookupEntries(bc.chainDb, types.NewBlockWithHeader(header).WithBody(body.Transactions, nil))
+	}
+	// Include the last transaction in the heavier chain too
+	second := makeHeaderChainWithDiff(bc.genesisBlock, []int{1, 2, 3, 4}, 22)
+	moved := types.NewBlockWithHeader(second[0]).WithBody(types.Transactions{txs[len(txs)-1]}, nil)
+	core.WriteTxLookupEntries(bc.chainDb, moved)
+
+	if _, err := bc.InsertHeaderChain(second, 1); err != nil {
+		t.Fatalf("failed to insert second chain: %v", err)
+	}
+	for i, tx := range txs[:len(txs)-1] {
+		if hash, _, _ := core.GetTxLookupEntry(bc.chainDb, tx.Hash()); hash != (common.Hash{}) {
+			t.Errorf("tx %d: lookup into reorged block %x retained", i, hash)
+		}
+	}
+	if hash, _, _ := core.GetTxLookupEntry(bc.chainDb, txs[len(txs)-1].Hash()); hash != moved.Hash() {
+		t.Errorf("canonical lookup mismatch: have %x, want %x", hash, moved.Hash())
+	}
+}
+
 // Tests that the insertion functions detect banned hashes.
 func TestBadHeaderHashes(t *testing.T) {
 	bc := newTestLightChain()
@@ -346,3 +383,37 @@ func TestReorgBadHeaderHashes(t *testing.T) {
 		t.Errorf("last header hash mismatch: have: %x, want %x", ncm.CurrentHeader().Hash(), headers[2].Hash())
 	}
 }
+
+// Tests that trusted headers can be inserted as the chain head without their
+// ancestry, and that lighter trusted headers don't override the current head.
+func TestInsertTrustedHeader(t *testing.T) {
+	db, _ := hucdb.NewMemDatabase()
+	gspec := core.Genesis{Config: params.TestChainConfig}
+	genesis := gspec.MustCommit(db)
+	headers := makeHeaderChain(genesis.Header(), 16, db, canonicalSeed)
+
+	lc, err := NewLightChain(&dummyOdr{db: db}, gspec.Config, huchash.NewFaker())
+	if err != nil {
+		t.Fatalf("failed to create light chain: %v", err)
+	}
+	head := headers[len(headers)-1]
+	if err := lc.InsertTrustedHeader(head, big.NewInt(1000000000)); err != nil {
+		t.Fatalf("failed to insert trusted header: %v", err)
+	}
+	if have := lc.CurrentHeader().Hash(); have != head.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, head.Hash())
+	}
+	if have := lc.GetHeaderByNumber(head.Number.Uint64()); have == nil || have.Hash() != head.Hash() {
+		t.Fatalf("canonical header mismatch: have %v, want %x", have, head.Hash())
+	}
+	if lc.GetHeaderByNumber(head.Number.Uint64()-1) != nil {
+		t.Fatalf("ancestry of trusted header unexpectedly available")
+	}
+	// Insert a lighter trusted header and ensure it's ignored
+	if err := lc.InsertTrustedHeader(headers[0], big.NewInt(1)); err != nil {
+		t.Fatalf("failed to insert light trusted header: %v", err)
+	}
+	if have := lc.CurrentHeader().Hash(); have != head.Hash() {
+		t.Fatalf("head overridden by lighter header: have %x, want %x", have, head.Hash())
+	}
+}
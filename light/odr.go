@@ -169,3 +169,28 @@ func (req *BloomRequest) StoreResult(db hucdb.Database) {
 		core.WriteBloomBits(db, req.BitIdx, sectionIdx, sectionHead, req.BloomBits[i])
 	}
 }
+
+// TxLookupRequest is the ODR request type for looking up the block containing a
+// transaction. The header of the block is proven by the given CHT if it's old
+// enough to be covered by it, otherwise it needs to be in the local chain.
+type TxLookupRequest struct {
+	OdrRequest
+	Hash     common.Hash
+	ChtCount uint64 // Number of trusted CHT sections (0 = no proof requested)
+	ChtRoot  common.Hash
+	Lookup   *core.TxLookupEntry // nil if the transaction is unknown to the server
+	Header   *types.Header
+	Td       *big.Int // nil if the header wasn't proven by the CHT
+}
+
+// StoreResult stores the retrieved data in local database
+func (req *TxLookupRequest) StoreResult(db hucdb.Database) {
+	// Headers of the local chain are already stored, only store the CHT proven ones.
+	// The lookup entry itself is stored by GetTransaction after checking the body.
+	if req.Td != nil {
+		core.WriteHeader(db, req.Header)
+		hash, num := req.Header.Hash(), req.Header.Number.Uint64()
+		core.WriteTd(db, hash, num, req.Td)
+		core.WriteCanonicalHash(db, hash, num)
+	}
+}
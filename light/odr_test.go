@@ -62,6 +62,10 @@ func (odr *testOdr) Database() hucdb.Database {
 	return odr.ldb
 }
 
+func (odr *testOdr) ChtIndexer() *core.ChainIndexer {
+	return nil
+}
+
 var ErrOdrDisabled = errors.New("ODR disabled")
 
 func (odr *testOdr) Retrieve(ctx context.Context, req OdrRequest) error {
@@ -80,6 +84,11 @@ func (odr *testOdr) Retrieve(ctx context.Context, req OdrRequest) error {
 		req.Proof = nodes
 	case *CodeRequest:
 		req.Data, _ = odr.sdb.Get(req.Hash[:])
+	case *TxLookupRequest:
+		if block, number, index := core.GetTxLookupEntry(odr.sdb, req.Hash); block != (common.Hash{}) {
+			req.Lookup = &core.TxLookupEntry{BlockHash: block, BlockIndex: number, Index: index}
+			req.Header = core.GetHeader(odr.sdb, block, number)
+		}
 	}
 	req.StoreResult(odr.ldb)
 	return nil
@@ -119,6 +128,44 @@ func odrGetReceipts(ctx context.Context, db hucdb.Database, bc *core.BlockChain,
 	return rlp, nil
 }
 
+func TestOdrTxLookupLes1(t *testing.T) { testChainOdr(t, 1, odrTxLookup) }
+
+func odrTxLookup(ctx context.Context, db hucdb.Database, bc *core.BlockChain, lc *LightChain, bhash common.Hash) ([]byte, error) {
+	var txs types.Transactions
+	if bc != nil {
+		txs = bc.GetBlockByHash(bhash).Transactions()
+	} else {
+		block, err := lc.GetBlockByHash(ctx, bhash)
+		if err != nil {
+			return nil, err
+		}
+		txs = block.Transactions()
+	}
+	// Look up every transaction of the block individually by hash
+	var res []byte
+	for _, tx := range txs {
+		var (
+			found     *types.Transaction
+			blockHash common.Hash
+			index     uint64
+		)
+		if bc != nil {
+			found, blockHash, _, index = core.GetTransaction(db, tx.Hash())
+		} else {
+			var err error
+			if found, blockHash, _, index, err = GetTransaction(ctx, lc.Odr(), tx.Hash()); err != nil {
+				return nil, err
+			}
+		}
+		if found == nil {
+			return nil, nil
+		}
+		enc, _ := rlp.EncodeToBytes([]interface{}{found, blockHash, index})
+		res = append(res, enc...)
+	}
+	return res, nil
+}
+
 func TestOdrAccountsLes1(t *testing.T) { testChainOdr(t, 1, odrAccounts) }
 
 func odrAccounts(ctx context.Context, db hucdb.Database, bc *core.BlockChain, lc *LightChain, bhash common.Hash) ([]byte, error) {
@@ -29,6 +29,11 @@ import (
 
 var sha3_nil = crypto.Keccak256Hash(nil)
 
+// BloomRetrievalChunk is the maximum number of bloom bit sections retrieved in a
+// single ODR request. Longer section lists are split into chunks that are
+// retrieved concurrently, so they can be served by multiple servers in parallel.
+const BloomRetrievalChunk = 8
+
 func GetHeaderByNumber(ctx context.Context, odr OdrBackend, number uint64) (*types.Header, error) {
 	db := odr.Database()
 	hash := core.GetCanonicalHash(db, number)
@@ -41,23 +46,7 @@ func GetHeaderByNumber(ctx context.Context, odr OdrBackend, number uint64) (*typ
 		return header, nil
 	}
 
-	var (
-		chtCount, sectionHeadNum uint64
-		sectionHead              common.Hash
-	)
-	if odr.ChtIndexer() != nil {
-		chtCount, sectionHeadNum, sectionHead = odr.ChtIndexer().Sections()
-		canonicalHash := core.GetCanonicalHash(db, sectionHeadNum)
-		// if the CHT was injected as a trusted checkpoint, we have no canonical hash yet so we accept zero hash too
-		for chtCount > 0 && canonicalHash != sectionHead && canonicalHash != (common.Hash{}) {
-			chtCount--
-			if chtCount > 0 {
-				sectionHeadNum = chtCount*CHTFrequencyClient - 1
-				sectionHead = odr.ChtIndexer().SectionHead(chtCount - 1)
-				canonicalHash = core.GetCanonicalHash(db, sectionHeadNum)
-			}
-		}
-	}
+	chtCount, sectionHead := trustedChtSections(odr)
 	if number >= chtCount*CHTFrequencyClient {
 		return nil, ErrNoTrustedCht
 	}
@@ -68,6 +57,28 @@ func GetHeaderByNumber(ctx context.Context, odr OdrBackend, number uint64) (*typ
 	return r.Header, nil
 }
 
+// trustedChtSections returns the number of CHT sections matching the local chain,
+// along with the head of the last one.
+func trustedChtSections(odr OdrBackend) (uint64, common.Hash) {
+	if odr.ChtIndexer() == nil {
+		return 0, common.Hash{}
+	}
+	db := odr.Database()
+
+	chtCount, sectionHeadNum, sectionHead := odr.ChtIndexer().Sections()
+	canonicalHash := core.GetCanonicalHash(db, sectionHeadNum)
+	// if the CHT was injected as a trusted checkpoint, we have no canonical hash yet so we accept zero hash too
+	for chtCount > 0 && canonicalHash != sectionHead && canonicalHash != (common.Hash{}) {
+		chtCount--
+		if chtCount > 0 {
+			sectionHeadNum = chtCount*CHTFrequencyClient - 1
+			sectionHead = odr.ChtIndexer().SectionHead(chtCount - 1)
+			canonicalHash = core.GetCanonicalHash(db, sectionHeadNum)
+		}
+	}
+	return chtCount, sectionHead
+}
+
 func GetCanonicalHash(ctx context.Context, odr OdrBackend, number uint64) (common.Hash, error) {
 	hash := core.GetCanonicalHash(odr.Database(), number)
 	if (hash != common.Hash{}) {
@@ -123,6 +134,45 @@ func GetBlock(ctx context.Context, odr OdrBackend, hash common.Hash, number uint
 	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles), nil
 }
 
+// GetTransaction retrieves a canonical transaction by hash, along with the hash
+// and number of the containing block and its index within. The block is looked
+// up remotely, with its header proven by the trusted CHT or the local chain, and
+// the transaction then verified against the retrieved body of the block.
+func GetTransaction(ctx context.Context, odr OdrBackend, txHash common.Hash) (*types.Transaction, common.Hash, uint64, uint64, error) {
+	db := odr.Database()
+	if tx, hash, number, index := core.GetTransaction(db, txHash); tx != nil {
+		if core.GetCanonicalHash(db, number) == hash {
+			return tx, hash, number, index, nil
+		}
+		// The lookup points to a block reorged out since, look the transaction up anew
+		core.DeleteTxLookupEntry(db, txHash)
+	}
+	r := &TxLookupRequest{Hash: txHash}
+	if chtCount, sectionHead := trustedChtSections(odr); chtCount > 0 {
+		r.ChtCount, r.ChtRoot = chtCount, GetChtRoot(db, chtCount-1, sectionHead)
+	}
+	if err := odr.Retrieve(ctx, r); err != nil {
+		return nil, common.Hash{}, 0, 0, err
+	}
+	if r.Lookup == nil {
+		return nil, common.Hash{}, 0, 0, nil
+	}
+	// The header is proven, make sure the block actually contains the transaction
+	body, err := GetBody(ctx, odr, r.Lookup.BlockHash, r.Lookup.BlockIndex)
+	if err != nil {
+		return nil, common.Hash{}, 0, 0, err
+	}
+	if r.Lookup.Index >= uint64(len(body.Transactions)) || body.Transactions[r.Lookup.Index].Hash() != txHash {
+		return nil, common.Hash{}, 0, 0, ErrTxLookupMismatch
+	}
+	// Verified, index the whole block to serve its transactions locally
+	block := types.NewBlockWithHeader(r.Header).WithBody(body.Transactions, body.Uncles)
+	if err := core.WriteTxLookupEntries(db, block); err != nil {
+		return nil, common.Hash{}, 0, 0, err
+	}
+	return body.Transactions[r.Lookup.Index], r.Lookup.BlockHash, r.Lookup.BlockIndex, r.Lookup.Index, nil
+}
+
 // GetBlockReceipts retrieves the receipts generated by the transactions included
 // in a block given by its hash.
 func GetBlockReceipts(ctx context.Context, odr OdrBackend, hash common.Hash, number uint64) (types.Receipts, error) {
@@ -219,13 +269,32 @@ func GetBloomBits(ctx context.Context, odr OdrBackend, bitIdx uint, sectionIdxLi
 		return result, nil
 	}
 
-	r := &BloomRequest{BloomTrieRoot: GetBloomTrieRoot(db, bloomTrieCount-1, sectionHead), BloomTrieNum: bloomTrieCount - 1, BitIdx: bitIdx, SectionIdxList: reqList}
-	if err := odr.Retrieve(ctx, r); err != nil {
-		return nil, err
-	} else {
-		for i, idx := range reqIdx {
-			result[idx] = r.BloomBits[i]
+	// Split the missing sections into chunks and retrieve them concurrently
+	var (
+		root = GetBloomTrieRoot(db, bloomTrieCount-1, sectionHead)
+		errs = make(chan error, (len(reqList)+BloomRetrievalChunk-1)/BloomRetrievalChunk)
+	)
+	for start := 0; start < len(reqList); start += BloomRetrievalChunk {
+		end := start + BloomRetrievalChunk
+		if end > len(reqList) {
+			end = len(reqList)
+		}
+		go func(sections []uint64, indices []int) {
+			r := &BloomRequest{BloomTrieRoot: root, BloomTrieNum: bloomTrieCount - 1, BitIdx: bitIdx, SectionIdxList: sections}
+			if err := odr.Retrieve(ctx, r); err != nil {
+				errs <- err
+				return
+			}
+			for i, idx := range indices {
+				result[idx] = r.BloomBits[i]
+			}
+			errs <- nil
+		}(reqList[start:end], reqIdx[start:end])
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			return nil, err
 		}
-		return result, nil
 	}
+	return result, nil
 }
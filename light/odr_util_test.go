@@ -0,0 +1,160 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/common/bitutil"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+)
+
+// bloomOdr is a test ODR backend serving bloom bit vectors from a server side
+// database with a fixed latency per section (modelling the server side proof
+// generation), tracking the number of concurrent requests.
+type bloomOdr struct {
+	OdrBackend
+	sdb, ldb hucdb.Database
+	indexer  *core.ChainIndexer
+	latency  time.Duration // Time needed to serve a single section
+
+	lock                  sync.Mutex
+	requests              int
+	inflight, maxInflight int
+}
+
+// newBloomOdr creates a test ODR backend with random bloom bit vectors for the
+// given bits and number of sections, all of them covered by a trusted BloomTrie.
+func newBloomOdr(bits uint, sections uint64, latency time.Duration) *bloomOdr {
+	sdb, _ := hucdb.NewMemDatabase()
+	for bit := uint(0); bit < bits; bit++ {
+		for section := uint64(0); section < sections; section++ {
+			vector := make([]byte, BloomTrieFrequency/8)
+			rand.Read(vector)
+			core.WriteBloomBits(sdb, bit, section, common.Hash{}, bitutil.CompressBytes(vector))
+		}
+	}
+	ldb, _ := hucdb.NewMemDatabase()
+	indexer := NewBloomTrieIndexer(ldb, true)
+	indexer.AddKnownSectionHead(sections-1, common.Hash{0x01})
+
+	return &bloomOdr{sdb: sdb, ldb: ldb, indexer: indexer, latency: latency}
+}
+
+func (odr *bloomOdr) Database() hucdb.Database {
+	return odr.ldb
+}
+
+func (odr *bloomOdr) BloomTrieIndexer() *core.ChainIndexer {
+	return odr.indexer
+}
+
+func (odr *bloomOdr) Retrieve(ctx context.Context, req OdrRequest) error {
+	r, ok := req.(*BloomRequest)
+	if !ok {
+		return ErrOdrDisabled
+	}
+	odr.lock.Lock()
+	odr.requests++
+	if odr.inflight++; odr.inflight > odr.maxInflight {
+		odr.maxInflight = odr.inflight
+	}
+	odr.lock.Unlock()
+
+	time.Sleep(odr.latency * time.Duration(len(r.SectionIdxList)))
+
+	r.BloomBits = make([][]byte, len(r.SectionIdxList))
+	for i, section := range r.SectionIdxList {
+		r.BloomBits[i], _ = core.GetBloomBits(odr.sdb, r.BitIdx, section, common.Hash{})
+	}
+	r.StoreResult(odr.ldb)
+
+	odr.lock.Lock()
+	odr.inflight--
+	odr.lock.Unlock()
+	return nil
+}
+
+// Tests that bloom bit vectors of long section ranges are retrieved in parallel
+// chunks, and that retrieved vectors are served locally afterwards.
+func TestGetBloomBitsChunked(t *testing.T) {
+	odr := newBloomOdr(1, 20, 2*time.Millisecond)
+	defer odr.indexer.Close()
+
+	sections := make([]uint64, 20)
+	for i := range sections {
+		sections[i] = uint64(i)
+	}
+	vectors, err := GetBloomBits(context.Background(), odr, 0, sections)
+	if err != nil {
+		t.Fatalf("failed to retrieve bloom bits: %v", err)
+	}
+	for i, section := range sections {
+		want, _ := core.GetBloomBits(odr.sdb, 0, section, common.Hash{})
+		if !bytes.Equal(vectors[i], want) {
+			t.Errorf("section %d: bloom bits mismatch", section)
+		}
+	}
+	if want := (len(sections) + BloomRetrievalChunk - 1) / BloomRetrievalChunk; odr.requests != want {
+		t.Errorf("request count mismatch: have %d, want %d", odr.requests, want)
+	}
+	if odr.maxInflight < 2 {
+		t.Errorf("chunks not retrieved concurrently: max %d in flight", odr.maxInflight)
+	}
+	// Retrieve again and ensure everything is served locally
+	requests := odr.requests
+	if _, err := GetBloomBits(context.Background(), odr, 0, sections); err != nil {
+		t.Fatalf("failed to retrieve cached bloom bits: %v", err)
+	}
+	if odr.requests != requests {
+		t.Errorf("cached sections retrieved again: %d new requests", odr.requests-requests)
+	}
+	// Sections not covered by the trusted BloomTrie must be rejected
+	if _, err := GetBloomBits(context.Background(), odr, 0, []uint64{20}); err != ErrNoTrustedBloomTrie {
+		t.Errorf("untrusted section error mismatch: have %v, want %v", err, ErrNoTrustedBloomTrie)
+	}
+}
+
+func BenchmarkGetBloomBits64Sections(b *testing.B)  { benchmarkGetBloomBits(b, 64, time.Millisecond) }
+func BenchmarkGetBloomBits256Sections(b *testing.B) { benchmarkGetBloomBits(b, 256, time.Millisecond) }
+
+func benchmarkGetBloomBits(b *testing.B, count uint64, latency time.Duration) {
+	sections := make([]uint64, count)
+	for i := range sections {
+		sections[i] = uint64(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		odr := newBloomOdr(1, count, latency)
+		b.StartTimer()
+
+		if _, err := GetBloomBits(context.Background(), odr, 0, sections); err != nil {
+			b.Fatalf("failed to retrieve bloom bits: %v", err)
+		}
+		b.StopTimer()
+		odr.indexer.Close()
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"errors"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/crypto/sha3"
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/rlp"
+)
+
+var (
+	// errEmptyBundle is returned if a bundle without any transactions is submitted.
+	errEmptyBundle = errors.New("empty bundle")
+
+	// errBundleFailed is returned if any transaction of a bundle fails to execute.
+	errBundleFailed = errors.New("bundle transaction failed")
+)
+
+// Bundle is a list of transactions which must be included atomically at the top
+// of the block with the given number, or not at all.
+type Bundle struct {
+	Txs         types.Transactions
+	BlockNumber uint64
+}
+
+// Hash returns the identifier of the bundle, derived from its transactions and
+// target block.
+func (b *Bundle) Hash() (h common.Hash) {
+	hashes := make([]common.Hash, len(b.Txs))
+	for i, tx := range b.Txs {
+		hashes[i] = tx.Hash()
+	}
+	hw := sha3.NewKeccak256()
+	rlp.Encode(hw, []interface{}{hashes, b.BlockNumber})
+	hw.Sum(h[:0])
+	return h
+}
+
+// commitBundle executes all transactions of a bundle on top of the current work.
+// If any of them fails or is reverted, all state changes done by the bundle are
+// rolled back and an error returned. On success the logs emitted by the bundle
+// are returned.
+func (env *Work) commitBundle(bundle *Bundle, bc *core.BlockChain, coinbase common.Address) ([]*types.Log, error) {
+	// Snapshots don't survive transaction finalisation, roll back from a copy
+	var (
+		snap     = env.state.Copy()
+		gasUsed  = env.header.GasUsed
+		txs      = len(env.txs)
+		receipts = len(env.receipts)
+		tcount   = env.tcount
+		gp       = new(core.GasPool).AddGas(env.header.GasLimit - env.header.GasUsed)
+		logs     []*types.Log
+	)
+	revert := func() {
+		env.state = snap
+		env.header.GasUsed = gasUsed
+		env.txs = env.txs[:txs]
+		env.receipts = env.receipts[:receipts]
+		env.tcount = tcount
+	}
+	for _, tx := range bundle.Txs {
+		if tx.Protected() && !env.config.IsEIP155(env.header.Number) {
+			revert()
+			return nil, errBundleFailed
+		}
+		env.state.Prepare(tx.Hash(), common.Hash{}, env.tcount)
+
+		err, txLogs := env.commitTransaction(tx, bc, coinbase, gp)
+		if err != nil {
+			log.Trace("Bundle transaction failed", "hash", tx.Hash(), "err", err)
+			revert()
+			return nil, errBundleFailed
+		}
+		if env.receipts[len(env.receipts)-1].Status == types.ReceiptStatusFailed {
+			log.Trace("Bundle transaction reverted", "hash", tx.Hash())
+			revert()
+			return nil, errBundleFailed
+		}
+		logs = append(logs, txLogs...)
+		env.tcount++
+	}
+	return logs, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus/huchash"
+	"github.com/happyuc-project/happyuc-go/core"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/core/vm"
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/hucdb"
+	"github.com/happyuc-project/happyuc-go/params"
+)
+
+// Tests that if any transaction of a bundle fails, all the state changes done
+// by the preceding transactions of the same bundle are rolled back.
+func TestBundleAtomicRevert(t *testing.T) {
+	var (
+		key, _  = crypto.GenerateKey()
+		addr    = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000000000)
+		db, _   = hucdb.NewMemDatabase()
+		gspec   = &core.Genesis{Config: params.TestChainConfig, Alloc: core.GenesisAlloc{addr: {Balance: funds}}}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(params.TestChainConfig.ChainId)
+	)
+	chain, err := core.NewBlockChain(db, nil, params.TestChainConfig, huchash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	statedb, err := chain.StateAt(genesis.Root())
+	if err != nil {
+		t.Fatalf("failed to retrieve genesis state: %v", err)
+	}
+	env := &Work{
+		config: params.TestChainConfig,
+		signer: signer,
+		state:  statedb,
+		header: &types.Header{
+			ParentHash: genesis.Hash(),
+			Number:     big.NewInt(1),
+			GasLimit:   genesis.GasLimit(),
+			Difficulty: big.NewInt(1),
+			Time:       new(big.Int).Add(genesis.Time(), common.Big1),
+		},
+	}
+	sign := func(nonce uint64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, common.Address{1}, big.NewInt(1000), 21000, big.NewInt(1), nil), signer, key)
+		return tx
+	}
+	// The second transaction skips a nonce, so it must fail and revert the first
+	bundle := &Bundle{Txs: types.Transactions{sign(0), sign(2)}, BlockNumber: 1}
+	if _, err := env.commitBundle(bundle, chain, common.Address{}); err != errBundleFailed {
+		t.Fatalf("bundle error mismatch: have %v, want %v", err, errBundleFailed)
+	}
+	if nonce := env.state.GetNonce(addr); nonce != 0 {
+		t.Errorf("sender nonce mismatch: have %d, want 0", nonce)
+	}
+	if balance := env.state.GetBalance(addr); balance.Cmp(funds) != 0 {
+		t.Errorf("sender balance mismatch: have %v, want %v", balance, funds)
+	}
+	if balance := env.state.GetBalance(common.Address{1}); balance.Sign() != 0 {
+		t.Errorf("recipient balance mismatch: have %v, want 0", balance)
+	}
+	if len(env.txs) != 0 || len(env.receipts) != 0 || env.tcount != 0 || env.header.GasUsed != 0 {
+		t.Errorf("work not reverted: txs %d, receipts %d, tcount %d, gas %d", len(env.txs), len(env.receipts), env.tcount, env.header.GasUsed)
+	}
+	// A fully valid bundle must be included in its entirety
+	bundle = &Bundle{Txs: types.Transactions{sign(0), sign(1)}, BlockNumber: 1}
+	if _, err := env.commitBundle(bundle, chain, common.Address{}); err != nil {
+		t.Fatalf("failed to commit valid bundle: %v", err)
+	}
+	if nonce := env.state.GetNonce(addr); nonce != 2 {
+		t.Errorf("sender nonce mismatch: have %d, want 2", nonce)
+	}
+	if len(env.txs) != 2 || env.tcount != 2 || env.header.GasUsed != 42000 {
+		t.Errorf("work mismatch: txs %d, tcount %d, gas %d", len(env.txs), env.tcount, env.header.GasUsed)
+	}
+}
@@ -57,12 +57,12 @@ type Miner struct {
 	shouldStart int32 // should start indicates whether we should start after sync
 }
 
-func New(eth Backend, config *params.ChainConfig, mux *event.TypeMux, engine consensus.Engine) *Miner {
+func New(eth Backend, config *params.ChainConfig, mux *event.TypeMux, engine consensus.Engine, gasFloor, gasCeil uint64) *Miner {
 	miner := &Miner{
 		eth:      eth,
 		mux:      mux,
 		engine:   engine,
-		worker:   newWorker(config, engine, common.Address{}, eth, mux),
+		worker:   newWorker(config, engine, common.Address{}, eth, mux, gasFloor, gasCeil),
 		canStart: 1,
 	}
 	miner.Register(NewCpuAgent(eth.BlockChain(), engine))
@@ -163,6 +163,44 @@ func (self *Miner) SetExtra(extra []byte) error {
 	return nil
 }
 
+// SetGasLimits sets the gas floor and ceiling the miner votes the block gas limit
+// towards. The gas limit of each new block may only move by a bounded amount
+// relative to its parent, so the targets are approached gradually.
+func (self *Miner) SetGasLimits(floor, ceil uint64) error {
+	if floor < params.MinGasLimit {
+		return fmt.Errorf("gas floor below protocol minimum: %d < %d", floor, params.MinGasLimit)
+	}
+	if floor > ceil {
+		return fmt.Errorf("gas floor above ceiling: %d > %d", floor, ceil)
+	}
+	self.worker.setGasLimits(floor, ceil)
+	return nil
+}
+
+// GasLimits returns the gas floor and ceiling the miner votes towards.
+func (self *Miner) GasLimits() (floor uint64, ceil uint64) {
+	return self.worker.gasLimits()
+}
+
+// SetOrderer replaces the policy used to order pending transactions in newly
+// assembled blocks.
+func (self *Miner) SetOrderer(orderer Orderer) {
+	self.worker.setOrderer(orderer)
+}
+
+// SubmitBundle schedules a list of transactions for atomic inclusion at the top
+// of the block with the given number. The bundle is only included if all of its
+// transactions execute successfully.
+func (self *Miner) SubmitBundle(txs types.Transactions, number uint64) (common.Hash, error) {
+	if len(txs) == 0 {
+		return common.Hash{}, errEmptyBundle
+	}
+	bundle := &Bundle{Txs: txs, BlockNumber: number}
+	self.worker.addBundle(bundle)
+
+	return bundle.Hash(), nil
+}
+
 // Pending returns the currently pending block and associated state.
 func (self *Miner) Pending() (*types.Block, *state.StateDB) {
 	return self.worker.pending()
@@ -0,0 +1,211 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core/types"
+)
+
+// TransactionSet is a nonce-honouring iterator over a set of pending transactions
+// that the worker drains while filling a block.
+type TransactionSet interface {
+	// Peek returns the next transaction to commit, or nil if the set is exhausted.
+	Peek() *types.Transaction
+
+	// Shift replaces the current head with the next transaction from the same account.
+	Shift()
+
+	// Pop removes the current head without replacing it with the next one from
+	// the same account, dropping all subsequent transactions of that account.
+	Pop()
+}
+
+// Orderer decides in which order pending transactions are committed into a new
+// block. Implementations must honour account nonces, all other ordering policy
+// is up to them.
+type Orderer interface {
+	// Order creates a transaction set over the given pending transactions. The
+	// per-account lists are nonce sorted and the map is reowned by the orderer.
+	Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet
+}
+
+// txObserver is an optional interface implemented by orderers that need to be
+// notified of every transaction entering the pool.
+type txObserver interface {
+	Observe(tx *types.Transaction)
+}
+
+// txPruner is an optional interface implemented by orderers that track state
+// about pool transactions and need to forget the ones no longer in the pool.
+type txPruner interface {
+	Prune(known func(hash common.Hash) bool)
+}
+
+// PriceOrderer is the default orderer, committing transactions in a profit
+// maximizing order.
+type PriceOrderer struct{}
+
+// Order implements Orderer, sorting transactions by price and nonce.
+func (PriceOrderer) Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet {
+	return types.NewTransactionsByPriceAndNonce(signer, pending)
+}
+
+// FCFSOrderer commits transactions in the order they were first seen by the
+// node, regardless of their gas price. Transactions from the same account are
+// still included in nonce order.
+type FCFSOrderer struct {
+	lock sync.Mutex
+	seen map[common.Hash]uint64 // Arrival sequence numbers of known transactions
+	next uint64                 // Sequence number to assign to the next arrival
+}
+
+// NewFCFSOrderer creates a first-come-first-served transaction orderer.
+func NewFCFSOrderer() *FCFSOrderer {
+	return &FCFSOrderer{
+		seen: make(map[common.Hash]uint64),
+	}
+}
+
+// Observe records the arrival of a transaction. Transactions are only stamped
+// the first time they are seen, subsequent announcements are ignored.
+func (o *FCFSOrderer) Observe(tx *types.Transaction) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.observe(tx.Hash())
+}
+
+// observe assigns an arrival sequence number to a hash if it has none yet. The
+// lock must be held by the caller.
+func (o *FCFSOrderer) observe(hash common.Hash) uint64 {
+	if seq, ok := o.seen[hash]; ok {
+		return seq
+	}
+	seq := o.next
+	o.seen[hash] = seq
+	o.next++
+	return seq
+}
+
+// Prune forgets the arrival stamps of all transactions no longer known to the
+// pool. Queued transactions retain their stamps until they are dropped.
+func (o *FCFSOrderer) Prune(known func(hash common.Hash) bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for hash := range o.seen {
+		if !known(hash) {
+			delete(o.seen, hash)
+		}
+	}
+}
+
+// Order implements Orderer, sorting transactions by their arrival time. Any
+// transaction not seen before is stamped on the spot.
+func (o *FCFSOrderer) Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	seqs := make(map[common.Hash]uint64)
+	for _, txs := range pending {
+		for _, tx := range txs {
+			hash := tx.Hash()
+			seqs[hash] = o.observe(hash)
+		}
+	}
+	return newTransactionsByArrival(signer, pending, seqs)
+}
+
+// transactionsByArrival is a TransactionSet returning transactions in arrival
+// order while honouring account nonces.
+type transactionsByArrival struct {
+	txs    map[common.Address]types.Transactions // Per account nonce-sorted list of transactions
+	heads  txByArrival                           // Next transaction for each unique account (arrival heap)
+	signer types.Signer                          // Signer for the set of transactions
+}
+
+func newTransactionsByArrival(signer types.Signer, txs map[common.Address]types.Transactions, seqs map[common.Hash]uint64) *transactionsByArrival {
+	heads := txByArrival{seqs: seqs}
+	for _, accTxs := range txs {
+		if len(accTxs) == 0 {
+			continue
+		}
+		heads.txs = append(heads.txs, accTxs[0])
+		acc, _ := types.Sender(signer, accTxs[0])
+		txs[acc] = accTxs[1:]
+	}
+	heap.Init(&heads)
+
+	return &transactionsByArrival{
+		txs:    txs,
+		heads:  heads,
+		signer: signer,
+	}
+}
+
+// Peek implements TransactionSet, returning the earliest seen executable transaction.
+func (t *transactionsByArrival) Peek() *types.Transaction {
+	if len(t.heads.txs) == 0 {
+		return nil
+	}
+	return t.heads.txs[0]
+}
+
+// Shift implements TransactionSet.
+func (t *transactionsByArrival) Shift() {
+	acc, _ := types.Sender(t.signer, t.heads.txs[0])
+	if txs, ok := t.txs[acc]; ok && len(txs) > 0 {
+		t.heads.txs[0], t.txs[acc] = txs[0], txs[1:]
+		heap.Fix(&t.heads, 0)
+	} else {
+		heap.Pop(&t.heads)
+	}
+}
+
+// Pop implements TransactionSet.
+func (t *transactionsByArrival) Pop() {
+	heap.Pop(&t.heads)
+}
+
+// txByArrival implements heap.Interface, ordering transactions by their arrival
+// sequence number.
+type txByArrival struct {
+	txs  types.Transactions
+	seqs map[common.Hash]uint64
+}
+
+func (s txByArrival) Len() int { return len(s.txs) }
+func (s txByArrival) Less(i, j int) bool {
+	return s.seqs[s.txs[i].Hash()] < s.seqs[s.txs[j].Hash()]
+}
+func (s txByArrival) Swap(i, j int) { s.txs[i], s.txs[j] = s.txs[j], s.txs[i] }
+
+func (s *txByArrival) Push(x interface{}) {
+	s.txs = append(s.txs, x.(*types.Transaction))
+}
+
+func (s *txByArrival) Pop() interface{} {
+	old := s.txs
+	n := len(old)
+	x := old[n-1]
+	s.txs = old[0 : n-1]
+	return x
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/crypto"
+)
+
+// Tests that the first-come-first-served orderer returns transactions in their
+// order of arrival, while still honouring account nonces.
+func TestFCFSOrdering(t *testing.T) {
+	var (
+		signer = types.HomesteadSigner{}
+		keys   = make([]*ecdsa.PrivateKey, 3)
+		addrs  = make([]common.Address, 3)
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	sign := func(key *ecdsa.PrivateKey, nonce uint64, price int64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(price), nil), signer, key)
+		return tx
+	}
+	// Create transactions whose arrival order contradicts their gas prices
+	var (
+		a0 = sign(keys[0], 0, 1)
+		b0 = sign(keys[1], 0, 100)
+		a1 = sign(keys[0], 1, 1000)
+		c0 = sign(keys[2], 0, 10)
+	)
+	orderer := NewFCFSOrderer()
+	for _, tx := range []*types.Transaction{a1, c0, b0, a0} {
+		orderer.Observe(tx)
+	}
+	pending := map[common.Address]types.Transactions{
+		addrs[0]: {a0, a1},
+		addrs[1]: {b0},
+		addrs[2]: {c0},
+	}
+	set := orderer.Order(signer, pending)
+
+	var got []*types.Transaction
+	for tx := set.Peek(); tx != nil; tx = set.Peek() {
+		got = append(got, tx)
+		set.Shift()
+	}
+	// a1 arrived first but is blocked by a0, so it must wait for it
+	want := []*types.Transaction{c0, b0, a0, a1}
+	if len(got) != len(want) {
+		t.Fatalf("transaction count mismatch: have %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("transaction %d mismatch: have %x, want %x", i, got[i].Hash(), want[i].Hash())
+		}
+	}
+}
+
+// Tests that transactions which are not yet pending keep their arrival stamp,
+// so they are not reordered behind later arrivals once promoted.
+func TestFCFSQueuedStamps(t *testing.T) {
+	var (
+		signer  = types.HomesteadSigner{}
+		key1, _ = crypto.GenerateKey()
+		key2, _ = crypto.GenerateKey()
+	)
+	sign := func(key *ecdsa.PrivateKey, nonce uint64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil), signer, key)
+		return tx
+	}
+	var (
+		queued = sign(key1, 0)
+		later  = sign(key2, 0)
+	)
+	orderer := NewFCFSOrderer()
+	orderer.Observe(queued)
+
+	// Order a block without the queued transaction, pruning against a pool
+	// that still contains it
+	orderer.Observe(later)
+	orderer.Prune(func(hash common.Hash) bool { return hash == queued.Hash() || hash == later.Hash() })
+	orderer.Order(signer, map[common.Address]types.Transactions{
+		crypto.PubkeyToAddress(key2.PublicKey): {later},
+	})
+	// Promote the queued transaction and ensure it retained its precedence
+	set := orderer.Order(signer, map[common.Address]types.Transactions{
+		crypto.PubkeyToAddress(key1.PublicKey): {queued},
+		crypto.PubkeyToAddress(key2.PublicKey): {later},
+	})
+	if tx := set.Peek(); tx != queued {
+		t.Fatalf("first transaction mismatch: have %x, want %x", tx.Hash(), queued.Hash())
+	}
+	// Drop the transaction from the pool and ensure its stamp is forgotten
+	orderer.Prune(func(hash common.Hash) bool { return hash == later.Hash() })
+	if _, ok := orderer.seen[queued.Hash()]; ok {
+		t.Errorf("stamp of dropped transaction retained")
+	}
+	if _, ok := orderer.seen[later.Hash()]; !ok {
+		t.Errorf("stamp of pooled transaction dropped")
+	}
+}
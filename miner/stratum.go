@@ -0,0 +1,689 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus"
+	"github.com/happyuc-project/happyuc-go/consensus/huchash"
+	"github.com/happyuc-project/happyuc-go/core/types"
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/metrics"
+)
+
+const (
+	stratumProtocol     = "EthereumStratum/1.0.0"
+	stratumMaxLine      = 4096             // Maximum length of a single JSON request line
+	stratumIdleTimeout  = 10 * time.Minute // Time after which silent miners are disconnected
+	stratumWriteTimeout = 10 * time.Second // Time allowance for pushing a single message
+	stratumJobLifetime  = 7 * (12 * time.Second)
+	stratumRateWindow   = 10 * time.Minute // Window over which worker hashrates are averaged
+	stratumExtranonce   = 2                // Number of nonce bytes reserved for the server
+)
+
+var (
+	// stratumUnitDifficulty is the share difficulty that stratum miners know as
+	// difficulty 1.
+	stratumUnitDifficulty = new(big.Int).Lsh(common.Big1, 32)
+
+	// maxUint256 is a big integer representing 2^256, the boundary of PoW values.
+	maxUint256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
+)
+
+var (
+	stratumSessionGauge  = metrics.NewRegisteredGauge("miner/stratum/sessions", nil)
+	stratumHashrateGauge = metrics.NewRegisteredGauge("miner/stratum/hashrate", nil)
+	stratumAcceptedMeter = metrics.NewRegisteredMeter("miner/stratum/shares/accepted", nil)
+	stratumRejectedMeter = metrics.NewRegisteredMeter("miner/stratum/shares/rejected", nil)
+	stratumStaleMeter    = metrics.NewRegisteredMeter("miner/stratum/shares/stale", nil)
+	stratumBlockMeter    = metrics.NewRegisteredMeter("miner/stratum/blocks", nil)
+)
+
+// Stratum error codes returned to miners, following the original stratum protocol.
+var (
+	errStratumUnknown       = &stratumError{20, "Other/Unknown"}
+	errStratumJobNotFound   = &stratumError{21, "Job not found"}
+	errStratumDuplicate     = &stratumError{22, "Duplicate share"}
+	errStratumLowDifficulty = &stratumError{23, "Low difficulty share"}
+	errStratumUnauthorized  = &stratumError{24, "Unauthorized worker"}
+	errStratumNotSubscribed = &stratumError{25, "Not subscribed"}
+)
+
+// hashimotoer is the part of the huchash engine needed to validate shares whose
+// difficulty is below that of the block being mined.
+type hashimotoer interface {
+	Hashimoto(header *types.Header) (common.Hash, *big.Int)
+}
+
+// stratumError is an error reported back to a stratum miner.
+type stratumError struct {
+	code    int
+	message string
+}
+
+func (e *stratumError) Error() string { return e.message }
+
+// MarshalJSON encodes the error in the [code, message, traceback] stratum format.
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.code, e.message, nil})
+}
+
+// stratumRequest is a JSON request sent by a miner.
+type stratumRequest struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// stratumResponse is a JSON reply to a miner request.
+type stratumResponse struct {
+	Id     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  *stratumError   `json:"error"`
+}
+
+// stratumNotification is a JSON message pushed to a miner unsolicited.
+type stratumNotification struct {
+	Id     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// stratumJob is a work package handed out to stratum miners.
+type stratumJob struct {
+	id     string
+	work   *Work
+	seed   common.Hash
+	hash   common.Hash
+	nonces map[uint64]struct{} // Nonces already submitted for this job
+}
+
+// stratumShare is an accepted share used for hashrate estimation.
+type stratumShare struct {
+	time       time.Time
+	difficulty *big.Int
+}
+
+// stratumWorker tracks the statistics of a single named remote worker.
+type stratumWorker struct {
+	shares   []stratumShare
+	accepted uint64
+	rejected uint64
+	stale    uint64
+}
+
+// hashrate estimates the hashrate of the worker from the difficulty of the shares
+// it submitted within the averaging window.
+func (w *stratumWorker) hashrate(now time.Time) uint64 {
+	work := new(big.Int)
+	for _, share := range w.shares {
+		if now.Sub(share.time) <= stratumRateWindow {
+			work.Add(work, share.difficulty)
+		}
+	}
+	return work.Div(work, big.NewInt(int64(stratumRateWindow/time.Second))).Uint64()
+}
+
+// StratumServer is a mining agent serving work to remote miners over the
+// EthereumStratum/1.0 TCP protocol. New work is pushed to all connected miners
+// as soon as it is available and shares are validated against per-worker
+// difficulties to track the hashrate of each worker.
+type StratumServer struct {
+	chain      consensus.ChainReader
+	engine     consensus.Engine
+	hasher     hashimotoer
+	difficulty *big.Int // Default share difficulty for new sessions
+
+	listener net.Listener
+	sessions map[*stratumSession]struct{}
+	jobs     map[string]*stratumJob
+	current  *stratumJob
+	workers  map[string]*stratumWorker
+
+	nextJob        uint64
+	nextExtranonce uint16
+
+	quitCh   chan struct{}
+	workCh   chan *Work
+	returnCh chan<- *Result
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	running int32 // running indicates whether the agent is active. Call atomically
+}
+
+// NewStratumServer creates a stratum mining agent. The engine must be a huchash
+// instance, as stratum only makes sense for proof-of-work; difficulty is the
+// default share difficulty assigned to miners which don't request their own.
+func NewStratumServer(chain consensus.ChainReader, engine consensus.Engine, difficulty *big.Int) (*StratumServer, error) {
+	hasher, ok := engine.(hashimotoer)
+	if !ok {
+		return nil, errors.New("stratum requires a huchash consensus engine")
+	}
+	if difficulty == nil || difficulty.Sign() <= 0 {
+		difficulty = stratumUnitDifficulty
+	}
+	return &StratumServer{
+		chain:      chain,
+		engine:     engine,
+		hasher:     hasher,
+		difficulty: new(big.Int).Set(difficulty),
+		sessions:   make(map[*stratumSession]struct{}),
+		jobs:       make(map[string]*stratumJob),
+		workers:    make(map[string]*stratumWorker),
+	}, nil
+}
+
+// Listen starts accepting stratum connections on the given TCP endpoint.
+func (s *StratumServer) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	log.Info("Stratum server started", "addr", listener.Addr())
+
+	s.wg.Add(1)
+	go s.accept(listener)
+	return nil
+}
+
+// Addr returns the listening address of the server, or nil if not listening.
+func (s *StratumServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops accepting new connections and disconnects all miners.
+func (s *StratumServer) Close() {
+	s.mu.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	for session := range s.sessions {
+		session.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *StratumServer) Work() chan<- *Work {
+	return s.workCh
+}
+
+func (s *StratumServer) SetReturnCh(returnCh chan<- *Result) {
+	s.returnCh = returnCh
+}
+
+func (s *StratumServer) Start() {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return
+	}
+	s.quitCh = make(chan struct{})
+	s.workCh = make(chan *Work, 1)
+	go s.loop(s.workCh, s.quitCh)
+}
+
+func (s *StratumServer) Stop() {
+	if !atomic.CompareAndSwapInt32(&s.running, 1, 0) {
+		return
+	}
+	close(s.quitCh)
+	close(s.workCh)
+}
+
+// GetHashRate returns the accumulated hashrate of all remote workers combined.
+func (s *StratumServer) GetHashRate() (tot int64) {
+	for _, rate := range s.Hashrates() {
+		tot += int64(rate)
+	}
+	return tot
+}
+
+// Hashrates returns the estimated hashrate of each remote worker.
+func (s *StratumServer) Hashrates() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	rates := make(map[string]uint64, len(s.workers))
+	for name, worker := range s.workers {
+		rates[name] = worker.hashrate(now)
+	}
+	return rates
+}
+
+// loop monitors mining events on the work and quit channels, pushing every new
+// work package to the connected miners and expiring stale state.
+func (s *StratumServer) loop(workCh chan *Work, quitCh chan struct{}) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quitCh:
+			return
+
+		case work := <-workCh:
+			if work == nil {
+				continue
+			}
+			s.mu.Lock()
+			job := s.newJob(work)
+			for session := range s.sessions {
+				session.notify(job, true)
+			}
+			s.mu.Unlock()
+
+		case <-ticker.C:
+			s.mu.Lock()
+			for id, job := range s.jobs {
+				if time.Since(job.work.createdAt) > stratumJobLifetime {
+					delete(s.jobs, id)
+				}
+			}
+			var (
+				now  = time.Now()
+				rate uint64
+			)
+			for name, worker := range s.workers {
+				for len(worker.shares) > 0 && now.Sub(worker.shares[0].time) > stratumRateWindow {
+					worker.shares = worker.shares[1:]
+				}
+				if len(worker.shares) == 0 {
+					delete(s.workers, name)
+					continue
+				}
+				rate += worker.hashrate(now)
+			}
+			s.mu.Unlock()
+
+			stratumHashrateGauge.Update(int64(rate))
+		}
+	}
+}
+
+// newJob creates a new stratum job from a work package and makes it current. The
+// lock must be held by the caller.
+func (s *StratumServer) newJob(work *Work) *stratumJob {
+	block := work.Block
+	job := &stratumJob{
+		id:     strconv.FormatUint(s.nextJob, 16),
+		work:   work,
+		seed:   common.BytesToHash(huchash.SeedHash(block.NumberU64())),
+		hash:   block.HashNoNonce(),
+		nonces: make(map[uint64]struct{}),
+	}
+	s.nextJob++
+
+	s.jobs[job.id] = job
+	s.current = job
+	return job
+}
+
+// accept runs the listener loop, spinning up a new session for every inbound
+// connection until the listener is closed.
+func (s *StratumServer) accept(listener net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				log.Debug("Temporary stratum accept error", "err", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			return
+		}
+		s.mu.Lock()
+		session := &stratumSession{
+			server:     s,
+			conn:       conn,
+			enc:        json.NewEncoder(conn),
+			difficulty: s.difficulty,
+			workers:    make(map[string]struct{}),
+			jobCh:      make(chan struct{}, 1),
+		}
+		binary.BigEndian.PutUint16(session.extranonce[:], s.nextExtranonce)
+		s.nextExtranonce++
+		s.sessions[session] = struct{}{}
+		stratumSessionGauge.Update(int64(len(s.sessions)))
+		s.mu.Unlock()
+
+		log.Debug("Stratum miner connected", "addr", conn.RemoteAddr())
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			session.serve()
+
+			s.mu.Lock()
+			delete(s.sessions, session)
+			stratumSessionGauge.Update(int64(len(s.sessions)))
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// submit validates a share submitted by a worker, forwarding it to the miner as
+// a sealed block if it also satisfies the block difficulty.
+func (s *StratumServer) submit(session *stratumSession, worker string, jobId string, nonce uint64) error {
+	result, returnCh, err := s.checkShare(session, worker, jobId, nonce)
+	if err != nil || result == nil {
+		return err
+	}
+	// Deliver the sealed block outside of the lock, the miner may be busy
+	returnCh <- result
+	return nil
+}
+
+// checkShare validates a share and updates the worker statistics, returning the
+// sealed block and the channel to deliver it on if the share is a full solution.
+// The share is hashed outside of the server lock, as that may need to generate
+// the verification cache of a new epoch.
+func (s *StratumServer) checkShare(session *stratumSession, worker string, jobId string, nonce uint64) (*Result, chan<- *Result, error) {
+	s.mu.Lock()
+	stats := s.workers[worker]
+	if stats == nil {
+		stats = new(stratumWorker)
+		s.workers[worker] = stats
+	}
+	job := s.jobs[jobId]
+	if job == nil {
+		stats.stale++
+		stratumStaleMeter.Mark(1)
+		s.mu.Unlock()
+		return nil, nil, errStratumJobNotFound
+	}
+	if _, ok := job.nonces[nonce]; ok {
+		stats.rejected++
+		stratumRejectedMeter.Mark(1)
+		s.mu.Unlock()
+		return nil, nil, errStratumDuplicate
+	}
+	job.nonces[nonce] = struct{}{}
+
+	header := job.work.Block.Header()
+	header.Nonce = types.EncodeNonce(nonce)
+	difficulty := session.difficulty
+	s.mu.Unlock()
+
+	digest, result := s.hasher.Hashimoto(header)
+
+	s.mu.Lock()
+	if result.Cmp(new(big.Int).Div(maxUint256, difficulty)) > 0 {
+		stats.rejected++
+		stratumRejectedMeter.Mark(1)
+		s.mu.Unlock()
+		return nil, nil, errStratumLowDifficulty
+	}
+	stats.accepted++
+	stats.shares = append(stats.shares, stratumShare{time.Now(), difficulty})
+	stratumAcceptedMeter.Mark(1)
+	s.mu.Unlock()
+
+	if result.Cmp(new(big.Int).Div(maxUint256, header.Difficulty)) > 0 {
+		return nil, nil, nil
+	}
+	// The share is a full solution, seal the block and hand it to the worker
+	header.MixDigest = digest
+	if err := s.engine.VerifySeal(s.chain, header); err != nil {
+		log.Warn("Invalid proof-of-work submitted", "worker", worker, "hash", job.hash, "err", err)
+		return nil, nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if atomic.LoadInt32(&s.running) == 0 || s.returnCh == nil {
+		return nil, nil, nil
+	}
+	log.Info("Stratum miner found block", "worker", worker, "number", header.Number, "hash", job.hash)
+	stratumBlockMeter.Mark(1)
+
+	delete(s.jobs, jobId)
+
+	return &Result{job.work, job.work.Block.WithSeal(header)}, s.returnCh, nil
+}
+
+// stratumSession is a single TCP connection of a stratum miner. The session state
+// is only modified by the session's own goroutine, while holding the server lock.
+type stratumSession struct {
+	server *StratumServer
+	conn   net.Conn
+
+	enc   *json.Encoder
+	encMu sync.Mutex
+
+	jobCh   chan struct{}        // Wakes the job writer up when a new job is queued
+	jobMu   sync.Mutex           // Protects the queued job
+	nextJob *stratumNotification // Latest job not yet pushed to the miner
+
+	extranonce [stratumExtranonce]byte
+	difficulty *big.Int            // Share difficulty assigned to the session
+	workers    map[string]struct{} // Workers authorized over this connection
+	subscribed bool
+}
+
+// serve reads and handles requests from the miner until the connection drops.
+func (s *stratumSession) serve() {
+	defer s.conn.Close()
+
+	quit := make(chan struct{})
+	defer close(quit)
+	go s.pushJobs(quit)
+
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 0, stratumMaxLine), stratumMaxLine)
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(stratumIdleTimeout))
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				log.Debug("Stratum miner dropped", "addr", s.conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var req stratumRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			log.Debug("Malformed stratum request", "addr", s.conn.RemoteAddr(), "err", err)
+			return
+		}
+		result, err := s.handle(&req)
+
+		res := &stratumResponse{Id: req.Id, Result: result}
+		if err != nil {
+			if serr, ok := err.(*stratumError); ok {
+				res.Error = serr
+			} else {
+				res.Error = &stratumError{errStratumUnknown.code, err.Error()}
+			}
+		}
+		if err := s.send(res); err != nil {
+			return
+		}
+		// Once authorized, bring the miner up to speed with the current state
+		if req.Method == "mining.authorize" && err == nil {
+			s.sendDifficulty()
+
+			s.server.mu.Lock()
+			if job := s.server.current; job != nil {
+				s.notify(job, true)
+			}
+			s.server.mu.Unlock()
+		}
+	}
+}
+
+// handle processes a single stratum request, returning the result to reply with.
+func (s *stratumSession) handle(req *stratumRequest) (interface{}, error) {
+	var params []string
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, fmt.Errorf("invalid params: %v", err)
+		}
+	}
+	switch req.Method {
+	case "mining.subscribe":
+		s.server.mu.Lock()
+		s.subscribed = true
+		s.server.mu.Unlock()
+
+		notify := []string{"mining.notify", hex.EncodeToString(s.extranonce[:]), stratumProtocol}
+		return []interface{}{notify, hex.EncodeToString(s.extranonce[:])}, nil
+
+	case "mining.extranonce.subscribe":
+		return true, nil
+
+	case "mining.authorize":
+		if !s.subscribed {
+			return nil, errStratumNotSubscribed
+		}
+		if len(params) < 1 || params[0] == "" {
+			return nil, errStratumUnauthorized
+		}
+		// Miners may request their own share difficulty in the password field
+		s.server.mu.Lock()
+		defer s.server.mu.Unlock()
+
+		if len(params) > 1 {
+			for _, opt := range strings.Split(params[1], ",") {
+				if strings.HasPrefix(opt, "d=") {
+					diff, ok := new(big.Int).SetString(strings.TrimPrefix(opt, "d="), 10)
+					if !ok || diff.Sign() <= 0 {
+						return nil, fmt.Errorf("invalid difficulty %q", opt)
+					}
+					s.difficulty = diff
+				}
+			}
+		}
+		s.workers[params[0]] = struct{}{}
+		return true, nil
+
+	case "mining.submit":
+		if len(params) < 3 {
+			return nil, errors.New("invalid submit params")
+		}
+		if _, ok := s.workers[params[0]]; !ok {
+			return nil, errStratumUnauthorized
+		}
+		suffix, err := hex.DecodeString(strings.TrimPrefix(params[2], "0x"))
+		if err != nil || len(suffix) != 8-stratumExtranonce {
+			return nil, fmt.Errorf("invalid nonce %q", params[2])
+		}
+		nonce := binary.BigEndian.Uint64(append(s.extranonce[:], suffix...))
+		if err := s.server.submit(s, params[0], params[1], nonce); err != nil {
+			return false, err
+		}
+		return true, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported method %q", req.Method)
+	}
+}
+
+// notify queues a job to be pushed to the miner if it's ready to receive work.
+// Jobs are written by a single goroutine, so they reach the miner in order, and
+// a job superseded before it could be written is never sent.
+func (s *stratumSession) notify(job *stratumJob, clean bool) {
+	if !s.subscribed || len(s.workers) == 0 {
+		return
+	}
+	s.jobMu.Lock()
+	s.nextJob = &stratumNotification{
+		Method: "mining.notify",
+		Params: []interface{}{job.id, hex.EncodeToString(job.seed[:]), hex.EncodeToString(job.hash[:]), clean},
+	}
+	s.jobMu.Unlock()
+
+	select {
+	case s.jobCh <- struct{}{}:
+	default:
+	}
+}
+
+// pushJobs writes the queued jobs to the miner until the session ends.
+func (s *stratumSession) pushJobs(quit chan struct{}) {
+	for {
+		select {
+		case <-s.jobCh:
+			s.jobMu.Lock()
+			msg := s.nextJob
+			s.nextJob = nil
+			s.jobMu.Unlock()
+
+			if msg != nil && s.send(msg) != nil {
+				return
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// sendDifficulty pushes the share difficulty of the session to the miner, in
+// the stratum units of 2^32 hashes.
+func (s *stratumSession) sendDifficulty() {
+	diff, _ := new(big.Rat).SetFrac(s.difficulty, stratumUnitDifficulty).Float64()
+
+	s.send(&stratumNotification{
+		Method: "mining.set_difficulty",
+		Params: []interface{}{diff},
+	})
+}
+
+// send writes a single JSON message to the miner.
+func (s *stratumSession) send(msg interface{}) error {
+	s.encMu.Lock()
+	defer s.encMu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+	if err := s.enc.Encode(msg); err != nil {
+		log.Debug("Failed to send stratum message", "addr", s.conn.RemoteAddr(), "err", err)
+		s.conn.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common"
+	"github.com/happyuc-project/happyuc-go/consensus/huchash"
+	"github.com/happyuc-project/happyuc-go/core/types"
+)
+
+// stratumTestMiner is a minimal stratum client driving a server in tests.
+type stratumTestMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	nextId int
+}
+
+func (m *stratumTestMiner) call(method string, params ...interface{}) map[string]interface{} {
+	m.nextId++
+	req, _ := json.Marshal(map[string]interface{}{"id": m.nextId, "method": method, "params": params})
+	if _, err := m.conn.Write(append(req, '\n')); err != nil {
+		m.t.Fatalf("failed to send %s: %v", method, err)
+	}
+	for {
+		msg := m.read()
+		if id, ok := msg["id"].(float64); ok && int(id) == m.nextId {
+			return msg
+		}
+	}
+}
+
+func (m *stratumTestMiner) read() map[string]interface{} {
+	m.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := m.reader.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatalf("failed to read message: %v", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		m.t.Fatalf("failed to decode message %q: %v", line, err)
+	}
+	return msg
+}
+
+func (m *stratumTestMiner) expect(method string) []interface{} {
+	for {
+		if msg := m.read(); msg["method"] == method {
+			return msg["params"].([]interface{})
+		}
+	}
+}
+
+// Tests the full stratum flow of subscribing, receiving work and submitting a
+// share that seals a block.
+func TestStratumSubmit(t *testing.T) {
+	server, err := NewStratumServer(nil, huchash.NewFaker(), big.NewInt(1000))
+	if err != nil {
+		t.Fatalf("failed to create stratum server: %v", err)
+	}
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start stratum server: %v", err)
+	}
+	defer server.Close()
+
+	results := make(chan *Result, 1)
+	server.SetReturnCh(results)
+	server.Start()
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	miner := &stratumTestMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	// Submitting before authorization must be rejected
+	if res := miner.call("mining.submit", "worker", "0", "000000000001"); res["error"] == nil {
+		t.Fatalf("unauthorized share accepted")
+	}
+	res := miner.call("mining.subscribe", "tester/1.0", stratumProtocol)
+	if res["error"] != nil {
+		t.Fatalf("subscribe failed: %v", res["error"])
+	}
+	if res := miner.call("mining.authorize", "worker", "d=4294967296"); res["result"] != true {
+		t.Fatalf("authorize failed: %v", res["error"])
+	}
+	if diff := miner.expect("mining.set_difficulty"); diff[0] != float64(1) {
+		t.Fatalf("difficulty mismatch: have %v, want 1", diff[0])
+	}
+	// Push some work and ensure it's delivered to the miner
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+	server.Work() <- &Work{Block: types.NewBlockWithHeader(header), createdAt: time.Now()}
+
+	job := miner.expect("mining.notify")
+	if want := fmt.Sprintf("%x", header.HashNoNonce()); job[2] != want {
+		t.Fatalf("header hash mismatch: have %v, want %v", job[2], want)
+	}
+	if res := miner.call("mining.submit", "worker", job[0], "00000000002a"); res["result"] != true {
+		t.Fatalf("share rejected: %v", res["error"])
+	}
+	select {
+	case result := <-results:
+		if nonce := result.Block.Nonce(); nonce&0xffffffffffff != 42 {
+			t.Errorf("nonce mismatch: have %x, want suffix 2a", nonce)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("sealed block not returned")
+	}
+	// Resubmitting the same job must fail, since it was already sealed
+	if res := miner.call("mining.submit", "worker", job[0], "00000000002a"); res["result"] == true {
+		t.Fatalf("stale share accepted")
+	}
+	if rate := server.Hashrates()["worker"]; rate == 0 {
+		t.Errorf("worker hashrate not tracked")
+	}
+}
+
+// Tests that shares are validated against the real proof-of-work, rejecting any
+// whose result doesn't meet the share target of the session.
+func TestStratumLowDifficultyShare(t *testing.T) {
+	engine := huchash.NewTester()
+	server, err := NewStratumServer(nil, engine, nil)
+	if err != nil {
+		t.Fatalf("failed to create stratum server: %v", err)
+	}
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start stratum server: %v", err)
+	}
+	defer server.Close()
+
+	server.SetReturnCh(make(chan *Result, 1))
+	server.Start()
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	miner := &stratumTestMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	if res := miner.call("mining.subscribe", "tester/1.0", stratumProtocol); res["error"] != nil {
+		t.Fatalf("subscribe failed: %v", res["error"])
+	}
+	if res := miner.call("mining.authorize", "worker", "d=1024"); res["result"] != true {
+		t.Fatalf("authorize failed: %v", res["error"])
+	}
+	// Push work that is impossible to seal, so only the share target matters
+	header := &types.Header{Number: big.NewInt(1), Difficulty: new(big.Int).Lsh(common.Big1, 128)}
+	server.Work() <- &Work{Block: types.NewBlockWithHeader(header), createdAt: time.Now()}
+	job := miner.expect("mining.notify")
+
+	// Find a nonce below and one above the share target
+	var (
+		target    = new(big.Int).Div(maxUint256, big.NewInt(1024))
+		low, high = -1, -1
+	)
+	for nonce := 0; low < 0 || high < 0; nonce++ {
+		header.Nonce = types.EncodeNonce(uint64(nonce))
+		if _, result := engine.Hashimoto(header); result.Cmp(target) > 0 {
+			if low < 0 {
+				low = nonce
+			}
+		} else if high < 0 {
+			high = nonce
+		}
+	}
+	res := miner.call("mining.submit", "worker", job[0], fmt.Sprintf("%012x", low))
+	if res["result"] == true {
+		t.Fatalf("low difficulty share accepted")
+	}
+	if code := res["error"].([]interface{})[0]; code != float64(errStratumLowDifficulty.code) {
+		t.Fatalf("error code mismatch: have %v, want %d", code, errStratumLowDifficulty.code)
+	}
+	if res := miner.call("mining.submit", "worker", job[0], fmt.Sprintf("%012x", high)); res["result"] != true {
+		t.Fatalf("valid share rejected: %v", res["error"])
+	}
+}
+
+// blockingHasher is a proof-of-work hasher that blocks until released, used to
+// hold a share submission mid-verification.
+type blockingHasher struct {
+	hasher  hashimotoer
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHasher) Hashimoto(header *types.Header) (common.Hash, *big.Int) {
+	h.entered <- struct{}{}
+	<-h.release
+	return h.hasher.Hashimoto(header)
+}
+
+// Tests that verifying a share doesn't hold up the rest of the server, i.e. the
+// proof-of-work is not computed while holding the server lock.
+func TestStratumHashingUnlocked(t *testing.T) {
+	server, err := NewStratumServer(nil, huchash.NewFaker(), big.NewInt(1000))
+	if err != nil {
+		t.Fatalf("failed to create stratum server: %v", err)
+	}
+	hasher := &blockingHasher{hasher: server.hasher, entered: make(chan struct{}), release: make(chan struct{})}
+	server.hasher = hasher
+
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start stratum server: %v", err)
+	}
+	defer server.Close()
+
+	server.SetReturnCh(make(chan *Result, 1))
+	server.Start()
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	miner := &stratumTestMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	if res := miner.call("mining.subscribe", "tester/1.0", stratumProtocol); res["error"] != nil {
+		t.Fatalf("subscribe failed: %v", res["error"])
+	}
+	if res := miner.call("mining.authorize", "worker", ""); res["result"] != true {
+		t.Fatalf("authorize failed: %v", res["error"])
+	}
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+	server.Work() <- &Work{Block: types.NewBlockWithHeader(header), createdAt: time.Now()}
+	job := miner.expect("mining.notify")
+
+	// Submit a share without waiting for the reply and block it in the hasher
+	req, _ := json.Marshal(map[string]interface{}{"id": 100, "method": "mining.submit", "params": []interface{}{"worker", job[0], "00000000002a"}})
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		t.Fatalf("failed to submit share: %v", err)
+	}
+	select {
+	case <-hasher.entered:
+	case <-time.After(time.Second):
+		t.Fatalf("share verification not started")
+	}
+	// The server must keep serving while the share is being hashed
+	done := make(chan struct{})
+	go func() {
+		server.Hashrates()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		close(hasher.release)
+		t.Fatalf("server locked during share verification")
+	}
+	close(hasher.release)
+
+	for {
+		if msg := miner.read(); msg["id"] == float64(100) {
+			if msg["result"] != true {
+				t.Fatalf("share rejected: %v", msg["error"])
+			}
+			break
+		}
+	}
+}
+
+// Tests that jobs are pushed to a miner in the order they were created, with the
+// most recent one always arriving last.
+func TestStratumNotifyOrder(t *testing.T) {
+	server, err := NewStratumServer(nil, huchash.NewFaker(), big.NewInt(1000))
+	if err != nil {
+		t.Fatalf("failed to create stratum server: %v", err)
+	}
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start stratum server: %v", err)
+	}
+	defer server.Close()
+
+	server.SetReturnCh(make(chan *Result, 1))
+	server.Start()
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	miner := &stratumTestMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	if res := miner.call("mining.subscribe", "tester/1.0", stratumProtocol); res["error"] != nil {
+		t.Fatalf("subscribe failed: %v", res["error"])
+	}
+	if res := miner.call("mining.authorize", "worker", ""); res["result"] != true {
+		t.Fatalf("authorize failed: %v", res["error"])
+	}
+	// Push a burst of work and ensure job ids only ever increase
+	var last string
+	for i := 1; i <= 64; i++ {
+		header := &types.Header{Number: big.NewInt(int64(i)), Difficulty: big.NewInt(1)}
+		server.Work() <- &Work{Block: types.NewBlockWithHeader(header), createdAt: time.Now()}
+		last = fmt.Sprintf("%x", header.HashNoNonce())
+	}
+	prev := int64(-1)
+	for {
+		job := miner.expect("mining.notify")
+
+		var id int64
+		fmt.Sscanf(job[0].(string), "%x", &id)
+		if id <= prev {
+			t.Fatalf("job %d delivered after job %d", id, prev)
+		}
+		prev = id
+		if job[2] == last {
+			break
+		}
+	}
+}
@@ -113,6 +113,10 @@ type worker struct {
 
 	coinbase common.Address
 	extra    []byte
+	gasFloor uint64    // Target gas floor for mined blocks
+	gasCeil  uint64    // Target gas ceiling for mined blocks
+	orderer  Orderer   // Transaction ordering policy for new blocks
+	bundles  []*Bundle // Atomic bundles waiting for inclusion
 
 	currentMu sync.Mutex
 	current   *Work
@@ -127,7 +131,7 @@ type worker struct {
 	atWork int32
 }
 
-func newWorker(config *params.ChainConfig, engine consensus.Engine, coinbase common.Address, eth Backend, mux *event.TypeMux) *worker {
+func newWorker(config *params.ChainConfig, engine consensus.Engine, coinbase common.Address, eth Backend, mux *event.TypeMux, gasFloor, gasCeil uint64) *worker {
 	worker := &worker{
 		config:         config,
 		engine:         engine,
@@ -142,6 +146,9 @@ func newWorker(config *params.ChainConfig, engine consensus.Engine, coinbase com
 		proc:           eth.BlockChain().Validator(),
 		possibleUncles: make(map[common.Hash]*types.Block),
 		coinbase:       coinbase,
+		gasFloor:       gasFloor,
+		gasCeil:        gasCeil,
+		orderer:        PriceOrderer{},
 		agents:         make(map[Agent]struct{}),
 		unconfirmed:    newUnconfirmedBlocks(eth.BlockChain(), miningLogAtDepth),
 	}
@@ -170,6 +177,30 @@ func (self *worker) setExtra(extra []byte) {
 	self.extra = extra
 }
 
+func (self *worker) setGasLimits(floor, ceil uint64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.gasFloor, self.gasCeil = floor, ceil
+}
+
+func (self *worker) gasLimits() (uint64, uint64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.gasFloor, self.gasCeil
+}
+
+func (self *worker) setOrderer(orderer Orderer) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.orderer = orderer
+}
+
+func (self *worker) addBundle(bundle *Bundle) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.bundles = append(self.bundles, bundle)
+}
+
 func (self *worker) pending() (*types.Block, *state.StateDB) {
 	self.currentMu.Lock()
 	defer self.currentMu.Unlock()
@@ -260,6 +291,12 @@ func (self *worker) update() {
 
 		// Handle TxPreEvent
 		case ev := <-self.txCh:
+			self.mu.Lock()
+			if observer, ok := self.orderer.(txObserver); ok {
+				observer.Observe(ev.Tx)
+			}
+			self.mu.Unlock()
+
 			// Apply transaction to the pending state if we're not mining
 			if atomic.LoadInt32(&self.mining) == 0 {
 				self.currentMu.Lock()
@@ -271,7 +308,7 @@ func (self *worker) update() {
 				self.currentMu.Unlock()
 			} else {
 				// If we're mining, but nothing is being processed, wake on new transactions
-				if self.config.Clique != nil && self.config.Clique.Period == 0 {
+				if (self.config.Clique != nil && self.config.Clique.Period == 0) || self.config.Instant != nil {
 					self.commitNewWork()
 				}
 			}
@@ -401,8 +438,9 @@ func (self *worker) commitNewWork() {
 	if parent.Time().Cmp(new(big.Int).SetInt64(tstamp)) >= 0 {
 		tstamp = parent.Time().Int64() + 1
 	}
-	// this will ensure we're not going off too far in the future
-	if now := time.Now().Unix(); tstamp > now+1 {
+	// this will ensure we're not going off too far in the future (instant chains
+	// run on their own clock, which may be ahead of the system one)
+	if now := time.Now().Unix(); tstamp > now+1 && self.config.Instant == nil {
 		wait := time.Duration(tstamp-now) * time.Second
 		log.Info("Mining too far in the future", "wait", common.PrettyDuration(wait))
 		time.Sleep(wait)
@@ -412,7 +450,7 @@ func (self *worker) commitNewWork() {
 	header := &types.Header{
 		ParentHash: parent.Hash(),
 		Number:     num.Add(num, common.Big1),
-		GasLimit:   core.CalcGasLimit(parent),
+		GasLimit:   core.CalcGasLimit(parent, self.gasFloor, self.gasCeil),
 		Extra:      self.extra,
 		Time:       big.NewInt(tstamp),
 	}
@@ -448,12 +486,18 @@ func (self *worker) commitNewWork() {
 	if self.config.DAOForkSupport && self.config.DAOForkBlock != nil && self.config.DAOForkBlock.Cmp(header.Number) == 0 {
 		misc.ApplyDAOHardFork(work.state)
 	}
-	pending, err := self.eth.TxPool().Pending()
+	self.commitBundles(work)
+
+	pool := self.eth.TxPool()
+	if pruner, ok := self.orderer.(txPruner); ok {
+		pruner.Prune(func(hash common.Hash) bool { return pool.Get(hash) != nil })
+	}
+	pending, err := pool.Pending()
 	if err != nil {
 		log.Error("Failed to fetch pending transactions", "err", err)
 		return
 	}
-	txs := types.NewTransactionsByPriceAndNonce(self.current.signer, pending)
+	txs := self.orderer.Order(self.current.signer, pending)
 	work.commitTransactions(self.mux, txs, self.chain, self.coinbase)
 
 	// compute uncles for the new block.
@@ -491,6 +535,45 @@ func (self *worker) commitNewWork() {
 	self.push(work)
 }
 
+// commitBundles applies all bundles targeting the block being assembled to the
+// top of the work, dropping any bundle that targets an already past block.
+func (self *worker) commitBundles(work *Work) {
+	number := work.header.Number.Uint64()
+
+	var (
+		live          []*Bundle
+		coalescedLogs []*types.Log
+	)
+	for _, bundle := range self.bundles {
+		switch {
+		case bundle.BlockNumber < number:
+			log.Trace("Dropping stale bundle", "hash", bundle.Hash(), "number", bundle.BlockNumber)
+			continue
+		case bundle.BlockNumber == number:
+			logs, err := work.commitBundle(bundle, self.chain, self.coinbase)
+			if err != nil {
+				log.Debug("Bundle skipped", "hash", bundle.Hash(), "err", err)
+			} else {
+				log.Debug("Committed bundle", "hash", bundle.Hash(), "txs", len(bundle.Txs))
+				coalescedLogs = append(coalescedLogs, logs...)
+			}
+		}
+		live = append(live, bundle)
+	}
+	self.bundles = live
+
+	if len(coalescedLogs) > 0 {
+		// Copy the logs for the same reason as in commitTransactions: the state
+		// caches them and they get "upgraded" to mined logs once sealed.
+		cpy := make([]*types.Log, len(coalescedLogs))
+		for i, l := range coalescedLogs {
+			cpy[i] = new(types.Log)
+			*cpy[i] = *l
+		}
+		go self.mux.Post(core.PendingLogsEvent{Logs: cpy})
+	}
+}
+
 func (self *worker) commitUncle(work *Work, uncle *types.Header) error {
 	hash := uncle.Hash()
 	if work.uncles.Has(hash) {
@@ -506,8 +589,8 @@ func (self *worker) commitUncle(work *Work, uncle *types.Header) error {
 	return nil
 }
 
-func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsByPriceAndNonce, bc *core.BlockChain, coinbase common.Address) {
-	gp := new(core.GasPool).AddGas(env.header.GasLimit)
+func (env *Work) commitTransactions(mux *event.TypeMux, txs TransactionSet, bc *core.BlockChain, coinbase common.Address) {
+	gp := new(core.GasPool).AddGas(env.header.GasLimit - env.header.GasUsed)
 
 	var coalescedLogs []*types.Log
 
@@ -70,6 +70,15 @@ type NodeConfig struct {
 	// It has the form "nodename:secret@host:port"
 	HappyUCNetStats string
 
+	// UltraLightServers is the list of trusted LES servers. If set, the node runs
+	// in ultra-light mode, following the heads announced by these servers instead
+	// of downloading and verifying the header chain.
+	UltraLightServers *Enodes
+
+	// UltraLightFraction is the percentage of trusted servers that need to announce
+	// a head before it is accepted in ultra-light mode.
+	UltraLightFraction int
+
 	// WhisperEnabled specifies whether the node should run the Whisper protocol.
 	WhisperEnabled bool
 }
@@ -149,6 +158,14 @@ func NewNode(datadir string, config *NodeConfig) (stack *Node, _ error) {
 		ethConf.SyncMode = downloader.LightSync
 		ethConf.NetworkId = uint64(config.HappyUCNetworkID)
 		ethConf.DatabaseCache = config.HappyUCDatabaseCache
+		if config.UltraLightServers != nil {
+			for _, server := range config.UltraLightServers.nodes {
+				ethConf.UltraLightServers = append(ethConf.UltraLightServers, server.String())
+			}
+			if config.UltraLightFraction != 0 {
+				ethConf.UltraLightFraction = config.UltraLightFraction
+			}
+		}
 		if err := rawStack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
 			return les.New(ctx, &ethConf)
 		}); err != nil {
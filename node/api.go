@@ -27,6 +27,7 @@ import (
 	"github.com/happyuc-project/happyuc-go/metrics"
 	"github.com/happyuc-project/happyuc-go/p2p"
 	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/p2p/netutil"
 	"github.com/happyuc-project/happyuc-go/rpc"
 )
 
@@ -75,6 +76,94 @@ func (api *PrivateAdminAPI) RemovePeer(url string) (bool, error) {
 	return true, nil
 }
 
+// AddTrustedPeer allows a remote node to always connect, even if slots are full.
+// The node is also added to the trusted node list in the data directory.
+func (api *PrivateAdminAPI) AddTrustedPeer(url string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid hnode: %v", err)
+	}
+	server.AddTrustedPeer(node)
+
+	api.node.persistLock.Lock()
+	defer api.node.persistLock.Unlock()
+	if err := api.node.config.addTrustedNode(node); err != nil {
+		return true, fmt.Errorf("can't persist trusted node: %v", err)
+	}
+	return true, nil
+}
+
+// RemoveTrustedPeer removes a remote node from the trusted peer set, but it
+// does not disconnect it automatically.
+func (api *PrivateAdminAPI) RemoveTrustedPeer(url string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid hnode: %v", err)
+	}
+	server.RemoveTrustedPeer(node)
+
+	api.node.persistLock.Lock()
+	defer api.node.persistLock.Unlock()
+	if err := api.node.config.removeTrustedNode(node); err != nil {
+		return true, fmt.Errorf("can't persist trusted node: %v", err)
+	}
+	return true, nil
+}
+
+// SetNetRestrict restricts network communication to the given comma-separated
+// list of CIDR masks. Connected peers outside the list are dropped unless they
+// are static or trusted. An empty list removes the restriction.
+func (api *PrivateAdminAPI) SetNetRestrict(cidrs string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	var list *netutil.Netlist
+	if strings.TrimSpace(cidrs) != "" {
+		var err error
+		if list, err = netutil.ParseNetlist(cidrs); err != nil {
+			return false, fmt.Errorf("invalid netrestrict: %v", err)
+		}
+	}
+	server.SetNetRestrict(list)
+
+	api.node.persistLock.Lock()
+	defer api.node.persistLock.Unlock()
+	if err := api.node.config.saveNetRestrict(list); err != nil {
+		return true, fmt.Errorf("can't persist netrestrict: %v", err)
+	}
+	return true, nil
+}
+
+// NetRestrict returns the CIDR masks of the network whitelist in effect.
+func (api *PrivateAdminAPI) NetRestrict() ([]string, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return nil, ErrNodeStopped
+	}
+	list := server.CurrentNetRestrict()
+	if list == nil {
+		return nil, nil
+	}
+	masks := make([]string, 0, len(*list))
+	for _, n := range *list {
+		masks = append(masks, n.String())
+	}
+	return masks, nil
+}
+
 // PeerEvents creates an RPC subscription which receives peer events from the
 // node's p2p.Server
 func (api *PrivateAdminAPI) PeerEvents(ctx context.Context) (*rpc.Subscription, error) {
@@ -18,6 +18,7 @@ package node
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -33,6 +34,7 @@ import (
 	"github.com/happyuc-project/happyuc-go/log"
 	"github.com/happyuc-project/happyuc-go/p2p"
 	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/p2p/netutil"
 )
 
 const (
@@ -40,6 +42,7 @@ const (
 	datadirDefaultKeyStore = "keystore"           // Path within the datadir to the keystore
 	datadirStaticNodes     = "static-nodes.json"  // Path within the datadir to the static node list
 	datadirTrustedNodes    = "trusted-nodes.json" // Path within the datadir to the trusted node list
+	datadirNetRestrict     = "netrestrict.json"   // Path within the datadir to the network whitelist
 	datadirNodeDatabase    = "nodes"              // Path within the datadir to store the node infos
 )
 
@@ -372,6 +375,94 @@ func (c *Config) parsePersistentNodes(path string) []*discover.Node {
 	return nodes
 }
 
+// NetRestrict returns the network whitelist persisted in the data directory,
+// or nil if there is none.
+func (c *Config) NetRestrict() *netutil.Netlist {
+	path := c.resolvePath(datadirNetRestrict)
+	if c.DataDir == "" || !common.FileExist(path) {
+		return nil
+	}
+	var masks []string
+	if err := common.LoadJSON(path, &masks); err != nil {
+		log.Error(fmt.Sprintf("Can't load netrestrict file %s: %v", path, err))
+		return nil
+	}
+	list, err := netutil.ParseNetlist(strings.Join(masks, ","))
+	if err != nil {
+		log.Error(fmt.Sprintf("Invalid netrestrict file %s: %v", path, err))
+		return nil
+	}
+	return list
+}
+
+// saveNetRestrict persists the network whitelist into the data directory.
+// A nil list removes the file.
+func (c *Config) saveNetRestrict(list *netutil.Netlist) error {
+	if c.DataDir == "" {
+		return nil
+	}
+	path := c.resolvePath(datadirNetRestrict)
+	if list == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	masks := make([]string, 0, len(*list))
+	for _, n := range *list {
+		masks = append(masks, n.String())
+	}
+	return writeJSONFile(path, masks)
+}
+
+// addTrustedNode adds the given node to the trusted node list in the data directory.
+func (c *Config) addTrustedNode(node *discover.Node) error {
+	return c.updatePersistentNodes(c.resolvePath(datadirTrustedNodes), node, true)
+}
+
+// removeTrustedNode removes the given node from the trusted node list in the
+// data directory.
+func (c *Config) removeTrustedNode(node *discover.Node) error {
+	return c.updatePersistentNodes(c.resolvePath(datadirTrustedNodes), node, false)
+}
+
+// updatePersistentNodes adds or removes a node in a .json node list within
+// the data directory. Entries are matched by node ID.
+func (c *Config) updatePersistentNodes(path string, node *discover.Node, add bool) error {
+	if c.DataDir == "" {
+		return nil
+	}
+	var nodelist []string
+	if common.FileExist(path) {
+		if err := common.LoadJSON(path, &nodelist); err != nil {
+			return err
+		}
+	}
+	urls := make([]string, 0, len(nodelist)+1)
+	for _, url := range nodelist {
+		if n, err := discover.ParseNode(url); err == nil && n.ID == node.ID {
+			continue
+		}
+		urls = append(urls, url)
+	}
+	if add {
+		urls = append(urls, node.String())
+	}
+	return writeJSONFile(path, urls)
+}
+
+// writeJSONFile stores val as indented JSON in the given file.
+func writeJSONFile(path string, val interface{}) error {
+	blob, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(blob, '\n'), 0600)
+}
+
 // AccountConfig determines the settings for scrypt and keydirectory
 func (c *Config) AccountConfig() (int, int, string, error) {
 	scryptN := keystore.StandardScryptN
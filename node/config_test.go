@@ -19,13 +19,17 @@ package node
 import (
 	"bytes"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"testing"
 
 	"github.com/happyuc-project/happyuc-go/crypto"
 	"github.com/happyuc-project/happyuc-go/p2p"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/p2p/netutil"
 )
 
 // Tests that datadirs can be successfully created, be them manually configured
@@ -148,3 +152,53 @@ func TestNodeKeyPersistency(t *testing.T) {
 		t.Fatalf("ephemeral node key persisted to disk")
 	}
 }
+
+// Tests that trusted nodes and the network whitelist are persisted into the
+// data directory and loaded back.
+func TestPeerListPersistency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-test")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{Name: "unit-test", DataDir: dir}
+	if nodes := config.TrustedNodes(); len(nodes) != 0 {
+		t.Fatalf("unexpected trusted nodes: %v", nodes)
+	}
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	node1 := discover.NewNode(discover.PubkeyID(&key1.PublicKey), net.IP{10, 0, 0, 1}, 30303, 30303)
+	node2 := discover.NewNode(discover.PubkeyID(&key2.PublicKey), net.IP{10, 0, 0, 2}, 30303, 30303)
+
+	// Add both nodes, re-adding the first one must not duplicate it.
+	for _, n := range []*discover.Node{node1, node2, node1} {
+		if err := config.addTrustedNode(n); err != nil {
+			t.Fatalf("failed to add trusted node: %v", err)
+		}
+	}
+	if nodes := config.TrustedNodes(); len(nodes) != 2 || nodes[0].ID != node2.ID || nodes[1].ID != node1.ID {
+		t.Fatalf("trusted nodes mismatch: %v", nodes)
+	}
+	if err := config.removeTrustedNode(node2); err != nil {
+		t.Fatalf("failed to remove trusted node: %v", err)
+	}
+	if nodes := config.TrustedNodes(); len(nodes) != 1 || nodes[0].ID != node1.ID {
+		t.Fatalf("trusted nodes mismatch after removal: %v", nodes)
+	}
+
+	// Store a whitelist, load it back and clear it.
+	list, _ := netutil.ParseNetlist("10.0.0.0/8, 192.168.1.0/24")
+	if err := config.saveNetRestrict(list); err != nil {
+		t.Fatalf("failed to save netrestrict: %v", err)
+	}
+	if loaded := config.NetRestrict(); !reflect.DeepEqual(loaded, list) {
+		t.Fatalf("netrestrict mismatch: have %v, want %v", loaded, list)
+	}
+	if err := config.saveNetRestrict(nil); err != nil {
+		t.Fatalf("failed to clear netrestrict: %v", err)
+	}
+	if loaded := config.NetRestrict(); loaded != nil {
+		t.Fatalf("netrestrict not cleared: %v", loaded)
+	}
+}
@@ -70,6 +70,8 @@ type Node struct {
 	stop chan struct{} // Channel to wait for termination notifications
 	lock sync.RWMutex
 
+	persistLock sync.Mutex // Serializes updates of the peer lists in the data directory
+
 	log log.Logger
 }
 
@@ -159,6 +161,9 @@ func (n *Node) Start() error {
 	if n.serverConfig.TrustedNodes == nil {
 		n.serverConfig.TrustedNodes = n.config.TrustedNodes()
 	}
+	if n.serverConfig.NetRestrict == nil {
+		n.serverConfig.NetRestrict = n.config.NetRestrict()
+	}
 	if n.serverConfig.NodeDatabase == "" {
 		n.serverConfig.NodeDatabase = n.config.NodeDB()
 	}
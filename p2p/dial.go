@@ -17,13 +17,13 @@
 package p2p
 
 import (
-	"container/heap"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"net"
 	"time"
 
+	"github.com/happyuc-project/happyuc-go/common/mclock"
 	"github.com/happyuc-project/happyuc-go/log"
 	"github.com/happyuc-project/happyuc-go/p2p/discover"
 	"github.com/happyuc-project/happyuc-go/p2p/netutil"
@@ -31,8 +31,12 @@ import (
 
 const (
 	// This is the amount of time spent waiting in between
-	// redialing a certain node.
+	// redialing a certain node. Nodes which fail to connect
+	// are retried with exponential backoff, up to the
+	// maximum delay below. Static nodes are retried sooner.
 	dialHistoryExpiration = 30 * time.Second
+	maxDialBackoff        = 30 * time.Minute
+	maxStaticDialBackoff  = 2 * time.Minute
 
 	// Discovery lookups are throttled and can only run
 	// once every few seconds.
@@ -71,16 +75,22 @@ func (t TCPDialer) Dial(dest *discover.Node) (net.Conn, error) {
 type dialstate struct {
 	maxDynDials int
 	ntab        discoverTable
+	dns         nodeSource // DNS node lists, may be nil
 	netrestrict *netutil.Netlist
+	subnets     *subnetLimiter // connected peers per subnet, may be nil
 
 	lookupRunning bool
 	dialing       map[discover.NodeID]connFlag
 	lookupBuf     []*discover.Node // current discovery lookup results
 	randomNodes   []*discover.Node // filled from Table
+	dnsNodes      []*discover.Node // filled from DNS node lists
+	introduced    []*discover.Node // nodes introduced by relays, dialed next
 	static        map[discover.NodeID]*dialTask
-	hist          *dialHistory
+	hist          *expHeap                         // nodes which can't be dialed yet
+	backoff       map[discover.NodeID]*dialBackoff // backoff state of failing nodes
+	lastPrune     mclock.AbsTime                   // time of the last backoff state cleanup
 
-	start     time.Time        // time when the dialer was first used
+	start     mclock.AbsTime   // time when the dialer was first used
 	bootnodes []*discover.Node // default dials when there are no peers
 }
 
@@ -90,15 +100,19 @@ type discoverTable interface {
 	Resolve(target discover.NodeID) *discover.Node
 	Lookup(target discover.NodeID) []*discover.Node
 	ReadRandomNodes([]*discover.Node) int
+	HolePunch(target discover.NodeID) (*discover.Node, error)
 }
 
-// the dial history remembers recent dials.
-type dialHistory []pastDial
+// nodeSource is a source of dial candidates which can't be searched,
+// such as the DNS node lists.
+type nodeSource interface {
+	ReadRandomNodes([]*discover.Node) int
+}
 
-// pastDial is an entry in the dial history.
-type pastDial struct {
-	id  discover.NodeID
-	exp time.Time
+// dialBackoff tracks the redial delay of a node which failed to connect.
+type dialBackoff struct {
+	delay time.Duration  // current delay, doubled on every failure
+	last  mclock.AbsTime // time of the last failure
 }
 
 type task interface {
@@ -112,6 +126,8 @@ type dialTask struct {
 	dest         *discover.Node
 	lastResolved time.Time
 	resolveDelay time.Duration
+	introduced   bool  // destination was introduced by a relay
+	err          error // result of the last attempt
 }
 
 // discoverTask runs discovery table operations.
@@ -136,7 +152,9 @@ func newDialState(static []*discover.Node, bootnodes []*discover.Node, ntab disc
 		dialing:     make(map[discover.NodeID]connFlag),
 		bootnodes:   make([]*discover.Node, len(bootnodes)),
 		randomNodes: make([]*discover.Node, maxdyn/2),
-		hist:        new(dialHistory),
+		dnsNodes:    make([]*discover.Node, maxdyn),
+		hist:        new(expHeap),
+		backoff:     make(map[discover.NodeID]*dialBackoff),
 	}
 	copy(s.bootnodes, bootnodes)
 	for _, n := range static {
@@ -156,17 +174,35 @@ func (s *dialstate) removeStatic(n *discover.Node) {
 	delete(s.static, n.ID)
 	// This removes a previous dial timestamp so that application
 	// can force a server to reconnect with chosen peer immediately.
-	s.hist.remove(n.ID)
+	s.hist.remove(string(n.ID[:]))
+	delete(s.backoff, n.ID)
+}
+
+func (s *dialstate) setNetRestrict(list *netutil.Netlist) {
+	s.netrestrict = list
+}
+
+// addIntroduced schedules a dial to a node introduced by a relay. Introductions
+// beyond what a single round can dial are dropped, their NAT mappings would be
+// gone by the time they get a turn.
+func (s *dialstate) addIntroduced(n *discover.Node) {
+	if len(s.introduced) < maxActiveDialTasks {
+		s.introduced = append(s.introduced, n)
+	}
 }
 
-func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now time.Time) []task {
-	if s.start.IsZero() {
+func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now mclock.AbsTime) []task {
+	if s.start == 0 {
 		s.start = now
 	}
 
 	var newtasks []task
 	addDial := func(flag connFlag, n *discover.Node) bool {
-		if err := s.checkDial(n, peers); err != nil {
+		err := s.checkDial(n, peers)
+		if err == nil && s.subnets != nil && !s.subnets.allowed(n.IP) {
+			err = errSubnetLimit
+		}
+		if err != nil {
 			log.Trace("Skipping dial candidate", "id", n.ID, "addr", &net.TCPAddr{IP: n.IP, Port: int(n.TCP)}, "err", err)
 			return false
 		}
@@ -190,6 +226,7 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 
 	// Expire the dial history on every invocation.
 	s.hist.expire(now)
+	s.pruneBackoff(now)
 
 	// Create dials for static nodes if they are not connected.
 	for id, t := range s.static {
@@ -203,6 +240,16 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 			newtasks = append(newtasks, t)
 		}
 	}
+	// Dial the nodes introduced by relays while their NAT mappings are open,
+	// subject to the same checks and limits as any other dynamic dial.
+	for _, n := range s.introduced {
+		if needDynDials > 0 && addDial(dynDialedConn, n) {
+			newtasks[len(newtasks)-1].(*dialTask).introduced = true
+			needDynDials--
+		}
+	}
+	s.introduced = s.introduced[:0]
+
 	// If we don't have any peers whatsoever, try to dial a random bootnode. This
 	// scenario is useful for the testnet (and private networks) where the discovery
 	// table might be full of mostly bad peers, making it hard to find good ones.
@@ -218,7 +265,7 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 	// Use random nodes from the table for half of the necessary
 	// dynamic dials.
 	randomCandidates := needDynDials / 2
-	if randomCandidates > 0 {
+	if randomCandidates > 0 && s.ntab != nil {
 		n := s.ntab.ReadRandomNodes(s.randomNodes)
 		for i := 0; i < randomCandidates && i < n; i++ {
 			if addDial(dynDialedConn, s.randomNodes[i]) {
@@ -226,6 +273,21 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 			}
 		}
 	}
+	// Use nodes from the DNS lists for half of the remaining dynamic
+	// dials, or for all of them when there is no discovery table.
+	if s.dns != nil {
+		dnsCandidates := needDynDials
+		if s.ntab != nil {
+			dnsCandidates /= 2
+		}
+		n := s.dns.ReadRandomNodes(s.dnsNodes)
+		for i := 0; i < n && dnsCandidates > 0; i++ {
+			if addDial(dynDialedConn, s.dnsNodes[i]) {
+				needDynDials--
+				dnsCandidates--
+			}
+		}
+	}
 	// Create dynamic dials from random lookup results, removing tried
 	// items from the result buffer.
 	i := 0
@@ -236,7 +298,7 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 	}
 	s.lookupBuf = s.lookupBuf[:copy(s.lookupBuf, s.lookupBuf[i:])]
 	// Launch a discovery lookup if more candidates are needed.
-	if len(s.lookupBuf) < needDynDials && !s.lookupRunning {
+	if s.ntab != nil && len(s.lookupBuf) < needDynDials && !s.lookupRunning {
 		s.lookupRunning = true
 		newtasks = append(newtasks, &discoverTask{})
 	}
@@ -246,7 +308,7 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 	// This should prevent cases where the dialer logic is not ticked
 	// because there are no pending events.
 	if nRunning == 0 && len(newtasks) == 0 && s.hist.Len() > 0 {
-		t := &waitExpireTask{s.hist.min().exp.Sub(now)}
+		t := &waitExpireTask{s.hist.nextExpiry().Sub(now)}
 		newtasks = append(newtasks, t)
 	}
 	return newtasks
@@ -258,6 +320,8 @@ var (
 	errAlreadyConnected = errors.New("already connected")
 	errRecentlyDialed   = errors.New("recently dialed")
 	errNotWhitelisted   = errors.New("not contained in netrestrict whitelist")
+	errSubnetLimit      = errors.New("too many peers in subnet")
+	errNotResolved      = errors.New("endpoint not resolved")
 )
 
 func (s *dialstate) checkDial(n *discover.Node, peers map[discover.NodeID]*Peer) error {
@@ -271,16 +335,16 @@ func (s *dialstate) checkDial(n *discover.Node, peers map[discover.NodeID]*Peer)
 		return errSelf
 	case s.netrestrict != nil && !s.netrestrict.Contains(n.IP):
 		return errNotWhitelisted
-	case s.hist.contains(n.ID):
+	case s.hist.contains(string(n.ID[:])):
 		return errRecentlyDialed
 	}
 	return nil
 }
 
-func (s *dialstate) taskDone(t task, now time.Time) {
+func (s *dialstate) taskDone(t task, now mclock.AbsTime) {
 	switch t := t.(type) {
 	case *dialTask:
-		s.hist.add(t.dest.ID, now.Add(dialHistoryExpiration))
+		s.hist.add(string(t.dest.ID[:]), now.Add(s.redialDelay(t, now)))
 		delete(s.dialing, t.dest.ID)
 	case *discoverTask:
 		s.lookupRunning = false
@@ -288,24 +352,92 @@ func (s *dialstate) taskDone(t task, now time.Time) {
 	}
 }
 
+// redialDelay returns the time to wait before dialing the destination of a
+// finished dial task again. The delay doubles with every failed attempt and
+// resets when a connection is established.
+func (s *dialstate) redialDelay(t *dialTask, now mclock.AbsTime) time.Duration {
+	if t.err == nil {
+		delete(s.backoff, t.dest.ID)
+		return dialHistoryExpiration
+	}
+	b := s.backoff[t.dest.ID]
+	if b == nil {
+		b = &dialBackoff{delay: dialHistoryExpiration}
+		s.backoff[t.dest.ID] = b
+	} else {
+		b.delay *= 2
+	}
+	max := maxDialBackoff
+	if t.flags&staticDialedConn != 0 {
+		max = maxStaticDialBackoff
+	}
+	if b.delay > max {
+		b.delay = max
+	}
+	b.last = now
+	return b.delay
+}
+
+// pruneBackoff forgets the backoff state of nodes which haven't failed
+// for a long time.
+func (s *dialstate) pruneBackoff(now mclock.AbsTime) {
+	if now.Sub(s.lastPrune) < maxDialBackoff {
+		return
+	}
+	for id, b := range s.backoff {
+		if now.Sub(b.last) > 2*maxDialBackoff {
+			delete(s.backoff, id)
+		}
+	}
+	s.lastPrune = now
+}
+
 func (t *dialTask) Do(srv *Server) {
 	if t.dest.Incomplete() {
 		if !t.resolve(srv) {
+			t.err = errNotResolved
 			return
 		}
 	}
-	err := t.dial(srv, t.dest)
-	if err != nil {
-		log.Trace("Dial error", "task", t, "err", err)
+	t.err = t.dial(srv, t.dest)
+	if t.err != nil {
+		log.Trace("Dial error", "task", t, "err", t.err)
 		// Try resolving the ID of static nodes if dialing failed.
-		if _, ok := err.(*dialError); ok && t.flags&staticDialedConn != 0 {
+		if _, ok := t.err.(*dialError); ok && t.flags&staticDialedConn != 0 {
 			if t.resolve(srv) {
-				t.dial(srv, t.dest)
+				t.err = t.dial(srv, t.dest)
+			}
+		}
+		// Try hole punching for dynamic dials.
+		if _, ok := t.err.(*dialError); ok && t.flags&dynDialedConn != 0 {
+			if dest := t.holePunch(srv); dest != nil {
+				t.err = t.dial(srv, dest)
 			}
 		}
 	}
 }
 
+// holePunch asks discovery nodes to introduce us to the destination. It
+// returns the endpoint of the destination as seen by the relay, or nil if
+// hole punching is disabled or failed.
+func (t *dialTask) holePunch(srv *Server) *discover.Node {
+	if !srv.HolePunch || srv.ntab == nil || t.introduced {
+		return nil
+	}
+	dest, err := srv.ntab.HolePunch(t.dest.ID)
+	if err != nil {
+		log.Trace("Hole punching failed", "id", t.dest.ID, "err", err)
+		return nil
+	}
+	// The endpoint comes from the relay, make sure we're allowed to dial it.
+	if list := srv.CurrentNetRestrict(); list != nil && !list.Contains(dest.IP) {
+		log.Trace("Hole punching failed", "id", t.dest.ID, "addr", &net.TCPAddr{IP: dest.IP, Port: int(dest.TCP)}, "err", errNotWhitelisted)
+		return nil
+	}
+	log.Debug("Hole punched", "id", t.dest.ID, "addr", &net.TCPAddr{IP: dest.IP, Port: int(dest.TCP)})
+	return dest
+}
+
 // resolve attempts to find the current endpoint for the destination
 // using discovery.
 //
@@ -363,10 +495,10 @@ func (t *discoverTask) Do(srv *Server) {
 	// necessary. Lookups need to take some time, otherwise the
 	// event loop spins too fast.
 	next := srv.lastLookup.Add(lookupInterval)
-	if now := time.Now(); now.Before(next) {
-		time.Sleep(next.Sub(now))
+	if now := srv.clock.Now(); now < next {
+		srv.clock.Sleep(next.Sub(now))
 	}
-	srv.lastLookup = time.Now()
+	srv.lastLookup = srv.clock.Now()
 	var target discover.NodeID
 	rand.Read(target[:])
 	t.results = srv.ntab.Lookup(target)
@@ -380,55 +512,9 @@ func (t *discoverTask) String() string {
 	return s
 }
 
-func (t waitExpireTask) Do(*Server) {
-	time.Sleep(t.Duration)
+func (t waitExpireTask) Do(srv *Server) {
+	srv.clock.Sleep(t.Duration)
 }
 func (t waitExpireTask) String() string {
 	return fmt.Sprintf("wait for dial hist expire (%v)", t.Duration)
 }
-
-// Use only these methods to access or modify dialHistory.
-func (h dialHistory) min() pastDial {
-	return h[0]
-}
-func (h *dialHistory) add(id discover.NodeID, exp time.Time) {
-	heap.Push(h, pastDial{id, exp})
-
-}
-func (h *dialHistory) remove(id discover.NodeID) bool {
-	for i, v := range *h {
-		if v.id == id {
-			heap.Remove(h, i)
-			return true
-		}
-	}
-	return false
-}
-func (h dialHistory) contains(id discover.NodeID) bool {
-	for _, v := range h {
-		if v.id == id {
-			return true
-		}
-	}
-	return false
-}
-func (h *dialHistory) expire(now time.Time) {
-	for h.Len() > 0 && h.min().exp.Before(now) {
-		heap.Pop(h)
-	}
-}
-
-// heap.Interface boilerplate
-func (h dialHistory) Len() int           { return len(h) }
-func (h dialHistory) Less(i, j int) bool { return h[i].exp.Before(h[j].exp) }
-func (h dialHistory) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
-func (h *dialHistory) Push(x interface{}) {
-	*h = append(*h, x.(pastDial))
-}
-func (h *dialHistory) Pop() interface{} {
-	old := *h
-	n := len(old)
-	x := old[n-1]
-	*h = old[0 : n-1]
-	return x
-}
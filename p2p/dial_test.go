@@ -18,12 +18,14 @@ package p2p
 
 import (
 	"encoding/binary"
+	"errors"
 	"net"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/happyuc-project/happyuc-go/common/mclock"
 	"github.com/happyuc-project/happyuc-go/p2p/discover"
 	"github.com/happyuc-project/happyuc-go/p2p/netutil"
 )
@@ -45,7 +47,7 @@ type round struct {
 
 func runDialTest(t *testing.T, test dialtest) {
 	var (
-		vtime   time.Time
+		clock   = new(mclock.Simulated)
 		running int
 	)
 	pm := func(ps []*Peer) map[discover.NodeID]*Peer {
@@ -61,17 +63,17 @@ func runDialTest(t *testing.T, test dialtest) {
 			if running < 0 {
 				panic("running task counter underflow")
 			}
-			test.init.taskDone(task, vtime)
+			test.init.taskDone(task, clock.Now())
 		}
 
-		new := test.init.newTasks(running, pm(round.peers), vtime)
+		new := test.init.newTasks(running, pm(round.peers), clock.Now())
 		if !sametasks(new, round.new) {
 			t.Errorf("round %d: new tasks mismatch:\ngot %v\nwant %v\nstate: %v\nrunning: %v\n",
 				i, spew.Sdump(new), spew.Sdump(round.new), spew.Sdump(test.init), spew.Sdump(running))
 		}
 
 		// Time advances by 16 seconds on every round.
-		vtime = vtime.Add(16 * time.Second)
+		clock.Run(16 * time.Second)
 		running += len(new)
 	}
 }
@@ -83,6 +85,9 @@ func (t fakeTable) Close()                                   {}
 func (t fakeTable) Lookup(discover.NodeID) []*discover.Node  { return nil }
 func (t fakeTable) Resolve(discover.NodeID) *discover.Node   { return nil }
 func (t fakeTable) ReadRandomNodes(buf []*discover.Node) int { return copy(buf, t) }
+func (t fakeTable) HolePunch(discover.NodeID) (*discover.Node, error) {
+	return nil, errors.New("not supported")
+}
 
 // This test checks that dynamic dials are launched from discovery results.
 func TestDialStateDynDial(t *testing.T) {
@@ -403,6 +408,37 @@ func TestDialStateDynDialFromTable(t *testing.T) {
 }
 
 // This test checks that candidates that do not match the netrestrict list are not dialed.
+// This test checks that nodes from DNS lists are dialed when there is
+// no discovery table.
+func TestDialStateDynDialFromDNS(t *testing.T) {
+	dns := fakeTable{
+		{ID: uintID(1)},
+		{ID: uintID(2)},
+		{ID: uintID(3)},
+		{ID: uintID(4)},
+	}
+	state := newDialState(nil, nil, nil, 4, nil)
+	state.dns = dns
+
+	runDialTest(t, dialtest{
+		init: state,
+		rounds: []round{
+			// All remaining dynamic dials are filled from the DNS lists,
+			// skipping connected nodes. No lookup is started.
+			{
+				peers: []*Peer{
+					{rw: &conn{flags: dynDialedConn, id: uintID(1)}},
+				},
+				new: []task{
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(2)}},
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(3)}},
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(4)}},
+				},
+			},
+		},
+	})
+}
+
 func TestDialStateNetRestrict(t *testing.T) {
 	// This table always returns the same random nodes
 	// in the order given below.
@@ -432,6 +468,49 @@ func TestDialStateNetRestrict(t *testing.T) {
 	})
 }
 
+// This test checks that nodes introduced by relays are dialed subject to the
+// same checks and limits as other dynamic dials.
+func TestDialStateIntroduced(t *testing.T) {
+	var (
+		clock    mclock.Simulated
+		restrict = new(netutil.Netlist)
+		nodes    = []*discover.Node{
+			{ID: uintID(1), IP: net.ParseIP("127.0.2.1")}, // already connected
+			{ID: uintID(2), IP: net.ParseIP("127.0.0.2")}, // outside netrestrict
+			{ID: uintID(3), IP: net.ParseIP("127.0.2.3")},
+			{ID: uintID(4), IP: net.ParseIP("127.0.2.4")},
+			{ID: uintID(5), IP: net.ParseIP("127.0.2.5")}, // over the dial limit
+		}
+		peers = map[discover.NodeID]*Peer{
+			uintID(1): {rw: &conn{flags: dynDialedConn, id: uintID(1)}},
+		}
+	)
+	restrict.Add("127.0.2.0/24")
+	state := newDialState(nil, nil, nil, 3, restrict)
+
+	for _, n := range nodes {
+		state.addIntroduced(n)
+	}
+	want := []task{
+		&dialTask{flags: dynDialedConn, dest: nodes[2], introduced: true},
+		&dialTask{flags: dynDialedConn, dest: nodes[3], introduced: true},
+	}
+	tasks := state.newTasks(0, peers, clock.Now())
+	if !sametasks(tasks, want) {
+		t.Fatalf("wrong dial tasks:\ngot  %v\nwant %v", spew.Sdump(tasks), spew.Sdump(want))
+	}
+	// Introductions which couldn't be dialed are not retried.
+	if tasks := state.newTasks(len(tasks), peers, clock.Now()); len(tasks) != 0 {
+		t.Fatalf("unexpected dial tasks: %v", spew.Sdump(tasks))
+	}
+	// Nodes dialed recently are not redialed when introduced again.
+	state.taskDone(want[0], clock.Now())
+	state.addIntroduced(nodes[2])
+	if tasks := state.newTasks(1, peers, clock.Now()); len(tasks) != 0 {
+		t.Fatalf("recently dialed node redialed: %v", spew.Sdump(tasks))
+	}
+}
+
 // This test checks that static dials are launched.
 func TestDialStateStaticDial(t *testing.T) {
 	wantStatic := []*discover.Node{
@@ -635,7 +714,7 @@ func TestDialResolve(t *testing.T) {
 	// Check that the task is generated with an incomplete ID.
 	dest := discover.NewNode(uintID(1), nil, 0, 0)
 	state.addStatic(dest)
-	tasks := state.newTasks(0, nil, time.Time{})
+	tasks := state.newTasks(0, nil, 0)
 	if !reflect.DeepEqual(tasks, []task{&dialTask{flags: staticDialedConn, dest: dest}}) {
 		t.Fatalf("expected dial task, got %#v", tasks)
 	}
@@ -649,7 +728,7 @@ func TestDialResolve(t *testing.T) {
 	}
 
 	// Report it as done to the dialer, which should update the static node record.
-	state.taskDone(tasks[0], time.Now())
+	state.taskDone(tasks[0], 0)
 	if state.static[uintID(1)].dest != resolved {
 		t.Fatalf("state.dest not updated")
 	}
@@ -680,8 +759,10 @@ func uintID(i uint32) discover.NodeID {
 
 // implements discoverTable for TestDialResolve
 type resolveMock struct {
-	resolveCalls []discover.NodeID
-	answer       *discover.Node
+	resolveCalls   []discover.NodeID
+	answer         *discover.Node
+	holePunchCalls []discover.NodeID
+	punched        *discover.Node
 }
 
 func (t *resolveMock) Resolve(id discover.NodeID) *discover.Node {
@@ -694,3 +775,167 @@ func (t *resolveMock) Close()                                   {}
 func (t *resolveMock) Bootstrap([]*discover.Node)               {}
 func (t *resolveMock) Lookup(discover.NodeID) []*discover.Node  { return nil }
 func (t *resolveMock) ReadRandomNodes(buf []*discover.Node) int { return 0 }
+
+func (t *resolveMock) HolePunch(id discover.NodeID) (*discover.Node, error) {
+	t.holePunchCalls = append(t.holePunchCalls, id)
+	if t.punched == nil {
+		return nil, errors.New("no relay")
+	}
+	return t.punched, nil
+}
+
+// failDialer records dial attempts and fails all of them.
+type failDialer struct {
+	dialed []*discover.Node
+}
+
+func (d *failDialer) Dial(dest *discover.Node) (net.Conn, error) {
+	d.dialed = append(d.dialed, dest)
+	return nil, errors.New("connection refused")
+}
+
+// This test checks that failed dynamic dials are retried once on the
+// endpoint reported by hole punching.
+func TestDialTaskHolePunch(t *testing.T) {
+	var (
+		dest    = discover.NewNode(uintID(1), net.IP{10, 0, 0, 1}, 30303, 30303)
+		punched = discover.NewNode(uintID(1), net.IP{10, 0, 0, 2}, 40404, 40404)
+		table   = &resolveMock{punched: punched}
+		dialer  = new(failDialer)
+		srv     = &Server{Config: Config{HolePunch: true, Dialer: dialer}, ntab: table}
+	)
+	task := &dialTask{flags: dynDialedConn, dest: dest}
+	task.Do(srv)
+	if !reflect.DeepEqual(dialer.dialed, []*discover.Node{dest, punched}) {
+		t.Fatalf("wrong dial attempts:\ngot  %v\nwant %v", dialer.dialed, []*discover.Node{dest, punched})
+	}
+	if !reflect.DeepEqual(table.holePunchCalls, []discover.NodeID{dest.ID}) {
+		t.Fatalf("wrong hole punch calls: %v", table.holePunchCalls)
+	}
+
+	// Static dials, introduced nodes and servers without hole punching
+	// don't try it.
+	for _, task := range []*dialTask{
+		{flags: staticDialedConn, dest: dest},
+		{flags: dynDialedConn, dest: dest, introduced: true},
+	} {
+		table.holePunchCalls = nil
+		task.Do(srv)
+		if len(table.holePunchCalls) != 0 {
+			t.Errorf("hole punch attempted for task %v", task)
+		}
+	}
+	// Relayed endpoints outside the netrestrict whitelist are not dialed.
+	srv.netrestrict = new(netutil.Netlist)
+	srv.netrestrict.Add("10.0.0.1/32")
+	dialer.dialed = nil
+	(&dialTask{flags: dynDialedConn, dest: dest}).Do(srv)
+	if !reflect.DeepEqual(dialer.dialed, []*discover.Node{dest}) {
+		t.Errorf("wrong dial attempts with netrestrict:\ngot  %v\nwant %v", dialer.dialed, []*discover.Node{dest})
+	}
+	srv.netrestrict = nil
+
+	srv.HolePunch = false
+	table.holePunchCalls = nil
+	(&dialTask{flags: dynDialedConn, dest: dest}).Do(srv)
+	if len(table.holePunchCalls) != 0 {
+		t.Error("hole punch attempted while disabled")
+	}
+}
+
+// This test checks that nodes which fail to connect are redialed
+// with exponential backoff.
+func TestDialStateBackoff(t *testing.T) {
+	var (
+		clock mclock.Simulated
+		node  = &discover.Node{ID: uintID(1), IP: net.IP{1, 2, 3, 4}}
+		state = newDialState(nil, nil, nil, 2, nil)
+		fail  = errors.New("dial failed")
+	)
+	state.dns = fakeTable{node}
+
+	// dialAfter advances the clock until the node is dialed
+	// and reports the time that took.
+	dialAfter := func(err error) time.Duration {
+		start := clock.Now()
+		for {
+			for _, tk := range state.newTasks(0, nil, clock.Now()) {
+				if dt, ok := tk.(*dialTask); ok {
+					dt.err = err
+					state.taskDone(dt, clock.Now())
+					return clock.Now().Sub(start)
+				}
+			}
+			clock.Run(time.Second)
+		}
+	}
+	if d := dialAfter(fail); d != 0 {
+		t.Fatalf("first dial delayed by %v", d)
+	}
+	for _, want := range []time.Duration{
+		dialHistoryExpiration,
+		2 * dialHistoryExpiration,
+		4 * dialHistoryExpiration,
+	} {
+		if d := dialAfter(fail); d != want+time.Second {
+			t.Fatalf("wrong redial delay %v, want %v", d, want+time.Second)
+		}
+	}
+	// The delay is bounded.
+	for i := 0; i < 10; i++ {
+		dialAfter(fail)
+	}
+	if d := dialAfter(nil); d != maxDialBackoff+time.Second {
+		t.Fatalf("wrong redial delay %v, want %v", d, maxDialBackoff+time.Second)
+	}
+	// The successful dial resets the backoff.
+	if d := dialAfter(fail); d != dialHistoryExpiration+time.Second {
+		t.Fatalf("wrong redial delay %v after success, want %v", d, dialHistoryExpiration+time.Second)
+	}
+}
+
+// This test checks that static nodes are redialed sooner than
+// dynamic ones.
+func TestDialStateStaticBackoff(t *testing.T) {
+	var (
+		now   mclock.AbsTime
+		node  = &discover.Node{ID: uintID(1), IP: net.IP{1, 2, 3, 4}}
+		state = newDialState([]*discover.Node{node}, nil, nil, 0, nil)
+		task  = state.static[node.ID]
+	)
+	task.err = errors.New("dial failed")
+	var delay time.Duration
+	for i := 0; i < 10; i++ {
+		delay = state.redialDelay(task, now)
+	}
+	if delay != maxStaticDialBackoff {
+		t.Fatalf("wrong static redial delay %v, want %v", delay, maxStaticDialBackoff)
+	}
+}
+
+// This test checks that dynamic dials respect the per-subnet limit,
+// while static dials don't.
+func TestDialStateSubnetLimit(t *testing.T) {
+	state := newDialState([]*discover.Node{{ID: uintID(4), IP: net.IP{1, 2, 3, 14}}}, nil, nil, 4, nil)
+	state.subnets = newSubnetLimiter(2)
+	state.subnets.add(net.IP{1, 2, 3, 10})
+	state.subnets.add(net.IP{1, 2, 3, 11})
+	state.dns = fakeTable{
+		{ID: uintID(1), IP: net.IP{1, 2, 3, 12}},    // same /24 as connected peers
+		{ID: uintID(2), IP: net.IP{1, 2, 4, 1}},     // different /24
+		{ID: uintID(3), IP: net.IP{192, 168, 0, 1}}, // LAN, not limited
+	}
+
+	runDialTest(t, dialtest{
+		init: state,
+		rounds: []round{
+			{
+				new: []task{
+					&dialTask{flags: staticDialedConn, dest: &discover.Node{ID: uintID(4), IP: net.IP{1, 2, 3, 14}}},
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(2), IP: net.IP{1, 2, 4, 1}}},
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(3), IP: net.IP{192, 168, 0, 1}}},
+				},
+			},
+		},
+	})
+}
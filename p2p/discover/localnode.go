@@ -0,0 +1,225 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"crypto/ecdsa"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/p2p/enr"
+	"github.com/happyuc-project/happyuc-go/p2p/netutil"
+)
+
+const (
+	// Settings of the external endpoint predictor.
+	iptrackMinStatements = 10
+	iptrackWindow        = 5 * time.Minute
+	iptrackContactWindow = 10 * time.Minute
+)
+
+// LocalNode maintains the signed node record of the running node. Whenever the
+// endpoint or any other entry changes, the record is signed again with a higher
+// sequence number, so remote nodes can tell which version is the latest one.
+// If backed by a node database, the sequence number survives restarts.
+type LocalNode struct {
+	key *ecdsa.PrivateKey
+	id  NodeID
+	db  *nodeDB // optional, persists the sequence number
+
+	mu       sync.Mutex
+	seq      uint64
+	ip       net.IP
+	udp, tcp uint16
+	entries  map[string]enr.Entry
+	cur      *Node // last signed node, nil if the record needs to be signed again
+
+	endpoint *netutil.IPTracker // predicts the external UDP endpoint
+}
+
+// NewLocalNode creates a local node which doesn't persist its record.
+func NewLocalNode(key *ecdsa.PrivateKey) *LocalNode {
+	return newLocalNode(nil, key)
+}
+
+func newLocalNode(db *nodeDB, key *ecdsa.PrivateKey) *LocalNode {
+	ln := &LocalNode{
+		key:      key,
+		id:       PubkeyID(&key.PublicKey),
+		db:       db,
+		entries:  make(map[string]enr.Entry),
+		endpoint: netutil.NewIPTracker(iptrackWindow, iptrackContactWindow, iptrackMinStatements),
+	}
+	if db != nil {
+		ln.seq = db.localSeq()
+	}
+	return ln
+}
+
+// ID returns the identifier of the local node.
+func (ln *LocalNode) ID() NodeID {
+	return ln.id
+}
+
+// Seq returns the sequence number of the current record.
+func (ln *LocalNode) Seq() uint64 {
+	ln.Node() // make sure pending changes are signed
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	return ln.seq
+}
+
+// Node returns the local node, along with its current signed record.
+// The returned node should not be modified by the caller.
+func (ln *LocalNode) Node() *Node {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if ln.cur == nil {
+		ln.sign()
+	}
+	return ln.cur
+}
+
+// SetIP updates the IP address announced in the record.
+func (ln *LocalNode) SetIP(ip net.IP) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+	if !ip.Equal(ln.ip) {
+		ln.ip, ln.cur = ip, nil
+	}
+}
+
+// SetUDP updates the discovery port announced in the record.
+func (ln *LocalNode) SetUDP(port uint16) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if port != ln.udp {
+		ln.udp, ln.cur = port, nil
+	}
+}
+
+// SetTCP updates the RLPx listening port announced in the record.
+func (ln *LocalNode) SetTCP(port uint16) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if port != ln.tcp {
+		ln.tcp, ln.cur = port, nil
+	}
+}
+
+// UDPEndpointStatement should be called whenever a statement about the local
+// node's UDP endpoint is received, e.g. in a PONG packet. It feeds the
+// external endpoint predictor.
+func (ln *LocalNode) UDPEndpointStatement(fromaddr, endpoint *net.UDPAddr) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	ln.endpoint.AddStatement(fromaddr.String(), endpoint.String())
+}
+
+// UDPContact should be called whenever the local node has announced itself
+// to another node via UDP.
+func (ln *LocalNode) UDPContact(toaddr *net.UDPAddr) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	ln.endpoint.AddContact(toaddr.String())
+}
+
+// PredictedEndpoint returns the external UDP endpoint of the local node as
+// reported by other nodes. It returns nil if there are not enough consistent
+// statements.
+func (ln *LocalNode) PredictedEndpoint() *net.UDPAddr {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	ep := ln.endpoint.PredictEndpoint()
+	if ep == "" {
+		return nil
+	}
+	addr, err := net.ResolveUDPAddr("udp", ep)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
+
+// PredictFullConeNAT reports whether the local node appears to be behind a
+// full cone NAT, i.e. other nodes can reach it without being contacted first.
+func (ln *LocalNode) PredictFullConeNAT() bool {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	return ln.endpoint.PredictFullConeNAT()
+}
+
+// Set adds or replaces an arbitrary entry of the record. The identity and
+// endpoint entries are managed by LocalNode and can't be overridden.
+func (ln *LocalNode) Set(e enr.Entry) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	ln.entries[e.ENRKey()], ln.cur = e, nil
+}
+
+// sign assembles and signs a new version of the record. It must be called with
+// the lock held.
+func (ln *LocalNode) sign() {
+	var r enr.Record
+	for _, e := range ln.entries {
+		r.Set(e)
+	}
+	if ip4 := ln.ip.To4(); ip4 != nil && !ip4.IsUnspecified() {
+		r.Set(enr.IP4(ip4))
+	} else if ln.ip != nil && !ln.ip.IsUnspecified() {
+		r.Set(enr.IP6(ln.ip))
+	}
+	if ln.udp != 0 {
+		r.Set(enr.UDP(ln.udp))
+	}
+	if ln.tcp != 0 {
+		r.Set(enr.TCP(ln.tcp))
+	}
+	// Sign increments the sequence number
+	r.SetSeq(ln.seq)
+	if err := r.Sign(ln.key); err != nil {
+		log.Error("Failed to sign local node record", "err", err)
+		ln.cur = NewNode(ln.id, ln.ip, ln.udp, ln.tcp)
+		return
+	}
+	ln.seq = r.Seq()
+	if ln.db != nil {
+		if err := ln.db.storeLocalSeq(ln.seq); err != nil {
+			log.Warn("Failed to store local record sequence number", "err", err)
+		}
+	}
+	n := NewNode(ln.id, ln.ip, ln.udp, ln.tcp)
+	n.record = &r
+	ln.cur = n
+
+	log.Debug("Updated local node record", "seq", ln.seq, "ip", ln.ip, "udp", ln.udp, "tcp", ln.tcp)
+}
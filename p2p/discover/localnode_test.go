@@ -0,0 +1,95 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLocalNodeRecord(t *testing.T) {
+	db, _ := newNodeDB("", Version, NodeID{})
+	defer db.close()
+	key := newkey()
+
+	ln := newLocalNode(db, key)
+	ln.SetIP(net.IP{127, 0, 0, 1})
+	ln.SetUDP(30303)
+	ln.SetTCP(30303)
+
+	n := ln.Node()
+	if n.Record() == nil {
+		t.Fatal("local node has no record")
+	}
+	if n.ID != PubkeyID(&key.PublicKey) {
+		t.Fatalf("wrong node ID %x", n.ID[:8])
+	}
+	seq := ln.Seq()
+
+	// Unchanged endpoints must not produce a new record.
+	ln.SetIP(net.IP{127, 0, 0, 1})
+	if ln.Seq() != seq {
+		t.Fatalf("seq changed without update: %d -> %d", seq, ln.Seq())
+	}
+	// Changing the IP signs a new record with a higher sequence number.
+	ln.SetIP(net.IP{10, 0, 0, 1})
+	if ln.Seq() != seq+1 {
+		t.Fatalf("wrong seq after IP change: got %d, want %d", ln.Seq(), seq+1)
+	}
+	if !ln.Node().IP.Equal(net.IP{10, 0, 0, 1}) {
+		t.Fatalf("wrong IP after update: %v", ln.Node().IP)
+	}
+	// The record round-trips through the text form.
+	parsed, err := ParseNode(ln.Node().ENR())
+	if err != nil {
+		t.Fatalf("can't parse record: %v", err)
+	}
+	if parsed.ID != n.ID || !parsed.IP.Equal(net.IP{10, 0, 0, 1}) || parsed.UDP != 30303 || parsed.TCP != 30303 {
+		t.Fatalf("parsed node mismatch: %v", parsed)
+	}
+	// A new instance on the same database continues the sequence.
+	ln2 := newLocalNode(db, key)
+	if ln2.Seq() <= seq+1 {
+		t.Fatalf("seq not persisted: got %d, want > %d", ln2.Seq(), seq+1)
+	}
+}
+
+func TestLocalNodeEndpointPrediction(t *testing.T) {
+	ln := NewLocalNode(newkey())
+	endpoint := &net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 30303}
+
+	// Statements from a single host never produce a prediction.
+	for i := 0; i < iptrackMinStatements; i++ {
+		ln.UDPEndpointStatement(&net.UDPAddr{IP: net.IP{5, 5, 5, 5}, Port: 30303}, endpoint)
+	}
+	if ep := ln.PredictedEndpoint(); ep != nil {
+		t.Fatalf("unexpected prediction %v", ep)
+	}
+	for i := 1; i < iptrackMinStatements; i++ {
+		from := &net.UDPAddr{IP: net.IP{5, 5, 5, byte(5 + i)}, Port: 30303}
+		ln.UDPContact(from)
+		ln.UDPEndpointStatement(from, endpoint)
+	}
+	ep := ln.PredictedEndpoint()
+	if ep == nil || !ep.IP.Equal(endpoint.IP) || ep.Port != endpoint.Port {
+		t.Fatalf("wrong prediction: got %v, want %v", ep, endpoint)
+	}
+	// The first host was never contacted.
+	if !ln.PredictFullConeNAT() {
+		t.Fatal("full cone NAT not predicted")
+	}
+}
@@ -19,6 +19,7 @@ package discover
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -34,6 +35,8 @@ import (
 	"github.com/happyuc-project/happyuc-go/common"
 	"github.com/happyuc-project/happyuc-go/crypto"
 	"github.com/happyuc-project/happyuc-go/crypto/secp256k1"
+	"github.com/happyuc-project/happyuc-go/p2p/enr"
+	"github.com/happyuc-project/happyuc-go/rlp"
 )
 
 const NodeIDBits = 512
@@ -54,6 +57,10 @@ type Node struct {
 
 	// Time when the node was added to the table.
 	addedAt time.Time
+
+	// Signed node record, if known. Nodes created from URLs or discovery
+	// packets don't have one until it is fetched via RequestENR.
+	record *enr.Record
 }
 
 // NewNode creates a new node. It is mostly meant to be used for
@@ -71,6 +78,25 @@ func NewNode(id NodeID, ip net.IP, udpPort, tcpPort uint16) *Node {
 	}
 }
 
+// Record returns the signed node record of n, or nil if it isn't known.
+// The returned record should not be modified by the caller.
+func (n *Node) Record() *enr.Record {
+	return n.record
+}
+
+// ENR returns the text form of the node record, or the empty string if the
+// record of n isn't known. See ParseNode for a description of the format.
+func (n *Node) ENR() string {
+	if n.record == nil {
+		return ""
+	}
+	blob, err := rlp.EncodeToBytes(n.record)
+	if err != nil {
+		return ""
+	}
+	return "enr:" + base64.RawURLEncoding.EncodeToString(blob)
+}
+
 func (n *Node) addr() *net.UDPAddr {
 	return &net.UDPAddr{IP: n.IP, Port: int(n.UDP)}
 }
@@ -140,7 +166,16 @@ var incompleteNodeURL = regexp.MustCompile("(?i)^(?:hnode://)?([0-9a-f]+)$")
 // and UDP discovery port 30301.
 //
 //    hnode://<hex node id>@10.3.58.6:30303?discport=30301
+//
+// Nodes may also be given as signed node records (EIP-778) in text form,
+// i.e. the URL-safe base64 encoding of the RLP record without padding,
+// prefixed with "enr:".
+//
+//    enr:<base64 encoded record>
 func ParseNode(rawurl string) (*Node, error) {
+	if strings.HasPrefix(rawurl, "enr:") {
+		return parseRecord(rawurl[4:])
+	}
 	if m := incompleteNodeURL.FindStringSubmatch(rawurl); m != nil {
 		id, err := HexID(m[1])
 		if err != nil {
@@ -430,3 +465,42 @@ func hashAtDistance(a common.Hash, n int) (b common.Hash) {
 	}
 	return b
 }
+
+func parseRecord(text string) (*Node, error) {
+	blob, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record encoding (%v)", err)
+	}
+	var r enr.Record
+	if err := rlp.DecodeBytes(blob, &r); err != nil {
+		return nil, fmt.Errorf("invalid record (%v)", err)
+	}
+	return nodeFromRecord(&r)
+}
+
+// nodeFromRecord creates a node from a signed node record. The record must
+// contain the public key of the node, the endpoint entries are optional.
+func nodeFromRecord(r *enr.Record) (*Node, error) {
+	var pubkey enr.Secp256k1
+	if err := r.Load(&pubkey); err != nil {
+		return nil, err
+	}
+	var (
+		ip4 enr.IP4
+		ip6 enr.IP6
+		ip  net.IP
+		tcp enr.TCP
+		udp enr.UDP
+	)
+	if r.Load(&ip4) == nil {
+		ip = net.IP(ip4)
+	} else if r.Load(&ip6) == nil {
+		ip = net.IP(ip6)
+	}
+	r.Load(&tcp)
+	r.Load(&udp)
+
+	n := NewNode(PubkeyID((*ecdsa.PublicKey)(&pubkey)), ip, uint16(udp), uint16(tcp))
+	n.record = r
+	return n, nil
+}
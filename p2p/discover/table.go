@@ -36,6 +36,7 @@ import (
 	"github.com/happyuc-project/happyuc-go/common"
 	"github.com/happyuc-project/happyuc-go/crypto"
 	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/p2p/enr"
 	"github.com/happyuc-project/happyuc-go/p2p/netutil"
 )
 
@@ -56,6 +57,7 @@ const (
 
 	maxBondingPingPongs = 16 // Limit on the number of concurrent ping/pong interactions
 	maxFindnodeFailures = 5  // Nodes exceeding this limit are dropped
+	holePunchRelays     = 3  // Number of relays asked to introduce a node
 
 	refreshInterval    = 30 * time.Minute
 	revalidateInterval = 10 * time.Second
@@ -84,8 +86,9 @@ type Table struct {
 
 	nodeAddedHook func(*Node) // for testing
 
-	net  transport
-	self *Node // metadata of the local node
+	net   transport
+	self  *Node      // metadata of the local node
+	local *LocalNode // signed record of the local node, nil in tests
 }
 
 type bondproc struct {
@@ -101,6 +104,8 @@ type transport interface {
 	ping(NodeID, *net.UDPAddr) error
 	waitping(NodeID) error
 	findnode(toid NodeID, addr *net.UDPAddr, target NodeID) ([]*Node, error)
+	requestENR(toid NodeID, addr *net.UDPAddr) (*enr.Record, error)
+	rendezvous(relay NodeID, relayaddr *net.UDPAddr, target NodeID) (*Node, error)
 	close()
 }
 
@@ -164,9 +169,59 @@ func (tab *Table) seedRand() {
 // Self returns the local node.
 // The returned node should not be modified by the caller.
 func (tab *Table) Self() *Node {
+	if tab.local != nil {
+		return tab.local.Node()
+	}
 	return tab.self
 }
 
+// LocalNode returns the local node record manager of the table.
+func (tab *Table) LocalNode() *LocalNode {
+	return tab.local
+}
+
+// RequestENR retrieves the signed node record of n. The returned node carries
+// the record and the endpoint announced in it.
+func (tab *Table) RequestENR(n *Node) (*Node, error) {
+	if err := n.validateComplete(); err != nil {
+		return nil, err
+	}
+	// The remote side only answers bonded nodes
+	if _, err := tab.bond(false, n.ID, n.addr(), n.TCP); err != nil {
+		return nil, err
+	}
+	r, err := tab.net.requestENR(n.ID, n.addr())
+	if err != nil {
+		return nil, err
+	}
+	return nodeFromRecord(r)
+}
+
+// HolePunch tries to reach target if it is behind a NAT which drops
+// unsolicited packets. Nodes in the table closest to target are asked to act
+// as relays, they are the most likely ones to know it. The returned node
+// carries the endpoint of target as seen by the relay and should be dialed
+// right away, while the NAT mappings opened by the probes are still alive.
+func (tab *Table) HolePunch(target NodeID) (*Node, error) {
+	hash := crypto.Keccak256Hash(target[:])
+	tab.mutex.Lock()
+	relays := tab.closest(hash, holePunchRelays+1).entries
+	tab.mutex.Unlock()
+
+	err := errors.New("no relay available")
+	for i, tried := 0, 0; i < len(relays) && tried < holePunchRelays; i++ {
+		if relays[i].ID == target {
+			continue
+		}
+		tried++
+		var n *Node
+		if n, err = tab.net.rendezvous(relays[i].ID, relays[i].addr(), target); err == nil {
+			return n, nil
+		}
+	}
+	return nil, err
+}
+
 // ReadRandomNodes fills the given slice with random nodes from the
 // table. It will not write the same node more than once. The nodes in
 // the slice are copies and can be modified by the caller.
@@ -30,6 +30,7 @@ import (
 
 	"github.com/happyuc-project/happyuc-go/common"
 	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/p2p/enr"
 )
 
 func TestTable_pingReplace(t *testing.T) {
@@ -194,6 +195,7 @@ func nodeAtDistance(base common.Hash, ld int) (n *Node) {
 type pingRecorder struct {
 	mu           sync.Mutex
 	dead, pinged map[NodeID]bool
+	relays       []NodeID
 }
 
 func newPingRecorder() *pingRecorder {
@@ -206,6 +208,16 @@ func newPingRecorder() *pingRecorder {
 func (t *pingRecorder) findnode(toid NodeID, toaddr *net.UDPAddr, target NodeID) ([]*Node, error) {
 	return nil, nil
 }
+func (t *pingRecorder) requestENR(toid NodeID, toaddr *net.UDPAddr) (*enr.Record, error) {
+	return nil, errTimeout
+}
+func (t *pingRecorder) rendezvous(relay NodeID, relayaddr *net.UDPAddr, target NodeID) (*Node, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.relays = append(t.relays, relay)
+	return nil, errTimeout
+}
 func (t *pingRecorder) close() {}
 func (t *pingRecorder) waitping(from NodeID) error {
 	return nil // remote always pings
@@ -278,6 +290,34 @@ func TestTable_closest(t *testing.T) {
 	}
 }
 
+// This test checks that HolePunch asks a limited number of relays and never
+// asks the target itself.
+func TestTable_HolePunchRelays(t *testing.T) {
+	transport := newPingRecorder()
+	tab, _ := newTable(transport, NodeID{}, &net.UDPAddr{}, "", nil)
+	defer tab.Close()
+	<-tab.initDone
+
+	var nodes []*Node
+	for ld := 240; ld < 240+2*holePunchRelays; ld++ {
+		nodes = append(nodes, nodeAtDistance(tab.self.sha, ld))
+	}
+	tab.stuff(nodes)
+	target := nodes[0].ID
+
+	if _, err := tab.HolePunch(target); err != errTimeout {
+		t.Fatalf("wrong error: got %v, want %v", err, errTimeout)
+	}
+	if len(transport.relays) != holePunchRelays {
+		t.Fatalf("wrong number of relays asked: got %d, want %d", len(transport.relays), holePunchRelays)
+	}
+	for _, id := range transport.relays {
+		if id == target {
+			t.Fatal("target was asked to relay")
+		}
+	}
+}
+
 func TestTable_ReadRandomNodesGetAll(t *testing.T) {
 	cfg := &quick.Config{
 		MaxCount: 200,
@@ -562,6 +602,14 @@ type preminedTestnet struct {
 	dists     [hashBits + 1][]NodeID
 }
 
+func (*preminedTestnet) requestENR(toid NodeID, toaddr *net.UDPAddr) (*enr.Record, error) {
+	return nil, errTimeout
+}
+
+func (*preminedTestnet) rendezvous(relay NodeID, relayaddr *net.UDPAddr, target NodeID) (*Node, error) {
+	return nil, errTimeout
+}
+
 func (tn *preminedTestnet) findnode(toid NodeID, toaddr *net.UDPAddr, target NodeID) ([]*Node, error) {
 	// current log distance is encoded in port number
 	// fmt.Println("findnode query at dist", toaddr.Port)
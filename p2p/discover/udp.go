@@ -23,10 +23,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/happyuc-project/happyuc-go/crypto"
 	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/p2p/enr"
 	"github.com/happyuc-project/happyuc-go/p2p/nat"
 	"github.com/happyuc-project/happyuc-go/p2p/netutil"
 	"github.com/happyuc-project/happyuc-go/rlp"
@@ -44,6 +46,9 @@ var (
 	errTimeout          = errors.New("RPC timeout")
 	errClockWarp        = errors.New("reply deadline too far in the future")
 	errClosed           = errors.New("socket closed")
+	errRecordMismatch   = errors.New("node record doesn't match node ID")
+	errNoHolePunch      = errors.New("hole punching disabled")
+	errTooManyIntros    = errors.New("too many introductions")
 )
 
 // Timeouts
@@ -55,6 +60,11 @@ const (
 	ntpFailureThreshold = 32               // Continuous timeouts after which to check NTP
 	ntpWarningCooldown  = 10 * time.Minute // Minimum amount of time to pass before repeating NTP warning
 	driftThreshold      = 10 * time.Second // Allowed clock drift before warning user
+
+	holePunchProbes = 3 // Number of pings sent to open the local NAT mapping
+
+	introductionInterval = 10 * time.Second // Minimum time between introductions by the same relay
+	maxIntroRelays       = 256              // Maximum number of relays tracked for rate limiting
 )
 
 // RPC packet types
@@ -63,6 +73,10 @@ const (
 	pongPacket
 	findnodePacket
 	neighborsPacket
+	enrRequestPacket
+	enrResponsePacket
+	rendezvousPacket
+	introductionPacket
 )
 
 // RPC request structures
@@ -104,6 +118,39 @@ type (
 		Rest []rlp.RawValue `rlp:"tail"`
 	}
 
+	// enrRequest queries for the signed node record of the recipient.
+	enrRequest struct {
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// enrResponse is the reply to enrRequest.
+	enrResponse struct {
+		ReplyTok []byte // Hash of the enrRequest packet.
+		Record   enr.Record
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// rendezvous asks the recipient to introduce the sender to the target
+	// node. Both must be bonded with the recipient.
+	rendezvous struct {
+		Target     NodeID
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// introduction is sent to both sides of a rendezvous. It carries the
+	// endpoint of the other side as seen by the relay.
+	introduction struct {
+		Peer       rpcNode
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
 	rpcNode struct {
 		IP  net.IP // len 4 for IPv4 or 16 for IPv6
 		UDP uint16 // for discovery protocol
@@ -167,8 +214,12 @@ type udp struct {
 	addpending chan *pending
 	gotreply   chan reply
 
-	closing chan struct{}
-	nat     nat.Interface
+	closing    chan struct{}
+	nat        nat.Interface
+	introduced chan<- *Node
+
+	introMu   sync.Mutex
+	lastIntro map[NodeID]time.Time // time of the last introduction accepted from each relay
 
 	*Table
 }
@@ -227,6 +278,12 @@ type Config struct {
 	NetRestrict  *netutil.Netlist  // network whitelist
 	Bootnodes    []*Node           // list of bootstrap nodes
 	Unhandled    chan<- ReadPacket // unhandled packets are sent on this channel
+
+	// If Introduced is set, the node takes part in hole punching: when a
+	// relay introduces another node, UDP probes are sent to it and it is
+	// delivered on this channel so the caller can dial it. Nodes keep
+	// acting as relays for others either way.
+	Introduced chan<- *Node
 }
 
 // ListenUDP returns a new table that listens for UDP packets on laddr.
@@ -244,6 +301,8 @@ func newUDP(c conn, cfg Config) (*Table, *udp, error) {
 		conn:        c,
 		priv:        cfg.PrivateKey,
 		netrestrict: cfg.NetRestrict,
+		introduced:  cfg.Introduced,
+		lastIntro:   make(map[NodeID]time.Time),
 		closing:     make(chan struct{}),
 		gotreply:    make(chan reply),
 		addpending:  make(chan *pending),
@@ -260,6 +319,13 @@ func newUDP(c conn, cfg Config) (*Table, *udp, error) {
 	}
 	udp.Table = tab
 
+	// Maintain the signed record of the local node, announcing the same
+	// endpoint as the discovery protocol until the server knows better.
+	tab.local = newLocalNode(tab.db, cfg.PrivateKey)
+	tab.local.SetIP(realaddr.IP)
+	tab.local.SetUDP(uint16(realaddr.Port))
+	tab.local.SetTCP(uint16(realaddr.Port))
+
 	go udp.loop()
 	go udp.readLoop(cfg.Unhandled)
 	return udp.Table, udp, nil
@@ -286,6 +352,7 @@ func (t *udp) ping(toid NodeID, toaddr *net.UDPAddr) error {
 	errc := t.pending(toid, pongPacket, func(p interface{}) bool {
 		return bytes.Equal(p.(*pong).ReplyTok, hash)
 	})
+	t.local.UDPContact(toaddr)
 	t.write(toaddr, req.name(), packet)
 	return <-errc
 }
@@ -320,6 +387,81 @@ func (t *udp) findnode(toid NodeID, toaddr *net.UDPAddr, target NodeID) ([]*Node
 	return nodes, err
 }
 
+// requestENR sends an enrRequest to the given node and waits for its
+// signed record.
+func (t *udp) requestENR(toid NodeID, toaddr *net.UDPAddr) (*enr.Record, error) {
+	req := &enrRequest{
+		Expiration: uint64(time.Now().Add(expiration).Unix()),
+	}
+	packet, hash, err := encodePacket(t.priv, enrRequestPacket, req)
+	if err != nil {
+		return nil, err
+	}
+	var record *enr.Record
+	errc := t.pending(toid, enrResponsePacket, func(r interface{}) bool {
+		resp := r.(*enrResponse)
+		if !bytes.Equal(resp.ReplyTok, hash) {
+			return false
+		}
+		record = &resp.Record
+		return true
+	})
+	t.write(toaddr, req.name(), packet)
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	// Make sure the record was signed by the node we asked
+	var pubkey enr.Secp256k1
+	if err := record.Load(&pubkey); err != nil {
+		return nil, err
+	}
+	if PubkeyID((*ecdsa.PublicKey)(&pubkey)) != toid {
+		return nil, errRecordMismatch
+	}
+	return record, nil
+}
+
+// rendezvous asks the relay to introduce the local node to target. The relay
+// answers with the endpoint of target and sends our endpoint to target, so
+// both sides start probing each other at the same time. This opens the
+// mappings in NATs which drop unsolicited packets.
+func (t *udp) rendezvous(relay NodeID, relayaddr *net.UDPAddr, target NodeID) (*Node, error) {
+	var peer *Node
+	errc := t.pending(relay, introductionPacket, func(r interface{}) bool {
+		intro := r.(*introduction)
+		if intro.Peer.ID != target {
+			return false
+		}
+		n, err := t.nodeFromRPC(relayaddr, intro.Peer)
+		if err != nil {
+			log.Trace("Invalid introduction received", "ip", intro.Peer.IP, "addr", relayaddr, "err", err)
+			return false
+		}
+		peer = n
+		return true
+	})
+	t.send(relayaddr, rendezvousPacket, &rendezvous{
+		Target:     target,
+		Expiration: uint64(time.Now().Add(expiration).Unix()),
+	})
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	go t.probe(peer)
+	return peer, nil
+}
+
+// probe pings n until it answers or holePunchProbes attempts have been made.
+// The first pings are usually dropped by the remote NAT, but they create the
+// mapping in the local one which lets the probes of the remote side through.
+func (t *udp) probe(n *Node) {
+	for i := 0; i < holePunchProbes; i++ {
+		if t.ping(n.ID, n.addr()) == nil {
+			return
+		}
+	}
+}
+
 // pending adds a reply callback to the pending reply queue.
 // see the documentation of type pending for a detailed explanation.
 func (t *udp) pending(id NodeID, ptype byte, callback func(interface{}) bool) <-chan error {
@@ -571,6 +713,14 @@ func decodePacket(buf []byte) (packet, NodeID, []byte, error) {
 		req = new(findnode)
 	case neighborsPacket:
 		req = new(neighbors)
+	case enrRequestPacket:
+		req = new(enrRequest)
+	case enrResponsePacket:
+		req = new(enrResponse)
+	case rendezvousPacket:
+		req = new(rendezvous)
+	case introductionPacket:
+		req = new(introduction)
 	default:
 		return nil, fromID, hash, fmt.Errorf("unknown type: %d", ptype)
 	}
@@ -604,6 +754,8 @@ func (req *pong) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) er
 	if !t.handleReply(fromID, pongPacket, req) {
 		return errUnsolicitedReply
 	}
+	// The pong tells us how the remote side sees our endpoint.
+	t.local.UDPEndpointStatement(from, &net.UDPAddr{IP: req.To.IP, Port: int(req.To.UDP)})
 	return nil
 }
 
@@ -662,6 +814,126 @@ func (req *neighbors) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byt
 
 func (req *neighbors) name() string { return "NEIGHBORS/v4" }
 
+func (req *enrRequest) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if !t.db.hasBond(fromID) {
+		// Same as findnode, don't amplify traffic towards unverified endpoints.
+		return errUnknownNode
+	}
+	record := t.local.Node().Record()
+	if record == nil {
+		return errors.New("local node record unavailable")
+	}
+	t.send(from, enrResponsePacket, &enrResponse{
+		ReplyTok: mac,
+		Record:   *record,
+	})
+	return nil
+}
+
+func (req *enrRequest) name() string { return "ENRREQUEST/v4" }
+
+func (req *enrResponse) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
+	if !t.handleReply(fromID, enrResponsePacket, req) {
+		return errUnsolicitedReply
+	}
+	return nil
+}
+
+func (req *enrResponse) name() string { return "ENRRESPONSE/v4" }
+
+func (req *rendezvous) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if !t.db.hasBond(fromID) {
+		// Same as findnode, don't send introductions to unverified endpoints.
+		return errUnknownNode
+	}
+	// The target endpoint must be verified too, it is stored with the
+	// address its packets came from, i.e. the outside of its NAT.
+	target := t.db.node(req.Target)
+	if target == nil || !t.db.hasBond(req.Target) || req.Target == fromID {
+		return errUnknownNode
+	}
+	if netutil.CheckRelayIP(from.IP, target.IP) != nil {
+		return errUnknownNode
+	}
+	var tcp uint16
+	if n := t.db.node(fromID); n != nil {
+		tcp = n.TCP
+	}
+	exp := uint64(time.Now().Add(expiration).Unix())
+	requester := nodeToRPC(NewNode(fromID, from.IP, uint16(from.Port), tcp))
+	t.send(target.addr(), introductionPacket, &introduction{Peer: requester, Expiration: exp})
+	t.send(from, introductionPacket, &introduction{Peer: nodeToRPC(target), Expiration: exp})
+	return nil
+}
+
+func (req *rendezvous) name() string { return "RENDEZVOUS/v4" }
+
+func (req *introduction) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if t.handleReply(fromID, introductionPacket, req) {
+		return nil
+	}
+	// Unsolicited introductions are sent to the target of a rendezvous.
+	// Only accept them from relays we know.
+	if t.introduced == nil {
+		return errNoHolePunch
+	}
+	if !t.db.hasBond(fromID) {
+		return errUnknownNode
+	}
+	if req.Peer.ID == t.self.ID {
+		return errUnknownNode
+	}
+	n, err := t.nodeFromRPC(from, req.Peer)
+	if err != nil {
+		return err
+	}
+	if !t.allowIntroduction(fromID) {
+		return errTooManyIntros
+	}
+	go t.probe(n)
+	select {
+	case t.introduced <- n:
+	default:
+	}
+	return nil
+}
+
+// allowIntroduction reports whether an unsolicited introduction by relay may be
+// acted upon, limiting every relay to one introduction per introductionInterval.
+func (t *udp) allowIntroduction(relay NodeID) bool {
+	t.introMu.Lock()
+	defer t.introMu.Unlock()
+
+	now := time.Now()
+	if last, ok := t.lastIntro[relay]; ok && now.Sub(last) < introductionInterval {
+		return false
+	}
+	// Forget the relays which are out of the window before tracking more
+	if len(t.lastIntro) >= maxIntroRelays {
+		for id, last := range t.lastIntro {
+			if now.Sub(last) >= introductionInterval {
+				delete(t.lastIntro, id)
+			}
+		}
+		if len(t.lastIntro) >= maxIntroRelays {
+			return false
+		}
+	}
+	t.lastIntro[relay] = now
+	return true
+}
+
+func (req *introduction) name() string { return "INTRODUCTION/v4" }
+
 func expired(ts uint64) bool {
 	return time.Unix(int64(ts), 0).Before(time.Now())
 }
@@ -36,6 +36,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/happyuc-project/happyuc-go/common"
 	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/p2p/enr"
 	"github.com/happyuc-project/happyuc-go/rlp"
 )
 
@@ -90,10 +91,11 @@ func (test *udpTest) packetIn(wantError error, ptype byte, data packet) error {
 }
 
 // waits for a packet to be sent by the transport.
-// validate should have type func(*udpTest, X) error, where X is a packet type.
+// validate should have type func(X) or func(X, *net.UDPAddr), where X is a
+// packet type. The second argument receives the destination address.
 func (test *udpTest) waitPacketOut(validate interface{}) ([]byte, error) {
 	dgram := test.pipe.waitPacketOut()
-	p, _, hash, err := decodePacket(dgram)
+	p, _, hash, err := decodePacket(dgram.data)
 	if err != nil {
 		return hash, test.errorf("sent packet decode error: %v", err)
 	}
@@ -102,7 +104,11 @@ func (test *udpTest) waitPacketOut(validate interface{}) ([]byte, error) {
 	if reflect.TypeOf(p) != exptype {
 		return hash, test.errorf("sent packet type mismatch, got: %v, want: %v", reflect.TypeOf(p), exptype)
 	}
-	fn.Call([]reflect.Value{reflect.ValueOf(p)})
+	args := []reflect.Value{reflect.ValueOf(p)}
+	if fn.Type().NumIn() > 1 {
+		args = append(args, reflect.ValueOf(&dgram.to))
+	}
+	fn.Call(args)
 	return hash, nil
 }
 
@@ -472,6 +478,178 @@ var testPackets = []struct {
 	},
 }
 
+func TestUDP_ENRRequest(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	// Requests from unbonded nodes are rejected.
+	test.packetIn(errUnknownNode, enrRequestPacket, &enrRequest{Expiration: futureExp})
+
+	test.table.db.updateBondTime(PubkeyID(&test.remotekey.PublicKey), time.Now())
+	test.packetIn(nil, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	test.waitPacketOut(func(p *enrResponse) {
+		n, err := nodeFromRecord(&p.Record)
+		if err != nil {
+			t.Fatalf("invalid record: %v", err)
+		}
+		if n.ID != PubkeyID(&test.localkey.PublicKey) {
+			t.Errorf("record ID mismatch: got %x, want local node", n.ID[:8])
+		}
+		if p.Record.Seq() != test.table.LocalNode().Seq() {
+			t.Errorf("record seq mismatch: got %d, want %d", p.Record.Seq(), test.table.LocalNode().Seq())
+		}
+	})
+}
+
+func TestUDP_requestENR(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	var remote enr.Record
+	remote.Set(enr.IP4(test.remoteaddr.IP))
+	remote.Set(enr.UDP(test.remoteaddr.Port))
+	remote.Set(enr.TCP(30304))
+	if err := remote.Sign(test.remotekey); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		record, err := test.udp.requestENR(PubkeyID(&test.remotekey.PublicKey), test.remoteaddr)
+		if err == nil && record.Seq() != remote.Seq() {
+			err = fmt.Errorf("wrong record seq %d, want %d", record.Seq(), remote.Seq())
+		}
+		done <- err
+	}()
+	hash, _ := test.waitPacketOut(func(p *enrRequest) {})
+
+	// A response with the wrong reply token is not matched.
+	test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: []byte{1, 2, 3}, Record: remote})
+	test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: hash, Record: remote})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUDP_rendezvousRelay(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	var (
+		remoteID  = PubkeyID(&test.remotekey.PublicKey)
+		targetKey = newkey()
+		target    = NewNode(PubkeyID(&targetKey.PublicKey), net.IP{10, 0, 2, 1}, 40404, 40405)
+		req       = &rendezvous{Target: target.ID, Expiration: futureExp}
+	)
+	// Requests from unbonded nodes are rejected.
+	test.packetIn(errUnknownNode, rendezvousPacket, req)
+	test.table.db.updateNode(NewNode(remoteID, test.remoteaddr.IP, uint16(test.remoteaddr.Port), 30304))
+	test.table.db.updateBondTime(remoteID, time.Now())
+
+	// The target must be bonded as well.
+	test.packetIn(errUnknownNode, rendezvousPacket, req)
+	test.table.db.updateNode(target)
+	test.table.db.updateBondTime(target.ID, time.Now())
+
+	// Both sides are told about each other.
+	test.packetIn(nil, rendezvousPacket, req)
+	test.waitPacketOut(func(p *introduction, to *net.UDPAddr) {
+		if !to.IP.Equal(target.IP) || to.Port != int(target.UDP) {
+			t.Errorf("introduction sent to %v, want target %v", to, target.addr())
+		}
+		want := rpcNode{ID: remoteID, IP: test.remoteaddr.IP.To4(), UDP: uint16(test.remoteaddr.Port), TCP: 30304}
+		if !reflect.DeepEqual(p.Peer, want) {
+			t.Errorf("wrong requester endpoint:\ngot  %v\nwant %v", p.Peer, want)
+		}
+	})
+	test.waitPacketOut(func(p *introduction, to *net.UDPAddr) {
+		if !to.IP.Equal(test.remoteaddr.IP) || to.Port != test.remoteaddr.Port {
+			t.Errorf("introduction sent to %v, want requester %v", to, test.remoteaddr)
+		}
+		if !reflect.DeepEqual(p.Peer, nodeToRPC(target)) {
+			t.Errorf("wrong target endpoint:\ngot  %v\nwant %v", p.Peer, nodeToRPC(target))
+		}
+	})
+}
+
+func TestUDP_rendezvousRequest(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	var (
+		relayID   = PubkeyID(&test.remotekey.PublicKey)
+		targetKey = newkey()
+		target    = NewNode(PubkeyID(&targetKey.PublicKey), net.IP{10, 0, 2, 1}, 40404, 40405)
+		result    = make(chan *Node, 1)
+	)
+	go func() {
+		n, err := test.udp.rendezvous(relayID, test.remoteaddr, target.ID)
+		if err != nil {
+			t.Errorf("rendezvous error: %v", err)
+		}
+		result <- n
+	}()
+	test.waitPacketOut(func(p *rendezvous) {
+		if p.Target != target.ID {
+			t.Errorf("wrong rendezvous target %x", p.Target[:8])
+		}
+	})
+	// Introductions of other nodes are not matched.
+	other := nodeToRPC(NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 2, 2}, 40404, 40405))
+	test.packetIn(nil, introductionPacket, &introduction{Peer: other, Expiration: futureExp})
+	test.packetIn(nil, introductionPacket, &introduction{Peer: nodeToRPC(target), Expiration: futureExp})
+
+	n := <-result
+	if n == nil || n.ID != target.ID || !n.IP.Equal(target.IP) || n.TCP != target.TCP {
+		t.Fatalf("wrong node returned: %v", n)
+	}
+	// The target is probed right away.
+	test.waitPacketOut(func(p *ping, to *net.UDPAddr) {
+		if !to.IP.Equal(target.IP) || to.Port != int(target.UDP) {
+			t.Errorf("probe sent to %v, want %v", to, target.addr())
+		}
+	})
+}
+
+func TestUDP_introduction(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	var (
+		relayID = PubkeyID(&test.remotekey.PublicKey)
+		peerKey = newkey()
+		peer    = NewNode(PubkeyID(&peerKey.PublicKey), net.IP{10, 0, 3, 1}, 40404, 40405)
+		intro   = &introduction{Peer: nodeToRPC(peer), Expiration: futureExp}
+	)
+	// Unsolicited introductions are ignored unless hole punching is enabled.
+	test.packetIn(errNoHolePunch, introductionPacket, intro)
+	introduced := make(chan *Node, 1)
+	test.udp.introduced = introduced
+
+	// They must come from a known relay.
+	test.packetIn(errUnknownNode, introductionPacket, intro)
+	test.table.db.updateBondTime(relayID, time.Now())
+	test.packetIn(nil, introductionPacket, intro)
+
+	test.waitPacketOut(func(p *ping, to *net.UDPAddr) {
+		if !to.IP.Equal(peer.IP) || to.Port != int(peer.UDP) {
+			t.Errorf("probe sent to %v, want %v", to, peer.addr())
+		}
+	})
+	select {
+	case n := <-introduced:
+		if n.ID != peer.ID || n.TCP != peer.TCP {
+			t.Errorf("wrong node delivered: %v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("introduced node not delivered")
+	}
+	// Further introductions by the same relay are throttled, and the local
+	// node is never introduced to itself.
+	test.packetIn(errTooManyIntros, introductionPacket, intro)
+	self := &introduction{Peer: nodeToRPC(test.table.self), Expiration: futureExp}
+	test.packetIn(errUnknownNode, introductionPacket, self)
+}
+
 func TestForwardCompatibility(t *testing.T) {
 	testkey, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
 	wantNodeID := PubkeyID(&testkey.PublicKey)
@@ -501,7 +679,12 @@ type dgramPipe struct {
 	cond    *sync.Cond
 	closing chan struct{}
 	closed  bool
-	queue   [][]byte
+	queue   []dgram
+}
+
+type dgram struct {
+	to   net.UDPAddr
+	data []byte
 }
 
 func newpipe() *dgramPipe {
@@ -522,7 +705,7 @@ func (c *dgramPipe) WriteToUDP(b []byte, to *net.UDPAddr) (n int, err error) {
 	if c.closed {
 		return 0, errors.New("closed")
 	}
-	c.queue = append(c.queue, msg)
+	c.queue = append(c.queue, dgram{*to, msg})
 	c.cond.Signal()
 	return len(b), nil
 }
@@ -547,7 +730,7 @@ func (c *dgramPipe) LocalAddr() net.Addr {
 	return &net.UDPAddr{IP: testLocal.IP, Port: int(testLocal.UDP)}
 }
 
-func (c *dgramPipe) waitPacketOut() []byte {
+func (c *dgramPipe) waitPacketOut() dgram {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	for len(c.queue) == 0 {
@@ -0,0 +1,348 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dnsdisc implements node discovery via DNS (EIP-1459). Lists of nodes
+// are published as signed merkle trees of node records in DNS TXT records, which
+// makes them reachable even where UDP based discovery is blocked.
+package dnsdisc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/crypto/sha3"
+	"github.com/happyuc-project/happyuc-go/log"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/hashicorp/golang-lru"
+)
+
+const maxTreeDepth = 32 // Maximum number of branch levels followed during sync
+
+var (
+	errNoRoot        = errors.New("no valid root found")
+	errHashMismatch  = errors.New("hash mismatch")
+	errNoEntry       = errors.New("no valid tree entry found")
+	errTreeTooDeep   = errors.New("tree too deep")
+	errLinkInENRTree = errors.New("link entry in node tree")
+	errENRInLinkTree = errors.New("node entry in link tree")
+)
+
+// Config holds the settings of a DNS discovery client.
+type Config struct {
+	Timeout         time.Duration // timeout used for DNS lookups (default 5s)
+	RecheckInterval time.Duration // time between tree root update checks (default 30min)
+	CacheLimit      int           // maximum number of cached records (default 1000)
+	Resolver        Resolver      // the DNS resolver to use (defaults to system DNS)
+	Logger          log.Logger    // destination of client log messages (defaults to root logger)
+}
+
+// Resolver is a DNS resolver that can query TXT records.
+type Resolver interface {
+	LookupTXT(ctx context.Context, domain string) ([]string, error)
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.RecheckInterval == 0 {
+		cfg.RecheckInterval = 30 * time.Minute
+	}
+	if cfg.CacheLimit == 0 {
+		cfg.CacheLimit = 1000
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = new(net.Resolver)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Root()
+	}
+	return cfg
+}
+
+// Client discovers nodes by querying DNS servers.
+type Client struct {
+	cfg     Config
+	entries *lru.Cache
+
+	mu    sync.Mutex
+	trees map[string]*clientTree // trees to sync, keyed by their URL
+	rand  *mrand.Rand
+
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// clientTree is the sync state of a single tree.
+type clientTree struct {
+	loc   *linkEntry
+	seq   uint
+	nodes []*discover.Node
+	err   error // last sync error, nil if the last sync succeeded
+}
+
+// NewClient creates a client which syncs the trees at the given enrtree URLs
+// (and any trees linked from them) once started.
+func NewClient(cfg Config, urls ...string) (*Client, error) {
+	cfg = cfg.withDefaults()
+	cache, err := lru.New(cfg.CacheLimit)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		cfg:     cfg,
+		entries: cache,
+		trees:   make(map[string]*clientTree),
+		rand:    mrand.New(mrand.NewSource(time.Now().UnixNano())),
+		closing: make(chan struct{}),
+	}
+	for _, url := range urls {
+		loc, err := parseLink(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid enrtree URL %q: %v", url, err)
+		}
+		c.trees[loc.url()] = &clientTree{loc: loc}
+	}
+	return c, nil
+}
+
+// SyncTree downloads the entire node tree at the given URL. It doesn't follow
+// links to other trees.
+func (c *Client) SyncTree(url string) (*Tree, error) {
+	loc, err := parseLink(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid enrtree URL: %v", err)
+	}
+	return c.syncTree(loc)
+}
+
+// Start launches the background sync of all trees.
+func (c *Client) Start() {
+	c.wg.Add(1)
+	go c.loop()
+}
+
+// Close stops the background sync.
+func (c *Client) Close() {
+	close(c.closing)
+	c.wg.Wait()
+}
+
+// ReadRandomNodes fills the given slice with random nodes from all synced trees.
+// It returns the number of nodes written.
+func (c *Client) ReadRandomNodes(buf []*discover.Node) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var all []*discover.Node
+	for _, t := range c.trees {
+		all = append(all, t.nodes...)
+	}
+	c.rand.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+	return copy(buf, all)
+}
+
+func (c *Client) loop() {
+	defer c.wg.Done()
+
+	recheck := time.NewTimer(0)
+	defer recheck.Stop()
+	for {
+		select {
+		case <-recheck.C:
+			c.syncAll()
+			recheck.Reset(c.cfg.RecheckInterval)
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// syncAll checks the roots of all known trees and syncs those which changed,
+// adding any newly linked trees to the set of known trees.
+func (c *Client) syncAll() {
+	for synced := make(map[string]bool); ; {
+		// Pick the next tree which hasn't been checked in this round
+		c.mu.Lock()
+		var next *clientTree
+		for url, t := range c.trees {
+			if !synced[url] {
+				next = t
+				break
+			}
+		}
+		c.mu.Unlock()
+		if next == nil {
+			return
+		}
+		synced[next.loc.url()] = true
+
+		select {
+		case <-c.closing:
+			return
+		default:
+		}
+		c.syncClientTree(next)
+	}
+}
+
+func (c *Client) syncClientTree(ct *clientTree) {
+	root, err := c.resolveRoot(ct.loc)
+	if err != nil {
+		c.cfg.Logger.Debug("Failed to resolve DNS tree root", "tree", ct.loc.domain, "err", err)
+		return
+	}
+	c.mu.Lock()
+	unchanged := ct.err == nil && ct.nodes != nil && root.seq == ct.seq
+	c.mu.Unlock()
+	if unchanged {
+		return
+	}
+	t, err := c.syncTreeFrom(ct.loc, root)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ct.err = err
+	if err != nil {
+		c.cfg.Logger.Debug("Failed to sync DNS tree", "tree", ct.loc.domain, "err", err)
+		return
+	}
+	ct.seq, ct.nodes = root.seq, t.Nodes()
+	for _, link := range t.Links() {
+		if _, ok := c.trees[link]; !ok {
+			loc, _ := parseLink(link)
+			c.trees[link] = &clientTree{loc: loc}
+		}
+	}
+	c.cfg.Logger.Info("Synced DNS discovery tree", "tree", ct.loc.domain, "seq", ct.seq, "nodes", len(ct.nodes))
+}
+
+func (c *Client) syncTree(loc *linkEntry) (*Tree, error) {
+	root, err := c.resolveRoot(loc)
+	if err != nil {
+		return nil, err
+	}
+	return c.syncTreeFrom(loc, root)
+}
+
+// syncTreeFrom downloads all entries of the tree below the given root.
+func (c *Client) syncTreeFrom(loc *linkEntry, root rootEntry) (*Tree, error) {
+	t := &Tree{root: &root, entries: make(map[string]entry)}
+	if err := c.syncSubtree(t, loc.domain, root.lroot, true, 0); err != nil {
+		return nil, err
+	}
+	if err := c.syncSubtree(t, loc.domain, root.eroot, false, 0); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (c *Client) syncSubtree(t *Tree, domain, hash string, link bool, depth int) error {
+	if depth > maxTreeDepth {
+		return errTreeTooDeep
+	}
+	e, err := c.resolveEntry(domain, hash)
+	if err != nil {
+		return err
+	}
+	t.entries[hash] = e
+
+	switch e := e.(type) {
+	case *branchEntry:
+		for _, h := range e.children {
+			if err := c.syncSubtree(t, domain, h, link, depth+1); err != nil {
+				return err
+			}
+		}
+	case *linkEntry:
+		if !link {
+			return errLinkInENRTree
+		}
+	case *enrEntry:
+		if link {
+			return errENRInLinkTree
+		}
+	}
+	return nil
+}
+
+// resolveRoot retrieves and verifies the root of the tree at loc.
+func (c *Client) resolveRoot(loc *linkEntry) (rootEntry, error) {
+	txts, err := c.lookupTXT(loc.domain)
+	if err != nil {
+		return rootEntry{}, err
+	}
+	for _, txt := range txts {
+		if strings.HasPrefix(txt, rootPrefix) {
+			e, err := parseRoot(txt)
+			if err != nil {
+				return e, err
+			}
+			if !e.verifySignature(loc.pubkey) {
+				return e, entryError{typ: "root", err: errInvalidSig}
+			}
+			return e, nil
+		}
+	}
+	return rootEntry{}, errNoRoot
+}
+
+// resolveEntry retrieves an entry from the cache or fetches it from the network
+// if it isn't cached.
+func (c *Client) resolveEntry(domain, hash string) (entry, error) {
+	cacheKey := hash + "." + domain
+	if e, ok := c.entries.Get(cacheKey); ok {
+		return e.(entry), nil
+	}
+	wantHash, err := b32format.DecodeString(hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base32 hash")
+	}
+	txts, err := c.lookupTXT(cacheKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, txt := range txts {
+		e, err := parseEntry(txt)
+		if err == errUnknownEntry {
+			continue
+		}
+		h := sha3.NewKeccak256()
+		h.Write([]byte(txt))
+		if !strings.HasPrefix(string(h.Sum(nil)), string(wantHash)) {
+			err = entryError{typ: "entry", err: errHashMismatch}
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.entries.Add(cacheKey, e)
+		return e, nil
+	}
+	return nil, errNoEntry
+}
+
+func (c *Client) lookupTXT(name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+	return c.cfg.Resolver.LookupTXT(ctx, name)
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+)
+
+// mapResolver is a Resolver backed by a map of TXT records.
+type mapResolver map[string]string
+
+func (mr mapResolver) add(m map[string]string) {
+	for k, v := range m {
+		mr[k] = v
+	}
+}
+
+func (mr mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if record, ok := mr[name]; ok {
+		return []string{record}, nil
+	}
+	return nil, fmt.Errorf("no such record %q", name)
+}
+
+func makeTestTree(t *testing.T, domain string, seq uint, nodes []*discover.Node, links []string) (*Tree, string, *ecdsa.PrivateKey) {
+	key, _ := crypto.GenerateKey()
+	tree, err := MakeTree(seq, nodes, links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := tree.Sign(key, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree, url, key
+}
+
+func TestClientSyncTree(t *testing.T) {
+	nodes := testNodes(2 * maxChildren)
+	tree, url, _ := makeTestTree(t, "n", 1, nodes, nil)
+	r := mapResolver(tree.ToTXT("n"))
+
+	c, _ := NewClient(Config{Resolver: r})
+	synced, err := c.SyncTree(url)
+	if err != nil {
+		t.Fatal("sync error:", err)
+	}
+	if !reflect.DeepEqual(synced.ToTXT("n"), tree.ToTXT("n")) {
+		t.Error("synced tree doesn't match published tree")
+	}
+	if got := synced.Nodes(); len(got) != len(nodes) {
+		t.Errorf("wrong node count %d, want %d", len(got), len(nodes))
+	}
+}
+
+func TestClientSyncTreeBadSignature(t *testing.T) {
+	tree, _, _ := makeTestTree(t, "n", 1, testNodes(3), nil)
+	r := mapResolver(tree.ToTXT("n"))
+
+	// Use the URL of a tree signed by a different key.
+	_, url, _ := makeTestTree(t, "n", 1, testNodes(1), nil)
+	c, _ := NewClient(Config{Resolver: r})
+	if _, err := c.SyncTree(url); err != (entryError{"root", errInvalidSig}) {
+		t.Fatal("expected signature error, got", err)
+	}
+}
+
+func TestClientSyncTreeHashMismatch(t *testing.T) {
+	tree, url, _ := makeTestTree(t, "n", 1, testNodes(3), nil)
+	r := mapResolver(tree.ToTXT("n"))
+
+	// Serve a different valid record in place of one of the tree's nodes.
+	other := testNodes(1)[0]
+	for name, content := range r {
+		if e, _ := parseEntry(content); e != nil {
+			if _, ok := e.(*enrEntry); ok {
+				r[name] = other.ENR()
+				break
+			}
+		}
+	}
+	c, _ := NewClient(Config{Resolver: r})
+	if _, err := c.SyncTree(url); err != (entryError{"entry", errHashMismatch}) {
+		t.Fatal("expected hash mismatch error, got", err)
+	}
+}
+
+func TestClientFollowLinks(t *testing.T) {
+	var (
+		nodesA = testNodes(3)
+		nodesB = testNodes(4)
+	)
+	treeB, urlB, _ := makeTestTree(t, "b", 1, nodesB, nil)
+	treeA, urlA, _ := makeTestTree(t, "a", 1, nodesA, []string{urlB})
+	r := make(mapResolver)
+	r.add(treeA.ToTXT("a"))
+	r.add(treeB.ToTXT("b"))
+
+	c, err := NewClient(Config{Resolver: r}, urlA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.syncAll()
+
+	buf := make([]*discover.Node, 20)
+	n := c.ReadRandomNodes(buf)
+	if n != len(nodesA)+len(nodesB) {
+		t.Fatalf("wrong number of nodes %d, want %d", n, len(nodesA)+len(nodesB))
+	}
+	want := make(map[discover.NodeID]bool)
+	for _, n := range append(nodesA, nodesB...) {
+		want[n.ID] = true
+	}
+	for _, n := range buf[:n] {
+		if !want[n.ID] {
+			t.Errorf("unexpected node %v", n.ID)
+		}
+	}
+}
+
+func TestClientUpdate(t *testing.T) {
+	nodes := testNodes(6)
+	tree1, url, key := makeTestTree(t, "n", 1, nodes[:3], nil)
+	r := mapResolver(tree1.ToTXT("n"))
+
+	c, _ := NewClient(Config{Resolver: r}, url)
+	c.syncAll()
+	if n := c.ReadRandomNodes(make([]*discover.Node, 10)); n != 3 {
+		t.Fatalf("wrong node count %d after first sync", n)
+	}
+
+	// Publish a new version of the tree under the same key.
+	tree2, err := MakeTree(2, nodes[3:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree2.Sign(key, "n"); err != nil {
+		t.Fatal(err)
+	}
+	r.add(tree2.ToTXT("n"))
+	c.syncAll()
+
+	buf := make([]*discover.Node, 10)
+	n := c.ReadRandomNodes(buf)
+	if n != 3 {
+		t.Fatalf("wrong node count %d after update", n)
+	}
+	for _, n := range buf[:n] {
+		if n.ID == nodes[0].ID || n.ID == nodes[1].ID || n.ID == nodes[2].ID {
+			t.Errorf("node %v of old tree still present", n.ID)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/happyuc-project/happyuc-go/crypto"
+	"github.com/happyuc-project/happyuc-go/p2p/discover"
+)
+
+func testNodes(n int) []*discover.Node {
+	nodes := make([]*discover.Node, n)
+	for i := range nodes {
+		key, _ := crypto.GenerateKey()
+		ln := discover.NewLocalNode(key)
+		ln.SetIP(net.IP{127, 0, 0, byte(i + 1)})
+		ln.SetUDP(30303)
+		ln.SetTCP(30303)
+		nodes[i] = ln.Node()
+	}
+	return sortByID(nodes)
+}
+
+func TestTreeSignAndParse(t *testing.T) {
+	var (
+		key, _ = crypto.GenerateKey()
+		nodes  = testNodes(3 * maxChildren)
+		links  = []string{"enrtree://AM5FCQLWIZX2QFPNJAP7VUERCCRNGRHWZG3YYHIUV7BVDQ5FDPRT2@morenodes.example.org"}
+	)
+	tree, err := MakeTree(1, nodes, links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := tree.Sign(key, "nodes.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(url, "@nodes.example.org") {
+		t.Errorf("wrong tree URL %q", url)
+	}
+
+	// Every record must parse back into the entry it was created from.
+	txt := tree.ToTXT("nodes.example.org")
+	root, err := parseRoot(txt["nodes.example.org"])
+	if err != nil {
+		t.Fatal("can't parse root:", err)
+	}
+	if !root.verifySignature(&key.PublicKey) {
+		t.Fatal("root signature invalid")
+	}
+	if root.seq != 1 {
+		t.Errorf("wrong root seq %d", root.seq)
+	}
+	for name, content := range txt {
+		if name == "nodes.example.org" {
+			continue
+		}
+		e, err := parseEntry(content)
+		if err != nil {
+			t.Fatalf("can't parse entry %s: %v", name, err)
+		}
+		if sd := subdomain(e) + ".nodes.example.org"; sd != name {
+			t.Errorf("entry %s stored at %s", sd, name)
+		}
+	}
+
+	if !reflect.DeepEqual(tree.Links(), links) {
+		t.Errorf("wrong links %v", tree.Links())
+	}
+	if got := tree.Nodes(); len(got) != len(nodes) {
+		t.Errorf("wrong node count %d, want %d", len(got), len(nodes))
+	}
+}
+
+func TestTreeSetSignature(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	tree, err := MakeTree(3, testNodes(2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Sign(key, "n"); err != nil {
+		t.Fatal(err)
+	}
+	sig := tree.Signature()
+
+	other, _ := MakeTree(3, tree.Nodes(), nil)
+	if err := other.SetSignature(&key.PublicKey, sig); err != nil {
+		t.Fatal("valid signature rejected:", err)
+	}
+	otherKey, _ := crypto.GenerateKey()
+	if err := other.SetSignature(&otherKey.PublicKey, sig); err != errInvalidSig {
+		t.Fatal("signature of different key accepted:", err)
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{input: "enrtree-branch:", err: nil},
+		{input: "enrtree-branch:AAAAAAAAAAAAAAAAAAAA", err: nil},
+		{input: "enrtree-branch:AAAAAAAAAAAAAAAAAAAA,AAAAAAAAAAAAAAAAAAAA", err: nil},
+		{input: "enrtree-branch:AAAAAAAAA", err: entryError{"branch", errInvalidChild}},
+		{input: "enrtree://AM5FCQLWIZX2QFPNJAP7VUERCCRNGRHWZG3YYHIUV7BVDQ5FDPRT2@nodes.example.org", err: nil},
+		{input: "enrtree://nodes.example.org", err: entryError{"link", errNoPubkey}},
+		{input: "enrtree://AAAA@nodes.example.org", err: entryError{"link", errBadPubkey}},
+		{input: "foo", err: errUnknownEntry},
+	}
+	for _, test := range tests {
+		if _, err := parseEntry(test.input); !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%q: wrong error %v, want %v", test.input, err, test.err)
+		}
+	}
+}
@@ -145,6 +145,69 @@ func (n extIP) String() string              { return fmt.Sprintf("ExtIP(%v)", ne
 func (extIP) AddMapping(string, int, int, string, time.Duration) error { return nil }
 func (extIP) DeleteMapping(string, int, int) error                     { return nil }
 
+// Observed is a NAT interface whose external IP is learned at runtime from
+// other nodes, e.g. the endpoint seen by discovery peers. Port mapping is
+// delegated to the wrapped mechanism, if any. The wrapped mechanism is also
+// used to get the external IP while nothing has been observed yet. An ExtIP
+// mechanism is never overridden by observations.
+type Observed struct {
+	fallback Interface
+
+	mu sync.Mutex
+	ip net.IP
+}
+
+// NewObserved creates an observed NAT interface. The fallback mechanism may
+// be nil.
+func NewObserved(fallback Interface) *Observed {
+	return &Observed{fallback: fallback}
+}
+
+// SetExternalIP records the external IP address reported by other nodes.
+func (n *Observed) SetExternalIP(ip net.IP) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.ip = ip
+}
+
+// ExternalIP returns the observed external IP address or asks the fallback
+// mechanism if there is none.
+func (n *Observed) ExternalIP() (net.IP, error) {
+	if _, static := n.fallback.(extIP); !static {
+		n.mu.Lock()
+		ip := n.ip
+		n.mu.Unlock()
+		if ip != nil {
+			return ip, nil
+		}
+	}
+	if n.fallback == nil {
+		return nil, errors.New("no external IP observed")
+	}
+	return n.fallback.ExternalIP()
+}
+
+func (n *Observed) AddMapping(protocol string, extport, intport int, name string, lifetime time.Duration) error {
+	if n.fallback == nil {
+		return nil
+	}
+	return n.fallback.AddMapping(protocol, extport, intport, name, lifetime)
+}
+
+func (n *Observed) DeleteMapping(protocol string, extport, intport int) error {
+	if n.fallback == nil {
+		return nil
+	}
+	return n.fallback.DeleteMapping(protocol, extport, intport)
+}
+
+func (n *Observed) String() string {
+	if n.fallback == nil {
+		return "observed"
+	}
+	return fmt.Sprintf("observed(%v)", n.fallback)
+}
+
 // Any returns a port mapper that tries to discover any supported
 // mechanism on the local network.
 func Any() Interface {
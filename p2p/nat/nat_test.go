@@ -61,3 +61,32 @@ func TestAutoDiscRace(t *testing.T) {
 		}
 	}
 }
+
+func TestObserved(t *testing.T) {
+	// Without a fallback, only observed addresses are returned.
+	n := NewObserved(nil)
+	if _, err := n.ExternalIP(); err == nil {
+		t.Fatal("expected error before any observation")
+	}
+	n.SetExternalIP(net.IP{1, 2, 3, 4})
+	if ip, err := n.ExternalIP(); err != nil || !ip.Equal(net.IP{1, 2, 3, 4}) {
+		t.Fatalf("wrong external IP: %v, %v", ip, err)
+	}
+
+	// The fallback is used until something is observed.
+	n = NewObserved(startautodisc("thing", func() Interface { return nil }))
+	if _, err := n.ExternalIP(); err == nil {
+		t.Fatal("expected fallback error")
+	}
+	n.SetExternalIP(net.IP{1, 2, 3, 4})
+	if ip, err := n.ExternalIP(); err != nil || !ip.Equal(net.IP{1, 2, 3, 4}) {
+		t.Fatalf("wrong external IP: %v, %v", ip, err)
+	}
+
+	// Static addresses are never overridden.
+	n = NewObserved(ExtIP(net.IP{33, 44, 55, 66}))
+	n.SetExternalIP(net.IP{1, 2, 3, 4})
+	if ip, err := n.ExternalIP(); err != nil || !ip.Equal(net.IP{33, 44, 55, 66}) {
+		t.Fatalf("wrong external IP: %v, %v", ip, err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package netutil
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common/mclock"
+)
+
+const (
+	testWindow        = 10 * time.Minute
+	testContactWindow = 20 * time.Minute
+	testMinStatements = 3
+)
+
+func newTestIPTracker() (*IPTracker, *mclock.Simulated) {
+	clock := new(mclock.Simulated)
+	it := NewIPTracker(testWindow, testContactWindow, testMinStatements)
+	it.clock = clock
+	return it, clock
+}
+
+func TestIPTrackerPredictEndpoint(t *testing.T) {
+	it, clock := newTestIPTracker()
+
+	// No prediction below the minimum number of statements.
+	it.AddStatement("host1", "1.2.3.4:30303")
+	it.AddStatement("host2", "1.2.3.4:30303")
+	if ep := it.PredictEndpoint(); ep != "" {
+		t.Fatalf("unexpected prediction %q with too few statements", ep)
+	}
+	it.AddStatement("host3", "1.2.3.4:30303")
+	if ep := it.PredictEndpoint(); ep != "1.2.3.4:30303" {
+		t.Fatalf("wrong prediction %q", ep)
+	}
+
+	// A host changing its statement only counts once.
+	for i := 0; i < 3; i++ {
+		it.AddStatement("host1", "5.6.7.8:30303")
+	}
+	if ep := it.PredictEndpoint(); ep != "" {
+		t.Fatalf("unexpected prediction %q after statement change", ep)
+	}
+
+	// The majority wins once enough hosts agree on the new endpoint.
+	for i := 4; i < 8; i++ {
+		it.AddStatement(fmt.Sprintf("host%d", i), "5.6.7.8:30303")
+	}
+	if ep := it.PredictEndpoint(); ep != "5.6.7.8:30303" {
+		t.Fatalf("wrong prediction %q after majority change", ep)
+	}
+
+	// Statements expire after the window.
+	clock.Run(testWindow + time.Second)
+	if ep := it.PredictEndpoint(); ep != "" {
+		t.Fatalf("unexpected prediction %q after expiry", ep)
+	}
+}
+
+func TestIPTrackerPredictFullConeNAT(t *testing.T) {
+	it, clock := newTestIPTracker()
+
+	// Statements from contacted hosts don't indicate full cone NAT.
+	it.AddContact("host1")
+	clock.Run(time.Second)
+	it.AddStatement("host1", "1.2.3.4:30303")
+	if it.PredictFullConeNAT() {
+		t.Fatal("full cone NAT predicted for contacted host")
+	}
+
+	// A statement from a host we never contacted does.
+	it.AddStatement("host2", "1.2.3.4:30303")
+	if !it.PredictFullConeNAT() {
+		t.Fatal("full cone NAT not predicted for uncontacted host")
+	}
+
+	// The prediction goes away once the statement expires.
+	clock.Run(testWindow + time.Second)
+	if it.PredictFullConeNAT() {
+		t.Fatal("full cone NAT predicted after statements expired")
+	}
+}
@@ -19,6 +19,7 @@ package p2p
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -223,6 +224,86 @@ func TestProtocolHandshake(t *testing.T) {
 	wg.Wait()
 }
 
+// This test checks that Snappy compression is enabled only when both sides
+// announce support for it and that messages can be exchanged either way.
+func TestProtocolHandshakeSnappy(t *testing.T) {
+	tests := []struct {
+		dialVersion, listenVersion uint64
+		wantSnappy                 bool
+	}{
+		{dialVersion: 4, listenVersion: 4, wantSnappy: false},
+		{dialVersion: 4, listenVersion: snappyProtocolVersion, wantSnappy: false},
+		{dialVersion: snappyProtocolVersion, listenVersion: 4, wantSnappy: false},
+		{dialVersion: snappyProtocolVersion, listenVersion: snappyProtocolVersion, wantSnappy: true},
+		{dialVersion: snappyProtocolVersion + 1, listenVersion: snappyProtocolVersion, wantSnappy: true},
+	}
+	for i, test := range tests {
+		if err := testProtocolHandshakeSnappy(test.dialVersion, test.listenVersion, test.wantSnappy); err != nil {
+			t.Errorf("test %d (dial v%d, listen v%d): %v", i, test.dialVersion, test.listenVersion, err)
+		}
+	}
+}
+
+func testProtocolHandshakeSnappy(dialVersion, listenVersion uint64, wantSnappy bool) error {
+	var (
+		prv0, _ = crypto.GenerateKey()
+		node0   = &discover.Node{ID: discover.PubkeyID(&prv0.PublicKey), IP: net.IP{1, 2, 3, 4}, TCP: 33}
+		hs0     = &protoHandshake{Version: dialVersion, ID: node0.ID}
+
+		prv1, _ = crypto.GenerateKey()
+		node1   = &discover.Node{ID: discover.PubkeyID(&prv1.PublicKey), IP: net.IP{5, 6, 7, 8}, TCP: 44}
+		hs1     = &protoHandshake{Version: listenVersion, ID: node1.ID}
+
+		payload = bytes.Repeat([]byte{0x42}, 4096)
+		result  = make(chan error, 2)
+	)
+	fd0, fd1, err := tcpPipe()
+	if err != nil {
+		return err
+	}
+	defer fd0.Close()
+	defer fd1.Close()
+
+	go func() {
+		c := newRLPX(fd0).(*rlpx)
+		if _, err := c.doEncHandshake(prv0, node1); err != nil {
+			result <- fmt.Errorf("dial side enc handshake failed: %v", err)
+			return
+		}
+		if _, err := c.doProtoHandshake(hs0); err != nil {
+			result <- fmt.Errorf("dial side proto handshake error: %v", err)
+			return
+		}
+		if c.rw.snappy != wantSnappy {
+			result <- fmt.Errorf("dial side snappy mismatch: got %t, want %t", c.rw.snappy, wantSnappy)
+			return
+		}
+		result <- Send(c, 0x10, payload)
+	}()
+	go func() {
+		c := newRLPX(fd1).(*rlpx)
+		if _, err := c.doEncHandshake(prv1, nil); err != nil {
+			result <- fmt.Errorf("listen side enc handshake failed: %v", err)
+			return
+		}
+		if _, err := c.doProtoHandshake(hs1); err != nil {
+			result <- fmt.Errorf("listen side proto handshake error: %v", err)
+			return
+		}
+		if c.rw.snappy != wantSnappy {
+			result <- fmt.Errorf("listen side snappy mismatch: got %t, want %t", c.rw.snappy, wantSnappy)
+			return
+		}
+		result <- ExpectMsg(c, 0x10, payload)
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-result; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func TestProtocolHandshakeErrors(t *testing.T) {
 	our := &protoHandshake{Version: 3, Caps: []Cap{{"foo", 2}, {"bar", 3}}, Name: "quux"}
 	tests := []struct {
@@ -312,6 +393,40 @@ ba628a4ba590cb43f7848f41c4382885
 	}
 }
 
+// This test checks that compressed messages exceeding the size limit are
+// rejected before they are decompressed, and that oversized messages
+// are not sent.
+func TestRLPXFrameSnappyLimit(t *testing.T) {
+	buf := new(bytes.Buffer)
+	hash := fakeHash([]byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1})
+	rw := newRLPXFrameRW(buf, secrets{
+		AES:        crypto.Keccak256(),
+		MAC:        crypto.Keccak256(),
+		IngressMAC: hash,
+		EgressMAC:  hash,
+	})
+
+	// Write a snappy block header announcing a decompressed length above
+	// the limit. The frame is written uncompressed so it reaches the reader
+	// as is.
+	bomb := make([]byte, binary.MaxVarintLen32+4)
+	n := binary.PutUvarint(bomb, uint64(maxUint24)+1)
+	bomb = bomb[:n+4]
+	if err := rw.WriteMsg(Msg{Code: 8, Size: uint32(len(bomb)), Payload: bytes.NewReader(bomb)}); err != nil {
+		t.Fatalf("WriteMsg error: %v", err)
+	}
+	rw.snappy = true
+	if _, err := rw.ReadMsg(); err != errPlainMessageTooLarge {
+		t.Fatalf("wrong error for oversized compressed message: got %v, want %v", err, errPlainMessageTooLarge)
+	}
+
+	// Messages larger than the limit can't be sent either.
+	big := Msg{Code: 8, Size: maxUint24 + 1, Payload: bytes.NewReader(nil)}
+	if err := rw.WriteMsg(big); err != errPlainMessageTooLarge {
+		t.Fatalf("wrong error for oversized message: got %v, want %v", err, errPlainMessageTooLarge)
+	}
+}
+
 type fakeHash []byte
 
 func (fakeHash) Write(p []byte) (int, error) { return len(p), nil }
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/happyuc-project/happyuc-go/common"
@@ -31,6 +32,7 @@ import (
 	"github.com/happyuc-project/happyuc-go/log"
 	"github.com/happyuc-project/happyuc-go/p2p/discover"
 	"github.com/happyuc-project/happyuc-go/p2p/discv5"
+	"github.com/happyuc-project/happyuc-go/p2p/dnsdisc"
 	"github.com/happyuc-project/happyuc-go/p2p/nat"
 	"github.com/happyuc-project/happyuc-go/p2p/netutil"
 )
@@ -39,9 +41,13 @@ const (
 	defaultDialTimeout = 15 * time.Second
 
 	// Connectivity defaults.
-	maxActiveDialTasks     = 16
-	defaultMaxPendingPeers = 50
-	defaultDialRatio       = 3
+	maxActiveDialTasks       = 16
+	defaultMaxPendingPeers   = 50
+	defaultDialRatio         = 3
+	defaultMaxPeersPerSubnet = 5
+
+	// Inbound connection attempts from the same IP are throttled.
+	inboundThrottleTime = 30 * time.Second
 
 	// Maximum time allowed for reading a complete message.
 	// This is effectively the amount of time a connection can be idle.
@@ -49,6 +55,13 @@ const (
 
 	// Maximum amount of time allowed for writing a complete message.
 	frameWriteTimeout = 20 * time.Second
+
+	// Interval at which the external IP is re-checked if NAT is configured.
+	natRefreshInterval = 10 * time.Minute
+
+	// Interval at which the external IP predicted from discovery is
+	// re-checked.
+	observedRefreshInterval = 1 * time.Minute
 )
 
 var errServerStopped = errors.New("server stopped")
@@ -72,6 +85,11 @@ type Config struct {
 	// Setting DialRatio to zero defaults it to 3.
 	DialRatio int `toml:",omitempty"`
 
+	// MaxPeersPerSubnet limits the number of peers from the same /24 (IPv4)
+	// or /56 (IPv6) network. Static, trusted and LAN peers are exempt.
+	// Zero defaults to preset values.
+	MaxPeersPerSubnet int `toml:",omitempty"`
+
 	// NoDiscovery can be used to disable the peer discovery mechanism.
 	// Disabling is useful for protocol debugging (manual topology).
 	NoDiscovery bool
@@ -93,6 +111,11 @@ type Config struct {
 	// protocol.
 	BootstrapNodesV5 []*discv5.Node `toml:",omitempty"`
 
+	// DNSDiscovery is a list of enrtree:// URLs of DNS node lists (EIP-1459).
+	// Nodes found in these lists are used as dial candidates, which works
+	// even when UDP based discovery is blocked.
+	DNSDiscovery []string `toml:",omitempty"`
+
 	// Static nodes are used as pre-configured connections which are always
 	// maintained and re-connected on disconnects.
 	StaticNodes []*discover.Node
@@ -128,6 +151,14 @@ type Config struct {
 	// Internet.
 	NAT nat.Interface `toml:",omitempty"`
 
+	// If HolePunch is set, dynamic dials which fail are retried after
+	// asking discovery nodes to introduce the local node to the
+	// destination, so that both sides send probes at the same time.
+	// Introductions requested by other nodes are dialed as well.
+	// This helps reaching nodes behind NATs which don't support port
+	// mapping. It has no effect if discovery is disabled.
+	HolePunch bool `toml:",omitempty"`
+
 	// If Dialer is set to a non-nil value, the given Dialer
 	// is used to dial outbound peer connections.
 	Dialer NodeDialer `toml:"-"`
@@ -141,6 +172,8 @@ type Config struct {
 
 	// Logger is a custom logger to use with the p2p.Server.
 	Logger log.Logger `toml:",omitempty"`
+
+	clock mclock.Clock
 }
 
 // Server manages all peer connections.
@@ -157,24 +190,38 @@ type Server struct {
 	running bool
 
 	ntab         discoverTable
+	localnode    *discover.LocalNode
 	listener     net.Listener
 	ourHandshake *protoHandshake
-	lastLookup   time.Time
+	lastLookup   mclock.AbsTime
 	DiscV5       *discv5.Network
+	dnsdisc      *dnsdisc.Client
+	observed     *nat.Observed // external IP as seen by discovery peers
+
+	// These are accessed by the run and listenLoop goroutines only.
+	subnets        *subnetLimiter
+	inboundHistory expHeap
+
+	netrestrictMu sync.RWMutex
+	netrestrict   *netutil.Netlist // current IP network whitelist, see SetNetRestrict
 
 	// These are for Peers, PeerCount (and nothing else).
 	peerOp     chan peerOpFunc
 	peerOpDone chan struct{}
 
-	quit          chan struct{}
-	addstatic     chan *discover.Node
-	removestatic  chan *discover.Node
-	posthandshake chan *conn
-	addpeer       chan *conn
-	delpeer       chan peerDrop
-	loopWG        sync.WaitGroup // loop, listenLoop
-	peerFeed      event.Feed
-	log           log.Logger
+	quit           chan struct{}
+	addstatic      chan *discover.Node
+	removestatic   chan *discover.Node
+	addtrusted     chan *discover.Node
+	removetrusted  chan *discover.Node
+	setnetrestrict chan *netutil.Netlist
+	posthandshake  chan *conn
+	addpeer        chan *conn
+	introduced     chan *discover.Node
+	delpeer        chan peerDrop
+	loopWG         sync.WaitGroup // loop, listenLoop
+	peerFeed       event.Feed
+	log            log.Logger
 }
 
 type peerOpFunc func(map[discover.NodeID]*Peer)
@@ -185,7 +232,7 @@ type peerDrop struct {
 	requested bool // true if signaled by the peer
 }
 
-type connFlag int
+type connFlag int32
 
 const (
 	dynDialedConn    connFlag = 1 << iota
@@ -204,6 +251,8 @@ type conn struct {
 	id    discover.NodeID // valid after the encryption handshake
 	caps  []Cap           // valid after the protocol handshake
 	name  string          // valid after the protocol handshake
+
+	subnetCounted bool // whether the subnet limiter counts this peer, run loop only
 }
 
 type transport interface {
@@ -221,7 +270,7 @@ type transport interface {
 }
 
 func (c *conn) String() string {
-	s := c.flags.String()
+	s := connFlag(atomic.LoadInt32((*int32)(&c.flags))).String()
 	if (c.id != discover.NodeID{}) {
 		s += " " + c.id.String()
 	}
@@ -249,8 +298,38 @@ func (f connFlag) String() string {
 	return s
 }
 
+// remoteIP returns the IP address of the remote end, or nil if the connection
+// isn't a TCP connection.
+func (c *conn) remoteIP() net.IP {
+	if c.fd == nil {
+		return nil
+	}
+	if tcp, ok := c.fd.RemoteAddr().(*net.TCPAddr); ok {
+		return tcp.IP
+	}
+	return nil
+}
+
 func (c *conn) is(f connFlag) bool {
-	return c.flags&f != 0
+	flags := connFlag(atomic.LoadInt32((*int32)(&c.flags)))
+	return flags&f != 0
+}
+
+// set sets or clears the given flag. It is safe to call while the
+// connection is in use.
+func (c *conn) set(f connFlag, val bool) {
+	for {
+		oldFlags := connFlag(atomic.LoadInt32((*int32)(&c.flags)))
+		flags := oldFlags
+		if val {
+			flags |= f
+		} else {
+			flags &= ^f
+		}
+		if atomic.CompareAndSwapInt32((*int32)(&c.flags), int32(oldFlags), int32(flags)) {
+			return
+		}
+	}
 }
 
 // Peers returns all connected peers.
@@ -300,6 +379,48 @@ func (srv *Server) RemovePeer(node *discover.Node) {
 	}
 }
 
+// AddTrustedPeer adds the given node to a reserved whitelist which allows the
+// node to always connect, even if the slots are full.
+func (srv *Server) AddTrustedPeer(node *discover.Node) {
+	select {
+	case srv.addtrusted <- node:
+	case <-srv.quit:
+	}
+}
+
+// RemoveTrustedPeer removes the given node from the trusted peer set.
+func (srv *Server) RemoveTrustedPeer(node *discover.Node) {
+	select {
+	case srv.removetrusted <- node:
+	case <-srv.quit:
+	}
+}
+
+// SetNetRestrict replaces the IP network whitelist of the server. Connected
+// peers outside the whitelisted networks are disconnected unless they are
+// static or trusted. A nil list removes all restrictions.
+//
+// Note that the whitelist of the discovery table is set on startup and isn't
+// changed.
+func (srv *Server) SetNetRestrict(list *netutil.Netlist) {
+	srv.netrestrictMu.Lock()
+	srv.netrestrict = list
+	srv.netrestrictMu.Unlock()
+
+	select {
+	case srv.setnetrestrict <- list:
+	case <-srv.quit:
+	}
+}
+
+// CurrentNetRestrict returns the IP network whitelist in effect.
+func (srv *Server) CurrentNetRestrict() *netutil.Netlist {
+	srv.netrestrictMu.RLock()
+	defer srv.netrestrictMu.RUnlock()
+
+	return srv.netrestrict
+}
+
 // SubscribePeers subscribes the given channel to peer events
 func (srv *Server) SubscribeEvents(ch chan *PeerEvent) event.Subscription {
 	return srv.peerFeed.Subscribe(ch)
@@ -316,6 +437,15 @@ func (srv *Server) Self() *discover.Node {
 	return srv.makeSelf(srv.listener, srv.ntab)
 }
 
+// LocalNode returns the manager of the local node record. It is nil until the
+// server is started.
+func (srv *Server) LocalNode() *discover.LocalNode {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	return srv.localnode
+}
+
 func (srv *Server) makeSelf(listener net.Listener, ntab discoverTable) *discover.Node {
 	// If the server's not running, return an empty node.
 	// If the node is running but discovery is off, manually assemble the node infos.
@@ -325,6 +455,9 @@ func (srv *Server) makeSelf(listener net.Listener, ntab discoverTable) *discover
 			return &discover.Node{IP: net.ParseIP("0.0.0.0"), ID: discover.PubkeyID(&srv.PrivateKey.PublicKey)}
 		}
 		// Otherwise inject the listener address too
+		if srv.localnode != nil {
+			return srv.localnode.Node()
+		}
 		addr := listener.Addr().(*net.TCPAddr)
 		return &discover.Node{
 			ID:  discover.PubkeyID(&srv.PrivateKey.PublicKey),
@@ -404,12 +537,20 @@ func (srv *Server) Start() (err error) {
 	if srv.Dialer == nil {
 		srv.Dialer = TCPDialer{&net.Dialer{Timeout: defaultDialTimeout}}
 	}
+	if srv.clock == nil {
+		srv.clock = mclock.System{}
+	}
+	srv.subnets = newSubnetLimiter(uint(srv.maxPeersPerSubnet()))
 	srv.quit = make(chan struct{})
 	srv.addpeer = make(chan *conn)
 	srv.delpeer = make(chan peerDrop)
 	srv.posthandshake = make(chan *conn)
 	srv.addstatic = make(chan *discover.Node)
 	srv.removestatic = make(chan *discover.Node)
+	srv.addtrusted = make(chan *discover.Node)
+	srv.removetrusted = make(chan *discover.Node)
+	srv.setnetrestrict = make(chan *netutil.Netlist)
+	srv.netrestrict = srv.NetRestrict
 	srv.peerOp = make(chan peerOpFunc)
 	srv.peerOpDone = make(chan struct{})
 
@@ -434,7 +575,7 @@ func (srv *Server) Start() (err error) {
 			if !realaddr.IP.IsLoopback() {
 				go nat.Map(srv.NAT, srv.quit, "udp", realaddr.Port, realaddr.Port, "happyuc discovery")
 			}
-			// TODO: react to external IP changes over time.
+			// Later changes of the external IP are picked up by natLoop.
 			if ip, err := srv.NAT.ExternalIP(); err == nil {
 				// TODO switch to real ip addr
 				// ip = net.ParseIP("127.0.0.1")
@@ -458,11 +599,30 @@ func (srv *Server) Start() (err error) {
 			Bootnodes:    srv.BootstrapNodes,
 			Unhandled:    unhandled,
 		}
+		if srv.HolePunch {
+			srv.introduced = make(chan *discover.Node, maxActiveDialTasks)
+			cfg.Introduced = srv.introduced
+		}
 		ntab, err := discover.ListenUDP(conn, cfg)
 		if err != nil {
 			return err
 		}
 		srv.ntab = ntab
+		srv.localnode = ntab.LocalNode()
+	} else {
+		srv.localnode = discover.NewLocalNode(srv.PrivateKey)
+	}
+	// The external IP seen by discovery peers takes precedence over the one
+	// reported by the NAT device because it stays correct behind multiple
+	// layers of NAT.
+	ext := srv.NAT
+	if srv.ntab != nil {
+		srv.observed = nat.NewObserved(srv.NAT)
+		ext = srv.observed
+	}
+	if ext != nil {
+		srv.loopWG.Add(1)
+		go srv.natLoop(ext)
 	}
 
 	if srv.DiscoveryV5 {
@@ -484,8 +644,22 @@ func (srv *Server) Start() (err error) {
 		srv.DiscV5 = ntab
 	}
 
+	// DNS node lists
+	if len(srv.DNSDiscovery) > 0 {
+		client, err := dnsdisc.NewClient(dnsdisc.Config{Logger: srv.log}, srv.DNSDiscovery...)
+		if err != nil {
+			return err
+		}
+		client.Start()
+		srv.dnsdisc = client
+	}
+
 	dynPeers := srv.maxDialedConns()
 	dialer := newDialState(srv.StaticNodes, srv.BootstrapNodes, srv.ntab, dynPeers, srv.NetRestrict)
+	if srv.dnsdisc != nil {
+		dialer.dns = srv.dnsdisc
+	}
+	dialer.subnets = srv.subnets
 
 	// handshake
 	srv.ourHandshake = &protoHandshake{Version: baseProtocolVersion, Name: srv.Name, ID: discover.PubkeyID(&srv.PrivateKey.PublicKey)}
@@ -517,6 +691,10 @@ func (srv *Server) startListening() error {
 	laddr := listener.Addr().(*net.TCPAddr)
 	srv.ListenAddr = laddr.String()
 	srv.listener = listener
+	srv.localnode.SetTCP(uint16(laddr.Port))
+	if srv.ntab == nil {
+		srv.localnode.SetIP(laddr.IP)
+	}
 	srv.loopWG.Add(1)
 	go srv.listenLoop()
 	// Map the TCP listening port if NAT is configured.
@@ -530,11 +708,46 @@ func (srv *Server) startListening() error {
 	return nil
 }
 
+// natLoop keeps the IP address in the local node record in sync with the
+// external address reported by the NAT device or predicted from the
+// endpoint statements of discovery peers.
+func (srv *Server) natLoop(ext nat.Interface) {
+	defer srv.loopWG.Done()
+
+	interval := natRefreshInterval
+	if srv.observed != nil {
+		interval = observedRefreshInterval
+	}
+	refresh := time.NewTimer(0)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-refresh.C:
+			if srv.observed != nil {
+				if ep := srv.localnode.PredictedEndpoint(); ep != nil {
+					srv.observed.SetExternalIP(ep.IP)
+				}
+			}
+			if ip, err := ext.ExternalIP(); err != nil {
+				srv.log.Debug("Couldn't get external IP", "interface", ext, "err", err)
+			} else if !ip.Equal(srv.localnode.Node().IP) {
+				srv.log.Info("External IP changed", "ip", ip)
+				srv.localnode.SetIP(ip)
+			}
+			refresh.Reset(interval)
+		case <-srv.quit:
+			return
+		}
+	}
+}
+
 type dialer interface {
-	newTasks(running int, peers map[discover.NodeID]*Peer, now time.Time) []task
-	taskDone(task, time.Time)
+	newTasks(running int, peers map[discover.NodeID]*Peer, now mclock.AbsTime) []task
+	taskDone(task, mclock.AbsTime)
 	addStatic(*discover.Node)
 	removeStatic(*discover.Node)
+	setNetRestrict(*netutil.Netlist)
+	addIntroduced(*discover.Node)
 }
 
 func (srv *Server) run(dialstate dialer) {
@@ -548,8 +761,7 @@ func (srv *Server) run(dialstate dialer) {
 		queuedTasks  []task // tasks that can't run yet
 	)
 	// Put trusted nodes into a map to speed up checks.
-	// Trusted peers are loaded on startup and cannot be
-	// modified while the server is running.
+	// Trusted peers are loaded on startup or added via AddTrustedPeer.
 	for _, n := range srv.TrustedNodes {
 		trusted[n.ID] = true
 	}
@@ -579,7 +791,7 @@ func (srv *Server) run(dialstate dialer) {
 		queuedTasks = append(queuedTasks[:0], startTasks(queuedTasks)...)
 		// Query dialer for new tasks and start as many as possible now.
 		if len(runningTasks) < maxActiveDialTasks {
-			nt := dialstate.newTasks(len(runningTasks)+len(queuedTasks), peers, time.Now())
+			nt := dialstate.newTasks(len(runningTasks)+len(queuedTasks), peers, srv.clock.Now())
 			queuedTasks = append(queuedTasks, startTasks(nt)...)
 		}
 	}
@@ -607,6 +819,44 @@ running:
 			if p, ok := peers[n.ID]; ok {
 				p.Disconnect(DiscRequested)
 			}
+		case n := <-srv.introduced:
+			// A relay has introduced a node which wants to connect.
+			// Hand it to the dialer, which dials it on the next
+			// round if it passes the dial checks.
+			srv.log.Trace("Adding introduced node", "node", n)
+			dialstate.addIntroduced(n)
+		case n := <-srv.addtrusted:
+			// This channel is used by AddTrustedPeer to add a node
+			// to the trusted node set.
+			srv.log.Trace("Adding trusted node", "node", n)
+			trusted[n.ID] = true
+			if p, ok := peers[n.ID]; ok {
+				// Trusted peers don't count toward the subnet limits.
+				if p.rw.subnetCounted {
+					srv.subnets.remove(p.rw.remoteIP())
+					p.rw.subnetCounted = false
+				}
+				p.rw.set(trustedConn, true)
+			}
+		case n := <-srv.removetrusted:
+			// This channel is used by RemoveTrustedPeer to remove a node
+			// from the trusted node set.
+			srv.log.Trace("Removing trusted node", "node", n)
+			delete(trusted, n.ID)
+			if p, ok := peers[n.ID]; ok {
+				p.rw.set(trustedConn, false)
+			}
+		case list := <-srv.setnetrestrict:
+			// This channel is used by SetNetRestrict. Drop the peers
+			// which are no longer whitelisted.
+			dialstate.setNetRestrict(list)
+			for _, p := range peers {
+				ip := p.rw.remoteIP()
+				if list != nil && ip != nil && !list.Contains(ip) && !p.rw.is(trustedConn|staticDialedConn) {
+					p.log.Debug("Disconnecting peer outside netrestrict whitelist", "addr", ip)
+					p.Disconnect(DiscRequested)
+				}
+			}
 		case op := <-srv.peerOp:
 			// This channel is used by Peers and PeerCount.
 			op(peers)
@@ -616,14 +866,14 @@ running:
 			// can update its state and remove it from the active
 			// tasks list.
 			srv.log.Trace("Dial task done", "task", t)
-			dialstate.taskDone(t, time.Now())
+			dialstate.taskDone(t, srv.clock.Now())
 			delTask(t)
 		case c := <-srv.posthandshake:
 			// A connection has passed the encryption handshake so
 			// the remote identity is known (but hasn't been verified yet).
 			if trusted[c.id] {
 				// Ensure that the trusted flag is set before checking against MaxPeers.
-				c.flags |= trustedConn
+				c.set(trustedConn, true)
 			}
 			// TODO: track in-progress inbound node IDs (pre-Peer) to avoid dialing them.
 			select {
@@ -650,6 +900,9 @@ running:
 				if p.Inbound() {
 					inboundCount++
 				}
+				if !c.is(trustedConn | staticDialedConn) {
+					c.subnetCounted = srv.subnets.add(c.remoteIP())
+				}
 			}
 			// The dialer logic relies on the assumption that
 			// dial tasks complete after the peer has been added or
@@ -667,6 +920,9 @@ running:
 			if pd.Inbound() {
 				inboundCount--
 			}
+			if pd.rw.subnetCounted {
+				srv.subnets.remove(pd.rw.remoteIP())
+			}
 		}
 	}
 
@@ -679,6 +935,9 @@ running:
 	if srv.DiscV5 != nil {
 		srv.DiscV5.Close()
 	}
+	if srv.dnsdisc != nil {
+		srv.dnsdisc.Close()
+	}
 	// Disconnect all peers.
 	for _, p := range peers {
 		p.Disconnect(DiscQuitting)
@@ -709,6 +968,8 @@ func (srv *Server) encHandshakeChecks(peers map[discover.NodeID]*Peer, inboundCo
 		return DiscTooManyPeers
 	case !c.is(trustedConn) && c.is(inboundConn) && inboundCount >= srv.maxInboundConns():
 		return DiscTooManyPeers
+	case !c.is(trustedConn|staticDialedConn) && srv.subnets != nil && !srv.subnets.allowed(c.remoteIP()):
+		return DiscTooManyPeers
 	case peers[c.id] != nil:
 		return DiscAlreadyConnected
 	case c.id == srv.Self().ID:
@@ -722,8 +983,15 @@ func (srv *Server) maxInboundConns() int {
 	return srv.MaxPeers - srv.maxDialedConns()
 }
 
+func (srv *Server) maxPeersPerSubnet() int {
+	if srv.MaxPeersPerSubnet > 0 {
+		return srv.MaxPeersPerSubnet
+	}
+	return defaultMaxPeersPerSubnet
+}
+
 func (srv *Server) maxDialedConns() int {
-	if srv.NoDiscovery || srv.NoDial {
+	if (srv.NoDiscovery && len(srv.DNSDiscovery) == 0) || srv.NoDial {
 		return 0
 	}
 	r := srv.DialRatio
@@ -772,14 +1040,15 @@ func (srv *Server) listenLoop() {
 			break
 		}
 
-		// Reject connections that do not match NetRestrict.
-		if srv.NetRestrict != nil {
-			if tcp, ok := fd.RemoteAddr().(*net.TCPAddr); ok && !srv.NetRestrict.Contains(tcp.IP) {
-				srv.log.Debug("Rejected conn (not whitelisted in NetRestrict)", "addr", fd.RemoteAddr())
-				fd.Close()
-				slots <- struct{}{}
-				continue
-			}
+		var remoteIP net.IP
+		if tcp, ok := fd.RemoteAddr().(*net.TCPAddr); ok {
+			remoteIP = tcp.IP
+		}
+		if err := srv.checkInboundConn(remoteIP); err != nil {
+			srv.log.Debug("Rejected inbound connection", "addr", fd.RemoteAddr(), "err", err)
+			fd.Close()
+			slots <- struct{}{}
+			continue
 		}
 
 		fd = newMeteredConn(fd, true)
@@ -791,6 +1060,26 @@ func (srv *Server) listenLoop() {
 	}
 }
 
+// checkInboundConn decides whether an inbound connection from remoteIP is
+// accepted before any handshake is performed.
+func (srv *Server) checkInboundConn(remoteIP net.IP) error {
+	if remoteIP == nil {
+		return nil
+	}
+	// Reject connections that do not match NetRestrict.
+	if list := srv.CurrentNetRestrict(); list != nil && !list.Contains(remoteIP) {
+		return errors.New("not whitelisted in NetRestrict")
+	}
+	// Reject Internet peers that try too often.
+	now := srv.clock.Now()
+	srv.inboundHistory.expire(now)
+	if !netutil.IsLAN(remoteIP) && srv.inboundHistory.contains(remoteIP.String()) {
+		return errors.New("too many attempts")
+	}
+	srv.inboundHistory.add(remoteIP.String(), now.Add(inboundThrottleTime))
+	return nil
+}
+
 // SetupConn runs the handshakes and attempts to add the connection
 // as a peer. It returns when the connection has been added as a peer
 // or the handshakes have failed.
@@ -912,6 +1201,7 @@ type NodeInfo struct {
 	ID    string `json:"id"`    // Unique node identifier (also the encryption key)
 	Name  string `json:"name"`  // Name of the node, including client type, version, OS, custom data
 	Enode string `json:"hnode"` // Enode URL for adding this peer from remote peers
+	ENR   string `json:"enr"`   // Signed node record in text form (EIP-778)
 	IP    string `json:"ip"`    // IP address of the node
 	Ports struct {
 		Discovery int `json:"discovery"` // UDP listening port for discovery protocol
@@ -929,6 +1219,7 @@ func (srv *Server) NodeInfo() *NodeInfo {
 	info := &NodeInfo{
 		Name:       srv.Name,
 		Enode:      node.String(),
+		ENR:        node.ENR(),
 		ID:         node.ID.String(),
 		IP:         node.IP.String(),
 		ListenAddr: srv.ListenAddr,
@@ -25,10 +25,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/happyuc-project/happyuc-go/common/mclock"
 	"github.com/happyuc-project/happyuc-go/crypto"
 	"github.com/happyuc-project/happyuc-go/crypto/sha3"
 	"github.com/happyuc-project/happyuc-go/log"
 	"github.com/happyuc-project/happyuc-go/p2p/discover"
+	"github.com/happyuc-project/happyuc-go/p2p/netutil"
 )
 
 func init() {
@@ -122,6 +124,84 @@ func TestServerListen(t *testing.T) {
 	}
 }
 
+// This test checks that trusted peers can be added and removed while the
+// server is running and that changing the network whitelist drops peers
+// which are no longer allowed.
+func TestServerTrustedPeersAndNetRestrict(t *testing.T) {
+	connected := make(chan *Peer)
+	remid := randomID()
+	srv := startTestServer(t, remid, func(p *Peer) { connected <- p })
+	defer close(connected)
+	defer srv.Stop()
+
+	events := make(chan *PeerEvent, 10)
+	sub := srv.SubscribeEvents(events)
+	defer sub.Unsubscribe()
+
+	conn, err := net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+
+	var peer *Peer
+	select {
+	case peer = <-connected:
+	case <-time.After(1 * time.Second):
+		t.Fatal("server did not accept within one second")
+	}
+
+	// Mark the peer as trusted. The whitelist must not affect it.
+	node := &discover.Node{ID: remid}
+	srv.AddTrustedPeer(node)
+	if !waitForFlag(peer, trustedConn, true) {
+		t.Fatal("peer not marked trusted")
+	}
+	restrict, _ := netutil.ParseNetlist("10.0.0.0/8")
+	srv.SetNetRestrict(restrict)
+	if srv.CurrentNetRestrict() != restrict {
+		t.Fatal("netrestrict not updated")
+	}
+	select {
+	case ev := <-events:
+		if ev.Type == PeerEventTypeDrop {
+			t.Fatalf("trusted peer dropped: %v", ev.Error)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Remove the trusted flag and apply the whitelist again.
+	srv.RemoveTrustedPeer(node)
+	if !waitForFlag(peer, trustedConn, false) {
+		t.Fatal("peer still marked trusted")
+	}
+	srv.SetNetRestrict(restrict)
+	timeout := time.After(1 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == PeerEventTypeDrop {
+				if ev.Peer != remid {
+					t.Fatalf("wrong peer dropped: %v", ev.Peer)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("peer outside whitelist not dropped")
+		}
+	}
+}
+
+func waitForFlag(p *Peer, f connFlag, val bool) bool {
+	for i := 0; i < 100; i++ {
+		if p.rw.is(f) == val {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
 func TestServerDial(t *testing.T) {
 	// run a one-shot TCP server to handle the connection.
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
@@ -203,7 +283,7 @@ func TestServerTaskScheduling(t *testing.T) {
 	// The Server in this test isn't actually running
 	// because we're only interested in what run does.
 	srv := &Server{
-		Config:  Config{MaxPeers: 10},
+		Config:  Config{MaxPeers: 10, clock: mclock.System{}},
 		quit:    make(chan struct{}),
 		ntab:    fakeTable{},
 		running: true,
@@ -249,6 +329,7 @@ func TestServerManyTasks(t *testing.T) {
 
 	var (
 		srv = &Server{
+			Config:  Config{clock: mclock.System{}},
 			quit:    make(chan struct{}),
 			ntab:    fakeTable{},
 			running: true,
@@ -299,16 +380,20 @@ type taskgen struct {
 	doneFunc func(task)
 }
 
-func (tg taskgen) newTasks(running int, peers map[discover.NodeID]*Peer, now time.Time) []task {
+func (tg taskgen) newTasks(running int, peers map[discover.NodeID]*Peer, now mclock.AbsTime) []task {
 	return tg.newFunc(running, peers)
 }
-func (tg taskgen) taskDone(t task, now time.Time) {
+func (tg taskgen) taskDone(t task, now mclock.AbsTime) {
 	tg.doneFunc(t)
 }
 func (tg taskgen) addStatic(*discover.Node) {
 }
 func (tg taskgen) removeStatic(*discover.Node) {
 }
+func (tg taskgen) setNetRestrict(*netutil.Netlist) {
+}
+func (tg taskgen) addIntroduced(*discover.Node) {
+}
 
 type testTask struct {
 	index  int
@@ -502,3 +587,61 @@ func randomID() (id discover.NodeID) {
 	}
 	return id
 }
+
+func TestServerInboundThrottle(t *testing.T) {
+	clock := new(mclock.Simulated)
+	srv := &Server{Config: Config{clock: clock}}
+
+	ip := net.IP{1, 2, 3, 4}
+	if err := srv.checkInboundConn(ip); err != nil {
+		t.Fatal("first connection rejected:", err)
+	}
+	if err := srv.checkInboundConn(ip); err == nil {
+		t.Fatal("second connection within throttle time accepted")
+	}
+	if err := srv.checkInboundConn(net.IP{1, 2, 3, 5}); err != nil {
+		t.Fatal("connection from other IP rejected:", err)
+	}
+	// LAN connections aren't throttled.
+	for i := 0; i < 3; i++ {
+		if err := srv.checkInboundConn(net.IP{127, 0, 0, 1}); err != nil {
+			t.Fatal("LAN connection rejected:", err)
+		}
+	}
+	clock.Run(inboundThrottleTime + time.Second)
+	if err := srv.checkInboundConn(ip); err != nil {
+		t.Fatal("connection rejected after throttle time:", err)
+	}
+}
+
+// remoteAddrConn is a net.Conn with a configurable remote address.
+type remoteAddrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c remoteAddrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestServerSubnetLimit(t *testing.T) {
+	srv := &Server{Config: Config{MaxPeers: 10, MaxPeersPerSubnet: 1, PrivateKey: newkey()}}
+	srv.subnets = newSubnetLimiter(uint(srv.maxPeersPerSubnet()))
+	srv.subnets.add(net.IP{1, 2, 3, 4})
+
+	newconn := func(flags connFlag, ip net.IP) *conn {
+		fd := remoteAddrConn{addr: &net.TCPAddr{IP: ip, Port: 30303}}
+		return &conn{fd: fd, flags: flags, id: randomID()}
+	}
+	peers := make(map[discover.NodeID]*Peer)
+	if err := srv.encHandshakeChecks(peers, 0, newconn(inboundConn, net.IP{1, 2, 3, 5})); err != DiscTooManyPeers {
+		t.Fatalf("wrong error for connection in full subnet: %v", err)
+	}
+	if err := srv.encHandshakeChecks(peers, 0, newconn(inboundConn|trustedConn, net.IP{1, 2, 3, 5})); err != nil {
+		t.Fatalf("trusted connection rejected: %v", err)
+	}
+	if err := srv.encHandshakeChecks(peers, 0, newconn(staticDialedConn, net.IP{1, 2, 3, 5})); err != nil {
+		t.Fatalf("static connection rejected: %v", err)
+	}
+	if err := srv.encHandshakeChecks(peers, 0, newconn(inboundConn, net.IP{1, 2, 4, 5})); err != nil {
+		t.Fatalf("connection in other subnet rejected: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"container/heap"
+	"net"
+
+	"github.com/happyuc-project/happyuc-go/common/mclock"
+	"github.com/happyuc-project/happyuc-go/p2p/netutil"
+)
+
+// expHeap tracks strings and their expiry time.
+type expHeap []expItem
+
+// expItem is an entry in expHeap.
+type expItem struct {
+	item string
+	exp  mclock.AbsTime
+}
+
+// nextExpiry returns the next expiry time.
+func (h *expHeap) nextExpiry() mclock.AbsTime {
+	return (*h)[0].exp
+}
+
+// add adds an item and sets its expiry time.
+func (h *expHeap) add(item string, exp mclock.AbsTime) {
+	heap.Push(h, expItem{item, exp})
+}
+
+// remove removes an item. It returns true if the item was present.
+func (h *expHeap) remove(item string) bool {
+	for i, v := range *h {
+		if v.item == item {
+			heap.Remove(h, i)
+			return true
+		}
+	}
+	return false
+}
+
+// contains checks whether an item is present.
+func (h expHeap) contains(item string) bool {
+	for _, v := range h {
+		if v.item == item {
+			return true
+		}
+	}
+	return false
+}
+
+// expire removes items with expiry time before 'now'.
+func (h *expHeap) expire(now mclock.AbsTime) {
+	for h.Len() > 0 && h.nextExpiry() < now {
+		heap.Pop(h)
+	}
+}
+
+// heap.Interface boilerplate
+func (h expHeap) Len() int            { return len(h) }
+func (h expHeap) Less(i, j int) bool  { return h[i].exp < h[j].exp }
+func (h expHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expHeap) Push(x interface{}) { *h = append(*h, x.(expItem)) }
+func (h *expHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+const (
+	subnetBitsV4 = 24 // IPv4 connections are limited per /24 network
+	subnetBitsV6 = 56 // IPv6 connections are limited per /56 network
+)
+
+// subnetLimiter counts connections per IP subnet and enforces an upper bound
+// on the number of connections in any single subnet. This makes it expensive
+// to eclipse a node with peers from a few hosting networks. Addresses in LAN
+// networks are never limited.
+type subnetLimiter struct {
+	v4, v6 netutil.DistinctNetSet
+}
+
+func newSubnetLimiter(limit uint) *subnetLimiter {
+	return &subnetLimiter{
+		v4: netutil.DistinctNetSet{Subnet: subnetBitsV4, Limit: limit},
+		v6: netutil.DistinctNetSet{Subnet: subnetBitsV6, Limit: limit},
+	}
+}
+
+// allowed reports whether another connection to ip would stay within the limit.
+func (l *subnetLimiter) allowed(ip net.IP) bool {
+	set := l.set(ip)
+	if set == nil {
+		return true
+	}
+	if !set.Add(ip) {
+		return false
+	}
+	set.Remove(ip)
+	return true
+}
+
+// add counts a connection to ip. It returns false (and doesn't count the
+// connection) if the subnet of ip is full.
+func (l *subnetLimiter) add(ip net.IP) bool {
+	if set := l.set(ip); set != nil {
+		return set.Add(ip)
+	}
+	return true
+}
+
+// remove removes a connection counted by add.
+func (l *subnetLimiter) remove(ip net.IP) {
+	if set := l.set(ip); set != nil {
+		set.Remove(ip)
+	}
+}
+
+func (l *subnetLimiter) set(ip net.IP) *netutil.DistinctNetSet {
+	switch {
+	case ip == nil || netutil.IsLAN(ip):
+		return nil
+	case ip.To4() != nil:
+		return &l.v4
+	default:
+		return &l.v6
+	}
+}
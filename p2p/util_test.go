@@ -0,0 +1,100 @@
+// Copyright 2018 The happyuc-go Authors
+// This file is part of the happyuc-go library.
+//
+// The happyuc-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The happyuc-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the happyuc-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/happyuc-project/happyuc-go/common/mclock"
+)
+
+func TestExpHeap(t *testing.T) {
+	var h expHeap
+
+	var (
+		basetime = mclock.AbsTime(10)
+		exptimeA = basetime.Add(2 * time.Second)
+		exptimeB = basetime.Add(3 * time.Second)
+		exptimeC = basetime.Add(4 * time.Second)
+	)
+	h.add("b", exptimeB)
+	h.add("a", exptimeA)
+	h.add("c", exptimeC)
+
+	if h.nextExpiry() != exptimeA {
+		t.Fatal("wrong nextExpiry")
+	}
+	if !h.contains("a") || !h.contains("b") || !h.contains("c") {
+		t.Fatal("heap doesn't contain all live items")
+	}
+
+	h.expire(exptimeA.Add(1))
+	if h.nextExpiry() != exptimeB {
+		t.Fatal("wrong nextExpiry")
+	}
+	if h.contains("a") {
+		t.Fatal("heap contains a even though it has already expired")
+	}
+	if !h.contains("b") || !h.contains("c") {
+		t.Fatal("heap doesn't contain all live items")
+	}
+
+	if !h.remove("c") || h.contains("c") {
+		t.Fatal("remove didn't remove the item")
+	}
+	if h.remove("c") {
+		t.Fatal("remove of missing item returned true")
+	}
+}
+
+func TestSubnetLimiter(t *testing.T) {
+	l := newSubnetLimiter(2)
+
+	// IPv4 addresses are limited per /24.
+	if !l.add(net.ParseIP("1.2.3.1")) || !l.add(net.ParseIP("1.2.3.2")) {
+		t.Fatal("can't add IPs below limit")
+	}
+	if l.allowed(net.ParseIP("1.2.3.3")) || l.add(net.ParseIP("1.2.3.3")) {
+		t.Fatal("IP in full /24 accepted")
+	}
+	if !l.allowed(net.ParseIP("1.2.4.1")) {
+		t.Fatal("IP in other /24 rejected")
+	}
+	l.remove(net.ParseIP("1.2.3.1"))
+	if !l.allowed(net.ParseIP("1.2.3.3")) {
+		t.Fatal("IP rejected after remove")
+	}
+
+	// IPv6 addresses are limited per /56.
+	l.add(net.ParseIP("2001:db8:0:100::1"))
+	l.add(net.ParseIP("2001:db8:0:1ff::1"))
+	if l.allowed(net.ParseIP("2001:db8:0:1aa::1")) {
+		t.Fatal("IP in full /56 accepted")
+	}
+	if !l.allowed(net.ParseIP("2001:db8:0:200::1")) {
+		t.Fatal("IP in other /56 rejected")
+	}
+
+	// LAN addresses are never limited.
+	for i := 0; i < 5; i++ {
+		if !l.add(net.ParseIP("192.168.0.1")) || !l.add(net.ParseIP("127.0.0.1")) {
+			t.Fatal("LAN IP rejected")
+		}
+	}
+}